@@ -0,0 +1,38 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("binlogrowmetadata", binlogRowMetadataCheck, ScopePreflight)
+}
+
+// binlogRowMetadataCheck verifies that binlog_row_metadata is FULL. With the
+// default of MINIMAL, the table map events in the binary log do not include
+// the column names, and the replication client maps the columns of each row
+// by their position in the table definition it has read. If the positions
+// differ, i.e. after a concurrent change to the table, the columns could be
+// mis-mapped without an error. Because MINIMAL is the default, it is only a
+// warning unless RequireFullRowMetadata is set.
+func binlogRowMetadataCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	var binlogRowMetadata string
+	if err := r.DB.QueryRowContext(ctx, "SELECT @@global.binlog_row_metadata").Scan(&binlogRowMetadata); err != nil {
+		if strings.Contains(err.Error(), "Unknown system variable") {
+			return nil // MySQL 5.7 does not have the setting.
+		}
+		return err
+	}
+	if strings.EqualFold(binlogRowMetadata, "FULL") {
+		return nil
+	}
+	if r.RequireFullRowMetadata {
+		return errors.New("binlog_row_metadata must be FULL, so that the binary log includes the column names of each row")
+	}
+	logger.Warnf("binlog_row_metadata is %s. FULL is recommended, so that the binary log includes the column names of each row.", binlogRowMetadata)
+	return nil
+}
@@ -0,0 +1,47 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinlogRowMetadata(t *testing.T) {
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	r := Resources{
+		DB:    db,
+		Table: &table.TableInfo{TableName: "test", SchemaName: "test"},
+	}
+
+	// The setting is dynamic, so we can change it.
+	var binlogRowMetadata string
+	assert.NoError(t, db.QueryRow("SELECT @@global.binlog_row_metadata").Scan(&binlogRowMetadata))
+	defer func() {
+		_, err := db.Exec("SET GLOBAL binlog_row_metadata = ?", binlogRowMetadata)
+		assert.NoError(t, err)
+	}()
+
+	_, err = db.Exec("SET GLOBAL binlog_row_metadata = 'FULL'")
+	assert.NoError(t, err)
+	logger, hook := test.NewNullLogger()
+	assert.NoError(t, binlogRowMetadataCheck(context.Background(), r, logger))
+	assert.Empty(t, hook.AllEntries())
+	r.RequireFullRowMetadata = true
+	assert.NoError(t, binlogRowMetadataCheck(context.Background(), r, logger))
+
+	// MINIMAL is a warning, unless FULL is required.
+	_, err = db.Exec("SET GLOBAL binlog_row_metadata = 'MINIMAL'")
+	assert.NoError(t, err)
+	assert.ErrorContains(t, binlogRowMetadataCheck(context.Background(), r, logger), "binlog_row_metadata must be FULL")
+	r.RequireFullRowMetadata = false
+	assert.NoError(t, binlogRowMetadataCheck(context.Background(), r, logger))
+	assert.Contains(t, hook.LastEntry().Message, "binlog_row_metadata is MINIMAL")
+}
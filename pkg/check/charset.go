@@ -0,0 +1,146 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("charset", charsetCheck, ScopePostSetup)
+}
+
+// columnCharset is the character set and collation of a string column.
+type columnCharset struct {
+	charset   string
+	collation string
+}
+
+// unicodeCharsets can hold every character.
+var unicodeCharsets = []string{"utf8mb4", "utf16", "utf16le", "utf32"}
+
+// charsetCheck compares the character set of each copied string column of
+// the table with the column of the new table. The copy converts the values
+// to the new character set, and with an empty sql_mode a character that it
+// can not hold is replaced with a ? without an error, so a change to a
+// character set that can not hold all of the characters of the previous
+// one fails the check, i.e. utf8mb4 to latin1, unless AllowLossyCharset is
+// set because the values are known to fit. A change of collation is a
+// warning, since it can change which values are equal: a unique index on a
+// column that becomes case insensitive discards rows that only differ by
+// case, since rows are copied with INSERT IGNORE.
+func charsetCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	newName := r.TableNamer.NewName(r.Table.TableName)
+	oldCharsets, err := columnCharsets(ctx, r.DB, r.Table.SchemaName, r.Table.TableName)
+	if err != nil {
+		return err
+	}
+	newCharsets, err := columnCharsets(ctx, r.DB, r.Table.SchemaName, newName)
+	if err != nil {
+		return err
+	}
+	var lossy, collations []string
+	for _, col := range r.Table.NonGeneratedColumns {
+		src, ok := oldCharsets[col]
+		if !ok {
+			continue // not a string column.
+		}
+		dst, ok := newCharsets[col]
+		if !ok {
+			continue // not copied, or no longer a string column.
+		}
+		if !charsetCanHold(src.charset, dst.charset) {
+			lossy = append(lossy, fmt.Sprintf("%s (%s to %s)", col, src.charset, dst.charset))
+		} else if src.collation != dst.collation {
+			collations = append(collations, fmt.Sprintf("%s (%s to %s)", col, src.collation, dst.collation))
+		}
+	}
+	if len(lossy) > 0 {
+		if !r.AllowLossyCharset {
+			return fmt.Errorf("new table %s has columns with a character set that can not hold every character of the table: %s. Convert them to a character set that can, such as utf8mb4, or set --allow-lossy-charset if the values are known to fit",
+				newName, strings.Join(lossy, ", "))
+		}
+		logger.Warnf("new table %s has columns with a character set that can not hold every character of the table: %s. Characters that do not fit will be replaced with ?",
+			newName, strings.Join(lossy, ", "))
+	}
+	oldCollation, err := tableCollation(ctx, r.DB, r.Table.SchemaName, r.Table.TableName)
+	if err != nil {
+		return err
+	}
+	newCollation, err := tableCollation(ctx, r.DB, r.Table.SchemaName, newName)
+	if err != nil {
+		return err
+	}
+	if oldCollation != newCollation {
+		logger.Warnf("the default collation of new table %s is %s, but the table's is %s", newName, newCollation, oldCollation)
+	}
+	if len(collations) > 0 {
+		logger.Warnf("new table %s has columns with a different collation, which may change which values are equal in a unique index: %s",
+			newName, strings.Join(collations, ", "))
+	}
+	return nil
+}
+
+// columnCharsets returns the character set and collation
+// of the string columns of the table, by name.
+func columnCharsets(ctx context.Context, db *sql.DB, schemaName, tableName string) (map[string]columnCharset, error) {
+	rows, err := db.QueryContext(ctx, "SELECT column_name, character_set_name, collation_name FROM information_schema.columns WHERE table_schema=? AND table_name=? AND character_set_name IS NOT NULL",
+		schemaName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	charsets := make(map[string]columnCharset)
+	for rows.Next() {
+		var name string
+		var cs columnCharset
+		if err := rows.Scan(&name, &cs.charset, &cs.collation); err != nil {
+			return nil, err
+		}
+		charsets[name] = cs
+	}
+	return charsets, rows.Err()
+}
+
+// tableCollation returns the default collation of the table.
+func tableCollation(ctx context.Context, db *sql.DB, schemaName, tableName string) (string, error) {
+	var collation string
+	err := db.QueryRowContext(ctx, "SELECT table_collation FROM information_schema.tables WHERE table_schema=? AND table_name=?",
+		schemaName, tableName).Scan(&collation)
+	return collation, err
+}
+
+// charsetCanHold returns true if every character of
+// the src character set can be converted to dst.
+func charsetCanHold(src, dst string) bool {
+	src, dst = normalizeCharset(src), normalizeCharset(dst)
+	switch {
+	case src == dst:
+		return true
+	case src == "binary":
+		return false // the bytes may not be valid in dst.
+	case src == "ascii":
+		return true
+	case slices.Contains(unicodeCharsets, dst):
+		return true
+	case dst == "utf8mb3":
+		// utf8mb3 holds the Basic Multilingual Plane, which
+		// includes every character of these character sets.
+		return slices.Contains([]string{"latin1", "ucs2"}, src)
+	}
+	return false
+}
+
+// normalizeCharset returns the name of the character set, using utf8mb3
+// for utf8, which is what it is called by MySQL 8.0.30 and later.
+func normalizeCharset(charset string) string {
+	charset = strings.ToLower(charset)
+	if charset == "utf8" {
+		return "utf8mb3"
+	}
+	return charset
+}
@@ -0,0 +1,76 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCharsetCanHold(t *testing.T) {
+	for _, tc := range []struct {
+		src, dst string
+		canHold  bool
+	}{
+		{"latin1", "latin1", true},
+		{"utf8", "utf8mb3", true},
+		{"ascii", "latin1", true},
+		{"latin1", "utf8mb4", true},
+		{"utf8mb3", "utf8mb4", true},
+		{"latin1", "utf8", true},
+		{"utf8mb4", "utf16", true},
+		{"utf8mb4", "latin1", false},
+		{"utf8", "latin1", false},
+		{"utf8mb4", "utf8mb3", false},
+		{"latin1", "ascii", false},
+		{"binary", "utf8mb4", false},
+	} {
+		assert.Equal(t, tc.canHold, charsetCanHold(tc.src, tc.dst), "%s to %s", tc.src, tc.dst)
+	}
+}
+
+func TestCharsetCheck(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS charsett1, _charsett1_new")
+	testutils.RunSQL(t, "CREATE TABLE charsett1 (id INT NOT NULL PRIMARY KEY, a VARCHAR(255) CHARACTER SET latin1, b VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin")
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+	r := Resources{
+		DB: db,
+		Table: &table.TableInfo{
+			TableName:           "charsett1",
+			SchemaName:          "test",
+			NonGeneratedColumns: []string{"id", "a", "b"},
+		},
+	}
+
+	// Converting to a character set that can hold every character is fine.
+	testutils.RunSQL(t, "CREATE TABLE _charsett1_new LIKE charsett1")
+	testutils.RunSQL(t, "ALTER TABLE _charsett1_new MODIFY a VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin")
+	logger, hook := test.NewNullLogger()
+	assert.NoError(t, charsetCheck(context.Background(), r, logger))
+	assert.Nil(t, hook.LastEntry())
+
+	// A change of collation is a warning.
+	testutils.RunSQL(t, "ALTER TABLE _charsett1_new MODIFY b VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci")
+	assert.NoError(t, charsetCheck(context.Background(), r, logger))
+	assert.Equal(t, "new table _charsett1_new has columns with a different collation, which may change which values are equal in a unique index: b (utf8mb4_bin to utf8mb4_general_ci)",
+		hook.LastEntry().Message)
+
+	// Converting to a character set that can not hold every character fails.
+	testutils.RunSQL(t, "ALTER TABLE _charsett1_new MODIFY b VARCHAR(255) CHARACTER SET latin1")
+	err = charsetCheck(context.Background(), r, logger)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "b (utf8mb4 to latin1)")
+
+	// Unless it is allowed, i.e. because the column only holds ASCII.
+	r.AllowLossyCharset = true
+	assert.NoError(t, charsetCheck(context.Background(), r, logger))
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Contains(t, hook.LastEntry().Message, "b (utf8mb4 to latin1)")
+}
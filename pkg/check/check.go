@@ -5,6 +5,11 @@ package check
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,15 +31,81 @@ const (
 	ScopeTesting     ScopeFlag = 1 << 5
 )
 
+// scopeNames are the names of the scopes that can be parsed by ParseScopes.
+var scopeNames = map[string]ScopeFlag{
+	"pre-run":      ScopePreRun,
+	"preflight":    ScopePreflight,
+	"post-setup":   ScopePostSetup,
+	"cutover":      ScopeCutover,
+	"post-cutover": ScopePostCutover,
+}
+
+// ParseScopes combines scope names, i.e. "preflight" and "cutover",
+// into a single ScopeFlag. It returns an error for an unknown name.
+func ParseScopes(names []string) (ScopeFlag, error) {
+	var scope ScopeFlag
+	for _, name := range names {
+		flag, ok := scopeNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return ScopeNone, fmt.Errorf("unknown check scope %q", name)
+		}
+		scope |= flag
+	}
+	return scope, nil
+}
+
+// String returns the names of the scopes in the flag, i.e. "post-setup,cutover".
+func (s ScopeFlag) String() string {
+	var names []string
+	for _, name := range []string{"pre-run", "preflight", "post-setup", "cutover", "post-cutover"} {
+		if s&scopeNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	if s&ScopeTesting != 0 {
+		names = append(names, "testing")
+	}
+	return strings.Join(names, ",")
+}
+
 type Resources struct {
 	DB                   *sql.DB
 	Replica              *sql.DB
+	ReplicationDB        *sql.DB // connected as the binary log user, if it is not the migration user
 	Table                *table.TableInfo
 	Statement            *statement.AbstractStatement
 	TargetChunkTime      time.Duration
 	Threads              int
 	ReplicaMaxLag        time.Duration
 	SkipDropAfterCutover bool
+	// TableNamer derives the names of the new, old,
+	// checkpoint and sentinel tables.
+	TableNamer TableNamer
+	// LongTransactionThreshold is the age of an open transaction
+	// that fails the cutover check. Zero disables the check.
+	LongTransactionThreshold time.Duration
+	// CutoverLockBudget is how long the cutover may stall queries on the
+	// table. Zero disables the check that estimates it.
+	CutoverLockBudget time.Duration
+	// ExpectedIndexes are the names of the secondary indexes that the new
+	// table must have after it is altered. Nil disables the check.
+	ExpectedIndexes []string
+	// RequireFullRowMetadata fails the preflight checks if binlog_row_metadata
+	// is not FULL. Otherwise it is only a warning.
+	RequireFullRowMetadata bool
+	// AllowLossyCharset lets the new table have columns with a character
+	// set that can not hold every character of the table, which is
+	// otherwise a failure. It is then only a warning.
+	AllowLossyCharset bool
+	// ReadOnly fails the checks that would write, instead of running them,
+	// since DB may be connected to a read-only node.
+	ReadOnly bool
+	// SkipScopes are the scopes for which RunChecks does not run any checks,
+	// i.e. in an environment where they can not succeed.
+	SkipScopes ScopeFlag
+	// MaxChunkSize is the maximum number of rows in a chunk of the copy.
+	// Zero is table.MaxDynamicRowSize, the maximum of the chunker.
+	MaxChunkSize uint64
 	// The following resources are only used by the
 	// pre-run checks
 	Host     string
@@ -42,9 +113,25 @@ type Resources struct {
 	Password string
 }
 
+// CheckFunc is a check that returns an error if the migration should not proceed.
+type CheckFunc func(context.Context, Resources, loggers.Advanced) error
+
+// Severity is what RunChecks does when a check returns an error.
+type Severity string
+
+const (
+	// SeverityError fails the checks with the error. All built-in checks
+	// have this severity.
+	SeverityError Severity = "error"
+	// SeverityWarning logs the error as a warning, and continues
+	// with the next check.
+	SeverityWarning Severity = "warning"
+)
+
 type check struct {
-	callback func(context.Context, Resources, loggers.Advanced) error
+	callback CheckFunc
 	scope    ScopeFlag
+	severity Severity
 }
 
 var (
@@ -52,26 +139,92 @@ var (
 	lock   sync.Mutex
 )
 
+// CheckDescriptor describes a registered check.
+type CheckDescriptor struct {
+	Name     string
+	Scope    ScopeFlag
+	Severity Severity
+}
+
+// ListChecks returns the registered checks, including any custom
+// checks registered with RegisterCheck, sorted by name.
+func ListChecks() []CheckDescriptor {
+	lock.Lock()
+	defer lock.Unlock()
+	descriptors := make([]CheckDescriptor, 0, len(checks))
+	for name, check := range checks {
+		descriptors = append(descriptors, CheckDescriptor{Name: name, Scope: check.scope, Severity: check.severity})
+	}
+	sort.Slice(descriptors, func(i, j int) bool {
+		return descriptors[i].Name < descriptors[j].Name
+	})
+	return descriptors
+}
+
+// RegisterCheck registers a custom check that runs alongside the built-in checks
+// for the given scope. It is intended for code that embeds spirit and needs
+// environment-specific checks. The severity decides whether an error from the
+// check fails the checks or is only logged. It returns an error if the name is
+// empty or already registered, the callback is nil, or the severity is unknown.
+func RegisterCheck(name string, callback CheckFunc, scope ScopeFlag, severity Severity) error {
+	if name == "" {
+		return errors.New("check name must not be empty")
+	}
+	if callback == nil {
+		return fmt.Errorf("check %q has no callback", name)
+	}
+	switch severity {
+	case SeverityError, SeverityWarning:
+	default:
+		return fmt.Errorf("unknown check severity %q", severity)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if _, exists := checks[name]; exists {
+		return fmt.Errorf("check %q is already registered", name)
+	}
+	if checks == nil {
+		checks = make(map[string]check)
+	}
+	checks[name] = check{callback: callback, scope: scope, severity: severity}
+	return nil
+}
+
 // registerCheck registers a check (callback func) and a scope (aka time) that it is expected to be run
-func registerCheck(name string, callback func(context.Context, Resources, loggers.Advanced) error, scope ScopeFlag) {
+func registerCheck(name string, callback CheckFunc, scope ScopeFlag) {
 	lock.Lock()
 	defer lock.Unlock()
 	if checks == nil {
 		checks = make(map[string]check)
 	}
-	checks[name] = check{callback: callback, scope: scope}
+	checks[name] = check{callback: callback, scope: scope, severity: SeverityError}
 }
 
-// RunChecks runs all checks that are registered for the given scope
+// RunChecks runs all checks that are registered for the given scope.
+// The scope may combine several scopes, i.e. ScopePreflight|ScopeCutover.
+// Scopes in r.SkipScopes are not run.
 func RunChecks(ctx context.Context, r Resources, logger loggers.Advanced, scope ScopeFlag) error {
-	for _, check := range checks {
+	scope &^= r.SkipScopes
+	if scope == ScopeNone {
+		return nil
+	}
+	// Checks may be registered while the checks are running.
+	lock.Lock()
+	registered := maps.Clone(checks)
+	lock.Unlock()
+	for name, check := range registered {
 		if check.scope&scope == 0 {
 			continue
 		}
 		err := check.callback(ctx, r, logger)
-		if err != nil {
-			return err
+		if err == nil {
+			continue
+		}
+		if check.severity == SeverityWarning {
+			logger.Warnf("check %s failed: %v", name, err)
+			continue
 		}
+		return err
 	}
 	return nil
 }
@@ -2,10 +2,14 @@ package check
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/siddontang/loggers"
 	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,3 +36,165 @@ func TestCheckAPI(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "newval", testVal)
 }
+
+func TestListChecks(t *testing.T) {
+	listed := make(map[string]ScopeFlag)
+	var names []string
+	for _, descriptor := range ListChecks() {
+		listed[descriptor.Name] = descriptor.Scope
+		names = append(names, descriptor.Name)
+		assert.Equal(t, SeverityError, descriptor.Severity, descriptor.Name)
+	}
+	assert.IsIncreasing(t, names)
+	builtin := map[string]ScopeFlag{
+		"addforeignkey":       ScopePreflight,
+		"binlogrowmetadata":   ScopePreflight,
+		"configuration":       ScopePreflight,
+		"cutoverlock":         ScopePreflight,
+		"dropadd":             ScopePreflight,
+		"generatedcolumns":    ScopePreflight,
+		"hasforeignkeys":      ScopePreflight,
+		"illegalClause":       ScopePreflight,
+		"longtransactions":    ScopeCutover,
+		"maxallowedpacket":    ScopePreflight,
+		"newtablecolumns":     ScopePostSetup,
+		"newtablecolumntypes": ScopePostSetup,
+		"charset":             ScopePostSetup,
+		"newtableindexes":     ScopePostSetup,
+		"primarykey":          ScopePreflight,
+		"privileges":          ScopePreflight,
+		"rename":              ScopePreflight,
+		"replica":             ScopePreflight,
+		"replicahealth":       ScopePostSetup | ScopeCutover,
+		"settings":            ScopePreflight,
+		"tablename":           ScopePreflight,
+		"version":             ScopePreRun,
+	}
+	for name, scope := range builtin {
+		assert.Contains(t, listed, name)
+		assert.Equal(t, scope, listed[name], name)
+	}
+	assert.Equal(t, "post-setup,cutover", listed["replicahealth"].String())
+	assert.Equal(t, "pre-run", listed["version"].String())
+	assert.Empty(t, ScopeNone.String())
+}
+
+func TestRunChecksScopes(t *testing.T) {
+	var ran []string
+	recorder := func(name string) CheckFunc {
+		return func(_ context.Context, _ Resources, _ loggers.Advanced) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	// Replace the registered checks, since the built-in
+	// checks can not run without a database.
+	lock.Lock()
+	builtin := checks
+	checks = map[string]check{
+		"preflight": {callback: recorder("preflight"), scope: ScopePreflight},
+		"cutover":   {callback: recorder("cutover"), scope: ScopeCutover},
+		"both":      {callback: recorder("both"), scope: ScopePreflight | ScopePostCutover},
+	}
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		checks = builtin
+		lock.Unlock()
+	}()
+
+	// Only the chosen scope runs.
+	assert.NoError(t, RunChecks(context.Background(), Resources{}, logrus.New(), ScopeCutover))
+	assert.Equal(t, []string{"cutover"}, ran)
+
+	// Several scopes can be run together.
+	ran = nil
+	assert.NoError(t, RunChecks(context.Background(), Resources{}, logrus.New(), ScopeCutover|ScopePostCutover))
+	assert.ElementsMatch(t, []string{"cutover", "both"}, ran)
+
+	// Skipped scopes do not run.
+	ran = nil
+	assert.NoError(t, RunChecks(context.Background(), Resources{SkipScopes: ScopePreflight}, logrus.New(), ScopePreflight))
+	assert.Empty(t, ran)
+	assert.NoError(t, RunChecks(context.Background(), Resources{SkipScopes: ScopePreflight}, logrus.New(), ScopePreflight|ScopePostCutover))
+	assert.Equal(t, []string{"both"}, ran) // it is also in a scope that is not skipped.
+}
+
+func TestParseScopes(t *testing.T) {
+	scope, err := ParseScopes(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ScopeNone, scope)
+
+	scope, err = ParseScopes([]string{"preflight", " Cutover"})
+	assert.NoError(t, err)
+	assert.Equal(t, ScopePreflight|ScopeCutover, scope)
+
+	scope, err = ParseScopes([]string{"pre-run", "post-setup", "post-cutover"})
+	assert.NoError(t, err)
+	assert.Equal(t, ScopePreRun|ScopePostSetup|ScopePostCutover, scope)
+
+	_, err = ParseScopes([]string{"preflight", "testing"})
+	assert.ErrorContains(t, err, `unknown check scope "testing"`)
+}
+
+func TestRegisterCheck(t *testing.T) {
+	var called int
+	custom := func(_ context.Context, r Resources, _ loggers.Advanced) error {
+		called++
+		if r.Threads > 8 {
+			return errors.New("org policy allows at most 8 threads")
+		}
+		return nil
+	}
+	assert.NoError(t, RegisterCheck("custompolicy", custom, ScopeTesting, SeverityError))
+	defer func() {
+		lock.Lock()
+		delete(checks, "custompolicy")
+		lock.Unlock()
+	}()
+
+	// Duplicate names are rejected, including built-in checks.
+	assert.ErrorContains(t, RegisterCheck("custompolicy", custom, ScopeTesting, SeverityError), `check "custompolicy" is already registered`)
+	assert.Error(t, RegisterCheck("privileges", custom, ScopePreflight, SeverityError))
+
+	// Invalid checks are rejected, rather than failing when they are run.
+	assert.ErrorContains(t, RegisterCheck("", custom, ScopeTesting, SeverityError), "must not be empty")
+	assert.ErrorContains(t, RegisterCheck("nilcallback", nil, ScopeTesting, SeverityError), "has no callback")
+	assert.ErrorContains(t, RegisterCheck("badseverity", custom, ScopeTesting, "fatal"), `unknown check severity "fatal"`)
+
+	assert.NoError(t, RunChecks(context.Background(), Resources{Threads: 4}, logrus.New(), ScopeTesting))
+	assert.ErrorContains(t, RunChecks(context.Background(), Resources{Threads: 16}, logrus.New(), ScopeTesting), "at most 8 threads")
+	assert.Equal(t, 2, called)
+
+	// A check with a warning severity only logs its error.
+	lock.Lock()
+	delete(checks, "custompolicy")
+	lock.Unlock()
+	assert.NoError(t, RegisterCheck("custompolicy", custom, ScopeTesting, SeverityWarning))
+	logger, hook := test.NewNullLogger()
+	assert.NoError(t, RunChecks(context.Background(), Resources{Threads: 16}, logger, ScopeTesting))
+	assert.Equal(t, 3, called)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "check custompolicy failed: org policy allows at most 8 threads", hook.LastEntry().Message)
+}
+
+func TestRunChecksConcurrentRegister(t *testing.T) {
+	noop := func(context.Context, Resources, loggers.Advanced) error { return nil }
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			assert.NoError(t, RegisterCheck(fmt.Sprintf("concurrent%d", i), noop, ScopeTesting, SeverityError))
+		}
+	}()
+	for range 100 {
+		assert.NoError(t, RunChecks(context.Background(), Resources{}, logrus.New(), ScopeTesting))
+	}
+	wg.Wait()
+	lock.Lock()
+	for i := range 100 {
+		delete(checks, fmt.Sprintf("concurrent%d", i))
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,80 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/dbconn/sqlescape"
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("cutoverlock", cutoverLockCheck, ScopePreflight)
+}
+
+// measureLockTime returns how long it takes to acquire a write lock on the
+// table, which approximates how long the cutover will stall queries while it
+// waits for its lock. It is a variable so tests can simulate lock timings.
+var measureLockTime = func(ctx context.Context, db *sql.DB, schemaName, tableName string, timeout time.Duration) (time.Duration, error) {
+	// LOCK TABLES applies to the session, so it
+	// must use the same connection as UNLOCK TABLES.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	// The connection is returned to the pool, so the
+	// lock_wait_timeout must be restored after the check.
+	var lockWaitTimeout int64
+	if err := conn.QueryRowContext(ctx, "SELECT @@SESSION.lock_wait_timeout").Scan(&lockWaitTimeout); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SET SESSION lock_wait_timeout = ?", lockWaitTimeout); err != nil {
+			// Discard the connection instead of returning it to the pool.
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+	// Don't wait for the lock for longer than the budget, since
+	// queries on the table are blocked while we are waiting.
+	if _, err := conn.ExecContext(ctx, "SET SESSION lock_wait_timeout = ?", int64(math.Ceil(timeout.Seconds()))); err != nil {
+		return 0, err
+	}
+	startTime := time.Now()
+	if _, err := conn.ExecContext(ctx, sqlescape.MustEscapeSQL("LOCK TABLES %n.%n WRITE", schemaName, tableName)); err != nil {
+		return time.Since(startTime), err
+	}
+	lockTime := time.Since(startTime)
+	_, err = conn.ExecContext(ctx, "UNLOCK TABLES")
+	return lockTime, err
+}
+
+// cutoverLockCheck briefly acquires a write lock on the table to estimate
+// how long the cutover will stall queries on it, and warns if it exceeds the
+// CutoverLockBudget. It does not fail the migration, since the load at the
+// time of the cutover may be different. A zero budget disables the check.
+func cutoverLockCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	if r.CutoverLockBudget <= 0 {
+		return nil
+	}
+	if r.ReadOnly {
+		return errors.New("the cutover lock check takes a write lock on the table, which is not allowed in read-only safe mode. Disable the check with --cutover-lock-budget=0")
+	}
+	lockTime, err := measureLockTime(ctx, r.DB, r.Table.SchemaName, r.Table.TableName, r.CutoverLockBudget)
+	if err != nil {
+		logger.Warnf("could not acquire a lock on %s.%s to estimate the cutover stall within %s: %v. Consider scheduling the cutover when the table is less busy, i.e. with --defer-cutover",
+			r.Table.SchemaName, r.Table.TableName, r.CutoverLockBudget, err)
+		return nil
+	}
+	if lockTime > r.CutoverLockBudget {
+		logger.Warnf("estimated cutover stall on %s.%s is %s, which exceeds the budget of %s. Consider scheduling the cutover when the table is less busy, i.e. with --defer-cutover",
+			r.Table.SchemaName, r.Table.TableName, lockTime, r.CutoverLockBudget)
+		return nil
+	}
+	logger.Infof("estimated cutover stall on %s.%s is %s (budget: %s)", r.Table.SchemaName, r.Table.TableName, lockTime, r.CutoverLockBudget)
+	return nil
+}
@@ -0,0 +1,97 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// simulateLockTime replaces measureLockTime for the duration of the test.
+func simulateLockTime(t *testing.T, lockTime time.Duration, err error) *int {
+	var calls int
+	original := measureLockTime
+	measureLockTime = func(ctx context.Context, db *sql.DB, schemaName, tableName string, timeout time.Duration) (time.Duration, error) {
+		calls++
+		return lockTime, err
+	}
+	t.Cleanup(func() { measureLockTime = original })
+	return &calls
+}
+
+func TestCutoverLockSimulated(t *testing.T) {
+	r := Resources{
+		Table: &table.TableInfo{TableName: "cutoverlockt1", SchemaName: "test"},
+	}
+
+	// Disabled by default.
+	logger, hook := test.NewNullLogger()
+	calls := simulateLockTime(t, time.Hour, nil)
+	assert.NoError(t, cutoverLockCheck(context.Background(), r, logger))
+	assert.Equal(t, 0, *calls)
+	assert.Empty(t, hook.AllEntries())
+
+	// A fast lock is within the budget.
+	r.CutoverLockBudget = time.Second
+	simulateLockTime(t, 5*time.Millisecond, nil)
+	assert.NoError(t, cutoverLockCheck(context.Background(), r, logger))
+	assert.Equal(t, logrus.InfoLevel, hook.LastEntry().Level)
+	assert.Equal(t, "estimated cutover stall on test.cutoverlockt1 is 5ms (budget: 1s)", hook.LastEntry().Message)
+
+	// A slow lock warns, but does not fail the migration.
+	simulateLockTime(t, 3*time.Second, nil)
+	assert.NoError(t, cutoverLockCheck(context.Background(), r, logger))
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Contains(t, hook.LastEntry().Message, "estimated cutover stall on test.cutoverlockt1 is 3s, which exceeds the budget of 1s")
+
+	// So does a lock that times out.
+	simulateLockTime(t, time.Second, errors.New("Error 1205 (HY000): Lock wait timeout exceeded; try restarting transaction"))
+	assert.NoError(t, cutoverLockCheck(context.Background(), r, logger))
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Contains(t, hook.LastEntry().Message, "could not acquire a lock on test.cutoverlockt1 to estimate the cutover stall within 1s")
+
+	// In read-only safe mode the lock is not taken.
+	r.ReadOnly = true
+	calls = simulateLockTime(t, 5*time.Millisecond, nil)
+	assert.ErrorContains(t, cutoverLockCheck(context.Background(), r, logger), "not allowed in read-only safe mode")
+	assert.Equal(t, 0, *calls)
+}
+
+func TestCutoverLock(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS cutoverlockt1")
+	testutils.RunSQL(t, "CREATE TABLE cutoverlockt1 (a INT NOT NULL PRIMARY KEY)")
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Use a single connection, to check that its lock_wait_timeout is restored.
+	db.SetMaxOpenConns(1)
+	var lockWaitTimeout int64
+	assert.NoError(t, db.QueryRow("SELECT @@SESSION.lock_wait_timeout").Scan(&lockWaitTimeout))
+
+	lockTime, err := measureLockTime(context.Background(), db, "test", "cutoverlockt1", time.Second)
+	assert.NoError(t, err)
+	assert.Less(t, lockTime, time.Second)
+
+	var restored int64
+	assert.NoError(t, db.QueryRow("SELECT @@SESSION.lock_wait_timeout").Scan(&restored))
+	assert.Equal(t, lockWaitTimeout, restored)
+	db.SetMaxOpenConns(0)
+
+	// A conflicting lock makes it wait until the timeout.
+	trx, err := db.Begin()
+	assert.NoError(t, err)
+	defer trx.Rollback() //nolint: errcheck
+	_, err = trx.Exec("INSERT INTO cutoverlockt1 VALUES (1)")
+	assert.NoError(t, err)
+	lockTime, err = measureLockTime(context.Background(), db, "test", "cutoverlockt1", time.Second)
+	assert.ErrorContains(t, err, "Lock wait timeout exceeded")
+	assert.GreaterOrEqual(t, lockTime, time.Second)
+}
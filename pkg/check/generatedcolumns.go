@@ -0,0 +1,109 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("generatedcolumns", generatedColumnsCheck, ScopePreflight)
+}
+
+var quotedIdentifier = regexp.MustCompile("`((?:[^`]|``)+)`")
+
+// generatedColumnsCheck inspects the generated columns on the table and
+// validates that they can still be populated after the alter is applied.
+// Generated columns are not copied, they are recalculated in the new table,
+// so a column that a generated column depends on must not be removed,
+// and a generated column must not become a regular column.
+func generatedColumnsCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	rows, err := r.DB.QueryContext(ctx, "SELECT column_name, generation_expression FROM information_schema.columns WHERE table_schema=? AND table_name=? AND generation_expression != ''",
+		r.Table.SchemaName, r.Table.TableName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	generated := make(map[string]string)
+	for rows.Next() {
+		var name, expression string
+		if err := rows.Scan(&name, &expression); err != nil {
+			return err
+		}
+		generated[name] = expression
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	if len(generated) == 0 {
+		return nil // no generated columns
+	}
+	alterStmt, ok := (*r.Statement.StmtNode).(*ast.AlterTableStmt)
+	if !ok {
+		return errors.New("not a valid alter table statement")
+	}
+	return generatedColumnsSatisfiable(generated, alterStmt, logger)
+}
+
+// generatedColumnsSatisfiable checks the generated columns (a map of column name
+// to generation expression) against the specs in the alter statement.
+func generatedColumnsSatisfiable(generated map[string]string, alterStmt *ast.AlterTableStmt, logger loggers.Advanced) error {
+	isGenerated := make(map[string]bool)
+	for name := range generated {
+		isGenerated[strings.ToLower(name)] = true
+	}
+	removed := make(map[string]string) // column -> "dropped" or "renamed"
+	redefined := make(map[string]bool)
+	for _, spec := range alterStmt.Specs {
+		switch spec.Tp {
+		case ast.AlterTableDropColumn:
+			removed[strings.ToLower(spec.OldColumnName.Name.O)] = "dropped"
+		case ast.AlterTableRenameColumn:
+			removed[strings.ToLower(spec.OldColumnName.Name.O)] = "renamed"
+		case ast.AlterTableChangeColumn, ast.AlterTableModifyColumn:
+			col := spec.NewColumns[0]
+			oldName := col.Name.Name.O
+			if spec.OldColumnName != nil {
+				oldName = spec.OldColumnName.Name.O
+			}
+			if !strings.EqualFold(oldName, col.Name.Name.O) {
+				removed[strings.ToLower(oldName)] = "renamed"
+			}
+			if !isGenerated[strings.ToLower(oldName)] {
+				continue
+			}
+			if !columnIsGenerated(col) {
+				return fmt.Errorf("generated column %s can not be converted to a regular column: its values are not copied to the new table", oldName)
+			}
+			redefined[strings.ToLower(oldName)] = true
+			logger.Warnf("generated column %s is redefined: its values will be recalculated in the new table", oldName)
+		}
+	}
+	for name, expression := range generated {
+		if removed[strings.ToLower(name)] != "" || redefined[strings.ToLower(name)] {
+			continue // the generated column itself is changed
+		}
+		for _, match := range quotedIdentifier.FindAllStringSubmatch(expression, -1) {
+			ref := strings.ReplaceAll(match[1], "``", "`")
+			if reason := removed[strings.ToLower(ref)]; reason != "" {
+				return fmt.Errorf("generated column %s references column %s, which is %s", name, ref, reason)
+			}
+		}
+	}
+	return nil
+}
+
+func columnIsGenerated(col *ast.ColumnDef) bool {
+	for _, opt := range col.Options {
+		if opt.Tp == ast.ColumnOptionGenerated {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/statement"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratedColumns(t *testing.T) {
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS generatedt1")
+	testutils.RunSQL(t, "CREATE TABLE generatedt1 (a INT NOT NULL, b INT, c INT AS (b * 2) STORED, PRIMARY KEY (a))")
+
+	r := Resources{
+		DB:        db,
+		Table:     &table.TableInfo{TableName: "generatedt1", SchemaName: "test"},
+		Statement: statement.MustNew("ALTER TABLE generatedt1 ADD COLUMN d INT"),
+	}
+	assert.NoError(t, generatedColumnsCheck(context.Background(), r, logrus.New()))
+
+	r.Statement = statement.MustNew("ALTER TABLE generatedt1 CHANGE b b2 INT")
+	assert.ErrorContains(t, generatedColumnsCheck(context.Background(), r, logrus.New()), "generated column c references column b, which is renamed")
+}
+
+func TestGeneratedColumnsSatisfiable(t *testing.T) {
+	generated := map[string]string{"c": "(`b` * 2)"}
+	check := func(alter string) error {
+		alterStmt := (*statement.MustNew(alter).StmtNode).(*ast.AlterTableStmt)
+		return generatedColumnsSatisfiable(generated, alterStmt, logrus.New())
+	}
+	assert.NoError(t, check("ALTER TABLE t1 ADD COLUMN d INT"))
+	assert.NoError(t, check("ALTER TABLE t1 MODIFY b BIGINT"))
+	assert.NoError(t, check("ALTER TABLE t1 DROP COLUMN a"))
+
+	// The referenced column is removed.
+	assert.ErrorContains(t, check("ALTER TABLE t1 DROP COLUMN b"), "generated column c references column b, which is dropped")
+	assert.ErrorContains(t, check("ALTER TABLE t1 RENAME COLUMN B TO b2"), "generated column c references column b, which is renamed")
+	assert.ErrorContains(t, check("ALTER TABLE t1 CHANGE b b2 INT"), "generated column c references column b, which is renamed")
+
+	// Unless the generated column is also dropped or redefined.
+	assert.NoError(t, check("ALTER TABLE t1 DROP COLUMN c, DROP COLUMN b"))
+	assert.NoError(t, check("ALTER TABLE t1 MODIFY c INT AS (a * 2) STORED, DROP COLUMN b"))
+
+	// A generated column can not become a regular column.
+	assert.ErrorContains(t, check("ALTER TABLE t1 MODIFY c INT"), "generated column c can not be converted to a regular column")
+}
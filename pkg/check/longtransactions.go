@@ -0,0 +1,68 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("longtransactions", longTransactionsCheck, ScopeCutover)
+}
+
+// longTransaction is an open transaction from information_schema.innodb_trx.
+type longTransaction struct {
+	id       string
+	threadID uint64
+	age      time.Duration
+}
+
+// longTransactionsCheck fails if any transaction has been open for longer than
+// the LongTransactionThreshold. The cutover needs an exclusive metadata lock on
+// the table, which can not be acquired while a transaction that has accessed
+// the table is open. Waiting for it also blocks all new queries on the table.
+// A zero threshold disables the check.
+func longTransactionsCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	if r.LongTransactionThreshold <= 0 {
+		return nil
+	}
+	rows, err := r.DB.QueryContext(ctx, `SELECT trx_id, trx_mysql_thread_id, TIMESTAMPDIFF(SECOND, trx_started, NOW())
+		FROM information_schema.innodb_trx
+		WHERE trx_started < NOW() - INTERVAL ? SECOND AND trx_mysql_thread_id != CONNECTION_ID()
+		ORDER BY trx_started`, int64(r.LongTransactionThreshold.Seconds()))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var trxs []longTransaction
+	for rows.Next() {
+		var trx longTransaction
+		var ageSeconds int64
+		if err := rows.Scan(&trx.id, &trx.threadID, &ageSeconds); err != nil {
+			return err
+		}
+		trx.age = time.Duration(ageSeconds) * time.Second
+		trxs = append(trxs, trx)
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	return longTransactionsError(trxs, r.LongTransactionThreshold)
+}
+
+// longTransactionsError returns an error naming each transaction,
+// or nil if there are none.
+func longTransactionsError(trxs []longTransaction, threshold time.Duration) error {
+	if len(trxs) == 0 {
+		return nil
+	}
+	descriptions := make([]string, 0, len(trxs))
+	for _, trx := range trxs {
+		descriptions = append(descriptions, fmt.Sprintf("trx_id=%s thread_id=%d age=%s", trx.id, trx.threadID, trx.age))
+	}
+	return fmt.Errorf("found %d transactions open for longer than %s, which may block the cutover: %s. Wait for them to complete, or kill them before retrying",
+		len(trxs), threshold, strings.Join(descriptions, ", "))
+}
@@ -0,0 +1,58 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLongTransactions(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS longtrxt1")
+	testutils.RunSQL(t, "CREATE TABLE longtrxt1 (a INT NOT NULL PRIMARY KEY)")
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+	r := Resources{
+		DB:    db,
+		Table: &table.TableInfo{TableName: "longtrxt1", SchemaName: "test"},
+	}
+	// The check is disabled by default.
+	assert.NoError(t, longTransactionsCheck(context.Background(), r, logrus.New()))
+
+	r.LongTransactionThreshold = time.Second
+	assert.NoError(t, longTransactionsCheck(context.Background(), r, logrus.New()))
+
+	trx, err := db.Begin()
+	assert.NoError(t, err)
+	defer trx.Rollback() //nolint: errcheck
+	_, err = trx.Exec("INSERT INTO longtrxt1 VALUES (1)")
+	assert.NoError(t, err)
+	var threadID uint64
+	assert.NoError(t, trx.QueryRow("SELECT CONNECTION_ID()").Scan(&threadID))
+
+	time.Sleep(2 * time.Second)
+	err = longTransactionsCheck(context.Background(), r, logrus.New())
+	assert.ErrorContains(t, err, "found 1 transactions open for longer than 1s")
+	assert.ErrorContains(t, err, fmt.Sprintf("thread_id=%d", threadID))
+
+	// Once the transaction is complete, the check passes.
+	assert.NoError(t, trx.Rollback())
+	assert.NoError(t, longTransactionsCheck(context.Background(), r, logrus.New()))
+}
+
+func TestLongTransactionsError(t *testing.T) {
+	assert.NoError(t, longTransactionsError(nil, time.Minute))
+
+	err := longTransactionsError([]longTransaction{
+		{id: "1234", threadID: 10, age: 5 * time.Minute},
+		{id: "421", threadID: 12, age: 90 * time.Second},
+	}, time.Minute)
+	assert.EqualError(t, err, "found 2 transactions open for longer than 1m0s, which may block the cutover: trx_id=1234 thread_id=10 age=5m0s, trx_id=421 thread_id=12 age=1m30s. Wait for them to complete, or kill them before retrying")
+}
@@ -0,0 +1,53 @@
+package check
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cashapp/spirit/pkg/repl"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("maxallowedpacket", maxAllowedPacketCheck, ScopePreflight)
+}
+
+// maxAllowedPacketCheck estimates the largest statement the copier or the
+// replication applier could send, and compares it to max_allowed_packet.
+// Exceeding the packet size otherwise fails mid-migration with an error
+// that is hard to attribute back to the configuration.
+func maxAllowedPacketCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	var maxAllowedPacket, avgRowLength uint64
+	if err := r.DB.QueryRowContext(ctx, "SELECT @@max_allowed_packet").Scan(&maxAllowedPacket); err != nil {
+		return err
+	}
+	err := r.DB.QueryRowContext(ctx, "SELECT IFNULL(avg_row_length,0) FROM information_schema.tables WHERE table_schema=? AND table_name=?",
+		r.Table.SchemaName, r.Table.TableName).Scan(&avgRowLength)
+	if err != nil {
+		return err
+	}
+	return maxAllowedPacketSufficient(maxAllowedPacket, avgRowLength, r.MaxChunkSize, logger)
+}
+
+// maxAllowedPacketSufficient returns an error if the estimated statement size
+// does not fit in maxAllowedPacket, and warns if it is more than half of it.
+// The estimate is the average row length multiplied by the largest number of
+// rows that either a copier chunk of at most maxChunkSize rows or a flush
+// batch may contain. A maxChunkSize of zero is table.MaxDynamicRowSize.
+func maxAllowedPacketSufficient(maxAllowedPacket, avgRowLength, maxChunkSize uint64, logger loggers.Advanced) error {
+	if maxChunkSize == 0 {
+		maxChunkSize = table.MaxDynamicRowSize
+	}
+	batchRows := max(maxChunkSize, uint64(repl.DefaultBatchSize))
+	estimatedSize := avgRowLength * batchRows
+	if estimatedSize >= maxAllowedPacket {
+		return fmt.Errorf("max_allowed_packet (%d) is too small: statements of up to %d rows with an average row length of %d bytes are estimated at %d bytes",
+			maxAllowedPacket, batchRows, avgRowLength, estimatedSize)
+	}
+	if estimatedSize >= maxAllowedPacket/2 {
+		logger.Warnf("max_allowed_packet (%d) is close to the estimated statement size of %d bytes. Consider increasing max_allowed_packet.",
+			maxAllowedPacket, estimatedSize)
+	}
+	return nil
+}
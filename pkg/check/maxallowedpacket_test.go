@@ -0,0 +1,47 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/repl"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxAllowedPacket(t *testing.T) {
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS maxpackett1")
+	testutils.RunSQL(t, "CREATE TABLE maxpackett1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+
+	r := Resources{
+		DB:    db,
+		Table: &table.TableInfo{TableName: "maxpackett1", SchemaName: "test"},
+	}
+	err = maxAllowedPacketCheck(context.Background(), r, logrus.New())
+	assert.NoError(t, err) // all looks good of course.
+}
+
+func TestMaxAllowedPacketBoundaries(t *testing.T) {
+	// The estimate is based on table.MaxDynamicRowSize rows.
+	rows := uint64(table.MaxDynamicRowSize)
+	logger := logrus.New()
+
+	assert.NoError(t, maxAllowedPacketSufficient(64*1024*1024, 0, 0, logger))
+	assert.NoError(t, maxAllowedPacketSufficient(100*rows+1, 100, 0, logger))
+	assert.Error(t, maxAllowedPacketSufficient(100*rows, 100, 0, logger))
+	assert.Error(t, maxAllowedPacketSufficient(100*rows-1, 100, 0, logger))
+	assert.ErrorContains(t, maxAllowedPacketSufficient(4*1024*1024, 1000, 0, logger), "max_allowed_packet (4194304) is too small")
+
+	// A configured maximum chunk size is used instead, but a
+	// flush batch can still have repl.DefaultBatchSize rows.
+	assert.NoError(t, maxAllowedPacketSufficient(4*1024*1024, 1000, 2000, logger))
+	assert.ErrorContains(t, maxAllowedPacketSufficient(4*1024*1024, 1000, 5000, logger), "statements of up to 5000 rows")
+	assert.ErrorContains(t, maxAllowedPacketSufficient(1000*1000, 1000, 10, logger), fmt.Sprintf("statements of up to %d rows", repl.DefaultBatchSize))
+}
@@ -0,0 +1,78 @@
+package check
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("newtablecolumns", newTableColumnsCheck, ScopePostSetup)
+}
+
+// newTableColumn is the definition of a column of the new table
+// that determines whether it can be left out of the copy.
+type newTableColumn struct {
+	name       string
+	nullable   bool
+	hasDefault bool
+	extra      string
+}
+
+// newTableColumnsCheck warns if the new table has a NOT NULL column without
+// a default that the copier does not populate. The copier only copies the
+// columns that are in both tables (see utils.IntersectNonGeneratedColumns),
+// so a column that is added or renamed by the alter is left to its default.
+// Without one, every row is copied with the implicit default of the type,
+// such as 0 or the empty string. That is the same as what MySQL does for
+// ADD COLUMN x INT NOT NULL, so it does not fail the migration, but for a
+// renamed column it is unlikely to be what was intended.
+func newTableColumnsCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	newName := r.TableNamer.NewName(r.Table.TableName)
+	rows, err := r.DB.QueryContext(ctx, "SELECT column_name, is_nullable, column_default IS NOT NULL, extra FROM information_schema.columns WHERE table_schema=? AND table_name=? ORDER BY ordinal_position",
+		r.Table.SchemaName, newName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var columns []newTableColumn
+	for rows.Next() {
+		var col newTableColumn
+		var isNullable string
+		if err := rows.Scan(&col.name, &isNullable, &col.hasDefault, &col.extra); err != nil {
+			return err
+		}
+		col.nullable = isNullable == "YES"
+		columns = append(columns, col)
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	if unpopulated := unpopulatedColumns(r.Table.NonGeneratedColumns, columns); len(unpopulated) > 0 {
+		logger.Warnf("new table %s has NOT NULL columns without a default that are not copied from the table: %s. They will be populated with the implicit default of their type",
+			newName, strings.Join(unpopulated, ", "))
+		return nil
+	}
+	logger.Infof("all NOT NULL columns of new table %s are populated by the copy", newName)
+	return nil
+}
+
+// unpopulatedColumns returns the columns of the new table that are NOT NULL
+// without a default, and are not one of the copied columns. Generated and
+// auto_increment columns are populated by MySQL.
+func unpopulatedColumns(copied []string, columns []newTableColumn) []string {
+	var unpopulated []string
+	for _, col := range columns {
+		if col.nullable || col.hasDefault || slices.Contains(copied, col.name) {
+			continue
+		}
+		extra := strings.ToLower(col.extra)
+		if strings.Contains(extra, "generated") || strings.Contains(extra, "auto_increment") {
+			continue
+		}
+		unpopulated = append(unpopulated, col.name)
+	}
+	return unpopulated
+}
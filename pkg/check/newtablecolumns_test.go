@@ -0,0 +1,56 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnpopulatedColumns(t *testing.T) {
+	columns := []newTableColumn{
+		{name: "id", extra: "auto_increment"},
+		{name: "a"},
+		{name: "b", nullable: true},
+		{name: "c", hasDefault: true},
+		{name: "d", extra: "VIRTUAL GENERATED"},
+		{name: "e"},
+	}
+	assert.Equal(t, []string{"e"}, unpopulatedColumns([]string{"id", "a"}, columns))
+	assert.Equal(t, []string{"a", "e"}, unpopulatedColumns([]string{"id"}, columns))
+	assert.Empty(t, unpopulatedColumns([]string{"id", "a", "e"}, columns))
+}
+
+func TestNewTableColumns(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS newcolt1, _newcolt1_new")
+	testutils.RunSQL(t, "CREATE TABLE newcolt1 (id INT NOT NULL PRIMARY KEY, a INT NOT NULL, b INT)")
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+	r := Resources{
+		DB: db,
+		Table: &table.TableInfo{
+			TableName:           "newcolt1",
+			SchemaName:          "test",
+			NonGeneratedColumns: []string{"id", "a", "b"},
+		},
+	}
+
+	// Columns that are added with a default or as nullable are fine.
+	testutils.RunSQL(t, "CREATE TABLE _newcolt1_new LIKE newcolt1")
+	testutils.RunSQL(t, "ALTER TABLE _newcolt1_new ADD COLUMN c INT NOT NULL DEFAULT 0, ADD COLUMN d INT, ADD COLUMN e INT AS (a + 1), DROP COLUMN b")
+	assert.NoError(t, newTableColumnsCheck(context.Background(), r, logrus.New()))
+
+	// A NOT NULL column without a default is not populated by the copy,
+	// which warns but does not fail, since MySQL would do the same.
+	testutils.RunSQL(t, "ALTER TABLE _newcolt1_new ADD COLUMN f INT NOT NULL, CHANGE COLUMN a renamed INT NOT NULL")
+	logger, hook := test.NewNullLogger()
+	assert.NoError(t, newTableColumnsCheck(context.Background(), r, logger))
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "new table _newcolt1_new has NOT NULL columns without a default that are not copied from the table: renamed, f. They will be populated with the implicit default of their type", hook.LastEntry().Message)
+}
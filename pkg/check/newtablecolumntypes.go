@@ -0,0 +1,167 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("newtablecolumntypes", newTableColumnTypesCheck, ScopePostSetup)
+}
+
+// columnType is the definition of a column, as read from information_schema.
+type columnType struct {
+	dataType          string // i.e. varchar
+	columnType        string // i.e. varchar(255)
+	maxLength         int64  // of string and binary types
+	precision         int64  // of numeric types
+	scale             int64  // of numeric types
+	datetimePrecision int64  // fractional seconds of temporal types
+}
+
+// integerRanks orders the integer types by the values they can hold.
+var integerRanks = map[string]int{"tinyint": 1, "smallint": 2, "mediumint": 3, "int": 4, "bigint": 5}
+
+// typeFamilies groups the types that can be converted to each
+// other, if the new type is at least as large as the old type.
+var typeFamilies = map[string]string{
+	"tinyint": "integer", "smallint": "integer", "mediumint": "integer", "int": "integer", "bigint": "integer",
+	"decimal": "decimal",
+	"float":   "float", "double": "float",
+	"char": "string", "varchar": "string", "tinytext": "string", "text": "string", "mediumtext": "string", "longtext": "string",
+	"binary": "binary", "varbinary": "binary", "tinyblob": "binary", "blob": "binary", "mediumblob": "binary", "longblob": "binary",
+	"date": "temporal", "datetime": "temporal", "timestamp": "temporal",
+	"enum": "enum", "set": "set",
+}
+
+// newTableColumnTypesCheck warns if a column of the new table may not be able
+// to hold all of the values of the same column in the table, i.e. after an
+// alter that changes an INT to a TINYINT. The copier and the replication
+// client run with an empty sql_mode, so a value that does not fit is
+// truncated without an error. It is only a warning, since an alter may
+// narrow a column that is known to only hold values that fit; if they do
+// not, the checksum detects it.
+func newTableColumnTypesCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	newName := r.TableNamer.NewName(r.Table.TableName)
+	oldTypes, err := columnTypes(ctx, r.DB, r.Table.SchemaName, r.Table.TableName)
+	if err != nil {
+		return err
+	}
+	newTypes, err := columnTypes(ctx, r.DB, r.Table.SchemaName, newName)
+	if err != nil {
+		return err
+	}
+	var narrowed []string
+	for _, col := range r.Table.NonGeneratedColumns {
+		src, ok := oldTypes[col]
+		if !ok {
+			continue
+		}
+		dst, ok := newTypes[col]
+		if !ok {
+			continue // the column is not copied.
+		}
+		if reason := narrowingConversion(src, dst); reason != "" {
+			narrowed = append(narrowed, fmt.Sprintf("%s (%s)", col, reason))
+		}
+	}
+	if len(narrowed) > 0 {
+		logger.Warnf("new table %s has columns that may not hold every value of the table, which would be truncated when rows are copied or changes are applied: %s",
+			newName, strings.Join(narrowed, ", "))
+		return nil
+	}
+	logger.Infof("all columns of new table %s can hold the values of the table", newName)
+	return nil
+}
+
+// columnTypes returns the types of the columns of the table, by name.
+func columnTypes(ctx context.Context, db *sql.DB, schemaName, tableName string) (map[string]columnType, error) {
+	rows, err := db.QueryContext(ctx, `SELECT column_name, data_type, column_type, IFNULL(character_maximum_length, 0),
+		IFNULL(numeric_precision, 0), IFNULL(numeric_scale, 0), IFNULL(datetime_precision, 0)
+		FROM information_schema.columns WHERE table_schema=? AND table_name=?`, schemaName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	types := make(map[string]columnType)
+	for rows.Next() {
+		var name string
+		var tp columnType
+		if err := rows.Scan(&name, &tp.dataType, &tp.columnType, &tp.maxLength, &tp.precision, &tp.scale, &tp.datetimePrecision); err != nil {
+			return nil, err
+		}
+		tp.dataType = strings.ToLower(tp.dataType)
+		tp.columnType = strings.ToLower(tp.columnType)
+		types[name] = tp
+	}
+	return types, rows.Err()
+}
+
+// narrowingConversion returns why dst may not be able to hold all of the
+// values of src, or an empty string if it can.
+func narrowingConversion(src, dst columnType) string {
+	if src.columnType == dst.columnType {
+		return ""
+	}
+	changed := fmt.Sprintf("%s to %s", src.columnType, dst.columnType)
+	family, ok := typeFamilies[src.dataType]
+	if !ok || family != typeFamilies[dst.dataType] {
+		// Types that are not known to be compatible, such as
+		// VARCHAR to INT, or a change of the width of a BIT.
+		return changed
+	}
+	switch family {
+	case "integer":
+		srcUnsigned := strings.Contains(src.columnType, "unsigned")
+		dstUnsigned := strings.Contains(dst.columnType, "unsigned")
+		srcRank, dstRank := integerRanks[src.dataType], integerRanks[dst.dataType]
+		if dstRank < srcRank || (!srcUnsigned && dstUnsigned) || (srcUnsigned && !dstUnsigned && dstRank == srcRank) {
+			return changed
+		}
+	case "decimal":
+		if dst.precision-dst.scale < src.precision-src.scale || dst.scale < src.scale {
+			return changed
+		}
+	case "float":
+		if src.dataType == "double" && dst.dataType == "float" {
+			return changed
+		}
+	case "string", "binary":
+		// A change of character set is checked by the charset check.
+		if dst.maxLength < src.maxLength {
+			return changed
+		}
+	case "temporal":
+		// A DATETIME can hold every DATE and TIMESTAMP, but a TIMESTAMP
+		// has a smaller range, and a DATE does not hold the time.
+		if src.dataType != dst.dataType && dst.dataType != "datetime" {
+			return changed
+		}
+		if dst.datetimePrecision < src.datetimePrecision {
+			return changed
+		}
+	case "enum", "set":
+		dstMembers := enumMembers(dst.columnType)
+		for _, member := range enumMembers(src.columnType) {
+			if !slices.Contains(dstMembers, member) {
+				return changed
+			}
+		}
+	}
+	return ""
+}
+
+// enumMembers returns the members of an ENUM or SET column
+// type, i.e. enum('a','b'), as they are escaped in the type.
+func enumMembers(colType string) []string {
+	start, end := strings.Index(colType, "('"), strings.LastIndex(colType, "')")
+	if start < 0 || end < start {
+		return nil
+	}
+	return strings.Split(colType[start+2:end], "','")
+}
@@ -0,0 +1,68 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNarrowingConversion(t *testing.T) {
+	for _, tc := range []struct {
+		src, dst  columnType
+		narrowing string
+	}{
+		{columnType{dataType: "int", columnType: "int"}, columnType{dataType: "bigint", columnType: "bigint"}, ""},
+		{columnType{dataType: "int", columnType: "int"}, columnType{dataType: "tinyint", columnType: "tinyint"}, "int to tinyint"},
+		{columnType{dataType: "int", columnType: "int"}, columnType{dataType: "int", columnType: "int unsigned"}, "int to int unsigned"},
+		{columnType{dataType: "int", columnType: "int unsigned"}, columnType{dataType: "int", columnType: "int"}, "int unsigned to int"},
+		{columnType{dataType: "int", columnType: "int unsigned"}, columnType{dataType: "bigint", columnType: "bigint"}, ""},
+		{columnType{dataType: "decimal", columnType: "decimal(10,2)", precision: 10, scale: 2}, columnType{dataType: "decimal", columnType: "decimal(12,4)", precision: 12, scale: 4}, ""},
+		{columnType{dataType: "decimal", columnType: "decimal(10,2)", precision: 10, scale: 2}, columnType{dataType: "decimal", columnType: "decimal(10,4)", precision: 10, scale: 4}, "decimal(10,2) to decimal(10,4)"},
+		{columnType{dataType: "double", columnType: "double"}, columnType{dataType: "float", columnType: "float"}, "double to float"},
+		{columnType{dataType: "varchar", columnType: "varchar(255)", maxLength: 255}, columnType{dataType: "text", columnType: "text", maxLength: 65535}, ""},
+		{columnType{dataType: "varchar", columnType: "varchar(255)", maxLength: 255}, columnType{dataType: "varchar", columnType: "varchar(100)", maxLength: 100}, "varchar(255) to varchar(100)"},
+		{columnType{dataType: "varchar", columnType: "varchar(10)", maxLength: 10}, columnType{dataType: "int", columnType: "int"}, "varchar(10) to int"},
+		{columnType{dataType: "date", columnType: "date"}, columnType{dataType: "datetime", columnType: "datetime"}, ""},
+		{columnType{dataType: "datetime", columnType: "datetime(6)", datetimePrecision: 6}, columnType{dataType: "timestamp", columnType: "timestamp(6)", datetimePrecision: 6}, "datetime(6) to timestamp(6)"},
+		{columnType{dataType: "datetime", columnType: "datetime(6)", datetimePrecision: 6}, columnType{dataType: "datetime", columnType: "datetime", datetimePrecision: 0}, "datetime(6) to datetime"},
+		{columnType{dataType: "enum", columnType: "enum('a','b')"}, columnType{dataType: "enum", columnType: "enum('a','b','c')"}, ""},
+		{columnType{dataType: "enum", columnType: "enum('a','b')"}, columnType{dataType: "enum", columnType: "enum('a','c')"}, "enum('a','b') to enum('a','c')"},
+		{columnType{dataType: "bit", columnType: "bit(8)"}, columnType{dataType: "bit", columnType: "bit(4)"}, "bit(8) to bit(4)"},
+	} {
+		assert.Equal(t, tc.narrowing, narrowingConversion(tc.src, tc.dst), "%s to %s", tc.src.columnType, tc.dst.columnType)
+	}
+}
+
+func TestNewTableColumnTypes(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS newcoltypest1, _newcoltypest1_new")
+	testutils.RunSQL(t, "CREATE TABLE newcoltypest1 (id INT NOT NULL PRIMARY KEY, a INT, b VARCHAR(255), c DECIMAL(10,2))")
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+	r := Resources{
+		DB: db,
+		Table: &table.TableInfo{
+			TableName:           "newcoltypest1",
+			SchemaName:          "test",
+			NonGeneratedColumns: []string{"id", "a", "b", "c"},
+		},
+	}
+
+	// Widening the columns is fine.
+	testutils.RunSQL(t, "CREATE TABLE _newcoltypest1_new LIKE newcoltypest1")
+	testutils.RunSQL(t, "ALTER TABLE _newcoltypest1_new MODIFY a BIGINT, MODIFY b TEXT, DROP COLUMN c")
+	logger, hook := test.NewNullLogger()
+	assert.NoError(t, newTableColumnTypesCheck(context.Background(), r, logger))
+	assert.Contains(t, hook.LastEntry().Message, "all columns of new table _newcoltypest1_new can hold the values of the table")
+
+	// Narrowing the columns is a warning.
+	testutils.RunSQL(t, "ALTER TABLE _newcoltypest1_new MODIFY a TINYINT, MODIFY b VARCHAR(10), ADD COLUMN c DECIMAL(10,2)")
+	assert.NoError(t, newTableColumnTypesCheck(context.Background(), r, logger))
+	assert.Equal(t, "new table _newcoltypest1_new has columns that may not hold every value of the table, which would be truncated when rows are copied or changes are applied: a (int to tinyint), b (varchar(255) to varchar(10))",
+		hook.LastEntry().Message)
+}
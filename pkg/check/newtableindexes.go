@@ -0,0 +1,79 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/siddontang/loggers"
+)
+
+func init() {
+	registerCheck("newtableindexes", newTableIndexesCheck, ScopePostSetup)
+}
+
+// newTableIndexesCheck fails if the secondary indexes of the new table are not
+// exactly the ExpectedIndexes. It runs after the new table has been altered,
+// but before the copy, so a statement that forgets or misnames an index does
+// not waste the whole copy. A nil ExpectedIndexes disables the check.
+func newTableIndexesCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
+	if r.ExpectedIndexes == nil {
+		return nil
+	}
+	newName := r.TableNamer.NewName(r.Table.TableName)
+	rows, err := r.DB.QueryContext(ctx, "SELECT DISTINCT index_name FROM information_schema.statistics WHERE table_schema=? AND table_name=? AND index_name != 'PRIMARY'",
+		r.Table.SchemaName, newName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		indexes = append(indexes, name)
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	if err := indexesMismatchError(r.ExpectedIndexes, indexes); err != nil {
+		return fmt.Errorf("new table %s: %w", newName, err)
+	}
+	logger.Infof("new table %s has the expected indexes: %s", newName, strings.Join(indexes, ", "))
+	return nil
+}
+
+// indexesMismatchError returns an error naming the expected indexes that are
+// missing and the indexes that are not expected, or nil if there are none.
+// Index names are compared case-insensitively, as MySQL does.
+func indexesMismatchError(expected, actual []string) error {
+	normalize := func(names []string) []string {
+		normalized := make([]string, 0, len(names))
+		for _, name := range names {
+			normalized = append(normalized, strings.ToLower(strings.TrimSpace(name)))
+		}
+		return normalized
+	}
+	expected, actual = normalize(expected), normalize(actual)
+	var missing, unexpected []string
+	for _, name := range expected {
+		if name != "primary" && !slices.Contains(actual, name) {
+			missing = append(missing, name)
+		}
+	}
+	for _, name := range actual {
+		if !slices.Contains(expected, name) {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return nil
+	}
+	slices.Sort(missing)
+	slices.Sort(unexpected)
+	return fmt.Errorf("indexes do not match the expected indexes: missing=[%s] unexpected=[%s]",
+		strings.Join(missing, ", "), strings.Join(unexpected, ", "))
+}
@@ -0,0 +1,52 @@
+package check
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTableIndexes(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS newidxt1, _newidxt1_new")
+	testutils.RunSQL(t, "CREATE TABLE newidxt1 (a INT NOT NULL PRIMARY KEY, b INT, c INT)")
+	testutils.RunSQL(t, "CREATE TABLE _newidxt1_new (a INT NOT NULL PRIMARY KEY, b INT, c INT, INDEX b (b), INDEX bc (b, c))")
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+	r := Resources{
+		DB:    db,
+		Table: &table.TableInfo{TableName: "newidxt1", SchemaName: "test"},
+	}
+	// The check is disabled by default.
+	assert.NoError(t, newTableIndexesCheck(context.Background(), r, logrus.New()))
+
+	r.ExpectedIndexes = []string{"b", "BC"}
+	assert.NoError(t, newTableIndexesCheck(context.Background(), r, logrus.New()))
+
+	r.ExpectedIndexes = []string{"b", "c"}
+	err = newTableIndexesCheck(context.Background(), r, logrus.New())
+	assert.EqualError(t, err, "new table _newidxt1_new: indexes do not match the expected indexes: missing=[c] unexpected=[bc]")
+
+	// An empty set expects no secondary indexes.
+	r.ExpectedIndexes = []string{}
+	err = newTableIndexesCheck(context.Background(), r, logrus.New())
+	assert.ErrorContains(t, err, "missing=[] unexpected=[b, bc]")
+}
+
+func TestIndexesMismatchError(t *testing.T) {
+	assert.NoError(t, indexesMismatchError(nil, nil))
+	assert.NoError(t, indexesMismatchError([]string{"idx_a", "idx_b"}, []string{"idx_b", "idx_a"}))
+	assert.NoError(t, indexesMismatchError([]string{"Idx_A"}, []string{"idx_a"}))
+	// The PRIMARY KEY is not a secondary index, but may be listed.
+	assert.NoError(t, indexesMismatchError([]string{"PRIMARY", "idx_a"}, []string{"idx_a"}))
+
+	assert.EqualError(t, indexesMismatchError([]string{"idx_a", "idx_b"}, []string{"idx_a"}),
+		"indexes do not match the expected indexes: missing=[idx_b] unexpected=[]")
+	assert.EqualError(t, indexesMismatchError([]string{"idx_a"}, []string{"idx_c", "idx_a", "idx_b"}),
+		"indexes do not match the expected indexes: missing=[] unexpected=[idx_b, idx_c]")
+}
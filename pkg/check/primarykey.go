@@ -3,6 +3,8 @@ package check
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 
 	"github.com/pingcap/tidb/pkg/parser/ast"
 	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
@@ -13,15 +15,43 @@ func init() {
 	registerCheck("primarykey", primaryKeyCheck, ScopePreflight)
 }
 
+// primaryKeyCheck only permits changing the PRIMARY KEY if the new key
+// contains all the columns of the existing key (i.e. a column is added to it).
+// The copier chunks on the existing key, and the replication client identifies
+// rows in the new table by the existing key columns. Because the existing key
+// is unique, the new key is then guaranteed to be unique for copied rows.
 func primaryKeyCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
 	alterStmt, ok := (*r.Statement.StmtNode).(*ast.AlterTableStmt)
 	if !ok {
 		return errors.New("not a valid alter table statement")
 	}
+	var dropsPrimaryKey bool
+	var newKeyColumns []string
 	for _, spec := range alterStmt.Specs {
 		if spec.Tp == ast.AlterTableDropPrimaryKey {
-			return errors.New("dropping primary key is not supported")
+			dropsPrimaryKey = true
+		}
+		if spec.Tp == ast.AlterTableAddConstraint && spec.Constraint.Tp == ast.ConstraintPrimaryKey {
+			for _, key := range spec.Constraint.Keys {
+				if key.Column == nil {
+					return errors.New("primary keys on expressions are not supported")
+				}
+				newKeyColumns = append(newKeyColumns, key.Column.Name.O)
+			}
+		}
+	}
+	if !dropsPrimaryKey {
+		return nil // no problems
+	}
+	if len(newKeyColumns) == 0 {
+		return errors.New("dropping primary key is not supported")
+	}
+	if r.Table != nil {
+		for _, col := range r.Table.KeyColumns {
+			if !slices.ContainsFunc(newKeyColumns, func(newCol string) bool { return strings.EqualFold(newCol, col) }) {
+				return errors.New("changing the primary key is only supported if the new primary key contains all columns of the existing primary key")
+			}
 		}
 	}
-	return nil // no problems
+	return nil
 }
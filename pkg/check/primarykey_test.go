@@ -5,16 +5,26 @@ import (
 	"testing"
 
 	"github.com/cashapp/spirit/pkg/statement"
+	"github.com/cashapp/spirit/pkg/table"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestPrimaryKey(t *testing.T) {
 	r := Resources{
+		Table:     &table.TableInfo{TableName: "t1", KeyColumns: []string{"id"}},
 		Statement: statement.MustNew("ALTER TABLE t1 DROP PRIMARY KEY, ADD PRIMARY KEY (anothercol)"),
 	}
 	err := primaryKeyCheck(context.Background(), r, logrus.New())
-	assert.Error(t, err) // drop primary key
+	assert.Error(t, err) // new primary key does not contain id
+
+	r.Statement = statement.MustNew("ALTER TABLE t1 DROP PRIMARY KEY")
+	err = primaryKeyCheck(context.Background(), r, logrus.New())
+	assert.ErrorContains(t, err, "dropping primary key is not supported")
+
+	r.Statement = statement.MustNew("ALTER TABLE t1 DROP PRIMARY KEY, ADD PRIMARY KEY (id, anothercol)")
+	err = primaryKeyCheck(context.Background(), r, logrus.New())
+	assert.NoError(t, err) // a column is added to the primary key
 
 	r.Statement = statement.MustNew("ALTER TABLE t1 ADD INDEX (anothercol)")
 	err = primaryKeyCheck(context.Background(), r, logrus.New())
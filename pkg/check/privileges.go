@@ -2,6 +2,7 @@ package check
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
@@ -13,83 +14,129 @@ func init() {
 	registerCheck("privileges", privilegesCheck, ScopePreflight)
 }
 
+// grants summarizes the privileges found in SHOW GRANTS.
+type grants struct {
+	all, super, replicationClient, replicationSlave, dbAll, reload, tableSelect bool
+}
+
 // Check the privileges of the user running the migration.
 // Ensure there is LOCK TABLES etc so we don't find out and get errors
-// at cutover time.
+// at cutover time. If the binary log is read by a different user
+// (r.ReplicationDB), the replication privileges are checked for that
+// user instead.
 func privilegesCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
 	// This is a re-implementation of the gh-ost check
 	// validateGrants() in gh-ost/go/logic/inspect.go
 
 	logger.Infof("Checking privileges for schema: %s", r.Table.SchemaName)
-
-	var foundAll, foundSuper, foundReplicationClient, foundReplicationSlave, foundDBAll, foundReload bool
-	rows, err := r.DB.QueryContext(ctx, `SHOW GRANTS`) //nolint: execinquery
+	found, err := showGrants(ctx, r.DB, r.Table.SchemaName, r.Table.TableName, logger)
 	if err != nil {
 		return err
 	}
+	if r.ReplicationDB == nil {
+		if found.all {
+			logger.Info("Found ALL PRIVILEGES - check passing")
+			return nil
+		}
+		if found.super && found.replicationSlave && found.dbAll {
+			logger.Info("Found SUPER + REPLICATION SLAVE + DB ALL - check passing")
+			return nil
+		}
+		if found.replicationClient && found.replicationSlave && found.dbAll && found.reload {
+			logger.Info("Found REPLICATION CLIENT + REPLICATION SLAVE + DB ALL + RELOAD - check passing")
+			return nil
+		}
+		return errors.New("insufficient privileges to run a migration. Needed: SUPER|REPLICATION CLIENT, RELOAD, REPLICATION SLAVE and ALL on %s.*")
+	}
+	// The migration user reads the binary log position,
+	// and the replication user streams the binary log.
+	if !found.all && !(found.dbAll && (found.super || found.replicationClient)) {
+		return fmt.Errorf("insufficient privileges to run a migration. Needed: SUPER|REPLICATION CLIENT and ALL on %s.*", r.Table.SchemaName)
+	}
+	logger.Infof("Checking privileges for the replication user")
+	replFound, err := showGrants(ctx, r.ReplicationDB, r.Table.SchemaName, r.Table.TableName, logger)
+	if err != nil {
+		return err
+	}
+	// The binary log is streamed without a dump, so RELOAD is not needed.
+	// SELECT is needed to read the columns of the table when it is streamed.
+	if replFound.all || (replFound.replicationSlave && (replFound.super || replFound.replicationClient) && replFound.tableSelect) {
+		logger.Info("Found replication privileges - check passing")
+		return nil
+	}
+	return fmt.Errorf("insufficient privileges to read the binary log. Needed: SUPER|REPLICATION CLIENT, REPLICATION SLAVE and SELECT on %s.%s", r.Table.SchemaName, r.Table.TableName)
+}
+
+// showGrants returns the privileges of the user connected to db.
+func showGrants(ctx context.Context, db *sql.DB, schemaName, tableName string, logger loggers.Advanced) (grants, error) {
+	var found grants
+	rows, err := db.QueryContext(ctx, `SHOW GRANTS`) //nolint: execinquery
+	if err != nil {
+		return found, err
+	}
 	defer rows.Close()
 	for rows.Next() {
 		var grant string
 		if err := rows.Scan(&grant); err != nil {
-			return err
+			return found, err
 		}
 		// Debug output of each grant
 		logger.Infof("Checking grant: %s", grant)
 
 		if strings.Contains(grant, `GRANT ALL PRIVILEGES ON *.*`) {
-			foundAll = true
+			found.all = true
 		}
 		if strings.Contains(grant, `SUPER`) && strings.Contains(grant, ` ON *.*`) {
-			foundSuper = true
+			found.super = true
 		}
 		if strings.Contains(grant, `REPLICATION CLIENT`) && strings.Contains(grant, ` ON *.*`) {
-			foundReplicationClient = true
+			found.replicationClient = true
 		}
 		if strings.Contains(grant, `REPLICATION SLAVE`) && strings.Contains(grant, ` ON *.*`) {
-			foundReplicationSlave = true
+			found.replicationSlave = true
 		}
 		if strings.Contains(grant, `RELOAD`) && strings.Contains(grant, ` ON *.*`) {
-			foundReload = true
+			found.reload = true
 		}
-		if strings.Contains(grant, fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.*", r.Table.SchemaName)) {
-			foundDBAll = true
+		if strings.Contains(grant, fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.*", schemaName)) {
+			found.dbAll = true
 		}
-		if strings.Contains(grant, fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.*", strings.Replace(r.Table.SchemaName, "_", "\\_", -1))) {
-			foundDBAll = true
+		if strings.Contains(grant, fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.*", strings.Replace(schemaName, "_", "\\_", -1))) {
+			found.dbAll = true
 		}
 		if stringContainsAll(grant, `ALTER`, `CREATE`, `DELETE`, `DROP`, `INDEX`, `INSERT`, `LOCK TABLES`, `SELECT`, `TRIGGER`, `UPDATE`, ` ON *.*`) {
-			foundDBAll = true
+			found.dbAll = true
+		}
+		if stringContainsAll(grant, `ALTER`, `CREATE`, `DELETE`, `DROP`, `INDEX`, `INSERT`, `LOCK TABLES`, `SELECT`, `TRIGGER`, `UPDATE`, fmt.Sprintf(" ON `%s`.*", schemaName)) {
+			found.dbAll = true
 		}
-		if stringContainsAll(grant, `ALTER`, `CREATE`, `DELETE`, `DROP`, `INDEX`, `INSERT`, `LOCK TABLES`, `SELECT`, `TRIGGER`, `UPDATE`, fmt.Sprintf(" ON `%s`.*", r.Table.SchemaName)) {
-			foundDBAll = true
+		if strings.Contains(grant, `ALL PRIVILEGES`) || strings.Contains(grant, `SELECT`) {
+			for _, on := range []string{
+				` ON *.*`,
+				fmt.Sprintf(" ON `%s`.*", schemaName),
+				fmt.Sprintf(" ON `%s`.*", strings.Replace(schemaName, "_", "\\_", -1)),
+				fmt.Sprintf(" ON `%s`.`%s`", schemaName, tableName),
+			} {
+				if strings.Contains(grant, on) {
+					found.tableSelect = true
+				}
+			}
 		}
 	}
 	if rows.Err() != nil {
-		return rows.Err()
+		return found, rows.Err()
 	}
 
 	// Debug output of privilege state
 	logger.Infof("Privilege check results:")
-	logger.Infof("- ALL PRIVILEGES: %v", foundAll)
-	logger.Infof("- SUPER: %v", foundSuper)
-	logger.Infof("- REPLICATION CLIENT: %v", foundReplicationClient)
-	logger.Infof("- REPLICATION SLAVE: %v", foundReplicationSlave)
-	logger.Infof("- DB ALL: %v", foundDBAll)
-	logger.Infof("- RELOAD: %v", foundReload)
-
-	if foundAll {
-		logger.Info("Found ALL PRIVILEGES - check passing")
-		return nil
-	}
-	if foundSuper && foundReplicationSlave && foundDBAll {
-		logger.Info("Found SUPER + REPLICATION SLAVE + DB ALL - check passing")
-		return nil
-	}
-	if foundReplicationClient && foundReplicationSlave && foundDBAll && foundReload {
-		logger.Info("Found REPLICATION CLIENT + REPLICATION SLAVE + DB ALL + RELOAD - check passing")
-		return nil
-	}
-	return errors.New("insufficient privileges to run a migration. Needed: SUPER|REPLICATION CLIENT, RELOAD, REPLICATION SLAVE and ALL on %s.*")
+	logger.Infof("- ALL PRIVILEGES: %v", found.all)
+	logger.Infof("- SUPER: %v", found.super)
+	logger.Infof("- REPLICATION CLIENT: %v", found.replicationClient)
+	logger.Infof("- REPLICATION SLAVE: %v", found.replicationSlave)
+	logger.Infof("- DB ALL: %v", found.dbAll)
+	logger.Infof("- RELOAD: %v", found.reload)
+	logger.Infof("- TABLE SELECT: %v", found.tableSelect)
+	return found, nil
 }
 
 // stringContainsAll returns true if `s` contains all non empty given `substrings`
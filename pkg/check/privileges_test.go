@@ -60,3 +60,57 @@ func TestPrivileges(t *testing.T) {
 	err = privilegesCheck(context.Background(), r, logrus.New())
 	assert.NoError(t, err) // privileges work fine
 }
+
+func TestPrivilegesReplicationUser(t *testing.T) {
+	config, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	config.User = "root" // needs grant privilege
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/%s", config.User, config.Passwd, config.Addr, config.DBName))
+	assert.NoError(t, err)
+
+	for _, user := range []string{"testddluser", "testrepluser"} {
+		_, err = db.Exec("DROP USER IF EXISTS " + user)
+		assert.NoError(t, err)
+		_, err = db.Exec("CREATE USER " + user)
+		assert.NoError(t, err)
+	}
+	_, err = db.Exec("GRANT ALL ON test.* TO testddluser")
+	assert.NoError(t, err)
+	_, err = db.Exec("GRANT REPLICATION CLIENT ON *.* TO testddluser")
+	assert.NoError(t, err)
+
+	ddlDB, err := sql.Open("mysql", fmt.Sprintf("testddluser:@tcp(%s)/%s", config.Addr, config.DBName))
+	assert.NoError(t, err)
+	replDB, err := sql.Open("mysql", fmt.Sprintf("testrepluser:@tcp(%s)/%s", config.Addr, config.DBName))
+	assert.NoError(t, err)
+
+	// Without a separate replication user, the DDL user
+	// would also need REPLICATION SLAVE and RELOAD.
+	r := Resources{
+		DB:    ddlDB,
+		Table: &table.TableInfo{TableName: "test", SchemaName: "test"},
+	}
+	assert.Error(t, privilegesCheck(context.Background(), r, logrus.New()))
+
+	// The replication privileges are checked against the replication user.
+	r.ReplicationDB = replDB
+	assert.ErrorContains(t, privilegesCheck(context.Background(), r, logrus.New()), "insufficient privileges to read the binary log")
+
+	_, err = db.Exec("GRANT REPLICATION SLAVE ON *.* TO testrepluser")
+	assert.NoError(t, err)
+	assert.ErrorContains(t, privilegesCheck(context.Background(), r, logrus.New()), "insufficient privileges to read the binary log")
+
+	// RELOAD is not required by the replication user, but SELECT on the table is.
+	_, err = db.Exec("GRANT REPLICATION CLIENT ON *.* TO testrepluser")
+	assert.NoError(t, err)
+	assert.ErrorContains(t, privilegesCheck(context.Background(), r, logrus.New()), "SELECT on test.test")
+	_, err = db.Exec("GRANT SELECT ON test.* TO testrepluser")
+	assert.NoError(t, err)
+	assert.NoError(t, privilegesCheck(context.Background(), r, logrus.New()))
+
+	// The replication user only needs SELECT,
+	// but the DDL user needs ALL on the schema.
+	r.DB = replDB
+	r.ReplicationDB = ddlDB
+	assert.ErrorContains(t, privilegesCheck(context.Background(), r, logrus.New()), "ALL on test.*")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/siddontang/loggers"
 )
@@ -18,7 +19,11 @@ func replicaHealth(ctx context.Context, r Resources, logger loggers.Advanced) er
 	if r.Replica == nil {
 		return nil // The user is not using the replica DSN feature.
 	}
-	rows, err := r.Replica.Query("SHOW REPLICA STATUS") //nolint: execinquery
+	var version string
+	if err := r.Replica.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return err
+	}
+	rows, err := r.Replica.QueryContext(ctx, replicaStatusQuery(version)) //nolint: execinquery
 	if err != nil {
 		return err
 	}
@@ -27,12 +32,35 @@ func replicaHealth(ctx context.Context, r Resources, logger loggers.Advanced) er
 	if err != nil {
 		return err
 	}
-	if status["Replica_IO_Running"].String != "Yes" || status["Replica_SQL_Running"].String != "Yes" {
+	if !replicaIsRunning(status) {
 		return errors.New("replica is not healthy")
 	}
 	return nil
 }
 
+// replicaStatusQuery returns SHOW REPLICA STATUS, or SHOW SLAVE STATUS
+// for versions prior to MySQL 8.0.22 where it is not supported.
+func replicaStatusQuery(version string) string {
+	var major, minor, patch int
+	// The version may have a suffix, i.e. 8.0.22-log.
+	if _, err := fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch); err != nil {
+		return "SHOW REPLICA STATUS" // assume a modern version.
+	}
+	if major < 8 || (major == 8 && minor == 0 && patch < 22) {
+		return "SHOW SLAVE STATUS"
+	}
+	return "SHOW REPLICA STATUS"
+}
+
+// replicaIsRunning returns true if both the IO and SQL threads are running.
+// It supports the column names of both SHOW REPLICA STATUS and SHOW SLAVE STATUS.
+func replicaIsRunning(status map[string]sql.NullString) bool {
+	if _, ok := status["Replica_IO_Running"]; ok {
+		return status["Replica_IO_Running"].String == "Yes" && status["Replica_SQL_Running"].String == "Yes"
+	}
+	return status["Slave_IO_Running"].String == "Yes" && status["Slave_SQL_Running"].String == "Yes"
+}
+
 func scanToMap(rows *sql.Rows) (map[string]sql.NullString, error) {
 	columns, err := rows.Columns()
 	if err != nil {
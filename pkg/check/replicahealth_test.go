@@ -45,3 +45,28 @@ func TestReplicaHealth(t *testing.T) {
 	err = replicaHealth(context.Background(), r, logrus.New())
 	assert.Error(t, err) // invalid
 }
+
+func TestReplicaStatusQuery(t *testing.T) {
+	assert.Equal(t, "SHOW SLAVE STATUS", replicaStatusQuery("5.7.44-log"))
+	assert.Equal(t, "SHOW SLAVE STATUS", replicaStatusQuery("8.0.21"))
+	assert.Equal(t, "SHOW REPLICA STATUS", replicaStatusQuery("8.0.22"))
+	assert.Equal(t, "SHOW REPLICA STATUS", replicaStatusQuery("8.0.35-27"))
+	assert.Equal(t, "SHOW REPLICA STATUS", replicaStatusQuery("8.4.0"))
+	assert.Equal(t, "SHOW REPLICA STATUS", replicaStatusQuery("unknown"))
+}
+
+func TestReplicaIsRunning(t *testing.T) {
+	yes := sql.NullString{String: "Yes", Valid: true}
+	no := sql.NullString{String: "No", Valid: true}
+
+	// SHOW REPLICA STATUS column names.
+	assert.True(t, replicaIsRunning(map[string]sql.NullString{"Replica_IO_Running": yes, "Replica_SQL_Running": yes}))
+	assert.False(t, replicaIsRunning(map[string]sql.NullString{"Replica_IO_Running": yes, "Replica_SQL_Running": no}))
+
+	// SHOW SLAVE STATUS column names.
+	assert.True(t, replicaIsRunning(map[string]sql.NullString{"Slave_IO_Running": yes, "Slave_SQL_Running": yes}))
+	assert.False(t, replicaIsRunning(map[string]sql.NullString{"Slave_IO_Running": no, "Slave_SQL_Running": yes}))
+
+	// Not a replica.
+	assert.False(t, replicaIsRunning(nil))
+}
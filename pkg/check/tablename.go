@@ -2,9 +2,11 @@ package check
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"strings"
+	"unicode/utf8"
 
 	"github.com/siddontang/loggers"
 )
@@ -13,35 +15,100 @@ const (
 	// Max table name length in MySQL
 	maxTableNameLength = 64
 
-	// Formats for table names
-	NameFormatSentinel     = "_%s_sentinel"
-	NameFormatCheckpoint   = "_%s_chkpnt"
-	NameFormatNew          = "_%s_new"
-	NameFormatOld          = "_%s_old"
-	NameFormatOldTimeStamp = "_%s_old_%s"
-	NameFormatTimestamp    = "20060102_150405"
+	// DefaultNamePrefix is prepended to the table name for all artifact tables.
+	DefaultNamePrefix = "_"
+
+	// Suffixes for artifact table names
+	NameSuffixSentinel   = "_sentinel"
+	NameSuffixCheckpoint = "_chkpnt"
+	NameSuffixNew        = "_new"
+	NameSuffixOld        = "_old"
+	NameFormatTimestamp  = "20060102_150405"
+
+	// The number of hex characters of the table name hash that
+	// are used when an artifact name needs to be truncated.
+	nameHashLength = 8
 )
 
+// Formats for table names with the default prefix.
+//
+// Deprecated: use TableNamer, which supports a configurable prefix
+// and truncates table names that would be too long.
+const (
+	NameFormatSentinel     = DefaultNamePrefix + "%s" + NameSuffixSentinel
+	NameFormatCheckpoint   = DefaultNamePrefix + "%s" + NameSuffixCheckpoint
+	NameFormatNew          = DefaultNamePrefix + "%s" + NameSuffixNew
+	NameFormatOld          = DefaultNamePrefix + "%s" + NameSuffixOld
+	NameFormatOldTimeStamp = DefaultNamePrefix + "%s" + NameSuffixOld + "_%s"
+)
+
+// The number of extra characters needed for table names with all possible
+// formats.
+//
+// Deprecated: table names are no longer limited by these lengths,
+// since TableNamer truncates names that would be too long.
 var (
-	// The number of extra characters needed for table names with all possible
-	// formats. These vars are calculated in the `init` function below.
-	NameFormatNormalExtraChars    = 0
-	NameFormatTimestampExtraChars = 0
+	NameFormatNormalExtraChars    = len(DefaultNamePrefix + NameSuffixSentinel)
+	NameFormatTimestampExtraChars = len(DefaultNamePrefix+NameSuffixOld+"_") + len(NameFormatTimestamp)
 )
 
-func init() {
-	registerCheck("tablename", tableNameCheck, ScopePreflight)
+// TableNamer derives the names of the tables that are created while
+// migrating a table: the new, old, checkpoint and sentinel tables.
+// Names are built as <prefix><table><suffix>. If that exceeds the max
+// table name length, the table name is truncated and a hash of the
+// full table name is appended, so that the name remains unique.
+type TableNamer struct {
+	// Prefix is prepended to each name. If empty, DefaultNamePrefix is used.
+	Prefix string
+}
+
+func (n TableNamer) prefix() string {
+	if n.Prefix == "" {
+		return DefaultNamePrefix
+	}
+	return n.Prefix
+}
+
+// Name returns the artifact name for tableName with the given suffix.
+func (n TableNamer) Name(tableName, suffix string) string {
+	prefix := n.prefix()
+	name := prefix + tableName + suffix
+	if utf8.RuneCountInString(name) <= maxTableNameLength {
+		return name
+	}
+	sum := sha1.Sum([]byte(tableName))
+	hash := hex.EncodeToString(sum[:])[:nameHashLength]
+	keep := maxTableNameLength - utf8.RuneCountInString(prefix) - utf8.RuneCountInString(suffix) - nameHashLength - 1
+	runes := []rune(tableName)
+	if keep < 0 {
+		keep = 0 // the tablename check rejects this.
+	}
+	return prefix + string(runes[:keep]) + "_" + hash + suffix
+}
+
+func (n TableNamer) NewName(tableName string) string {
+	return n.Name(tableName, NameSuffixNew)
+}
 
-	// Calculate the number of extra characters needed table names with all possible formats
-	for _, format := range []string{NameFormatSentinel, NameFormatCheckpoint, NameFormatNew, NameFormatOld} {
-		extraChars := len(strings.Replace(format, "%s", "", -1))
-		if extraChars > NameFormatNormalExtraChars {
-			NameFormatNormalExtraChars = extraChars
-		}
+func (n TableNamer) CheckpointName(tableName string) string {
+	return n.Name(tableName, NameSuffixCheckpoint)
+}
+
+func (n TableNamer) SentinelName(tableName string) string {
+	return n.Name(tableName, NameSuffixSentinel)
+}
+
+// OldName returns the name the original table is renamed to on cutover.
+// If timestamp is not empty, it is appended to the name.
+func (n TableNamer) OldName(tableName string, timestamp string) string {
+	if timestamp == "" {
+		return n.Name(tableName, NameSuffixOld)
 	}
+	return n.Name(tableName, NameSuffixOld+"_"+timestamp)
+}
 
-	// Calculate the number of extra characters needed for table names with the old timestamp format
-	NameFormatTimestampExtraChars = len(strings.Replace(NameFormatOldTimeStamp, "%s", "", -1)) + len(NameFormatTimestamp)
+func init() {
+	registerCheck("tablename", tableNameCheck, ScopePreflight)
 }
 
 func tableNameCheck(ctx context.Context, r Resources, logger loggers.Advanced) error {
@@ -49,15 +116,15 @@ func tableNameCheck(ctx context.Context, r Resources, logger loggers.Advanced) e
 	if len(tableName) < 1 {
 		return errors.New("table name must be at least 1 character")
 	}
-
-	timestampTableNameLength := maxTableNameLength - NameFormatTimestampExtraChars
-	if r.SkipDropAfterCutover && len(tableName) > timestampTableNameLength {
-		return fmt.Errorf("table name must be less than %d characters when --skip-drop-after-cutover is set", timestampTableNameLength)
+	// Long table names are truncated, but there must be space
+	// for the prefix, the hash and the longest suffix.
+	longestSuffix := NameSuffixSentinel
+	if r.SkipDropAfterCutover {
+		longestSuffix = NameSuffixOld + "_" + NameFormatTimestamp
 	}
-
-	normalTableNameLength := maxTableNameLength - NameFormatNormalExtraChars
-	if len(tableName) > normalTableNameLength {
-		return fmt.Errorf("table name must be less than %d characters", normalTableNameLength)
+	prefix := r.TableNamer.prefix()
+	if utf8.RuneCountInString(prefix)+nameHashLength+1+len(longestSuffix) >= maxTableNameLength {
+		return fmt.Errorf("artifact table prefix %q is too long, it must leave room for the table name in %d characters", prefix, maxTableNameLength)
 	}
 	return nil
 }
@@ -2,23 +2,16 @@ package check
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/cashapp/spirit/pkg/table"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestCheckTableNameConstants(t *testing.T) {
-	// Calculated extra chars should always be greater than 0
-	assert.Positive(t, NameFormatNormalExtraChars)
-	assert.Positive(t, NameFormatTimestampExtraChars)
-
-	// Calculated extra chars should be less than the max table name length
-	assert.Less(t, NameFormatNormalExtraChars, maxTableNameLength)
-	assert.Less(t, NameFormatTimestampExtraChars, maxTableNameLength)
-}
-
 func TestCheckTableName(t *testing.T) {
 	testTableName := func(name string, skipDropAfterCutover bool) error {
 		r := Resources{
@@ -36,7 +29,73 @@ func TestCheckTableName(t *testing.T) {
 	assert.ErrorContains(t, testTableName("", false), "table name must be at least 1 character")
 	assert.ErrorContains(t, testTableName("", true), "table name must be at least 1 character")
 
-	longName := "thisisareallylongtablenamethisisareallylongtablenamethisisareallylongtablename"
-	assert.ErrorContains(t, testTableName(longName, false), "table name must be less than")
-	assert.ErrorContains(t, testTableName(longName, true), "table name must be less than")
+	// Long names are truncated, so they are permitted.
+	longName := strings.Repeat("a", maxTableNameLength)
+	assert.NoError(t, testTableName(longName, false))
+	assert.NoError(t, testTableName(longName, true))
+
+	// But the prefix must leave room for the table name.
+	r := Resources{
+		Table:      &table.TableInfo{TableName: "a"},
+		TableNamer: TableNamer{Prefix: strings.Repeat("p", 56)},
+	}
+	assert.ErrorContains(t, tableNameCheck(context.Background(), r, logrus.New()), "is too long")
+}
+
+func TestTableNamer(t *testing.T) {
+	namer := TableNamer{}
+	assert.Equal(t, "_t1_new", namer.NewName("t1"))
+	assert.Equal(t, "_t1_chkpnt", namer.CheckpointName("t1"))
+	assert.Equal(t, "_t1_sentinel", namer.SentinelName("t1"))
+	assert.Equal(t, "_t1_old", namer.OldName("t1", ""))
+	assert.Equal(t, "_t1_old_20240102_150405", namer.OldName("t1", "20240102_150405"))
+
+	// The deprecated formats match the default names.
+	assert.Equal(t, namer.NewName("t1"), fmt.Sprintf(NameFormatNew, "t1"))
+	assert.Equal(t, namer.CheckpointName("t1"), fmt.Sprintf(NameFormatCheckpoint, "t1"))
+	assert.Equal(t, namer.SentinelName("t1"), fmt.Sprintf(NameFormatSentinel, "t1"))
+	assert.Equal(t, namer.OldName("t1", ""), fmt.Sprintf(NameFormatOld, "t1"))
+	assert.Equal(t, namer.OldName("t1", "20240102_150405"), fmt.Sprintf(NameFormatOldTimeStamp, "t1", "20240102_150405"))
+	assert.Equal(t, 10, NameFormatNormalExtraChars)
+	assert.Equal(t, 21, NameFormatTimestampExtraChars)
+
+	namer = TableNamer{Prefix: "__spirit_"}
+	assert.Equal(t, "__spirit_t1_new", namer.NewName("t1"))
+}
+
+func TestTableNamerLongNames(t *testing.T) {
+	// Two 60 character names, which only differ in the last character.
+	base := strings.Repeat("x", 59)
+	tables := []string{base + "1", base + "2"}
+	timestamp := "20240102_150405"
+
+	for _, namer := range []TableNamer{{}, {Prefix: "__spirit_"}} {
+		seen := make(map[string]struct{})
+		for _, tbl := range tables {
+			assert.Equal(t, 60, utf8.RuneCountInString(tbl))
+			names := []string{
+				namer.NewName(tbl),
+				namer.CheckpointName(tbl),
+				namer.SentinelName(tbl),
+				namer.OldName(tbl, ""),
+				namer.OldName(tbl, timestamp),
+			}
+			for _, name := range names {
+				assert.LessOrEqual(t, utf8.RuneCountInString(name), maxTableNameLength)
+				assert.True(t, strings.HasPrefix(name, namer.prefix()))
+				assert.NotContains(t, name, "`")
+				_, ok := seen[name]
+				assert.False(t, ok, "duplicate name %s", name)
+				seen[name] = struct{}{}
+			}
+			// Deterministic, so a resume finds the same tables.
+			assert.Equal(t, namer.CheckpointName(tbl), namer.CheckpointName(tbl))
+		}
+	}
+
+	// Multi-byte names are truncated by character, not byte.
+	mb := strings.Repeat("ü", 60)
+	name := TableNamer{}.NewName(mb)
+	assert.True(t, utf8.ValidString(name))
+	assert.Equal(t, maxTableNameLength, utf8.RuneCountInString(name))
 }
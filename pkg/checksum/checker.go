@@ -9,6 +9,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,7 +17,9 @@ import (
 
 	"github.com/cashapp/spirit/pkg/dbconn"
 	"github.com/cashapp/spirit/pkg/repl"
+	"github.com/cashapp/spirit/pkg/row"
 	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/throttler"
 	"github.com/cashapp/spirit/pkg/utils"
 	"github.com/siddontang/loggers"
 	"github.com/sirupsen/logrus"
@@ -42,6 +45,9 @@ type Checker struct {
 	differencesFound atomic.Uint64
 	recopyLock       sync.Mutex
 	isResume         bool
+	throttler        throttler.Throttler
+	mismatches       []*table.Chunk // chunks with differences that were not fixed
+	mode             row.ChecksumMode
 }
 
 type CheckerConfig struct {
@@ -51,6 +57,13 @@ type CheckerConfig struct {
 	Logger          loggers.Advanced
 	FixDifferences  bool
 	Watermark       string // optional; defines a watermark to start from
+	Throttler       throttler.Throttler
+	// Mode is what is compared for each chunk: a checksum of the rows
+	// (row.ChecksumModeFull), or the number of rows (row.ChecksumModeRowCount).
+	// Empty is row.ChecksumModeFull. In either mode, the checker only reports
+	// the differences (or repairs them with FixDifferences); whether they
+	// block the cutover is decided by the caller.
+	Mode row.ChecksumMode
 }
 
 func NewCheckerDefaultConfig() *CheckerConfig {
@@ -60,6 +73,7 @@ func NewCheckerDefaultConfig() *CheckerConfig {
 		DBConfig:        dbconn.NewDBConfig(),
 		Logger:          logrus.New(),
 		FixDifferences:  false,
+		Throttler:       &throttler.Noop{},
 	}
 }
 
@@ -74,6 +88,17 @@ func NewChecker(db *sql.DB, tbl, newTable *table.TableInfo, feed *repl.Client, c
 	if config.DBConfig == nil {
 		config.DBConfig = dbconn.NewDBConfig()
 	}
+	if config.Throttler == nil {
+		config.Throttler = &throttler.Noop{}
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = row.ChecksumModeFull
+	case row.ChecksumModeFull, row.ChecksumModeRowCount:
+	default:
+		return nil, fmt.Errorf("unsupported checksum mode %q, must be one of: %s, %s",
+			config.Mode, row.ChecksumModeFull, row.ChecksumModeRowCount)
+	}
 	chunker, err := table.NewChunker(tbl, config.TargetChunkTime, config.Logger)
 	if err != nil {
 		return nil, err
@@ -97,11 +122,16 @@ func NewChecker(db *sql.DB, tbl, newTable *table.TableInfo, feed *repl.Client, c
 		logger:         config.Logger,
 		fixDifferences: config.FixDifferences,
 		isResume:       config.Watermark != "",
+		throttler:      config.Throttler,
+		mode:           config.Mode,
 	}
 	return checksum, nil
 }
 
 func (c *Checker) ChecksumChunk(ctx context.Context, trxPool *dbconn.TrxPool, chunk *table.Chunk) error {
+	if err := c.throttler.BlockWait(); err != nil {
+		return err
+	}
 	startTime := time.Now()
 	trx, err := trxPool.Get()
 	if err != nil {
@@ -109,16 +139,8 @@ func (c *Checker) ChecksumChunk(ctx context.Context, trxPool *dbconn.TrxPool, ch
 	}
 	defer trxPool.Put(trx)
 	c.logger.Debugf("checksumming chunk: %s", chunk.String())
-	source := fmt.Sprintf("SELECT BIT_XOR(CRC32(CONCAT(%s))) as checksum FROM %s WHERE %s",
-		c.intersectColumns(),
-		c.table.QuotedName,
-		chunk.String(),
-	)
-	target := fmt.Sprintf("SELECT BIT_XOR(CRC32(CONCAT(%s))) as checksum FROM %s WHERE %s",
-		c.intersectColumns(),
-		c.newTable.QuotedName,
-		chunk.String(),
-	)
+	source := c.chunkQuery(c.table, chunk)
+	target := c.chunkQuery(c.newTable, chunk)
 	var sourceChecksum, targetChecksum int64
 	err = trx.QueryRow(source).Scan(&sourceChecksum)
 	if err != nil {
@@ -136,13 +158,15 @@ func (c *Checker) ChecksumChunk(ctx context.Context, trxPool *dbconn.TrxPool, ch
 		if err := c.inspectDifferences(trx, chunk); err != nil {
 			return err
 		}
-		// Are we allowed to fix the differences? If not, return an error.
+		// Are we allowed to fix the differences? If not, record the chunk
+		// and continue, so all differences are reported when Run returns.
 		// This is mostly used by the test-suite.
 		if !c.fixDifferences {
-			return errors.New("checksum mismatch")
-		}
-		// Since we can fix differences, replace the chunk.
-		if err = c.replaceChunk(ctx, chunk); err != nil {
+			c.Lock()
+			c.mismatches = append(c.mismatches, chunk)
+			c.Unlock()
+		} else if err = c.replaceChunk(ctx, chunk); err != nil {
+			// Since we can fix differences, replace the chunk.
 			return err
 		}
 	}
@@ -160,6 +184,37 @@ func (c *Checker) DifferencesFound() uint64 {
 	return c.differencesFound.Load()
 }
 
+// Mismatches returns the chunks with differences that were not fixed,
+// in key order.
+func (c *Checker) Mismatches() []string {
+	c.Lock()
+	chunks := slices.Clone(c.mismatches)
+	c.Unlock()
+	slices.SortFunc(chunks, func(a, b *table.Chunk) int {
+		// The first chunk has no lower bound.
+		if a.LowerBound == nil {
+			return -1
+		}
+		if b.LowerBound == nil {
+			return 1
+		}
+		return c.table.CompareKeyValues(boundaryValues(a.LowerBound), boundaryValues(b.LowerBound))
+	})
+	ranges := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		ranges = append(ranges, chunk.String())
+	}
+	return ranges
+}
+
+func boundaryValues(b *table.Boundary) []string {
+	values := make([]string, 0, len(b.Value))
+	for _, v := range b.Value {
+		values = append(values, v.String())
+	}
+	return values
+}
+
 func (c *Checker) RecentValue() string {
 	c.Lock()
 	defer c.Unlock()
@@ -350,7 +405,7 @@ func (c *Checker) initConnPool(ctx context.Context) error {
 	// Lock the source table in a trx
 	// so the connection is not used by others
 	c.logger.Info("starting checksum operation, this will require a table lock")
-	tableLock, err := dbconn.NewTableLock(ctx, c.db, c.table, c.dbConfig, c.logger)
+	tableLock, err := dbconn.NewTableLock(ctx, c.db, c.table, c.newTable, c.dbConfig, c.logger)
 	if err != nil {
 		return err
 	}
@@ -438,6 +493,9 @@ func (c *Checker) Run(ctx context.Context) error {
 		c.logger.Error("checksum failed")
 		return err1
 	}
+	if mismatches := c.Mismatches(); len(mismatches) > 0 {
+		return fmt.Errorf("checksum mismatch in %d chunks: %s", len(mismatches), strings.Join(mismatches, "; "))
+	}
 	return nil
 }
 
@@ -445,13 +503,45 @@ func (c *Checker) Run(ctx context.Context) error {
 // wraps an IFNULL(), ISNULL() and cast operation around the columns.
 // The cast is to c.newTable type.
 func (c *Checker) intersectColumns() string {
+	return checksumColumns(c.table, c.newTable)
+}
+
+// chunkQuery returns the query that is compared between the tables
+// for chunk, which depends on the mode of the checker.
+func (c *Checker) chunkQuery(tbl *table.TableInfo, chunk *table.Chunk) string {
+	if c.mode == row.ChecksumModeRowCount {
+		return rowCountQuery(tbl, chunk)
+	}
+	return checksumQuery(c.intersectColumns(), tbl, chunk)
+}
+
+// rowCountQuery returns a query for the number of rows of tbl in chunk.
+func rowCountQuery(tbl *table.TableInfo, chunk *table.Chunk) string {
+	return fmt.Sprintf("SELECT COUNT(*) as checksum FROM %s WHERE %s",
+		tbl.QuotedName,
+		chunk.String(),
+	)
+}
+
+// checksumQuery returns a query for the checksum of the rows of tbl in chunk.
+func checksumQuery(columns string, tbl *table.TableInfo, chunk *table.Chunk) string {
+	return fmt.Sprintf("SELECT BIT_XOR(CRC32(CONCAT(%s))) as checksum FROM %s WHERE %s",
+		columns,
+		tbl.QuotedName,
+		chunk.String(),
+	)
+}
+
+// checksumColumns returns the columns that are in both t1 and t2,
+// wrapped in IFNULL(), ISNULL() and a cast to the t2 type.
+func checksumColumns(t1, t2 *table.TableInfo) string {
 	var intersection []string
-	for _, col := range c.table.Columns {
-		for _, col2 := range c.newTable.Columns {
+	for _, col := range t1.Columns {
+		for _, col2 := range t2.Columns {
 			if col == col2 {
 				// Column exists in both, so we add intersection wrapped in
 				// IFNULL, ISNULL and CAST.
-				intersection = append(intersection, "IFNULL("+c.newTable.WrapCastType(col)+",''), ISNULL(`"+col+"`)")
+				intersection = append(intersection, "IFNULL("+t2.WrapCastType(col)+",''), ISNULL(`"+col+"`)")
 			}
 		}
 	}
@@ -2,6 +2,7 @@ package checksum
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 
 	"github.com/cashapp/spirit/pkg/dbconn"
 	"github.com/cashapp/spirit/pkg/repl"
+	"github.com/cashapp/spirit/pkg/row"
 	"github.com/cashapp/spirit/pkg/table"
 )
 
@@ -164,6 +166,141 @@ func TestCorruptChecksum(t *testing.T) {
 	assert.ErrorContains(t, err, "checksum mismatch")
 }
 
+func TestRowCountChecksum(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS rowcountchkt1, _rowcountchkt1_new, _rowcountchkt1_chkpnt")
+	testutils.RunSQL(t, "CREATE TABLE rowcountchkt1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _rowcountchkt1_new (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _rowcountchkt1_chkpnt (a INT)") // for binlog advancement
+	testutils.RunSQL(t, "INSERT INTO rowcountchkt1 VALUES (1, 2, 3)")
+	testutils.RunSQL(t, "INSERT INTO _rowcountchkt1_new VALUES (1, 2, 4)") // a different value is not found.
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "rowcountchkt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_rowcountchkt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	feed := repl.NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, repl.NewClientDefaultConfig())
+	assert.NoError(t, feed.Run())
+	defer feed.Close()
+
+	config := NewCheckerDefaultConfig()
+	config.Mode = row.ChecksumModeRowCount
+	checker, err := NewChecker(db, t1, t2, feed, config)
+	assert.NoError(t, err)
+	assert.NoError(t, checker.Run(context.Background()))
+
+	// An extra row is found.
+	testutils.RunSQL(t, "INSERT INTO _rowcountchkt1_new VALUES (2, 2, 3)")
+	checker, err = NewChecker(db, t1, t2, feed, config)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, checker.Run(context.Background()), "checksum mismatch")
+	assert.Equal(t, uint64(1), checker.DifferencesFound())
+
+	// A mode that does not verify the table is not supported.
+	config.Mode = row.ChecksumModeNone
+	_, err = NewChecker(db, t1, t2, feed, config)
+	assert.ErrorContains(t, err, `unsupported checksum mode "none"`)
+}
+
+func TestChunkQuery(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "chunkqueryt1")
+	chunk := &table.Chunk{Key: []string{"a"}}
+	checker := &Checker{table: t1, newTable: t1, mode: row.ChecksumModeFull}
+	assert.True(t, strings.HasPrefix(checker.chunkQuery(t1, chunk), "SELECT BIT_XOR(CRC32(CONCAT("))
+	checker.mode = row.ChecksumModeRowCount
+	assert.Equal(t, "SELECT COUNT(*) as checksum FROM `test`.`chunkqueryt1` WHERE 1=1", checker.chunkQuery(t1, chunk))
+}
+
+func TestChecksumReportsAllMismatches(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS allmismatcht1, _allmismatcht1_new, _allmismatcht1_chkpnt")
+	testutils.RunSQL(t, "CREATE TABLE allmismatcht1 (a INT NOT NULL AUTO_INCREMENT, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _allmismatcht1_new (a INT NOT NULL AUTO_INCREMENT, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _allmismatcht1_chkpnt (a INT)") // for binlog advancement
+	testutils.RunSQL(t, "INSERT INTO allmismatcht1 (b, c) VALUES (1, 1)")
+	for range 13 {
+		testutils.RunSQL(t, "INSERT INTO allmismatcht1 (b, c) SELECT b, c FROM allmismatcht1")
+	}
+	testutils.RunSQL(t, "INSERT INTO _allmismatcht1_new SELECT * FROM allmismatcht1")
+	// Plant mismatches across the key space.
+	testutils.RunSQL(t, "UPDATE _allmismatcht1_new SET b = 2 WHERE a IN (5, 4005, 8005)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "allmismatcht1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_allmismatcht1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	feed := repl.NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, repl.NewClientDefaultConfig())
+	assert.NoError(t, feed.Run())
+	defer feed.Close()
+
+	config := NewCheckerDefaultConfig()
+	config.Concurrency = 8
+	checker, err := NewChecker(db, t1, t2, feed, config)
+	assert.NoError(t, err)
+	err = checker.Run(context.Background())
+	assert.ErrorContains(t, err, "checksum mismatch in 3 chunks")
+	assert.Equal(t, uint64(3), checker.DifferencesFound())
+
+	// All mismatches are reported, in key order.
+	mismatches := checker.Mismatches()
+	assert.Len(t, mismatches, 3)
+	assert.Contains(t, mismatches[0], "`a` >= 1 AND")
+	for _, mismatch := range mismatches {
+		assert.Contains(t, err.Error(), mismatch)
+	}
+}
+
+func TestChecksumJSONAndSpatial(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS jsonspatialt1, _jsonspatialt1_new, _jsonspatialt1_chkpnt")
+	testutils.RunSQL(t, "CREATE TABLE jsonspatialt1 (a INT NOT NULL, doc TEXT, pt POINT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _jsonspatialt1_new (a INT NOT NULL, doc JSON, pt POINT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _jsonspatialt1_chkpnt (a INT)") // for binlog advancement
+	// The JSON documents are formatted differently, but are equal.
+	testutils.RunSQL(t, `INSERT INTO jsonspatialt1 VALUES
+		(1, '{"b":2,  "a": [1,2,3]}', ST_GeomFromText('POINT(1 2)')),
+		(2, '{ "nested" : { "y": null, "x": true } }', NULL),
+		(3, NULL, ST_GeomFromText('POINT(3 4)'))`)
+	testutils.RunSQL(t, `INSERT INTO _jsonspatialt1_new VALUES
+		(1, '{"a": [1, 2, 3], "b": 2}', ST_GeomFromText('POINT(1 2)')),
+		(2, '{"nested": {"x": true, "y": null}}', NULL),
+		(3, NULL, ST_GeomFromText('POINT(3 4)'))`)
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "jsonspatialt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_jsonspatialt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	feed := repl.NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, repl.NewClientDefaultConfig())
+	assert.NoError(t, feed.Run())
+	defer feed.Close()
+
+	checker, err := NewChecker(db, t1, t2, feed, NewCheckerDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, checker.Run(context.Background()))
+
+	// A different point is still a mismatch.
+	testutils.RunSQL(t, "UPDATE _jsonspatialt1_new SET pt = ST_GeomFromText('POINT(4 3)') WHERE a = 3")
+	checker, err = NewChecker(db, t1, t2, feed, NewCheckerDefaultConfig())
+	assert.NoError(t, err)
+	assert.ErrorContains(t, checker.Run(context.Background()), "checksum mismatch")
+}
+
 func TestBoundaryCases(t *testing.T) {
 	testutils.RunSQL(t, "DROP TABLE IF EXISTS checkert1, _checkert1_new, _checkert1_chkpnt")
 	testutils.RunSQL(t, "CREATE TABLE checkert1 (a INT NOT NULL, b FLOAT, c VARCHAR(255), PRIMARY KEY (a))")
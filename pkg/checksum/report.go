@@ -0,0 +1,124 @@
+package checksum
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/siddontang/loggers"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// ChunkChecksum is the checksum of one chunk of a table.
+type ChunkChecksum struct {
+	Chunk    string `json:"chunk"` // the chunk as a WHERE condition
+	Checksum int64  `json:"checksum"`
+}
+
+// Reporter computes a checksum for each chunk of a single table,
+// without comparing it to a new table. It is intended for auditing,
+// where the checksums are compared by external tooling, i.e. against
+// a previous run. Unlike the Checker, it does not lock the table, so
+// each chunk is read from its own consistent snapshot.
+type Reporter struct {
+	table       *table.TableInfo
+	db          *sql.DB
+	chunker     table.Chunker
+	concurrency int
+	logger      loggers.Advanced
+}
+
+type ReporterConfig struct {
+	Concurrency int
+	// ChunkSize is the fixed number of rows in each chunk. The chunk
+	// size is not dynamic, so that chunks are comparable between runs.
+	ChunkSize uint64
+	Logger    loggers.Advanced
+}
+
+func NewReporterDefaultConfig() *ReporterConfig {
+	return &ReporterConfig{
+		Concurrency: 4,
+		ChunkSize:   table.StartingChunkSize,
+		Logger:      logrus.New(),
+	}
+}
+
+// NewReporter creates a new checksum reporter for tbl.
+func NewReporter(db *sql.DB, tbl *table.TableInfo, config *ReporterConfig) (*Reporter, error) {
+	if tbl == nil {
+		return nil, errors.New("table must be non-nil")
+	}
+	if config.ChunkSize == 0 {
+		return nil, errors.New("chunk size must be greater than zero")
+	}
+	chunker, err := table.NewChunker(tbl, table.ChunkerDefaultTarget, config.Logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := chunker.SetChunkSizeBounds(config.ChunkSize, config.ChunkSize); err != nil {
+		return nil, err
+	}
+	return &Reporter{
+		table:       tbl,
+		db:          db,
+		chunker:     chunker,
+		concurrency: config.Concurrency,
+		logger:      config.Logger,
+	}, nil
+}
+
+// Run checksums each chunk of the table and returns the checksums in chunk
+// order. If w is non-nil, each checksum is also written to it as a line
+// of "<checksum>\t<chunk>".
+func (r *Reporter) Run(ctx context.Context, w io.Writer) ([]ChunkChecksum, error) {
+	if err := r.chunker.Open(); err != nil {
+		return nil, err
+	}
+	defer r.chunker.Close()
+	columns := checksumColumns(r.table, r.table)
+	var results []*ChunkChecksum
+	g, errGrpCtx := errgroup.WithContext(ctx)
+	g.SetLimit(r.concurrency)
+	for !r.chunker.IsRead() && errGrpCtx.Err() == nil {
+		chunk, err := r.chunker.Next()
+		if err != nil {
+			if err == table.ErrTableIsRead {
+				break
+			}
+			return nil, errors.Join(err, g.Wait())
+		}
+		// The chunks are read in order, but checksummed in parallel.
+		result := &ChunkChecksum{Chunk: chunk.String()}
+		results = append(results, result)
+		g.Go(func() error {
+			startTime := time.Now()
+			if err := r.db.QueryRowContext(errGrpCtx, checksumQuery(columns, r.table, chunk)).Scan(&result.Checksum); err != nil {
+				return err
+			}
+			r.chunker.Feedback(chunk, time.Since(startTime))
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	checksums := make([]ChunkChecksum, 0, len(results))
+	for _, result := range results {
+		if w != nil {
+			if _, err := fmt.Fprintf(w, "%d\t%s\n", result.Checksum, result.Chunk); err != nil {
+				return nil, err
+			}
+		}
+		checksums = append(checksums, *result)
+	}
+	return checksums, nil
+}
@@ -0,0 +1,59 @@
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporter(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS reportt1")
+	testutils.RunSQL(t, "CREATE TABLE reportt1 (a INT NOT NULL AUTO_INCREMENT, b INT, c VARCHAR(255), PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO reportt1 (b, c) VALUES (1, 'abc')")
+	for range 10 {
+		testutils.RunSQL(t, "INSERT INTO reportt1 (b, c) SELECT b, c FROM reportt1")
+	}
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	report := func() ([]ChunkChecksum, string) {
+		tbl := table.NewTableInfo(db, "test", "reportt1")
+		assert.NoError(t, tbl.SetInfo(context.TODO()))
+		config := NewReporterDefaultConfig()
+		config.ChunkSize = 100
+		reporter, err := NewReporter(db, tbl, config)
+		assert.NoError(t, err)
+		var buf bytes.Buffer
+		checksums, err := reporter.Run(context.Background(), &buf)
+		assert.NoError(t, err)
+		return checksums, buf.String()
+	}
+
+	// Identical data produces identical checksums.
+	first, output := report()
+	second, _ := report()
+	assert.Greater(t, len(first), 1)
+	assert.Equal(t, first, second)
+	assert.Len(t, strings.Split(strings.TrimSpace(output), "\n"), len(first))
+
+	// Changing a row only changes the checksum of its chunk.
+	testutils.RunSQL(t, "UPDATE reportt1 SET c = 'xyz' WHERE a = 5")
+	changed, _ := report()
+	assert.Len(t, changed, len(first))
+	var differences int
+	for i := range first {
+		assert.Equal(t, first[i].Chunk, changed[i].Chunk)
+		if first[i].Checksum != changed[i].Checksum {
+			differences++
+		}
+	}
+	assert.Equal(t, 1, differences)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,6 +21,10 @@ import (
 const (
 	rdsTLSConfigName = "rds"
 	maxConnLifetime  = time.Minute * 3
+	// DefaultMaxIdleTime is how long a connection can be idle in the pool
+	// before it is closed. It is less than the wait_timeout of many managed
+	// databases, which close connections that are idle for longer.
+	DefaultMaxIdleTime = time.Minute
 )
 
 // rdsAddr matches Amazon RDS hostnames with optional :port suffix.
@@ -30,6 +35,11 @@ var (
 	// https://truststore.pki.rds.amazonaws.com/global/global-bundle.pem
 	//go:embed rdsGlobalBundle.pem
 	rdsGlobalBundle []byte
+
+	// transactionIsolationLevels are the isolation levels that copy only
+	// committed rows. READ UNCOMMITTED could copy a row that is later rolled
+	// back, and SERIALIZABLE locks every row that is read.
+	transactionIsolationLevels = []string{"read-committed", "repeatable-read"}
 )
 
 func IsRDSHost(host string) bool {
@@ -78,7 +88,14 @@ func newDSN(dsn string, config *DBConfig) (string, error) {
 	ops = append(ops, fmt.Sprintf("%s=%s", "innodb_lock_wait_timeout", url.QueryEscape(strconv.Itoa(config.InnodbLockWaitTimeout))))
 	ops = append(ops, fmt.Sprintf("%s=%s", "lock_wait_timeout", url.QueryEscape(strconv.Itoa(config.LockWaitTimeout))))
 	ops = append(ops, fmt.Sprintf("%s=%s", "range_optimizer_max_mem_size", url.QueryEscape(strconv.FormatInt(config.RangeOptimizerMaxMemSize, 10))))
-	ops = append(ops, fmt.Sprintf("%s=%s", "transaction_isolation", url.QueryEscape(`"read-committed"`)))
+	isolation := config.TransactionIsolation
+	if isolation == "" {
+		isolation = "read-committed"
+	}
+	if !slices.Contains(transactionIsolationLevels, isolation) {
+		return "", fmt.Errorf("unsupported transaction isolation %q, must be one of: %s", isolation, strings.Join(transactionIsolationLevels, ", "))
+	}
+	ops = append(ops, fmt.Sprintf("%s=%s", "transaction_isolation", url.QueryEscape(`"`+isolation+`"`)))
 	// go driver options, should set:
 	// character_set_client, character_set_connection, character_set_results
 	ops = append(ops, fmt.Sprintf("%s=%s", "charset", "binary"))
@@ -111,5 +128,6 @@ func New(inputDSN string, config *DBConfig) (*sql.DB, error) {
 	}
 	db.SetMaxOpenConns(config.MaxOpenConnections)
 	db.SetConnMaxLifetime(maxConnLifetime)
+	db.SetConnMaxIdleTime(config.MaxIdleTime)
 	return db, nil
 }
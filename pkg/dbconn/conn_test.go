@@ -4,6 +4,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"testing"
+	"time"
 
 	"github.com/cashapp/spirit/pkg/testutils"
 
@@ -42,6 +43,30 @@ func TestNewDSN(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "root:password@tcp(tern-001.cluster-ro-ckxxxxxxvm.us-west-2.rds.amazonaws.com:12345)/test?tls=rds&sql_mode=%22%22&time_zone=%22%2B00%3A00%22&innodb_lock_wait_timeout=3&lock_wait_timeout=30&range_optimizer_max_mem_size=0&transaction_isolation=%22read-committed%22&charset=binary&collation=binary&rejectReadOnly=true&interpolateParams=false", resp)
 
+	// With a different transaction isolation.
+	dsn = "root:password@tcp(127.0.0.1:3306)/test"
+	config = NewDBConfig()
+	config.TransactionIsolation = "repeatable-read"
+	resp, err = newDSN(dsn, config)
+	assert.NoError(t, err)
+	assert.Contains(t, resp, "transaction_isolation=%22repeatable-read%22")
+
+	// An empty transaction isolation uses the default.
+	resp, err = newDSN(dsn, &DBConfig{})
+	assert.NoError(t, err)
+	assert.Contains(t, resp, "transaction_isolation=%22read-committed%22")
+
+	config.TransactionIsolation = "read committed"
+	_, err = newDSN(dsn, config)
+	assert.ErrorContains(t, err, "unsupported transaction isolation")
+
+	// Only the levels that read committed rows are supported.
+	for _, isolation := range []string{"read-uncommitted", "serializable"} {
+		config.TransactionIsolation = isolation
+		_, err = newDSN(dsn, config)
+		assert.ErrorContains(t, err, `unsupported transaction isolation "`+isolation+`", must be one of: read-committed, repeatable-read`)
+	}
+
 	// Invalid DSN, can't parse.
 	dsn = "invalid"
 	resp, err = newDSN(dsn, NewDBConfig())
@@ -69,6 +94,58 @@ func TestNewConn(t *testing.T) {
 	assert.Nil(t, db)
 }
 
+func TestNewConnTransactionIsolation(t *testing.T) {
+	for isolation, expected := range map[string]string{
+		"":                "READ-COMMITTED",
+		"read-committed":  "READ-COMMITTED",
+		"repeatable-read": "REPEATABLE-READ",
+	} {
+		config := NewDBConfig()
+		config.TransactionIsolation = isolation
+		db, err := New(testutils.DSN(), config)
+		assert.NoError(t, err)
+		var actual string
+		assert.NoError(t, db.QueryRow("SELECT @@transaction_isolation").Scan(&actual))
+		assert.Equal(t, expected, actual)
+		assert.NoError(t, db.Close())
+	}
+}
+
+func TestNewConnMaxIdleTime(t *testing.T) {
+	config := NewDBConfig()
+	assert.Equal(t, DefaultMaxIdleTime, config.MaxIdleTime)
+	config.MaxIdleTime = 10 * time.Millisecond
+	db, err := New(testutils.DSN(), config)
+	assert.NoError(t, err)
+	defer db.Close()
+	// The connection of the ping is closed once it has been idle for too long.
+	assert.Eventually(t, func() bool {
+		return db.Stats().MaxIdleTimeClosed > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewConnIdleWaitTimeout(t *testing.T) {
+	config := NewDBConfig()
+	config.MaxOpenConnections = 1
+	config.MaxIdleTime = 500 * time.Millisecond
+	db, err := New(testutils.DSN(), config)
+	assert.NoError(t, err)
+	defer db.Close()
+	// The server drops the connection once it has been idle for 1s,
+	// like a managed database with a short wait_timeout would.
+	var connID int64
+	assert.NoError(t, db.QueryRow("SELECT CONNECTION_ID()").Scan(&connID))
+	_, err = db.Exec("SET SESSION wait_timeout = 1")
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Second)
+	// The pool closed the connection before the server dropped
+	// it, so the next query succeeds on a new connection.
+	var newConnID int64
+	assert.NoError(t, db.QueryRow("SELECT CONNECTION_ID()").Scan(&newConnID))
+	assert.NotEqual(t, connID, newConnID)
+	assert.Positive(t, db.Stats().MaxIdleTimeClosed)
+}
+
 func TestNewConnRejectsReadOnlyConnections(t *testing.T) {
 	testutils.RunSQL(t, "DROP TABLE IF EXISTS conn_read_only")
 	testutils.RunSQL(t, "CREATE TABLE conn_read_only (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
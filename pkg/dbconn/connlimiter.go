@@ -0,0 +1,55 @@
+package dbconn
+
+import (
+	"context"
+)
+
+// ConnLimiter bounds the number of queries that run concurrently across
+// the components that share it, i.e. the copier and the replication client.
+// Each component has its own concurrency setting, and together they could
+// exceed the number of connections that the migration should use.
+//
+// A nil ConnLimiter does not limit anything, so components can
+// call Acquire and Release without checking if one is configured.
+type ConnLimiter struct {
+	slots chan struct{}
+}
+
+// NewConnLimiter returns a limiter that allows up to limit concurrent queries.
+// It returns nil if limit is zero or less, which means unlimited.
+func NewConnLimiter(limit int) *ConnLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &ConnLimiter{slots: make(chan struct{}, limit)}
+}
+
+// Acquire blocks until a slot is available, or the context is done.
+// Every successful Acquire must be followed by a Release.
+func (l *ConnLimiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns a slot that was acquired.
+func (l *ConnLimiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// InUse returns the number of slots that are currently acquired.
+func (l *ConnLimiter) InUse() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
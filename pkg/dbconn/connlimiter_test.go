@@ -0,0 +1,69 @@
+package dbconn
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimiter(t *testing.T) {
+	limiter := NewConnLimiter(3)
+	var inFlight, maxInFlight int64
+	var wg sync.WaitGroup
+	// Two groups of workers share the limiter, like the copier and
+	// the replication client. Combined they never exceed the limit.
+	for range 2 {
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 20 {
+					assert.NoError(t, limiter.Acquire(context.Background()))
+					n := atomic.AddInt64(&inFlight, 1)
+					for {
+						maxSoFar := atomic.LoadInt64(&maxInFlight)
+						if n <= maxSoFar || atomic.CompareAndSwapInt64(&maxInFlight, maxSoFar, n) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond)
+					atomic.AddInt64(&inFlight, -1)
+					limiter.Release()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxInFlight, int64(3))
+	assert.Positive(t, maxInFlight)
+	assert.Equal(t, 0, limiter.InUse())
+}
+
+func TestConnLimiterContext(t *testing.T) {
+	limiter := NewConnLimiter(1)
+	assert.NoError(t, limiter.Acquire(context.Background()))
+	assert.Equal(t, 1, limiter.InUse())
+
+	// Waiting for a slot respects the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Acquire(ctx), context.DeadlineExceeded)
+
+	limiter.Release()
+	assert.NoError(t, limiter.Acquire(context.Background()))
+	limiter.Release()
+}
+
+func TestConnLimiterUnlimited(t *testing.T) {
+	limiter := NewConnLimiter(0)
+	assert.Nil(t, limiter)
+	for range 100 {
+		assert.NoError(t, limiter.Acquire(context.Background()))
+	}
+	assert.Equal(t, 0, limiter.InUse())
+	limiter.Release()
+}
@@ -22,6 +22,8 @@ const (
 	errQueryKilled      = 1836
 	errCapacityExceeded = 3170
 	errFoundDuppKey     = 1062 // yes I know there's a typo
+	errTransCacheFull   = 1197
+	errLockTableFull    = 1206
 )
 
 type DBConfig struct {
@@ -31,6 +33,18 @@ type DBConfig struct {
 	MaxOpenConnections       int
 	RangeOptimizerMaxMemSize int64
 	InterpolateParams        bool
+	// TransactionIsolation is the isolation level of each connection,
+	// "read-committed" or "repeatable-read". READ COMMITTED avoids
+	// gap locks on the source table when copying with INSERT .. SELECT.
+	// An empty value uses the default of "read-committed".
+	TransactionIsolation string
+	// OnRetry is called with the error each time RetryableTransaction
+	// is about to retry. It may be nil.
+	OnRetry func(err error)
+	// MaxIdleTime closes connections that have been idle in the pool for
+	// longer than this, so that a connection is not reused after the server
+	// closed it for exceeding wait_timeout. Zero keeps idle connections.
+	MaxIdleTime time.Duration
 }
 
 func NewDBConfig() *DBConfig {
@@ -41,6 +55,8 @@ func NewDBConfig() *DBConfig {
 		MaxOpenConnections:       32,    // default is high for historical tests. It's overwritten by the user threads count + 2 for headroom.
 		RangeOptimizerMaxMemSize: 0,     // default is 8M, we set to unlimited. Not user configurable (may reconsider in the future).
 		InterpolateParams:        false, // default is false
+		TransactionIsolation:     "read-committed",
+		MaxIdleTime:              DefaultMaxIdleTime,
 	}
 }
 
@@ -63,6 +79,30 @@ func canRetryError(err error) bool {
 	}
 }
 
+// IsTransactionTooLargeError returns true if err is a MySQL error that
+// is likely to succeed if the transaction modified fewer rows. This is
+// the case when the transaction waited too long for locks, held more
+// locks than fit in the buffer pool, or exceeded max_binlog_cache_size.
+func IsTransactionTooLargeError(err error) bool {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return false
+	}
+	switch myErr.Number {
+	case errLockWaitTimeout, errTransCacheFull, errLockTableFull:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsLockWaitTimeoutError returns true if err is a MySQL lock wait timeout.
+// By default it only rolls back the statement, not the transaction.
+func IsLockWaitTimeoutError(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == errLockWaitTimeout
+}
+
 // RetryableTransaction retries all statements in a transaction, retrying if a statement
 // errors, or there is a deadlock. It will retry up to maxRetries times.
 func RetryableTransaction(ctx context.Context, db *sql.DB, ignoreDupKeyWarnings bool, config *DBConfig, stmts ...string) (int64, error) {
@@ -85,6 +125,9 @@ func RetryableTransaction(ctx context.Context, db *sql.DB, ignoreDupKeyWarnings
 				if err != nil {
 					_ = trx.Rollback()
 					if i < config.MaxRetries-1 && !isFatal {
+						if config.OnRetry != nil {
+							config.OnRetry(err)
+						}
 						backoff(i)
 					}
 				}
@@ -3,12 +3,15 @@ package dbconn
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/cashapp/spirit/pkg/testutils"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -40,6 +43,22 @@ func TestLockWaitTimeouts(t *testing.T) {
 	assert.Equal(t, strconv.Itoa(config.InnodbLockWaitTimeout), innodbLockWaitTimeout)
 }
 
+func TestIsTransactionTooLargeError(t *testing.T) {
+	assert.True(t, IsTransactionTooLargeError(&mysql.MySQLError{Number: 1205}))
+	assert.True(t, IsTransactionTooLargeError(fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1197})))
+	assert.True(t, IsTransactionTooLargeError(&mysql.MySQLError{Number: 1206}))
+	assert.False(t, IsTransactionTooLargeError(&mysql.MySQLError{Number: 1213})) // deadlock
+	assert.False(t, IsTransactionTooLargeError(errors.New("lock wait timeout")))
+	assert.False(t, IsTransactionTooLargeError(nil))
+}
+
+func TestIsLockWaitTimeoutError(t *testing.T) {
+	assert.True(t, IsLockWaitTimeoutError(&mysql.MySQLError{Number: 1205}))
+	assert.True(t, IsLockWaitTimeoutError(fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1205})))
+	assert.False(t, IsLockWaitTimeoutError(&mysql.MySQLError{Number: 1213}))
+	assert.False(t, IsLockWaitTimeoutError(nil))
+}
+
 func TestRetryableTrx(t *testing.T) {
 	config := NewDBConfig()
 	db, err := New(testutils.DSN(), config)
@@ -0,0 +1,271 @@
+package dbconn
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/dbconn/sqlescape"
+	"github.com/siddontang/loggers"
+)
+
+const (
+	DefaultLeaseTTL          = time.Minute      // a lease that is not renewed for this long can be taken by another holder
+	DefaultLeaseWaitInterval = 10 * time.Second // how often a waiting holder checks for a free slot
+)
+
+// ErrLeaseLost is returned by Err when the lease could not be renewed
+// before it expired, and another holder may have taken its slot.
+var ErrLeaseLost = errors.New("the lease expired before it could be renewed")
+
+// LeaseConfig configures AcquireLease.
+type LeaseConfig struct {
+	// SchemaName and TableName are the table that holds the leases. It is
+	// created if it does not exist. Every process that shares the limit must
+	// use the same table, on the same server.
+	SchemaName string
+	TableName  string
+	// Slots is the number of leases that can be held at the same time.
+	Slots int
+	// Holder describes the holder of the lease, i.e. the table that is
+	// being migrated. A unique suffix is added to it.
+	Holder string
+	// TTL is how long a lease is held without being renewed. It is renewed
+	// every third of the TTL, so the slot of a holder that stopped without
+	// releasing it is freed after at most the TTL. Zero uses DefaultLeaseTTL.
+	TTL time.Duration
+	// WaitInterval is how often to check for a free slot while all of
+	// them are held. Zero uses DefaultLeaseWaitInterval.
+	WaitInterval time.Duration
+}
+
+// Lease is one of a fixed number of slots in a table, which limits how many
+// processes, i.e. migrations, run at the same time across hosts. The lease
+// expires unless it is renewed, which it is in the background until Close.
+type Lease struct {
+	sync.Mutex
+	db         *sql.DB
+	tableName  string // quoted schema and table name
+	slot       int
+	holder     string
+	ttl        time.Duration
+	cancel     context.CancelFunc
+	renewsDone chan struct{}
+	err        error
+	lost       chan struct{} // closed when err is set
+}
+
+// AcquireLease blocks until a slot of the lease table is free, or ctx is
+// done, and then holds it. A slot is free if it has never been held, was
+// released, or its lease expired.
+func AcquireLease(ctx context.Context, db *sql.DB, config *LeaseConfig, logger loggers.Advanced) (*Lease, error) {
+	if config.Slots <= 0 {
+		return nil, errors.New("the number of lease slots must be greater than zero")
+	}
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	waitInterval := config.WaitInterval
+	if waitInterval <= 0 {
+		waitInterval = DefaultLeaseWaitInterval
+	}
+	holder, err := leaseHolder(config.Holder)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lease{
+		db:        db,
+		tableName: sqlescape.MustEscapeSQL("%n.%n", config.SchemaName, config.TableName),
+		holder:    holder,
+		ttl:       ttl,
+		lost:      make(chan struct{}),
+	}
+	if err := l.createTable(ctx, config.Slots); err != nil {
+		return nil, err
+	}
+	logger.Infof("acquiring a lease of %s as %s", l.tableName, l.holder)
+	for {
+		acquired, err := l.tryAcquire(ctx, config.Slots)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		logger.Infof("all %d slots of %s are held, waiting %s: %s", config.Slots, l.tableName, waitInterval, l.holders(ctx))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(waitInterval):
+		}
+	}
+	logger.Infof("acquired slot %d of %s", l.slot, l.tableName)
+
+	// Renew the lease in the background until it is closed.
+	var renewCtx context.Context
+	renewCtx, l.cancel = context.WithCancel(context.Background())
+	l.renewsDone = make(chan struct{})
+	go func() {
+		defer close(l.renewsDone)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				if err := l.renew(renewCtx); err != nil {
+					logger.Errorf("could not renew slot %d of %s: %v", l.slot, l.tableName, err)
+					if errors.Is(err, ErrLeaseLost) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return l, nil
+}
+
+// leaseHolder returns the description of the holder,
+// with the host, process and a random suffix to make it unique.
+func leaseHolder(description string) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	holder := fmt.Sprintf("%s@%s:%d-%s", description, hostname, os.Getpid(), hex.EncodeToString(suffix))
+	if len(holder) > 255 {
+		holder = holder[len(holder)-255:]
+	}
+	return holder, nil
+}
+
+// createTable creates the lease table, and a row for each slot.
+func (l *Lease) createTable(ctx context.Context, slots int) error {
+	if _, err := l.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		slot INT UNSIGNED NOT NULL PRIMARY KEY,
+		holder VARCHAR(255) NOT NULL DEFAULT '',
+		expires_at DATETIME(6) NOT NULL DEFAULT '1970-01-01 00:00:01'
+	)`, l.tableName)); err != nil {
+		return fmt.Errorf("could not create the lease table %s: %w", l.tableName, err)
+	}
+	for slot := range slots {
+		if _, err := l.db.ExecContext(ctx, fmt.Sprintf("INSERT IGNORE INTO %s (slot) VALUES (?)", l.tableName), slot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tryAcquire holds the first free slot, and returns false if there is none.
+// The slots are taken with a conditional UPDATE, so two holders can not take
+// the same slot.
+func (l *Lease) tryAcquire(ctx context.Context, slots int) (bool, error) {
+	for slot := range slots {
+		res, err := l.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET holder = ?, expires_at = NOW(6) + INTERVAL ? MICROSECOND WHERE slot = ? AND (holder = '' OR expires_at < NOW(6))", l.tableName),
+			l.holder, l.ttl.Microseconds(), slot)
+		if err != nil {
+			return false, fmt.Errorf("could not acquire slot %d of %s: %w", slot, l.tableName, err)
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return false, err
+		} else if affected == 1 {
+			l.slot = slot
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// renew extends the lease by the TTL. It returns ErrLeaseLost if the slot
+// is no longer held, because it expired and was taken by another holder.
+func (l *Lease) renew(ctx context.Context) error {
+	res, err := l.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET expires_at = NOW(6) + INTERVAL ? MICROSECOND WHERE slot = ? AND holder = ?", l.tableName),
+		l.ttl.Microseconds(), l.slot, l.holder)
+	if err != nil {
+		return err
+	}
+	// The row always changes, since expires_at moves forward.
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		l.Lock()
+		if l.err == nil {
+			l.err = ErrLeaseLost
+			close(l.lost)
+		}
+		l.Unlock()
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// holders describes the holders of the slots, for
+// logging while waiting. It is empty if they can not be read.
+func (l *Lease) holders(ctx context.Context) string {
+	rows, err := l.db.QueryContext(ctx, fmt.Sprintf("SELECT holder, TIMESTAMPDIFF(SECOND, NOW(6), expires_at) FROM %s WHERE holder != '' ORDER BY slot", l.tableName))
+	if err != nil {
+		return ""
+	}
+	defer rows.Close()
+	var holders []string
+	for rows.Next() {
+		var holder string
+		var expiresIn int64
+		if err := rows.Scan(&holder, &expiresIn); err != nil {
+			return ""
+		}
+		holders = append(holders, fmt.Sprintf("%s (expires in %ds)", holder, expiresIn))
+	}
+	return strings.Join(holders, ", ")
+}
+
+// Slot returns the slot that is held.
+func (l *Lease) Slot() int {
+	return l.slot
+}
+
+// Err returns ErrLeaseLost if the lease could not be renewed before it
+// expired, and nil while it is held. A nil Lease is always held.
+func (l *Lease) Err() error {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.err
+}
+
+// Lost returns a channel that is closed when the lease is lost, after which
+// Err returns ErrLeaseLost. The channel of a nil Lease is never closed.
+func (l *Lease) Lost() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	return l.lost
+}
+
+// Close stops renewing the lease and releases its slot, so that
+// a waiting holder can take it. It is safe to call more than once.
+func (l *Lease) Close() error {
+	if l.cancel == nil {
+		return nil
+	}
+	l.cancel()
+	<-l.renewsDone
+	_, err := l.db.Exec(fmt.Sprintf("UPDATE %s SET holder = '', expires_at = '1970-01-01 00:00:01' WHERE slot = ? AND holder = ?", l.tableName),
+		l.slot, l.holder)
+	return err
+}
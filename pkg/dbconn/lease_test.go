@@ -0,0 +1,136 @@
+package dbconn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseHolder(t *testing.T) {
+	holder, err := leaseHolder("test.t1")
+	assert.NoError(t, err)
+	assert.Contains(t, holder, "test.t1@")
+	other, err := leaseHolder("test.t1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, holder, other) // the suffix makes each holder unique.
+
+	_, err = AcquireLease(context.Background(), nil, &LeaseConfig{}, logrus.New())
+	assert.ErrorContains(t, err, "must be greater than zero")
+
+	// Without a lease table there is no lease, which is never lost.
+	var lease *Lease
+	assert.NoError(t, lease.Err())
+	assert.Nil(t, lease.Lost())
+}
+
+func TestLease(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS leaset1")
+	db, err := New(testutils.DSN(), NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	config := &LeaseConfig{
+		SchemaName:   "test",
+		TableName:    "leaset1",
+		Slots:        2,
+		Holder:       "test.t1",
+		WaitInterval: 10 * time.Millisecond,
+	}
+
+	// N contenders acquire a slot each.
+	first, err := AcquireLease(context.Background(), db, config, logrus.New())
+	assert.NoError(t, err)
+	second, err := AcquireLease(context.Background(), db, config, logrus.New())
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{0, 1}, []int{first.Slot(), second.Slot()})
+
+	// The N+1th contender waits.
+	acquired := make(chan *Lease)
+	go func() {
+		lease, err := AcquireLease(context.Background(), db, config, logrus.New())
+		assert.NoError(t, err)
+		acquired <- lease
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a lease while all slots are held")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// It takes the slot that is released.
+	assert.NoError(t, second.Close())
+	assert.NoError(t, second.Close()) // it is safe to close twice.
+	var third *Lease
+	select {
+	case third = <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not acquire the released slot")
+	}
+	assert.Equal(t, second.Slot(), third.Slot())
+
+	// A contender that gives up waiting returns the error of the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = AcquireLease(ctx, db, config, logrus.New())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, first.Close())
+	assert.NoError(t, third.Close())
+	var held int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM test.leaset1 WHERE holder != ''").Scan(&held))
+	assert.Equal(t, 0, held)
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS leaset2")
+	db, err := New(testutils.DSN(), NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	config := &LeaseConfig{
+		SchemaName:   "test",
+		TableName:    "leaset2",
+		Slots:        1,
+		Holder:       "test.t1",
+		TTL:          300 * time.Millisecond,
+		WaitInterval: 10 * time.Millisecond,
+	}
+
+	// The lease is renewed in the background, so it does not expire.
+	lease, err := AcquireLease(context.Background(), db, config, logrus.New())
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	_, err = AcquireLease(ctx, db, config, logrus.New())
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoError(t, lease.Err())
+
+	// The holder is killed without releasing the lease, so it is not
+	// renewed. Another contender takes the slot once it expires.
+	lease.cancel()
+	<-lease.renewsDone
+	startTime := time.Now()
+	other, err := AcquireLease(context.Background(), db, config, logrus.New())
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(startTime), time.Second)
+	assert.Equal(t, 0, other.Slot())
+
+	// The lease of the killed holder is lost.
+	select {
+	case <-lease.Lost():
+		t.Fatal("the lease was lost before it could not be renewed")
+	default:
+	}
+	assert.ErrorIs(t, lease.renew(context.Background()), ErrLeaseLost)
+	assert.ErrorIs(t, lease.Err(), ErrLeaseLost)
+	<-lease.Lost()
+	// It is safe to lose it again.
+	assert.ErrorIs(t, lease.renew(context.Background()), ErrLeaseLost)
+	assert.NoError(t, lease.Close()) // does not release the slot of the other holder.
+	var holder string
+	assert.NoError(t, db.QueryRow("SELECT holder FROM test.leaset2 WHERE slot = 0").Scan(&holder))
+	assert.Equal(t, other.holder, holder)
+	assert.NoError(t, other.Close())
+}
@@ -20,6 +20,11 @@ var (
 	// because we want to return immediately if the lock is not available
 	getLockTimeout  = 0 * time.Second
 	refreshInterval = 1 * time.Minute
+
+	// ErrMetadataLockHeld is returned when another connection holds the
+	// metadata lock, which usually means another migration is running
+	// on the same table.
+	ErrMetadataLockHeld = errors.New("lock is held by another connection")
 )
 
 type MetadataLock struct {
@@ -70,11 +75,10 @@ func NewMetadataLock(ctx context.Context, dsn string, table *table.TableInfo, lo
 		}
 		if answer == 0 {
 			// 0 means the lock is held by another connection
-			// TODO: we could lookup the connection that holds the lock and report details about it
-			logger.Warnf("could not acquire metadata lock for %s, lock is held by another connection", mdl.lockName)
-
-			// TODO: we could deal in error codes instead of string contains checks.
-			return fmt.Errorf("could not acquire metadata lock for %s, lock is held by another connection", mdl.lockName)
+			holder := mdl.lockHolder(ctx)
+			logger.Warnf("could not acquire metadata lock for %s, lock is held by another connection%s", mdl.lockName, holder)
+			return fmt.Errorf("could not acquire metadata lock for %s, %w%s: is another migration already running on %s.%s?",
+				mdl.lockName, ErrMetadataLockHeld, holder, table.SchemaName, table.TableName)
 		} else if answer != 1 {
 			// probably we never get here, but just in case
 			return fmt.Errorf("could not acquire metadata lock %s, GET_LOCK returned: %d", mdl.lockName, answer)
@@ -159,6 +163,19 @@ func (m *MetadataLock) CloseDBConnection(logger loggers.Advanced) error {
 	return nil
 }
 
+// lockHolder returns a description of the connection that holds the lock,
+// for use in error messages. It returns an empty string if the holder can not
+// be found, i.e. because the lock was released in the meantime.
+func (m *MetadataLock) lockHolder(ctx context.Context) string {
+	var id int64
+	var user, host string
+	err := m.db.QueryRowContext(ctx, "SELECT ID, USER, HOST FROM information_schema.processlist WHERE ID = IS_USED_LOCK(?)", m.lockName).Scan(&id, &user, &host)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(" (connection %d, %s@%s)", id, user, host)
+}
+
 func (m *MetadataLock) GetLockName() string {
 	return m.lockName
 }
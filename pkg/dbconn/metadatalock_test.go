@@ -33,6 +33,33 @@ func TestMetadataLock(t *testing.T) {
 	assert.NoError(t, mdl3.Close())
 }
 
+func TestMetadataLockContention(t *testing.T) {
+	lockTableInfo := table.TableInfo{SchemaName: "test", TableName: "test-contention"}
+	logger := logrus.New()
+	mdl, err := NewMetadataLock(context.Background(), testutils.DSN(), &lockTableInfo, logger)
+	assert.NoError(t, err)
+
+	// A second client fails fast, and is told who holds the lock.
+	start := time.Now()
+	_, err = NewMetadataLock(context.Background(), testutils.DSN(), &lockTableInfo, logger)
+	assert.ErrorIs(t, err, ErrMetadataLockHeld)
+	assert.ErrorContains(t, err, "(connection ")
+	assert.ErrorContains(t, err, "is another migration already running on test.test-contention?")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// A lock on a different table is not affected.
+	otherTableInfo := table.TableInfo{SchemaName: "test", TableName: "test-contention2"}
+	mdl2, err := NewMetadataLock(context.Background(), testutils.DSN(), &otherTableInfo, logger)
+	assert.NoError(t, err)
+	assert.NoError(t, mdl2.Close())
+
+	// Once released, the second client can acquire it.
+	assert.NoError(t, mdl.Close())
+	mdl3, err := NewMetadataLock(context.Background(), testutils.DSN(), &lockTableInfo, logger)
+	assert.NoError(t, err)
+	assert.NoError(t, mdl3.Close())
+}
+
 func TestMetadataLockContextCancel(t *testing.T) {
 	lockTableInfo := table.TableInfo{SchemaName: "test", TableName: "test-cancel"}
 
@@ -16,13 +16,13 @@ type TableLock struct {
 	logger  loggers.Advanced
 }
 
-// NewTableLock creates a new server wide lock on a table.
-// i.e. LOCK TABLES .. READ.
+// NewTableLock creates a new server wide lock on a table and its new table.
+// i.e. LOCK TABLES .. WRITE.
 // It uses a short-timeout with backoff and retry, since if there is a long-running
 // process that currently prevents the lock by being acquired, it is considered "nice"
 // to let a few short-running processes slip in and proceed, then optimistically try
 // and acquire the lock again.
-func NewTableLock(ctx context.Context, db *sql.DB, table *table.TableInfo, config *DBConfig, logger loggers.Advanced) (*TableLock, error) {
+func NewTableLock(ctx context.Context, db *sql.DB, table, newTable *table.TableInfo, config *DBConfig, logger loggers.Advanced) (*TableLock, error) {
 	var err error
 	var isFatal bool
 	var lockTxn *sql.Tx
@@ -42,9 +42,9 @@ func NewTableLock(ctx context.Context, db *sql.DB, table *table.TableInfo, confi
 			// instead, we DROP IF EXISTS just before the rename, which
 			// has a brief race.
 			logger.Warnf("trying to acquire table lock, timeout: %d", config.LockWaitTimeout)
-			_, err = lockTxn.ExecContext(ctx, fmt.Sprintf("LOCK TABLES %s WRITE, `%s`.`_%s_new` WRITE",
-				table.QuotedName,
+			_, err = lockTxn.ExecContext(ctx, fmt.Sprintf("LOCK TABLES `%s`.`%s` WRITE, `%s`.`%s` WRITE",
 				table.SchemaName, table.TableName,
+				newTable.SchemaName, newTable.TableName,
 			))
 			if err != nil {
 				// See if the error is retryable, many are
@@ -31,13 +31,14 @@ func TestTableLock(t *testing.T) {
 	assert.NoError(t, err)
 
 	tbl := &table.TableInfo{SchemaName: "test", TableName: "testlock", QuotedName: "`test`.`testlock`"}
+	newTbl := &table.TableInfo{SchemaName: "test", TableName: "_testlock_new", QuotedName: "`test`.`_testlock_new`"}
 
-	lock1, err := NewTableLock(context.Background(), db, tbl, testConfig(), logrus.New())
+	lock1, err := NewTableLock(context.Background(), db, tbl, newTbl, testConfig(), logrus.New())
 	assert.NoError(t, err)
 
 	// Try to acquire a table that is already locked, should fail because we use WRITE locks now.
 	// But should also fail very quickly because we've set the lock_wait_timeout to 1s.
-	_, err = NewTableLock(context.Background(), db, tbl, testConfig(), logrus.New())
+	_, err = NewTableLock(context.Background(), db, tbl, newTbl, testConfig(), logrus.New())
 	assert.Error(t, err)
 
 	assert.NoError(t, lock1.Close())
@@ -55,7 +56,8 @@ func TestExecUnderLock(t *testing.T) {
 	assert.NoError(t, err)
 
 	tbl := &table.TableInfo{SchemaName: "test", TableName: "testunderlock", QuotedName: "`test`.`testunderlock`"}
-	lock, err := NewTableLock(context.Background(), db, tbl, testConfig(), logrus.New())
+	newTbl := &table.TableInfo{SchemaName: "test", TableName: "_testunderlock_new", QuotedName: "`test`.`_testunderlock_new`"}
+	lock, err := NewTableLock(context.Background(), db, tbl, newTbl, testConfig(), logrus.New())
 	assert.NoError(t, err)
 	err = lock.ExecUnderLock(context.Background(), "INSERT INTO testunderlock VALUES (1, 1)", "", "INSERT INTO testunderlock VALUES (2, 2)")
 	assert.NoError(t, err) // pass, under write lock.
@@ -92,6 +94,7 @@ func TestTableLockFail(t *testing.T) {
 	wg.Wait()
 
 	tbl := &table.TableInfo{SchemaName: "test", TableName: "testlockfail"}
-	_, err = NewTableLock(context.Background(), db, tbl, testConfig(), logrus.New())
+	newTbl := &table.TableInfo{SchemaName: "test", TableName: "_testlockfail_new"}
+	_, err = NewTableLock(context.Background(), db, tbl, newTbl, testConfig(), logrus.New())
 	assert.Error(t, err)
 }
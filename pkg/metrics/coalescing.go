@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// CoalesceErrorInterval is the minimum interval between
+	// errors returned by a coalescing sink.
+	CoalesceErrorInterval = 1 * time.Minute
+	// CircuitBreakerThreshold is the number of consecutive failures
+	// after which a coalescing sink stops sending metrics.
+	CircuitBreakerThreshold = 10
+	// CircuitBreakerCooldown is how long a coalescing sink stops
+	// sending metrics for after CircuitBreakerThreshold failures.
+	CircuitBreakerCooldown = 5 * time.Minute
+)
+
+// coalescingSink wraps a Sink so that a persistently failing sink does not
+// flood the logs of the caller. Errors are returned at most once per
+// CoalesceErrorInterval, with a count of the failures since the last error
+// was returned. After CircuitBreakerThreshold consecutive failures, metrics
+// are dropped without attempting to send them for CircuitBreakerCooldown.
+type coalescingSink struct {
+	sync.Mutex
+	sink                Sink
+	clock               func() time.Time
+	failures            int // since the last error was returned
+	consecutiveFailures int
+	lastError           time.Time
+	openUntil           time.Time
+}
+
+func (s *coalescingSink) Send(ctx context.Context, m *Metrics) error {
+	s.Lock()
+	isOpen := s.clock().Before(s.openUntil)
+	s.Unlock()
+	if isOpen {
+		return nil // circuit breaker is open, drop the metrics.
+	}
+	err := s.sink.Send(ctx, m)
+	s.Lock()
+	defer s.Unlock()
+	if err == nil {
+		s.consecutiveFailures = 0
+		return nil
+	}
+	now := s.clock()
+	s.failures++
+	s.consecutiveFailures++
+	if s.consecutiveFailures >= CircuitBreakerThreshold {
+		err = fmt.Errorf("%w (%d failures, not sending metrics for %s)", err, s.failures, CircuitBreakerCooldown)
+		s.openUntil = now.Add(CircuitBreakerCooldown)
+		s.consecutiveFailures = 0
+	} else if !s.lastError.IsZero() && now.Sub(s.lastError) < CoalesceErrorInterval {
+		return nil // coalesced into the next error.
+	} else {
+		err = fmt.Errorf("%w (%d failures since last reported)", err, s.failures)
+	}
+	s.failures = 0
+	s.lastError = now
+	return err
+}
+
+var _ Sink = &coalescingSink{}
+
+// NewCoalescingSink returns a sink that coalesces errors from sink,
+// and stops sending to it for a cooldown after repeated failures.
+func NewCoalescingSink(sink Sink) Sink {
+	return &coalescingSink{
+		sink:  sink,
+		clock: time.Now,
+	}
+}
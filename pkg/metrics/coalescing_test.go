@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSink struct {
+	calls int
+	err   error
+}
+
+func (s *failingSink) Send(ctx context.Context, m *Metrics) error {
+	s.calls++
+	return s.err
+}
+
+func TestCoalescingSink(t *testing.T) {
+	underlying := &failingSink{err: errors.New("sink unavailable")}
+	sink := NewCoalescingSink(underlying).(*coalescingSink)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink.clock = func() time.Time { return now }
+
+	// The first failure is returned, the next are coalesced
+	// until the circuit breaker opens.
+	var errs []error
+	for range CircuitBreakerThreshold + 5 {
+		if err := sink.Send(context.Background(), &Metrics{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	assert.Len(t, errs, 2)
+	assert.ErrorIs(t, errs[0], underlying.err)
+	assert.ErrorContains(t, errs[0], "1 failures since last reported")
+	assert.ErrorContains(t, errs[1], "9 failures, not sending metrics")
+	assert.Equal(t, CircuitBreakerThreshold, underlying.calls)
+
+	// After the cooldown, sends are attempted again.
+	now = now.Add(CircuitBreakerCooldown)
+	assert.ErrorContains(t, sink.Send(context.Background(), &Metrics{}), "1 failures since last reported")
+	assert.Equal(t, CircuitBreakerThreshold+1, underlying.calls)
+
+	// A success resets the consecutive failures.
+	underlying.err = nil
+	assert.NoError(t, sink.Send(context.Background(), &Metrics{}))
+	assert.Equal(t, 0, sink.consecutiveFailures)
+
+	// Failures are reported again after the interval.
+	underlying.err = errors.New("sink unavailable")
+	assert.NoError(t, sink.Send(context.Background(), &Metrics{}))
+	now = now.Add(CoalesceErrorInterval)
+	assert.ErrorContains(t, sink.Send(context.Background(), &Metrics{}), "2 failures since last reported")
+}
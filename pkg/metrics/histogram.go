@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// DefaultLatencyBuckets are the upper bounds in milliseconds of the buckets
+// of a Histogram of latencies, from 1ms to 30s.
+var DefaultLatencyBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// Histogram counts observations in buckets, like a Prometheus histogram.
+// A client uses it to aggregate observations before they are sent as
+// counters, see Values.
+type Histogram struct {
+	sync.Mutex
+	buckets []float64 // the upper bound of each bucket, ascending
+	counts  []uint64  // the observations in each bucket, the last is +Inf
+	count   uint64
+	sum     float64
+}
+
+// NewHistogram returns a histogram with buckets, the ascending
+// upper bounds of its buckets. A +Inf bucket is always added.
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: slices.Clone(buckets),
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds value to the bucket with the smallest upper bound that it
+// does not exceed.
+func (h *Histogram) Observe(value float64) {
+	h.Lock()
+	defer h.Unlock()
+	i, _ := slices.BinarySearch(h.buckets, value)
+	h.counts[i]++
+	h.count++
+	h.sum += value
+}
+
+// BucketCounts returns the cumulative count of observations that are less
+// than or equal to the upper bound of each bucket, as Prometheus reports
+// them. The last count is the +Inf bucket, which is all observations.
+func (h *Histogram) BucketCounts() []uint64 {
+	h.Lock()
+	defer h.Unlock()
+	cumulative := make([]uint64, len(h.counts))
+	var total uint64
+	for i, count := range h.counts {
+		total += count
+		cumulative[i] = total
+	}
+	return cumulative
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.count
+}
+
+// Sum returns the sum of all observations.
+func (h *Histogram) Sum() float64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.sum
+}
+
+// Values returns the observations as COUNTER values: name_bucket_le_<bound>
+// with the cumulative count of each bucket (the last is name_bucket_le_inf),
+// name_count and name_sum. A client that sends them periodically should
+// start a new histogram after each send, so that they are the observations
+// since the last send, like the other counters.
+func (h *Histogram) Values(name string) []MetricValue {
+	counts := h.BucketCounts()
+	values := make([]MetricValue, 0, len(counts)+2)
+	for i, count := range counts {
+		bound := "inf"
+		if i < len(h.buckets) {
+			bound = strconv.FormatFloat(h.buckets[i], 'f', -1, 64)
+		}
+		values = append(values, MetricValue{Name: name + "_bucket_le_" + bound, Type: COUNTER, Value: float64(count)})
+	}
+	return append(values,
+		MetricValue{Name: name + "_count", Type: COUNTER, Value: float64(h.Count())},
+		MetricValue{Name: name + "_sum", Type: COUNTER, Value: h.Sum()},
+	)
+}
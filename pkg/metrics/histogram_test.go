@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{10, 100, 1000})
+	for _, v := range []float64{1, 10, 11, 50, 100, 999, 5000, 0} {
+		h.Observe(v)
+	}
+	// An observation equal to an upper bound is in that bucket.
+	assert.Equal(t, []uint64{3, 6, 7, 8}, h.BucketCounts())
+	assert.Equal(t, uint64(8), h.Count())
+	assert.InDelta(t, 6171.0, h.Sum(), 0.001)
+
+	// An empty histogram has a +Inf bucket.
+	h = NewHistogram(nil)
+	assert.Equal(t, []uint64{0}, h.BucketCounts())
+	h.Observe(1)
+	assert.Equal(t, []uint64{1}, h.BucketCounts())
+}
+
+func TestHistogramValues(t *testing.T) {
+	h := NewHistogram([]float64{0.5, 10})
+	for _, v := range []float64{0.1, 5, 20} {
+		h.Observe(v)
+	}
+	assert.Equal(t, []MetricValue{
+		{Name: "latency_bucket_le_0.5", Type: COUNTER, Value: 1},
+		{Name: "latency_bucket_le_10", Type: COUNTER, Value: 2},
+		{Name: "latency_bucket_le_inf", Type: COUNTER, Value: 3},
+		{Name: "latency_count", Type: COUNTER, Value: 3},
+		{Name: "latency_sum", Type: COUNTER, Value: 25.1},
+	}, h.Values("latency"))
+}
@@ -18,8 +18,21 @@ const (
 	SinkTimeout = 1 * time.Second
 
 	ChunkProcessingTimeMetricName    = "chunk_processing_time"
+	ChunkProcessingTimeP50MetricName = "chunk_processing_time_p50"
+	ChunkProcessingTimeP95MetricName = "chunk_processing_time_p95"
+	ChunkProcessingTimeP99MetricName = "chunk_processing_time_p99"
 	ChunkLogicalRowsCountMetricName  = "chunk_num_logical_rows"
 	ChunkAffectedRowsCountMetricName = "chunk_num_affected_rows"
+	ChunkSlowCountMetricName         = "chunk_slow_count"
+	ChunkIgnoredRowsCountMetricName  = "chunk_num_ignored_rows"
+	ChunkThrottleWaitTimeMetricName  = "chunk_throttle_wait_time"
+	ChunkPrefetchTimeMetricName      = "chunk_prefetch_time"
+	BinlogErrorCountMetricName       = "binlog_error_count"
+	BinlogRowEventsCountMetricName   = "binlog_row_events_count"
+	BinlogRowEventsRateMetricName    = "binlog_row_events_per_second"
+	BinlogUnknownActionsMetricName   = "binlog_unknown_actions"
+	BinlogFlushBatchTimeMetricName   = "binlog_flush_batch_time"
+	BinlogChangesetDepthMetricName   = "binlog_changeset_depth"
 )
 
 // Metrics are collection of MetricValues.
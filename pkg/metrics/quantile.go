@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultQuantileAccuracy is the relative accuracy of the
+	// quantiles of a QuantileSketch, i.e. within 1% of the value.
+	DefaultQuantileAccuracy = 0.01
+	// maxSketchBuckets bounds the memory of a QuantileSketch. With 1%
+	// accuracy it covers values from 1ms to more than a day without
+	// collapsing, which is more than the range of chunk times.
+	maxSketchBuckets = 2048
+)
+
+// QuantileSketch estimates the quantiles of a stream of positive values
+// in bounded memory. Values are counted in buckets whose bounds grow
+// logarithmically, so a quantile is estimated within a relative accuracy
+// of the true value, regardless of the distribution. If the buckets
+// exceed a fixed maximum, the lowest buckets are collapsed into one,
+// so only the accuracy of the lowest quantiles is lost.
+type QuantileSketch struct {
+	sync.Mutex
+	gamma    float64        // the ratio of the upper to the lower bound of a bucket
+	logGamma float64        // math.Log(gamma)
+	buckets  map[int]uint64 // observations by bucket index
+	zeros    uint64         // observations that are zero or negative
+	count    uint64
+}
+
+// NewQuantileSketch returns a sketch that estimates quantiles within
+// accuracy of their true value, e.g. 0.01 for 1%. An accuracy that is
+// not between 0 and 1 uses DefaultQuantileAccuracy.
+func NewQuantileSketch(accuracy float64) *QuantileSketch {
+	if accuracy <= 0 || accuracy >= 1 {
+		accuracy = DefaultQuantileAccuracy
+	}
+	gamma := (1 + accuracy) / (1 - accuracy)
+	return &QuantileSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		buckets:  make(map[int]uint64),
+	}
+}
+
+// Observe adds value to the sketch.
+func (s *QuantileSketch) Observe(value float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.count++
+	if value <= 0 || math.IsNaN(value) {
+		s.zeros++
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(value)/s.logGamma))]++
+	if len(s.buckets) > maxSketchBuckets {
+		s.collapseLowest()
+	}
+}
+
+// collapseLowest merges the two lowest buckets.
+func (s *QuantileSketch) collapseLowest() {
+	indexes := s.sortedIndexes()
+	lowest, next := indexes[0], indexes[1]
+	s.buckets[next] += s.buckets[lowest]
+	delete(s.buckets, lowest)
+}
+
+func (s *QuantileSketch) sortedIndexes() []int {
+	indexes := make([]int, 0, len(s.buckets))
+	for i := range s.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Quantile returns the estimate of the q quantile, e.g. 0.99 for the
+// 99th percentile. It returns zero if there are no observations.
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	s.Lock()
+	defer s.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	q = math.Max(0, math.Min(1, q))
+	rank := uint64(q * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+	seen := s.zeros
+	for _, i := range s.sortedIndexes() {
+		seen += s.buckets[i]
+		if seen > rank {
+			// The midpoint of the bucket, which is
+			// within the accuracy of all of its values.
+			return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+		}
+	}
+	return 0 // not reached
+}
+
+// Count returns the number of observations.
+func (s *QuantileSketch) Count() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.count
+}
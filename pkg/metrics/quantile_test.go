@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	s := NewQuantileSketch(DefaultQuantileAccuracy)
+	assert.Zero(t, s.Quantile(0.5)) // no observations
+
+	// A uniform distribution from 1ms to 1000ms, in a random order.
+	values := make([]float64, 0, 100000)
+	for i := 1; i <= 100000; i++ {
+		values = append(values, float64(i)/100)
+	}
+	rand.Shuffle(len(values), func(i, j int) { values[i], values[j] = values[j], values[i] })
+	for _, v := range values {
+		s.Observe(v)
+	}
+	assert.Equal(t, uint64(100000), s.Count())
+	assert.InEpsilon(t, 500.0, s.Quantile(0.5), 0.01)
+	assert.InEpsilon(t, 950.0, s.Quantile(0.95), 0.01)
+	assert.InEpsilon(t, 990.0, s.Quantile(0.99), 0.01)
+	assert.InEpsilon(t, 1000.0, s.Quantile(1), 0.01)
+	assert.InEpsilon(t, 0.01, s.Quantile(0), 0.01)
+}
+
+func TestQuantileSketchSkewed(t *testing.T) {
+	// Mostly fast chunks, with a long tail of slow ones. The
+	// estimates are compared with the exact quantiles.
+	r := rand.New(rand.NewSource(1))
+	s := NewQuantileSketch(DefaultQuantileAccuracy)
+	values := make([]float64, 0, 50000)
+	for range 50000 {
+		v := r.ExpFloat64() * 50
+		values = append(values, v)
+		s.Observe(v)
+	}
+	sort.Float64s(values)
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		exact := values[int(q*float64(len(values)-1))]
+		assert.InEpsilon(t, exact, s.Quantile(q), 0.01, "quantile %v", q)
+	}
+
+	// Zeros are counted, and estimated as zero.
+	s = NewQuantileSketch(0)
+	for range 10 {
+		s.Observe(0)
+	}
+	s.Observe(100)
+	assert.Zero(t, s.Quantile(0.5))
+	assert.InEpsilon(t, 100.0, s.Quantile(1), 0.01)
+}
+
+func TestQuantileSketchBoundedMemory(t *testing.T) {
+	s := NewQuantileSketch(DefaultQuantileAccuracy)
+	// Values over 60 orders of magnitude need more buckets than the maximum.
+	for i := -30; i < 30; i++ {
+		for v := 1.0; v < 10; v += 0.01 {
+			s.Observe(v * math.Pow10(i))
+		}
+	}
+	assert.LessOrEqual(t, len(s.buckets), maxSketchBuckets)
+	// The highest quantiles are still accurate.
+	assert.InEpsilon(t, 9.99e29, s.Quantile(1), 0.01)
+}
@@ -0,0 +1,120 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+)
+
+var ErrNoCheckpoint = errors.New("no checkpoint found")
+
+// Checkpoint is the state of a migration that is required to resume it:
+// how far the copier and checksum have progressed, and the binary log
+// position from which changes must be replayed.
+type Checkpoint struct {
+	CopierWatermark   string
+	ChecksumWatermark string
+	BinlogName        string
+	BinlogPos         uint32
+	RowsCopied        uint64
+	RowsCopiedLogical uint64
+	AlterStatement    string
+	// OriginalDDL is the SHOW CREATE TABLE of the table before it was
+	// migrated, for auditing and planning a rollback.
+	OriginalDDL string
+	// DeferredIndexes are the definitions of the secondary indexes that are
+	// added to the new table after the copy, separated by newlines.
+	DeferredIndexes string
+}
+
+// CheckpointStore saves and loads checkpoints for a single migration.
+// By default checkpoints are stored in a _chkpnt table next to the table
+// being migrated, but any implementation can be provided with
+// Runner.SetCheckpointStore.
+type CheckpointStore interface {
+	// Create prepares the store for a new migration,
+	// discarding any checkpoint that it already contains.
+	Create(ctx context.Context) error
+	// Save stores a checkpoint. It is only required that
+	// the most recently saved checkpoint can be loaded.
+	Save(ctx context.Context, cp *Checkpoint) error
+	// Load returns the most recently saved checkpoint,
+	// or an error wrapping ErrNoCheckpoint if there is none.
+	Load(ctx context.Context) (*Checkpoint, error)
+	// Drop removes all checkpoints from the store.
+	Drop(ctx context.Context) error
+}
+
+// tableCheckpointStore is the default CheckpointStore.
+// Each checkpoint is inserted as a new row in a table.
+type tableCheckpointStore struct {
+	db         *sql.DB
+	schemaName string
+	tableName  string
+}
+
+var _ CheckpointStore = &tableCheckpointStore{}
+
+func (s *tableCheckpointStore) Create(ctx context.Context) error {
+	if err := s.Drop(ctx); err != nil {
+		return err
+	}
+	return dbconn.Exec(ctx, s.db, `CREATE TABLE %n.%n (
+	id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	copier_watermark TEXT,
+	checksum_watermark TEXT,
+	binlog_name VARCHAR(255),
+	binlog_pos INT,
+	rows_copied BIGINT,
+	rows_copied_logical BIGINT,
+	alter_statement TEXT,
+	original_ddl TEXT,
+	deferred_indexes TEXT
+	)`,
+		s.schemaName, s.tableName)
+}
+
+func (s *tableCheckpointStore) Save(ctx context.Context, cp *Checkpoint) error {
+	return dbconn.Exec(ctx, s.db, "INSERT INTO %n.%n (copier_watermark, checksum_watermark, binlog_name, binlog_pos, rows_copied, rows_copied_logical, alter_statement, original_ddl, deferred_indexes) VALUES (%?, %?, %?, %?, %?, %?, %?, %?, %?)",
+		s.schemaName,
+		s.tableName,
+		cp.CopierWatermark,
+		cp.ChecksumWatermark,
+		cp.BinlogName,
+		cp.BinlogPos,
+		cp.RowsCopied,
+		cp.RowsCopiedLogical,
+		cp.AlterStatement,
+		cp.OriginalDDL,
+		cp.DeferredIndexes,
+	)
+}
+
+func (s *tableCheckpointStore) Load(ctx context.Context) (*Checkpoint, error) {
+	// We intentionally SELECT * FROM the checkpoint table because if the structure
+	// changes, we want this operation to fail. This will indicate that the checkpoint
+	// was created by either an earlier or later version of spirit, in which case
+	// we do not support recovery.
+	query := fmt.Sprintf("SELECT * FROM `%s`.`%s` ORDER BY id DESC LIMIT 1",
+		s.schemaName, s.tableName)
+	var cp Checkpoint
+	var id int
+	var originalDDL, deferredIndexes sql.NullString
+	err := s.db.QueryRowContext(ctx, query).Scan(&id, &cp.CopierWatermark, &cp.ChecksumWatermark, &cp.BinlogName, &cp.BinlogPos, &cp.RowsCopied, &cp.RowsCopiedLogical, &cp.AlterStatement, &originalDDL, &deferredIndexes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w in table '%s'", ErrNoCheckpoint, s.tableName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read from table '%s', err:%v", s.tableName, err)
+	}
+	cp.OriginalDDL = originalDDL.String
+	cp.DeferredIndexes = deferredIndexes.String
+	return &cp, nil
+}
+
+func (s *tableCheckpointStore) Drop(ctx context.Context) error {
+	return dbconn.Exec(ctx, s.db, "DROP TABLE IF EXISTS %n.%n", s.schemaName, s.tableName)
+}
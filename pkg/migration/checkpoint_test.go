@@ -0,0 +1,205 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/repl"
+	"github.com/cashapp/spirit/pkg/row"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryCheckpointStore keeps checkpoints in memory.
+// It survives a new Runner, but not a new process.
+type memoryCheckpointStore struct {
+	sync.Mutex
+	checkpoints []Checkpoint
+}
+
+func (s *memoryCheckpointStore) Create(ctx context.Context) error {
+	return s.Drop(ctx)
+}
+
+func (s *memoryCheckpointStore) Save(_ context.Context, cp *Checkpoint) error {
+	s.Lock()
+	defer s.Unlock()
+	s.checkpoints = append(s.checkpoints, *cp)
+	return nil
+}
+
+func (s *memoryCheckpointStore) Load(_ context.Context) (*Checkpoint, error) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.checkpoints) == 0 {
+		return nil, ErrNoCheckpoint
+	}
+	cp := s.checkpoints[len(s.checkpoints)-1]
+	return &cp, nil
+}
+
+func (s *memoryCheckpointStore) Drop(_ context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+	s.checkpoints = nil
+	return nil
+}
+
+func TestMemoryCheckpointStore(t *testing.T) {
+	store := &memoryCheckpointStore{}
+	_, err := store.Load(context.Background())
+	assert.ErrorIs(t, err, ErrNoCheckpoint)
+
+	cp1 := &Checkpoint{
+		CopierWatermark:   `{"Key":["id"],"ChunkSize":1000,"LowerBound":{"Value":["1001"],"Inclusive":true},"UpperBound":{"Value":["2001"],"Inclusive":false}}`,
+		BinlogName:        "binlog.000002",
+		BinlogPos:         4,
+		RowsCopied:        1000,
+		RowsCopiedLogical: 1000,
+		AlterStatement:    "ENGINE=InnoDB",
+	}
+	cp2 := *cp1
+	cp2.ChecksumWatermark = `{"Key":["id"],"ChunkSize":1000,"LowerBound":{"Value":["1"],"Inclusive":true},"UpperBound":{"Value":["1001"],"Inclusive":false}}`
+	cp2.BinlogPos = 1234
+	assert.NoError(t, store.Save(context.Background(), cp1))
+	assert.NoError(t, store.Save(context.Background(), &cp2))
+
+	// The most recent checkpoint is loaded.
+	loaded, err := store.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, cp2, *loaded)
+
+	assert.NoError(t, store.Create(context.Background()))
+	_, err = store.Load(context.Background())
+	assert.ErrorIs(t, err, ErrNoCheckpoint)
+}
+
+func TestCustomCheckpointStore(t *testing.T) {
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS cpstore1, _cpstore1_new, _cpstore1_chkpnt`)
+	testutils.RunSQL(t, `CREATE TABLE cpstore1 (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, pad VARCHAR(100) NOT NULL)`)
+	testutils.RunSQL(t, `INSERT INTO cpstore1 (pad) SELECT REPEAT('a', 100) FROM dual`)
+	testutils.RunSQL(t, `INSERT INTO cpstore1 (pad) SELECT REPEAT('a', 100) FROM cpstore1 a JOIN cpstore1 b JOIN cpstore1 c`)
+	testutils.RunSQL(t, `INSERT INTO cpstore1 (pad) SELECT REPEAT('a', 100) FROM cpstore1 a JOIN cpstore1 b JOIN cpstore1 c`)
+	testutils.RunSQL(t, `INSERT INTO cpstore1 (pad) SELECT REPEAT('a', 100) FROM cpstore1 a JOIN cpstore1 b JOIN cpstore1 c LIMIT 10000`)
+
+	store := &memoryCheckpointStore{}
+	preSetup := func() *Runner {
+		r, err := NewRunner(&Migration{
+			Host:     cfg.Addr,
+			Username: cfg.User,
+			Password: cfg.Passwd,
+			Database: cfg.DBName,
+			Threads:  2,
+			Table:    "cpstore1",
+			Alter:    "ENGINE=InnoDB",
+		})
+		assert.NoError(t, err)
+		r.SetCheckpointStore(store)
+		r.db, err = dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+		assert.NoError(t, err)
+		r.dbConfig = dbconn.NewDBConfig()
+		r.table = table.NewTableInfo(r.db, r.migration.Database, r.migration.Table)
+		assert.NoError(t, r.table.SetInfo(context.TODO()))
+		return r
+	}
+
+	r := preSetup()
+	assert.ErrorIs(t, r.resumeFromCheckpoint(context.TODO()), ErrNoCheckpoint)
+	assert.NoError(t, r.createNewTable(context.TODO()))
+	assert.NoError(t, r.alterNewTable(context.TODO()))
+	assert.NoError(t, r.createCheckpoint(context.TODO()))
+	r.replClient = repl.NewClient(r.db, r.migration.Host, r.table, r.newTable, r.migration.Username, r.migration.Password, &repl.ClientConfig{
+		Logger:          logrus.New(),
+		Concurrency:     2,
+		TargetBatchTime: r.migration.TargetChunkTime,
+	})
+	r.copier, err = row.NewCopier(r.db, r.table, r.newTable, row.NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, r.replClient.Run())
+	r.setCurrentState(stateCopyRows)
+	assert.NoError(t, r.copier.Open4Test())
+	for range 3 {
+		chunk, err := r.copier.Next4Test()
+		assert.NoError(t, err)
+		assert.NoError(t, r.copier.CopyChunk(context.TODO(), chunk))
+	}
+	assert.NoError(t, r.dumpCheckpoint(context.TODO()))
+	r.replClient.Close()
+	assert.NoError(t, r.db.Close())
+
+	// The checkpoint was saved to the store, and not to a table.
+	var count int
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema='%s' AND table_name='_cpstore1_chkpnt'", cfg.DBName)).Scan(&count))
+	assert.Equal(t, 0, count)
+	cp, err := store.Load(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, "ENGINE=InnoDB", cp.AlterStatement)
+	assert.Equal(t, uint64(3000), cp.RowsCopied)
+
+	// A new runner resumes from the store.
+	r = preSetup()
+	assert.NoError(t, r.resumeFromCheckpoint(context.TODO()))
+	assert.True(t, r.usedResumeFromCheckpoint)
+	chunk, err := r.copier.Next4Test()
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", chunk.LowerBound.Value[0].String())
+	r.replClient.Close()
+
+	// Cleanup drops the checkpoints from the store.
+	assert.NoError(t, r.cleanup(context.TODO()))
+	_, err = store.Load(context.TODO())
+	assert.ErrorIs(t, err, ErrNoCheckpoint)
+	assert.NoError(t, r.db.Close())
+}
+
+func TestCheckpointOriginalDDL(t *testing.T) {
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS cpddl1, _cpddl1_chkpnt`)
+	testutils.RunSQL(t, `CREATE TABLE cpddl1 (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, name VARCHAR(100) NOT NULL DEFAULT 'it''s', KEY (name))`)
+
+	r, err := NewRunner(&Migration{
+		Host:     cfg.Addr,
+		Username: cfg.User,
+		Password: cfg.Passwd,
+		Database: cfg.DBName,
+		Threads:  1,
+		Table:    "cpddl1",
+		Alter:    "ENGINE=InnoDB",
+	})
+	assert.NoError(t, err)
+	r.db, err = dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer r.db.Close()
+	r.table = table.NewTableInfo(r.db, r.migration.Database, r.migration.Table)
+	assert.NoError(t, r.table.SetInfo(context.TODO()))
+
+	// The captured definition is the output of SHOW CREATE TABLE.
+	var tableName, expected string
+	assert.NoError(t, r.db.QueryRow("SHOW CREATE TABLE cpddl1").Scan(&tableName, &expected))
+	assert.NoError(t, r.captureOriginalDDL(context.TODO()))
+	assert.Equal(t, expected, r.OriginalDDL())
+
+	// It is saved with the checkpoint.
+	assert.NoError(t, r.createCheckpoint(context.TODO()))
+	assert.NoError(t, r.checkpoints().Save(context.TODO(), &Checkpoint{
+		AlterStatement: r.stmt.Alter,
+		OriginalDDL:    r.OriginalDDL(),
+	}))
+	cp, err := r.checkpoints().Load(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, cp.OriginalDDL)
+	assert.NoError(t, r.checkpoints().Drop(context.TODO()))
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/siddontang/loggers"
 
@@ -13,6 +15,26 @@ import (
 	"github.com/cashapp/spirit/pkg/table"
 )
 
+// CutOverAlgorithm is how the new table is swapped with the table.
+type CutOverAlgorithm string
+
+const (
+	// CutOverRenameUnderLock renames the tables on the connection that holds
+	// LOCK TABLES on them. It requires MySQL 8.0.13 or later. This is the default.
+	CutOverRenameUnderLock CutOverAlgorithm = "rename-under-lock"
+	// CutOverAtomicRename holds LOCK TABLES while a second connection issues
+	// the rename, which blocks until the lock is released. Because a pending
+	// rename is granted before the queries that are waiting on the table, no
+	// query runs against the table between the unlock and the rename. This
+	// does not require renaming tables under LOCK TABLES.
+	CutOverAtomicRename CutOverAlgorithm = "atomic-rename"
+)
+
+var (
+	renameWaitTimeout  = 10 * time.Second       // how long to wait for the rename to block on the table lock
+	renameWaitInterval = 100 * time.Millisecond // how frequently to check if the rename is blocked
+)
+
 type CutOver struct {
 	db           *sql.DB
 	table        *table.TableInfo
@@ -21,6 +43,8 @@ type CutOver struct {
 	feed         *repl.Client
 	dbConfig     *dbconn.DBConfig
 	logger       loggers.Advanced
+	algorithm    CutOverAlgorithm
+	beforeSwap   func() // called before the tables are swapped, used by tests
 }
 
 // NewCutOver contains the logic to perform the final cut over. It requires the original table,
@@ -43,6 +67,7 @@ func NewCutOver(db *sql.DB, table, newTable *table.TableInfo, oldTableName strin
 		feed:         feed,
 		dbConfig:     dbConfig,
 		logger:       logger,
+		algorithm:    CutOverRenameUnderLock,
 	}, nil
 }
 
@@ -66,10 +91,21 @@ func (c *CutOver) Run(ctx context.Context) error {
 		if err := c.feed.Flush(ctx); err != nil {
 			return err
 		}
+		// The new table differs from the table for any keys that were
+		// skipped. Keys are never skipped in the final flush under the
+		// table lock, so this is the last point at which they can be.
+		if err := skippedKeysError(c.feed.SkippedKeys()); err != nil {
+			return err
+		}
 		// We use maxCutoverRetries as our retrycount, but nested
 		// within c.algorithmX() it may also have a retry for the specific statement
 		c.logger.Warnf("Attempting final cut over operation (attempt %d/%d)", i+1, c.dbConfig.MaxRetries)
-		err = c.algorithmRenameUnderLock(ctx)
+		switch c.algorithm {
+		case CutOverAtomicRename:
+			err = c.algorithmAtomicRename(ctx)
+		default:
+			err = c.algorithmRenameUnderLock(ctx)
+		}
 		if err != nil {
 			c.logger.Warnf("cutover failed. err: %s", err.Error())
 			continue
@@ -81,13 +117,27 @@ func (c *CutOver) Run(ctx context.Context) error {
 	return err
 }
 
+// skippedKeysError returns an error naming the keys that were skipped
+// under the skip flush failure policy, if there are any.
+func skippedKeysError(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	const maxKeys = 10
+	names := strings.Join(keys[:min(len(keys), maxKeys)], ", ")
+	if len(keys) > maxKeys {
+		names += ", ..."
+	}
+	return fmt.Errorf("cutover is not safe, %d keys could not be applied to the new table and were skipped: %s", len(keys), names)
+}
+
 // algorithmRenameUnderLock is the preferred cutover algorithm.
 // As of MySQL 8.0.13, you can rename tables locked with a LOCK TABLES statement
 // https://dev.mysql.com/worklog/task/?id=9826
 func (c *CutOver) algorithmRenameUnderLock(ctx context.Context) error {
 	// Lock the source table in a trx
 	// so the connection is not used by others
-	tableLock, err := dbconn.NewTableLock(ctx, c.db, c.table, c.dbConfig, c.logger)
+	tableLock, err := dbconn.NewTableLock(ctx, c.db, c.table, c.newTable, c.dbConfig, c.logger)
 	if err != nil {
 		return err
 	}
@@ -98,10 +148,107 @@ func (c *CutOver) algorithmRenameUnderLock(ctx context.Context) error {
 	if !c.feed.AllChangesFlushed() {
 		return errors.New("not all changes flushed, final flush might be broken")
 	}
+	if c.beforeSwap != nil {
+		c.beforeSwap()
+	}
+	return tableLock.ExecUnderLock(ctx, c.renameStatement())
+}
+
+// renameStatement returns the statement that swaps the tables. Renaming
+// both tables in one statement is atomic: there is no point at which
+// the table does not exist.
+func (c *CutOver) renameStatement() string {
 	oldQuotedName := fmt.Sprintf("`%s`.`%s`", c.table.SchemaName, c.oldTableName)
-	renameStatement := fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s",
+	return fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s",
 		c.table.QuotedName, oldQuotedName,
 		c.newTable.QuotedName, c.table.QuotedName,
 	)
-	return tableLock.ExecUnderLock(ctx, renameStatement)
+}
+
+// algorithmAtomicRename is the cutover algorithm for when tables can not be
+// renamed under LOCK TABLES. The replication client is stopped from flushing
+// in the background, and the remaining changes are flushed under the lock.
+// The rename is then issued on a second connection, where it blocks on the
+// lock. Once it is waiting, the lock is released and the rename proceeds
+// before any of the queries that are waiting on the table.
+func (c *CutOver) algorithmAtomicRename(ctx context.Context) error {
+	// No changes may be applied to the new table after the final flush,
+	// since they would be applied after the swap.
+	c.feed.StopPeriodicFlush()
+	tableLock, err := dbconn.NewTableLock(ctx, c.db, c.table, c.newTable, c.dbConfig, c.logger)
+	if err != nil {
+		return err
+	}
+	unlocked := false
+	defer func() {
+		if !unlocked {
+			_ = tableLock.Close()
+		}
+	}()
+	if err := c.feed.FlushUnderTableLock(ctx, tableLock); err != nil {
+		return err
+	}
+	if !c.feed.AllChangesFlushed() {
+		return errors.New("not all changes flushed, final flush might be broken")
+	}
+	if c.beforeSwap != nil {
+		c.beforeSwap()
+	}
+	renameConn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer renameConn.Close()
+	var renameConnID int64
+	if err := renameConn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&renameConnID); err != nil {
+		return err
+	}
+	renameErr := make(chan error, 1)
+	go func() {
+		_, err := renameConn.ExecContext(ctx, c.renameStatement())
+		renameErr <- err
+	}()
+	if err := c.waitForBlockedRename(ctx, renameConnID, renameErr); err != nil {
+		// The rename must not proceed after the lock is released,
+		// since other queries could then run before it.
+		if _, killErr := c.db.ExecContext(context.Background(), fmt.Sprintf("KILL QUERY %d", renameConnID)); killErr != nil {
+			c.logger.Errorf("could not kill the rename: %v", killErr)
+		}
+		<-renameErr
+		return err
+	}
+	unlocked = true
+	if err := tableLock.Close(); err != nil {
+		return err
+	}
+	return <-renameErr
+}
+
+// waitForBlockedRename waits until the rename on connection id is
+// waiting for the table lock, or returns an error if it fails first.
+func (c *CutOver) waitForBlockedRename(ctx context.Context, id int64, renameErr chan error) error {
+	timeout := time.After(renameWaitTimeout)
+	for {
+		var waiting int
+		err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.processlist WHERE id = ? AND state = 'Waiting for table metadata lock' AND info LIKE 'RENAME TABLE%'", id).Scan(&waiting)
+		if err != nil {
+			return err
+		}
+		if waiting > 0 {
+			return nil
+		}
+		select {
+		case err := <-renameErr:
+			renameErr <- err // return it to the caller.
+			if err == nil {
+				return errors.New("the rename did not wait for the table lock")
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("the rename did not wait for the table lock within %s", renameWaitTimeout)
+		case <-time.After(renameWaitInterval):
+		}
+	}
 }
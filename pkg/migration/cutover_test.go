@@ -3,6 +3,9 @@ package migration
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -74,6 +77,105 @@ func TestCutOver(t *testing.T) {
 	assert.Equal(t, 2, count)
 }
 
+func TestCutOverAtomicRename(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS cutovert2, _cutovert2_new, _cutovert2_old, _cutovert2_chkpnt`)
+	tbl := `CREATE TABLE cutovert2 (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		name varchar(255) NOT NULL,
+		PRIMARY KEY (id)
+	)`
+	testutils.RunSQL(t, tbl)
+	tbl = `CREATE TABLE _cutovert2_new (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		name varchar(255) NOT NULL,
+		PRIMARY KEY (id)
+	)`
+	testutils.RunSQL(t, tbl)
+	testutils.RunSQL(t, `CREATE TABLE _cutovert2_chkpnt (a int)`) // for binlog advancement
+	testutils.RunSQL(t, `INSERT INTO cutovert2 VALUES (1, 2), (2,2)`)
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "cutovert2")
+	assert.NoError(t, t1.SetInfo(context.Background())) // required to extract PK.
+	t1new := table.NewTableInfo(db, "test", "_cutovert2_new")
+	logger := logrus.New()
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	feed := repl.NewClient(db, cfg.Addr, t1, t1new, cfg.User, cfg.Passwd, &repl.ClientConfig{
+		Logger:          logger,
+		Concurrency:     4,
+		TargetBatchTime: time.Second,
+	})
+	assert.NoError(t, feed.Run())
+	defer feed.Close()
+	periodicFlushDone := make(chan struct{})
+	go func() {
+		feed.StartPeriodicFlush(context.Background(), 10*time.Millisecond)
+		close(periodicFlushDone)
+	}()
+
+	// Rows are inserted throughout the cutover. Each one must be in the
+	// new table afterwards: either it was inserted into the table before
+	// the lock and applied by the final flush, or it waited for the lock
+	// and was inserted after the rename.
+	stop := make(chan struct{})
+	var inserted atomic.Int64
+	var writes sync.WaitGroup
+	writes.Add(1)
+	go func() {
+		defer writes.Done()
+		for id := 100; ; id++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := db.Exec("INSERT INTO cutovert2 VALUES (?, 'concurrent')", id); err != nil {
+				t.Errorf("insert failed during cutover: %v", err)
+				return
+			}
+			inserted.Add(1)
+		}
+	}()
+
+	cutover, err := NewCutOver(db, t1, t1new, "_cutovert2_old", feed, dbconn.NewDBConfig(), logger)
+	assert.NoError(t, err)
+	cutover.algorithm = CutOverAtomicRename
+	var swapped bool
+	cutover.beforeSwap = func() {
+		// The subscriber must have stopped applying changes
+		// in the background, and all changes are applied.
+		assert.Eventually(t, func() bool {
+			select {
+			case <-periodicFlushDone:
+				return true
+			default:
+				return false
+			}
+		}, time.Second, 10*time.Millisecond)
+		assert.True(t, feed.AllChangesFlushed())
+		swapped = true
+	}
+	time.Sleep(100 * time.Millisecond) // let some rows be inserted before the cutover.
+	assert.NoError(t, cutover.Run(context.Background()))
+	assert.True(t, swapped)
+	time.Sleep(100 * time.Millisecond) // and some after.
+	close(stop)
+	writes.Wait()
+
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM cutovert2 WHERE id >= 100").Scan(&count))
+	assert.Positive(t, count)
+	assert.Equal(t, int(inserted.Load()), count)
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM cutovert2 WHERE id < 100").Scan(&count))
+	assert.Equal(t, 0, count) // only copied by the copier, which was not run.
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _cutovert2_old WHERE id < 100").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
 func TestMDLLockFails(t *testing.T) {
 	testutils.RunSQL(t, `DROP TABLE IF EXISTS mdllocks, _mdllocks_new, _mdllocks_old, _mdllocks_chkpnt`)
 	tbl := `CREATE TABLE mdllocks (
@@ -170,3 +272,17 @@ func TestInvalidOptions(t *testing.T) {
 	_, err = NewCutOver(db, nil, t1new, "", feed, dbconn.NewDBConfig(), logger)
 	assert.Error(t, err)
 }
+
+func TestSkippedKeysError(t *testing.T) {
+	assert.NoError(t, skippedKeysError(nil))
+	assert.EqualError(t, skippedKeysError([]string{"'3'", "'7'"}),
+		"cutover is not safe, 2 keys could not be applied to the new table and were skipped: '3', '7'")
+
+	// Only the first keys are named.
+	var keys []string
+	for i := range 12 {
+		keys = append(keys, fmt.Sprintf("'%d'", i))
+	}
+	assert.EqualError(t, skippedKeysError(keys),
+		"cutover is not safe, 12 keys could not be applied to the new table and were skipped: '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', ...")
+}
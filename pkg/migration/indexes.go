@@ -0,0 +1,140 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/dbconn/sqlescape"
+)
+
+// indexBuildProgressTimeout bounds the query that reports the progress of
+// adding the deferred indexes, since it is run when the progress is requested.
+const indexBuildProgressTimeout = time.Second
+
+// deferrableIndexRegexp matches the definition of a non-unique secondary
+// index in SHOW CREATE TABLE, and captures its name.
+var deferrableIndexRegexp = regexp.MustCompile("^KEY `((?:[^`]|``)+)` ")
+
+// deferrableIndexes returns the definitions of the secondary indexes in
+// createTable (the output of SHOW CREATE TABLE) that can be added after the
+// copy. Unique indexes are not deferred, because INSERT IGNORE relies on them
+// to discard duplicates, and FULLTEXT and SPATIAL indexes can not be added
+// without locking the table.
+func deferrableIndexes(createTable string) []string {
+	var defs []string
+	for _, line := range strings.Split(createTable, "\n") {
+		def := strings.TrimSuffix(strings.TrimSpace(line), ",")
+		if deferrableIndexRegexp.MatchString(def) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// splitDeferredIndexes returns the definitions in Checkpoint.DeferredIndexes.
+func splitDeferredIndexes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// indexName returns the name of the index in def, a definition
+// returned by deferrableIndexes.
+func indexName(def string) string {
+	return strings.ReplaceAll(deferrableIndexRegexp.FindStringSubmatch(def)[1], "``", "`")
+}
+
+// deferSecondaryIndexes drops the non-unique secondary indexes of the new
+// table before the copy, and records them so that addDeferredIndexes can add
+// them afterwards. Inserting rows into a table with only its PRIMARY KEY is
+// faster, and building an index in one pass is faster than maintaining it
+// row by row.
+func (r *Runner) deferSecondaryIndexes(ctx context.Context) error {
+	var tableName, createTable string
+	query := sqlescape.MustEscapeSQL("SHOW CREATE TABLE %n.%n", r.newTable.SchemaName, r.newTable.TableName)
+	if err := r.db.QueryRowContext(ctx, query).Scan(&tableName, &createTable); err != nil {
+		return err
+	}
+	defs := deferrableIndexes(createTable)
+	if len(defs) == 0 {
+		return nil
+	}
+	drops := make([]string, 0, len(defs))
+	args := []interface{}{r.newTable.SchemaName, r.newTable.TableName}
+	for _, def := range defs {
+		drops = append(drops, "DROP INDEX %n")
+		args = append(args, indexName(def))
+	}
+	r.logger.Infof("deferring %d secondary indexes of %s until after the copy", len(defs), r.newTable.QuotedName)
+	if err := dbconn.Exec(ctx, r.db, "ALTER TABLE %n.%n "+strings.Join(drops, ", "), args...); err != nil {
+		return err
+	}
+	r.deferredIndexes = defs
+	return r.newTable.SetInfo(ctx)
+}
+
+// addDeferredIndexes adds the indexes that were dropped by
+// deferSecondaryIndexes. The indexes are added in a single ALTER, so
+// either all of them or none of them are added. When resuming, the
+// indexes that the new table already has are not added again.
+func (r *Runner) addDeferredIndexes(ctx context.Context) error {
+	if len(r.deferredIndexes) == 0 {
+		return nil
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT DISTINCT index_name FROM information_schema.statistics WHERE table_schema=? AND table_name=?",
+		r.newTable.SchemaName, r.newTable.TableName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	var adds []string
+	for _, def := range r.deferredIndexes {
+		if !existing[strings.ToLower(indexName(def))] {
+			// The definition is SQL, which is not escaped, so any % in
+			// it (i.e. in a name or COMMENT) must not be read as a verb.
+			adds = append(adds, "ADD "+strings.ReplaceAll(def, "%", "%%"))
+		}
+	}
+	if len(adds) == 0 {
+		return nil
+	}
+	r.logger.Infof("adding %d deferred secondary indexes to %s", len(adds), r.newTable.QuotedName)
+	startTime := time.Now()
+	// Changes from the binary log are applied while the indexes are added.
+	if err := dbconn.Exec(ctx, r.db, "ALTER TABLE %n.%n "+strings.Join(adds, ", ")+", ALGORITHM=INPLACE, LOCK=NONE",
+		r.newTable.SchemaName, r.newTable.TableName); err != nil {
+		return err
+	}
+	r.logger.Infof("added deferred secondary indexes in %s", time.Since(startTime).Round(time.Second))
+	return r.newTable.SetInfo(ctx)
+}
+
+// indexBuildProgress returns the progress of adding the deferred indexes,
+// as reported by performance_schema. It is empty if the events_stages_current
+// consumer is not enabled, which is the default.
+func (r *Runner) indexBuildProgress() string {
+	ctx, cancel := context.WithTimeout(context.Background(), indexBuildProgressTimeout)
+	defer cancel()
+	var completed, estimated int64
+	err := r.db.QueryRowContext(ctx, "SELECT IFNULL(SUM(work_completed), 0), IFNULL(SUM(work_estimated), 0) FROM performance_schema.events_stages_current WHERE event_name LIKE 'stage/innodb/alter table%'").Scan(&completed, &estimated)
+	if err != nil || estimated == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d %.2f%%", completed, estimated, float64(completed)/float64(estimated)*100)
+}
@@ -0,0 +1,92 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeferrableIndexes(t *testing.T) {
+	createTable := "CREATE TABLE `t1` (\n" +
+		"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(255) NOT NULL,\n" +
+		"  `b` int DEFAULT NULL,\n" +
+		"  `doc` text,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  UNIQUE KEY `name_uniq` (`name`),\n" +
+		"  KEY `b` (`b`),\n" +
+		"  KEY `name_b` (`name`(10),`b` DESC) COMMENT 'for lookups',\n" +
+		"  KEY `odd``name` ((`b` + 1)),\n" +
+		"  FULLTEXT KEY `doc` (`doc`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	defs := deferrableIndexes(createTable)
+	assert.Equal(t, []string{
+		"KEY `b` (`b`)",
+		"KEY `name_b` (`name`(10),`b` DESC) COMMENT 'for lookups'",
+		"KEY `odd``name` ((`b` + 1))",
+	}, defs)
+	assert.Equal(t, "b", indexName(defs[0]))
+	assert.Equal(t, "name_b", indexName(defs[1]))
+	assert.Equal(t, "odd`name", indexName(defs[2]))
+
+	assert.Nil(t, splitDeferredIndexes(""))
+	assert.Equal(t, defs, splitDeferredIndexes("KEY `b` (`b`)\nKEY `name_b` (`name`(10),`b` DESC) COMMENT 'for lookups'\nKEY `odd``name` ((`b` + 1))"))
+}
+
+func TestDeferSecondaryIndexes(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS deferidx1, _deferidx1_new, _deferidx1_old, _deferidx1_chkpnt, deferidx1ref`)
+	tbl := `CREATE TABLE %s (
+		id int NOT NULL AUTO_INCREMENT,
+		name varchar(255) NOT NULL,
+		b int,
+		c int,
+		PRIMARY KEY (id),
+		UNIQUE KEY name_uniq (name),
+		KEY b (b),
+		KEY ` + "`b%%n`" + ` (b, c DESC) COMMENT '100%% of lookups %%?'
+	)`
+	testutils.RunSQL(t, fmt.Sprintf(tbl, "deferidx1"))
+	testutils.RunSQL(t, fmt.Sprintf(tbl, "deferidx1ref"))
+	testutils.RunSQL(t, `INSERT INTO deferidx1 (name, b, c) VALUES ('a', 1, 1), ('b', 2, 2), ('c', 3, 3)`)
+	testutils.RunSQL(t, `INSERT INTO deferidx1ref (name, b, c) VALUES ('a', 1, 1), ('b', 2, 2), ('c', 3, 3)`)
+	alter := "ADD COLUMN d int, ADD INDEX c (c)"
+	testutils.RunSQL(t, "ALTER TABLE deferidx1ref "+alter)
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	m, err := NewRunner(&Migration{
+		Host:                  cfg.Addr,
+		Username:              cfg.User,
+		Password:              cfg.Passwd,
+		Database:              cfg.DBName,
+		Threads:               1,
+		Table:                 "deferidx1",
+		Alter:                 alter,
+		Checksum:              true,
+		DeferSecondaryIndexes: true,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Run(context.Background()))
+	// The index added by the alter is deferred too.
+	assert.Len(t, m.deferredIndexes, 3)
+	assert.NoError(t, m.Close())
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The table has the same definition as if it had been altered directly.
+	var name, migrated, expected string
+	assert.NoError(t, db.QueryRow("SHOW CREATE TABLE deferidx1").Scan(&name, &migrated))
+	assert.NoError(t, db.QueryRow("SHOW CREATE TABLE deferidx1ref").Scan(&name, &expected))
+	assert.Equal(t, strings.Replace(expected, "deferidx1ref", "deferidx1", 1), migrated)
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM deferidx1").Scan(&count))
+	assert.Equal(t, 3, count)
+}
@@ -9,34 +9,82 @@ import (
 	"time"
 
 	"github.com/cashapp/spirit/pkg/check"
+	"github.com/cashapp/spirit/pkg/repl"
+	"github.com/cashapp/spirit/pkg/row"
 	"github.com/cashapp/spirit/pkg/statement"
 	"github.com/cashapp/spirit/pkg/table"
 	"github.com/pingcap/tidb/pkg/parser"
 )
 
 var (
-	ErrMismatchedAlter = errors.New("alter statement in checkpoint table does not match the alter statement specified here")
+	ErrMismatchedAlter     = errors.New("alter statement in checkpoint table does not match the alter statement specified here")
+	ErrChecksumDifferences = errors.New("checksum found differences between the table and the new table")
+)
+
+// ChecksumFailurePolicy is what the migration does when
+// the checksum finds differences after the copy. It decides whether
+// differences block the cutover in both the full and row-count modes.
+type ChecksumFailurePolicy string
+
+const (
+	// ChecksumFailurePolicyRecopy recopies the chunks with differences, and
+	// repeats the checksum until it passes, up to 3 times. This is the default.
+	ChecksumFailurePolicyRecopy ChecksumFailurePolicy = "recopy"
+	// ChecksumFailurePolicyAbort fails the migration before cutover.
+	ChecksumFailurePolicyAbort ChecksumFailurePolicy = "abort"
+	// ChecksumFailurePolicyWarn logs the differences and continues to cutover.
+	ChecksumFailurePolicyWarn ChecksumFailurePolicy = "warn"
 )
 
 type Migration struct {
-	Host                 string        `name:"host" help:"Hostname" optional:"" default:"127.0.0.1:3306"`
-	Username             string        `name:"username" help:"User" optional:"" default:"msandbox"`
-	Password             string        `name:"password" help:"Password" optional:"" default:"msandbox"`
-	Database             string        `name:"database" help:"Database" optional:"" default:"test"`
-	Table                string        `name:"table" help:"Table" optional:""`
-	Alter                string        `name:"alter" help:"The alter statement to run on the table" optional:""`
-	Threads              int           `name:"threads" help:"Number of concurrent threads for copy and checksum tasks" optional:"" default:"4"`
-	TargetChunkTime      time.Duration `name:"target-chunk-time" help:"The target copy time for each chunk" optional:"" default:"500ms"`
-	ForceInplace         bool          `name:"force-inplace" help:"Force attempt to use inplace (only safe without replicas or with Aurora Global)" optional:"" default:"false"`
-	Checksum             bool          `name:"checksum" help:"Checksum new table before final cut-over" optional:"" default:"true"`
-	ReplicaDSN           string        `name:"replica-dsn" help:"A DSN for a replica which (if specified) will be used for lag checking." optional:""`
-	ReplicaMaxLag        time.Duration `name:"replica-max-lag" help:"The maximum lag allowed on the replica before the migration throttles." optional:"" default:"120s"`
-	LockWaitTimeout      time.Duration `name:"lock-wait-timeout" help:"The DDL lock_wait_timeout required for checksum and cutover" optional:"" default:"30s"`
-	SkipDropAfterCutover bool          `name:"skip-drop-after-cutover" help:"Keep old table after completing cutover" optional:"" default:"false"`
-	DeferCutOver         bool          `name:"defer-cutover" help:"Defer cutover (and checksum) until sentinel table is dropped" optional:"" default:"false"`
-	Strict               bool          `name:"strict" help:"Exit on --alter mismatch when incomplete migration is detected" optional:"" default:"false"`
-	InterpolateParams    bool          `name:"interpolate-params" help:"Enable interpolate params for DSN" optional:"" default:"false" hidden:""`
-	Statement            string        `name:"statement" help:"The SQL statement to run (replaces --table and --alter)" optional:"" default:""`
+	Host                     string            `name:"host" help:"Hostname" optional:"" default:"127.0.0.1:3306"`
+	Username                 string            `name:"username" help:"User" optional:"" default:"msandbox"`
+	Password                 string            `name:"password" help:"Password" optional:"" default:"msandbox"`
+	Database                 string            `name:"database" help:"Database" optional:"" default:"test"`
+	Table                    string            `name:"table" help:"Table" optional:""`
+	Alter                    string            `name:"alter" help:"The alter statement to run on the table" optional:""`
+	Threads                  int               `name:"threads" help:"Number of concurrent threads for copy and checksum tasks" optional:"" default:"4"`
+	TargetChunkTime          time.Duration     `name:"target-chunk-time" help:"The target copy time for each chunk" optional:"" default:"500ms"`
+	ForceInplace             bool              `name:"force-inplace" help:"Force attempt to use inplace (only safe without replicas or with Aurora Global)" optional:"" default:"false"`
+	Checksum                 bool              `name:"checksum" help:"Checksum new table before final cut-over" optional:"" default:"true"`
+	ReplicaDSN               string            `name:"replica-dsn" help:"A DSN for a replica which (if specified) will be used for lag checking." optional:""`
+	ReplicaMaxLag            time.Duration     `name:"replica-max-lag" help:"The maximum lag allowed on the replica before the migration throttles." optional:"" default:"120s"`
+	LockWaitTimeout          time.Duration     `name:"lock-wait-timeout" help:"The DDL lock_wait_timeout required for checksum and cutover" optional:"" default:"30s"`
+	SkipDropAfterCutover     bool              `name:"skip-drop-after-cutover" help:"Keep old table after completing cutover" optional:"" default:"false"`
+	DeferCutOver             bool              `name:"defer-cutover" help:"Defer cutover (and checksum) until sentinel table is dropped" optional:"" default:"false"`
+	Strict                   bool              `name:"strict" help:"Exit on --alter mismatch when incomplete migration is detected" optional:"" default:"false"`
+	InterpolateParams        bool              `name:"interpolate-params" help:"Enable interpolate params for DSN" optional:"" default:"false" hidden:""`
+	Statement                string            `name:"statement" help:"The SQL statement to run (replaces --table and --alter)" optional:"" default:""`
+	NewestFirstKeyRange      uint64            `name:"newest-first-key-range" help:"Copy this many of the newest key values first, then backfill the rest of the table (requires an auto_increment primary key)" optional:"" default:"0"`
+	LongTransactionThreshold time.Duration     `name:"long-transaction-threshold" help:"Fail before cutover if a transaction has been open for longer than this (0 disables)" optional:"" default:"0s"`
+	ArtifactTablePrefix      string            `name:"artifact-table-prefix" help:"The prefix for the new, old, checkpoint and sentinel table names" optional:"" default:"_"`
+	ThrottlerErrorPolicy     string            `name:"throttler-error-policy" help:"What to do when the replica lag can not be checked: continue, fail or block" optional:"" default:"continue"`
+	CutoverLockBudget        time.Duration     `name:"cutover-lock-budget" help:"Warn before starting if acquiring a write lock on the table takes longer than this (0 disables)" optional:"" default:"0s"`
+	MaxConcurrentQueries     int               `name:"max-concurrent-queries" help:"The maximum number of concurrent queries across the copier and the replication applier (0 is unlimited)" optional:"" default:"0"`
+	SkipCheckScopes          []string          `name:"skip-check-scopes" help:"Do not run the checks of these scopes: pre-run, preflight, post-setup, cutover or post-cutover" optional:""`
+	ExpectedIndexes          []string          `name:"expected-indexes" help:"Fail before copying if the secondary indexes of the new table are not exactly these" optional:""`
+	QueryComment             string            `name:"query-comment" help:"A comment to add to the copy and apply statements, i.e. 'spirit migration=123 table={table} chunk={chunk}'" optional:""`
+	ChecksumFailurePolicy    string            `name:"checksum-failure-policy" help:"What to do when the checksum finds differences: recopy, abort or warn" optional:"" default:"recopy"`
+	MaxLoad                  map[string]uint64 `name:"max-load" help:"Pause copying while a global status variable exceeds its threshold, i.e. Threads_running=25" optional:"" mapsep:","`
+	CriticalLoad             map[string]uint64 `name:"critical-load" help:"Fail the migration if a global status variable exceeds its threshold, i.e. Threads_running=100" optional:"" mapsep:","`
+	FlushFailurePolicy       string            `name:"flush-failure-policy" help:"What to do when a batch of changes from the binary log can not be applied: fail, retry or skip" optional:"" default:"fail"`
+	CutOverAlgorithm         string            `name:"cutover-algorithm" help:"How the new table is swapped with the table: rename-under-lock or atomic-rename" optional:"" default:"rename-under-lock"`
+	DeferSecondaryIndexes    bool              `name:"defer-secondary-indexes" help:"Copy into the new table without its non-unique secondary indexes, and add them after the copy" optional:"" default:"false"`
+	RequireFullRowMetadata   bool              `name:"require-full-row-metadata" help:"Fail before starting if binlog_row_metadata is not FULL, instead of warning" optional:"" default:"false"`
+	UnknownActionPolicy      string            `name:"unknown-action-policy" help:"What to do with a binary log event whose action is not an insert, update or delete: fail or skip" optional:"" default:"fail"`
+	ReplicationUsername      string            `name:"replication-username" help:"The user that reads the binary log, if it is not the user that runs the migration" optional:""`
+	ReplicationPassword      string            `name:"replication-password" help:"The password of the replication-username" optional:""`
+	ReadOnlySafe             bool              `name:"read-only-safe" help:"Do not write while running checks and estimating rows, i.e. ANALYZE TABLE, in case the connection is to a read-only node" optional:"" default:"false"`
+	SmallTableMaxRows        uint64            `name:"small-table-max-rows" help:"Copy a table with at most this many estimated rows in one shot, and then checksum it until a checksum finds no differences (0 disables)" optional:"" default:"0"`
+	MaxChangesetDepth        int               `name:"max-changeset-depth" help:"Throttle the copy while more than this many changes from the binary log are not yet applied to the new table (0 disables)" optional:"" default:"0"`
+	ExplainChunkPolicy       string            `name:"explain-chunk-policy" help:"Run EXPLAIN on a chunk before copying, and what to do if the plan is not the expected one: off, warn or fail" optional:"" default:"off"`
+	ConnMaxIdleTime          time.Duration     `name:"conn-max-idle-time" help:"Close database connections that have been idle for longer than this, so that a connection closed by the server while idle is not reused (0 keeps them)" optional:"" default:"1m"`
+	FlushApplyOrder          string            `name:"flush-apply-order" help:"The order in which a flush applies the deleted and the changed rows: deletes-first or replaces-first" optional:"" default:"deletes-first"`
+	ConcurrencyLeaseTable    string            `name:"concurrency-lease-table" help:"Wait for a lease in this table before copying, to limit how many migrations copy at the same time, i.e. spirit.leases" optional:""`
+	ConcurrencyLeaseSlots    int               `name:"concurrency-lease-slots" help:"The number of migrations that can hold a lease of the concurrency-lease-table at the same time" optional:"" default:"1"`
+	ChecksumMode             string            `name:"checksum-mode" help:"How to verify the new table before cutover: full, row-count or none (default: full, or none if checksum is disabled)" optional:""`
+	AllowLossyCharset        bool              `name:"allow-lossy-charset" help:"Warn instead of failing if the new table has columns with a character set that can not hold every character of the table" optional:"" default:"false"`
+	ConsistentSnapshot       bool              `name:"consistent-snapshot" help:"Copy the table in a single consistent snapshot, and apply the changes from the binary log after the copy. Only for tables of up to 1,000,000 estimated rows" optional:"" default:"false"`
 }
 
 func (m *Migration) Run() error {
@@ -66,6 +114,79 @@ func (m *Migration) normalizeOptions() (stmt *statement.AbstractStatement, err e
 	if m.ReplicaMaxLag == 0 {
 		m.ReplicaMaxLag = 120 * time.Second
 	}
+	if m.ChecksumFailurePolicy == "" {
+		m.ChecksumFailurePolicy = string(ChecksumFailurePolicyRecopy)
+	}
+	switch ChecksumFailurePolicy(m.ChecksumFailurePolicy) {
+	case ChecksumFailurePolicyRecopy, ChecksumFailurePolicyAbort, ChecksumFailurePolicyWarn:
+	default:
+		return nil, fmt.Errorf("unknown checksum failure policy %q", m.ChecksumFailurePolicy)
+	}
+	switch row.ChecksumMode(m.ChecksumMode) {
+	case "":
+		m.ChecksumMode = string(row.ChecksumModeNone)
+		if m.Checksum {
+			m.ChecksumMode = string(row.ChecksumModeFull)
+		}
+	case row.ChecksumModeFull, row.ChecksumModeRowCount:
+		if !m.Checksum {
+			return nil, fmt.Errorf("checksum mode %q can not be used when the checksum is disabled", m.ChecksumMode)
+		}
+	case row.ChecksumModeNone:
+		m.Checksum = false
+	default:
+		return nil, fmt.Errorf("unknown checksum mode %q", m.ChecksumMode)
+	}
+	if m.ExplainChunkPolicy == "" {
+		m.ExplainChunkPolicy = string(row.ExplainPolicyOff)
+	}
+	switch row.ExplainPolicy(m.ExplainChunkPolicy) {
+	case row.ExplainPolicyOff, row.ExplainPolicyWarn, row.ExplainPolicyFail:
+	default:
+		return nil, fmt.Errorf("unknown explain chunk policy %q", m.ExplainChunkPolicy)
+	}
+	if m.FlushFailurePolicy == "" {
+		m.FlushFailurePolicy = string(repl.FlushFailurePolicyFail)
+	}
+	switch repl.FlushFailurePolicy(m.FlushFailurePolicy) {
+	case repl.FlushFailurePolicyFail, repl.FlushFailurePolicyRetry, repl.FlushFailurePolicySkip:
+	default:
+		return nil, fmt.Errorf("unknown flush failure policy %q", m.FlushFailurePolicy)
+	}
+	if m.UnknownActionPolicy == "" {
+		m.UnknownActionPolicy = string(repl.UnknownActionPolicyFail)
+	}
+	switch repl.UnknownActionPolicy(m.UnknownActionPolicy) {
+	case repl.UnknownActionPolicyFail, repl.UnknownActionPolicySkip:
+	default:
+		return nil, fmt.Errorf("invalid unknown action policy %q", m.UnknownActionPolicy)
+	}
+	if m.FlushApplyOrder == "" {
+		m.FlushApplyOrder = string(repl.ApplyOrderDeletesFirst)
+	}
+	switch repl.ApplyOrder(m.FlushApplyOrder) {
+	case repl.ApplyOrderDeletesFirst, repl.ApplyOrderReplacesFirst:
+	default:
+		return nil, fmt.Errorf("unknown flush apply order %q", m.FlushApplyOrder)
+	}
+	if m.ConsistentSnapshot && m.MaxChangesetDepth > 0 {
+		// The changeset is not applied until the copy has completed.
+		return nil, errors.New("consistent-snapshot can not be combined with max-changeset-depth")
+	}
+	if m.ConcurrencyLeaseSlots == 0 {
+		m.ConcurrencyLeaseSlots = 1
+	}
+	if m.ConcurrencyLeaseSlots < 0 {
+		return nil, errors.New("concurrency-lease-slots must be greater than zero")
+	}
+	if m.CutOverAlgorithm == "" {
+		m.CutOverAlgorithm = string(CutOverRenameUnderLock)
+	}
+	switch CutOverAlgorithm(m.CutOverAlgorithm) {
+	case CutOverRenameUnderLock, CutOverAtomicRename:
+	default:
+		return nil, fmt.Errorf("unknown cutover algorithm %q", m.CutOverAlgorithm)
+	}
 	if m.Host == "" {
 		return nil, errors.New("host is required")
 	}
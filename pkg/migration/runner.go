@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/cashapp/spirit/pkg/check"
 	"github.com/cashapp/spirit/pkg/checksum"
 	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/dbconn/sqlescape"
 	"github.com/cashapp/spirit/pkg/repl"
 	"github.com/cashapp/spirit/pkg/row"
 	"github.com/cashapp/spirit/pkg/table"
@@ -29,6 +31,7 @@ type migrationState int32
 const (
 	stateInitial migrationState = iota
 	stateCopyRows
+	stateAddIndexes
 	stateWaitingOnSentinelTable
 	stateApplyChangeset // first mass apply
 	stateAnalyzeTable
@@ -48,12 +51,19 @@ var (
 	sentinelWaitLimit       = 48 * time.Hour
 )
 
+const (
+	checksumAttempts           = 3  // times the checksum is run until it finds no differences
+	smallTableChecksumAttempts = 10 // checksumAttempts for a table with at most SmallTableMaxRows
+)
+
 func (s migrationState) String() string {
 	switch s {
 	case stateInitial:
 		return "initial"
 	case stateCopyRows:
 		return "copyRows"
+	case stateAddIndexes:
+		return "addIndexes"
 	case stateWaitingOnSentinelTable:
 		return "waitingOnSentinelTable"
 	case stateApplyChangeset:
@@ -79,22 +89,32 @@ type Runner struct {
 	db              *sql.DB
 	dbConfig        *dbconn.DBConfig
 	replica         *sql.DB
+	replicationDB   *sql.DB // connected as the replication user, only for the preflight checks
 	table           *table.TableInfo
 	newTable        *table.TableInfo
-	checkpointTable *table.TableInfo
+	checkpointStore CheckpointStore
 	stmt            *statement.AbstractStatement
 	metadataLock    *dbconn.MetadataLock
+	lease           *dbconn.Lease // a slot of the ConcurrencyLeaseTable, if it is set
 
 	currentState migrationState // must use atomic to get/set
 	replClient   *repl.Client   // feed contains all binlog subscription activity.
 	copier       *row.Copier
 	throttler    throttler.Throttler
+	connLimiter  *dbconn.ConnLimiter // shared by the copier and replClient, nil if unlimited
+	skipScopes   check.ScopeFlag     // scopes of checks that are not run
 	checker      *checksum.Checker
 	checkerLock  sync.Mutex
 
 	// used to recover direct to checksum.
 	checksumWatermark string
 
+	// The SHOW CREATE TABLE of the table before the migration.
+	originalDDL string
+
+	// The secondary indexes that are added to the new table after the copy.
+	deferredIndexes []string
+
 	// Track some key statistics.
 	startTime             time.Time
 	sentinelWaitStartTime time.Time
@@ -125,11 +145,16 @@ func NewRunner(m *Migration) (*Runner, error) {
 	if err != nil {
 		return nil, err
 	}
+	skipScopes, err := check.ParseScopes(m.SkipCheckScopes)
+	if err != nil {
+		return nil, err
+	}
 	return &Runner{
 		migration:   m,
 		logger:      logrus.New(),
 		metricsSink: &metrics.NoopSink{},
 		stmt:        stmt,
+		skipScopes:  skipScopes,
 	}, nil
 }
 
@@ -141,6 +166,25 @@ func (r *Runner) SetLogger(logger loggers.Advanced) {
 	r.logger = logger
 }
 
+// SetCheckpointStore replaces the default _chkpnt table as the
+// place where checkpoints are saved and resumed from.
+func (r *Runner) SetCheckpointStore(store CheckpointStore) {
+	r.checkpointStore = store
+}
+
+// checkpoints returns the checkpoint store,
+// defaulting to the _chkpnt table if one was not set.
+func (r *Runner) checkpoints() CheckpointStore {
+	if r.checkpointStore == nil {
+		r.checkpointStore = &tableCheckpointStore{
+			db:         r.db,
+			schemaName: r.table.SchemaName,
+			tableName:  r.tableNamer().CheckpointName(r.table.TableName),
+		}
+	}
+	return r.checkpointStore
+}
+
 func (r *Runner) Run(originalCtx context.Context) error {
 	ctx, cancel := context.WithCancel(originalCtx)
 	defer cancel()
@@ -155,6 +199,8 @@ func (r *Runner) Run(originalCtx context.Context) error {
 	r.dbConfig = dbconn.NewDBConfig()
 	r.dbConfig.LockWaitTimeout = int(r.migration.LockWaitTimeout.Seconds())
 	r.dbConfig.InterpolateParams = r.migration.InterpolateParams
+	// The pool can sit idle for a long time while the copy is throttled or paused.
+	r.dbConfig.MaxIdleTime = r.migration.ConnMaxIdleTime
 	// The copier and checker will use Threads to limit N tasks concurrently,
 	// but we also set it at the DB pool level with +1. Because the copier and
 	// the replication applier use the same pool, it allows for some natural throttling
@@ -169,6 +215,9 @@ func (r *Runner) Run(originalCtx context.Context) error {
 	// We could extend the +1 to +2, but instead we increase the pool size
 	// during the cutover procedure.
 	r.dbConfig.MaxOpenConnections = r.migration.Threads + 1
+	// The copier and the replication applier can also share a limit on
+	// the queries they run concurrently, if one is configured.
+	r.connLimiter = dbconn.NewConnLimiter(r.migration.MaxConcurrentQueries)
 	r.db, err = dbconn.New(r.dsn(), r.dbConfig)
 	if err != nil {
 		return err
@@ -187,6 +236,7 @@ func (r *Runner) Run(originalCtx context.Context) error {
 
 	// Get Table Info
 	r.table = table.NewTableInfo(r.db, r.stmt.Schema, r.stmt.Table)
+	r.table.ReadOnly = r.migration.ReadOnlySafe
 	if err := r.table.SetInfo(ctx); err != nil {
 		return err
 	}
@@ -207,7 +257,20 @@ func (r *Runner) Run(originalCtx context.Context) error {
 		return nil // success!
 	}
 
+	// Wait until fewer than the maximum number of migrations are copying.
+	// An INSTANT or INPLACE change is quick, so it did not need to wait.
+	if err := r.acquireLease(ctx); err != nil {
+		return err
+	}
+
 	// Perform preflight basic checks.
+	// The privileges of a separate replication user are checked too.
+	if r.migration.ReplicationUsername != "" {
+		r.replicationDB, err = dbconn.New(r.replicationDSN(), r.dbConfig)
+		if err != nil {
+			return err
+		}
+	}
 	if err := r.runChecks(ctx, check.ScopePreflight); err != nil {
 		return err
 	}
@@ -228,6 +291,12 @@ func (r *Runner) Run(originalCtx context.Context) error {
 		}
 	}
 
+	// A small table is verified with the checksum until it finds no differences.
+	if !r.migration.Checksum && r.isSmallTable() {
+		r.logger.Infof("force enabling checksum: the table has at most %d estimated rows", r.migration.SmallTableMaxRows)
+		r.migration.Checksum = true
+	}
+
 	// We don't want to allow visibility changes
 	// This is because we've already attempted MySQL DDL as INPLACE, and it didn't work.
 	// It likely means the user is combining this operation with other unsafe operations,
@@ -242,8 +311,29 @@ func (r *Runner) Run(originalCtx context.Context) error {
 		return err
 	}
 
+	// The indexes are dropped after the checks, which
+	// validate the indexes of the new table.
+	if r.migration.DeferSecondaryIndexes && !r.usedResumeFromCheckpoint {
+		if err := r.deferSecondaryIndexes(ctx); err != nil {
+			return err
+		}
+	}
+
 	go r.dumpStatus(ctx)                 // start periodically writing status
 	go r.dumpCheckpointContinuously(ctx) // start periodically dumping the checkpoint.
+	go func() {
+		// The migration can not complete without the binary log
+		// subscription, so there is no point in continuing to copy.
+		// Nor can it continue once the lease is lost, since another
+		// migration may have taken its slot.
+		select {
+		case <-r.replClient.Failed():
+			cancel()
+		case <-r.lease.Lost():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
 	// Perform the main copy rows task. This is where the majority
 	// of migrations usually spend time. It is not strictly necessary,
@@ -251,10 +341,25 @@ func (r *Runner) Run(originalCtx context.Context) error {
 	// partially through the checksum.
 	r.setCurrentState(stateCopyRows)
 	if err := r.copier.Run(ctx); err != nil {
-		return err
+		return r.cancelCause(err)
 	}
 	r.logger.Info("copy rows complete")
 	r.replClient.SetKeyAboveWatermarkOptimization(false) // should no longer be used.
+	if r.copier.ConsistentSnapshot() {
+		// Apply the changes that were held back during the copy.
+		if err := r.replClient.Flush(ctx); err != nil {
+			return err
+		}
+		go r.replClient.StartPeriodicFlush(ctx, repl.DefaultFlushInterval)
+	}
+
+	// Add the indexes that were deferred until after the copy.
+	// This is before the checksum, so that it checks the new
+	// table as it will be after the cutover.
+	r.setCurrentState(stateAddIndexes)
+	if err := r.addDeferredIndexes(ctx); err != nil {
+		return err
+	}
 
 	// r.waitOnSentinel may return an error if there is
 	// some unexpected problem checking for the existence of
@@ -273,7 +378,7 @@ func (r *Runner) Run(originalCtx context.Context) error {
 	// catching up on replClient apply, running ANALYZE TABLE so
 	// that the statistics will be up-to-date on cutover.
 	if err := r.prepareForCutover(ctx); err != nil {
-		return err
+		return r.cancelCause(err)
 	}
 	// Run any checks that need to be done pre-cutover.
 	if err := r.runChecks(ctx, check.ScopeCutover); err != nil {
@@ -286,6 +391,7 @@ func (r *Runner) Run(originalCtx context.Context) error {
 	if err != nil {
 		return err
 	}
+	cutover.algorithm = CutOverAlgorithm(r.migration.CutOverAlgorithm)
 	// Drop the _old table if it exists. This ensures
 	// that the rename will succeed (although there is a brief race)
 	if err := r.dropOldTable(ctx); err != nil {
@@ -294,6 +400,9 @@ func (r *Runner) Run(originalCtx context.Context) error {
 	if err := cutover.Run(ctx); err != nil {
 		return err
 	}
+	if pos, ok := r.replClient.FinalPosition(); ok {
+		r.logger.Infof("tables swapped at binlog position %s gtid-set=%q", pos.Position, pos.GTIDSet)
+	}
 	if !r.migration.SkipDropAfterCutover {
 		if err := r.dropOldTable(ctx); err != nil {
 			// Don't return the error because our automation
@@ -318,9 +427,24 @@ func (r *Runner) Run(originalCtx context.Context) error {
 		time.Since(r.startTime).Round(time.Second),
 		r.db.Stats().InUse,
 	)
+	r.logger.Infof("copier summary: %s", r.copier.Summary())
+	r.logger.Infof("replication summary: %s", r.replClient.Summary())
 	return r.cleanup(ctx)
 }
 
+// cancelCause returns the error that the copy or the checksum was cancelled
+// for, if the binary log subscription failed or the lease was lost, and
+// err otherwise.
+func (r *Runner) cancelCause(err error) error {
+	if replErr := r.replClient.Err(); replErr != nil {
+		return replErr
+	}
+	if leaseErr := r.lease.Err(); leaseErr != nil {
+		return leaseErr
+	}
+	return err
+}
+
 // prepareForCutover performs steps to prepare for the final cutover.
 // most of these steps are technically optional, but skipping them
 // could for example cause a stall during the cutover if the replClient
@@ -378,11 +502,25 @@ func (r *Runner) prepareForCutover(ctx context.Context) error {
 	return nil
 }
 
+// checksumMode returns how the new table is verified before cutover. The
+// checksum can be enabled after the options are normalized, i.e. when
+// resuming from a checkpoint, in which case it is the full checksum.
+func (r *Runner) checksumMode() row.ChecksumMode {
+	if !r.migration.Checksum {
+		return row.ChecksumModeNone
+	}
+	if mode := row.ChecksumMode(r.migration.ChecksumMode); mode == row.ChecksumModeRowCount {
+		return mode
+	}
+	return row.ChecksumModeFull
+}
+
 // runChecks wraps around check.RunChecks and adds the context of this migration
 func (r *Runner) runChecks(ctx context.Context, scope check.ScopeFlag) error {
 	return check.RunChecks(ctx, check.Resources{
 		DB:              r.db,
 		Replica:         r.replica,
+		ReplicationDB:   r.replicationDB,
 		Table:           r.table,
 		Statement:       r.stmt,
 		TargetChunkTime: r.migration.TargetChunkTime,
@@ -390,10 +528,19 @@ func (r *Runner) runChecks(ctx context.Context, scope check.ScopeFlag) error {
 		ReplicaMaxLag:   r.migration.ReplicaMaxLag,
 		// For the pre-run checks we don't have a DB connection yet.
 		// Instead we check the credentials provided.
-		Host:                 r.migration.Host,
-		Username:             r.migration.Username,
-		Password:             r.migration.Password,
-		SkipDropAfterCutover: r.migration.SkipDropAfterCutover,
+		Host:                     r.migration.Host,
+		Username:                 r.migration.Username,
+		Password:                 r.migration.Password,
+		SkipDropAfterCutover:     r.migration.SkipDropAfterCutover,
+		TableNamer:               r.tableNamer(),
+		LongTransactionThreshold: r.migration.LongTransactionThreshold,
+		CutoverLockBudget:        r.migration.CutoverLockBudget,
+		ExpectedIndexes:          r.migration.ExpectedIndexes,
+		RequireFullRowMetadata:   r.migration.RequireFullRowMetadata,
+		AllowLossyCharset:        r.migration.AllowLossyCharset,
+		ReadOnly:                 r.migration.ReadOnlySafe,
+		SkipScopes:               r.skipScopes,
+		MaxChunkSize:             r.maxChunkSize(),
 	}, r.logger, scope)
 }
 
@@ -441,6 +588,22 @@ func (r *Runner) dsn() string {
 	return fmt.Sprintf("%s:%s@tcp(%s)/%s", r.migration.Username, r.migration.Password, r.migration.Host, r.stmt.Schema)
 }
 
+// replicationDSN returns the DSN of the replication user. It does not
+// select the schema, since the user only needs privileges on the table.
+func (r *Runner) replicationDSN() string {
+	username, password := r.replicationCredentials()
+	return fmt.Sprintf("%s:%s@tcp(%s)/", username, password, r.migration.Host)
+}
+
+// replicationCredentials returns the user and password that read the
+// binary log, which are those of the migration user by default.
+func (r *Runner) replicationCredentials() (string, string) {
+	if r.migration.ReplicationUsername == "" {
+		return r.migration.Username, r.migration.Password
+	}
+	return r.migration.ReplicationUsername, r.migration.ReplicationPassword
+}
+
 func (r *Runner) setup(ctx context.Context) error {
 	// Drop the old table. It shouldn't exist, but it could.
 	if err := r.dropOldTable(ctx); err != nil {
@@ -457,13 +620,16 @@ func (r *Runner) setup(ctx context.Context) error {
 			return err
 		}
 
+		if err := r.captureOriginalDDL(ctx); err != nil {
+			return err
+		}
 		if err := r.createNewTable(ctx); err != nil {
 			return err
 		}
 		if err := r.alterNewTable(ctx); err != nil {
 			return err
 		}
-		if err := r.createCheckpointTable(ctx); err != nil {
+		if err := r.createCheckpoint(ctx); err != nil {
 			return err
 		}
 
@@ -473,32 +639,61 @@ func (r *Runner) setup(ctx context.Context) error {
 			}
 		}
 
+		// A small table is copied in one shot, with one thread and a chunk
+		// size that is fixed at the maximum rows, since the estimate of the
+		// rows may be low.
+		threads, chunkSize := r.migration.Threads, r.maxChunkSize()
+		if r.isSmallTable() {
+			threads = 1
+			r.logger.Infof("copying the table in one shot: estimated-rows=%d small-table-max-rows=%d", r.table.EstimatedRows, r.migration.SmallTableMaxRows)
+		}
 		r.copier, err = row.NewCopier(r.db, r.table, r.newTable, &row.CopierConfig{
-			Concurrency:     r.migration.Threads,
-			TargetChunkTime: r.migration.TargetChunkTime,
-			FinalChecksum:   r.migration.Checksum,
-			Throttler:       &throttler.Noop{},
-			Logger:          r.logger,
-			MetricsSink:     r.metricsSink,
-			DBConfig:        r.dbConfig,
+			Concurrency:         threads,
+			TargetChunkTime:     r.migration.TargetChunkTime,
+			MinChunkSize:        chunkSize,
+			MaxChunkSize:        chunkSize,
+			ExplainPolicy:       row.ExplainPolicy(r.migration.ExplainChunkPolicy),
+			ChecksumMode:        r.checksumMode(),
+			Throttler:           &throttler.Noop{},
+			Logger:              r.logger,
+			MetricsSink:         r.metricsSink,
+			DBConfig:            r.dbConfig,
+			NewestFirstKeyRange: r.migration.NewestFirstKeyRange,
+			ConnLimiter:         r.connLimiter,
+			QueryComment:        r.migration.QueryComment,
+			MaxLoad:             r.migration.MaxLoad,
+			CriticalLoad:        r.migration.CriticalLoad,
+			ConsistentSnapshot:  r.migration.ConsistentSnapshot,
 		})
 		if err != nil {
 			return err
 		}
-		r.replClient = repl.NewClient(r.db, r.migration.Host, r.table, r.newTable, r.migration.Username, r.migration.Password, &repl.ClientConfig{
-			Logger:          r.logger,
-			Concurrency:     r.migration.Threads,
-			TargetBatchTime: r.migration.TargetChunkTime,
+		replUsername, replPassword := r.replicationCredentials()
+		r.replClient = repl.NewClient(r.db, r.migration.Host, r.table, r.newTable, replUsername, replPassword, &repl.ClientConfig{
+			Logger:              r.logger,
+			Concurrency:         r.migration.Threads,
+			TargetBatchTime:     r.migration.TargetChunkTime,
+			ConnLimiter:         r.connLimiter,
+			QueryComment:        r.migration.QueryComment,
+			FlushFailurePolicy:  repl.FlushFailurePolicy(r.migration.FlushFailurePolicy),
+			ApplyOrder:          repl.ApplyOrder(r.migration.FlushApplyOrder),
+			UnknownActionPolicy: repl.UnknownActionPolicy(r.migration.UnknownActionPolicy),
+			MetricsSink:         r.metricsSink,
 		})
 		// Start the binary log feed now
 		if err := r.replClient.Run(); err != nil {
 			return err
 		}
 	}
+	go r.drainReplErrors(ctx, r.replClient.Errors())
+	go r.replClient.StartEventMetrics(ctx, repl.DefaultEventMetricsInterval)
 
 	// If the replica DSN was specified, attach a replication throttler.
+	// If the max changeset depth was specified, attach a changeset throttler,
+	// so the copy slows down while the flushes can not keep up.
 	// Otherwise, it will default to the NOOP throttler.
 	var err error
+	var throttlers []throttler.Throttler
 	if r.migration.ReplicaDSN != "" {
 		r.replica, err = dbconn.New(r.migration.ReplicaDSN, r.dbConfig)
 		if err != nil {
@@ -507,17 +702,44 @@ func (r *Runner) setup(ctx context.Context) error {
 		// An error here means the connection to the replica is not valid, or it can't be detected
 		// This is fatal because if a user specifies a replica throttler, and it can't be used,
 		// we should not proceed.
-		r.throttler, err = throttler.NewReplicationThrottler(r.replica, r.migration.ReplicaMaxLag, r.logger)
+		replicationThrottler, err := throttler.NewReplicationThrottler(r.replica, r.migration.ReplicaMaxLag, throttler.ErrorPolicy(r.migration.ThrottlerErrorPolicy), r.logger)
 		if err != nil {
 			r.logger.Warnf("could not create replication throttler: %v", err)
 			return err
 		}
+		throttlers = append(throttlers, replicationThrottler)
+	}
+	if r.migration.MaxChangesetDepth > 0 {
+		changesetThrottler, err := throttler.NewChangesetThrottler(r.replClient.GetDeltaLen, r.migration.MaxChangesetDepth, r.logger)
+		if err != nil {
+			return err
+		}
+		throttlers = append(throttlers, changesetThrottler)
+	}
+	if len(throttlers) > 0 {
+		r.throttler = throttlers[0]
+		if len(throttlers) > 1 {
+			r.throttler = throttler.NewMultiThrottler(throttlers...)
+		}
 		r.copier.SetThrottler(r.throttler)
 		if err := r.throttler.Open(); err != nil {
 			return err
 		}
 	}
 
+	// Do not start copying until the binary log is being streamed,
+	// since the key above watermark optimization depends on it.
+	// If streaming can not be confirmed, i.e. on an idle server when the
+	// binary log can not be rotated, copy without the optimization.
+	keyAboveWatermark := true
+	if err := r.replClient.WaitUntilReady(ctx); err != nil {
+		if !errors.Is(err, repl.ErrNotStreaming) {
+			return err
+		}
+		r.logger.Warnf("%v, disabling the key above watermark optimization", err)
+		keyAboveWatermark = false
+	}
+
 	// Make sure the definition of the table never changes.
 	// If it does, we could be in trouble.
 	r.replClient.TableChangeNotificationCallback = r.tableChangeNotification
@@ -525,7 +747,7 @@ func (r *Runner) setup(ctx context.Context) error {
 	// If this is NOT nil then it will use this optimization when determining
 	// if it can ignore a KEY.
 	r.replClient.KeyAboveCopierCallback = r.copier.KeyAboveHighWatermark
-	r.replClient.SetKeyAboveWatermarkOptimization(true)
+	r.replClient.SetKeyAboveWatermarkOptimization(keyAboveWatermark)
 
 	// Start routines in table and replication packages to
 	// Continuously update the min/max and estimated rows
@@ -534,10 +756,35 @@ func (r *Runner) setup(ctx context.Context) error {
 	// and checksum starts, although the PeriodicFlush
 	// will be restarted again after.
 	go r.table.AutoUpdateStatistics(ctx, tableStatUpdateInterval, r.logger)
-	go r.replClient.StartPeriodicFlush(ctx, repl.DefaultFlushInterval)
+	// In consistent snapshot mode, the changes can not be applied to the
+	// new table until the copy has committed, so the flush starts after it.
+	if !r.copier.ConsistentSnapshot() {
+		go r.replClient.StartPeriodicFlush(ctx, repl.DefaultFlushInterval)
+	}
 	return nil
 }
 
+// acquireLease waits for a slot of the ConcurrencyLeaseTable, if it is set,
+// so that at most ConcurrencyLeaseSlots migrations copy at the same time.
+// The table is in the schema of the migration unless it is qualified.
+func (r *Runner) acquireLease(ctx context.Context) error {
+	if r.migration.ConcurrencyLeaseTable == "" {
+		return nil
+	}
+	schemaName, tableName := r.stmt.Schema, r.migration.ConcurrencyLeaseTable
+	if before, after, ok := strings.Cut(tableName, "."); ok {
+		schemaName, tableName = before, after
+	}
+	var err error
+	r.lease, err = dbconn.AcquireLease(ctx, r.db, &dbconn.LeaseConfig{
+		SchemaName: schemaName,
+		TableName:  tableName,
+		Slots:      r.migration.ConcurrencyLeaseSlots,
+		Holder:     fmt.Sprintf("%s.%s", r.stmt.Schema, r.stmt.Table),
+	}, r.logger)
+	return err
+}
+
 func (r *Runner) tableChangeNotification() {
 	// It's an async message, so we don't know the current state
 	// from which this "notification" was generated, but typically if our
@@ -559,11 +806,11 @@ func (r *Runner) tableChangeNotification() {
 }
 
 func (r *Runner) dropCheckpoint(ctx context.Context) error {
-	return dbconn.Exec(ctx, r.db, "DROP TABLE IF EXISTS %n.%n", r.checkpointTable.SchemaName, r.checkpointTable.TableName)
+	return r.checkpoints().Drop(ctx)
 }
 
 func (r *Runner) createNewTable(ctx context.Context) error {
-	newName := fmt.Sprintf(check.NameFormatNew, r.table.TableName)
+	newName := r.tableNamer().NewName(r.table.TableName)
 	// drop both if we've decided to call this func.
 	if err := dbconn.Exec(ctx, r.db, "DROP TABLE IF EXISTS %n.%n", r.table.SchemaName, newName); err != nil {
 		return err
@@ -606,9 +853,15 @@ func (r *Runner) oldTableName() string {
 	// By default we just set the old table name to _<table>_old
 	// but if they've enabled SkipDropAfterCutover, we add a timestamp
 	if !r.migration.SkipDropAfterCutover {
-		return fmt.Sprintf(check.NameFormatOld, r.table.TableName)
+		return r.tableNamer().OldName(r.table.TableName, "")
 	}
-	return fmt.Sprintf(check.NameFormatOldTimeStamp, r.table.TableName, r.startTime.UTC().Format(check.NameFormatTimestamp))
+	return r.tableNamer().OldName(r.table.TableName, r.startTime.UTC().Format(check.NameFormatTimestamp))
+}
+
+// tableNamer returns the naming strategy for the new, old,
+// checkpoint and sentinel tables.
+func (r *Runner) tableNamer() check.TableNamer {
+	return check.TableNamer{Prefix: r.migration.ArtifactTablePrefix}
 }
 
 func (r *Runner) attemptInstantDDL(ctx context.Context) error {
@@ -619,29 +872,28 @@ func (r *Runner) attemptInplaceDDL(ctx context.Context) error {
 	return dbconn.Exec(ctx, r.db, "ALTER TABLE %n.%n "+r.stmt.Alter+", ALGORITHM=INPLACE, LOCK=NONE", r.table.SchemaName, r.table.TableName)
 }
 
-func (r *Runner) createCheckpointTable(ctx context.Context) error {
-	cpName := fmt.Sprintf(check.NameFormatCheckpoint, r.table.TableName)
-	// drop both if we've decided to call this func.
-	if err := dbconn.Exec(ctx, r.db, "DROP TABLE IF EXISTS %n.%n", r.table.SchemaName, cpName); err != nil {
-		return err
-	}
-	if err := dbconn.Exec(ctx, r.db, `CREATE TABLE %n.%n (
-	id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
-	copier_watermark TEXT,
-	checksum_watermark TEXT,
-	binlog_name VARCHAR(255),
-	binlog_pos INT,
-	rows_copied BIGINT,
-	rows_copied_logical BIGINT,
-	alter_statement TEXT
-	)`,
-		r.table.SchemaName, cpName); err != nil {
-		return err
+// captureOriginalDDL records the SHOW CREATE TABLE of the table,
+// so that it is saved with each checkpoint.
+func (r *Runner) captureOriginalDDL(ctx context.Context) error {
+	var tableName string
+	query := sqlescape.MustEscapeSQL("SHOW CREATE TABLE %n.%n", r.table.SchemaName, r.table.TableName)
+	if err := r.db.QueryRowContext(ctx, query).Scan(&tableName, &r.originalDDL); err != nil {
+		return fmt.Errorf("could not capture the original definition of %s: %w", r.table.QuotedName, err)
 	}
-	r.checkpointTable = table.NewTableInfo(r.db, r.table.SchemaName, cpName)
 	return nil
 }
 
+// OriginalDDL returns the SHOW CREATE TABLE of the table as it was when
+// the migration started. When resuming from a checkpoint, it is the
+// definition that was saved with the checkpoint.
+func (r *Runner) OriginalDDL() string {
+	return r.originalDDL
+}
+
+func (r *Runner) createCheckpoint(ctx context.Context) error {
+	return r.checkpoints().Create(ctx)
+}
+
 func (r *Runner) GetProgress() Progress {
 	var summary string
 	switch r.getCurrentState() { //nolint: exhaustive
@@ -651,6 +903,14 @@ func (r *Runner) GetProgress() Progress {
 			r.getCurrentState().String(),
 			r.copier.GetETA(),
 		)
+		if r.copier.Throttler.IsThrottled() {
+			summary += ", throttler " + r.copier.Throttler.State()
+		}
+	case stateAddIndexes:
+		summary = fmt.Sprintf("Adding %d Deferred Indexes", len(r.deferredIndexes))
+		if progress := r.indexBuildProgress(); progress != "" {
+			summary += " Progress=" + progress
+		}
 	case stateWaitingOnSentinelTable:
 		summary = "Waiting on Sentinel Table"
 	case stateApplyChangeset, statePostChecksum:
@@ -667,7 +927,7 @@ func (r *Runner) GetProgress() Progress {
 }
 
 func (r *Runner) sentinelTableName() string {
-	return fmt.Sprintf(check.NameFormatSentinel, r.table.TableName)
+	return r.tableNamer().SentinelName(r.table.TableName)
 }
 
 func (r *Runner) createSentinelTable(ctx context.Context) error {
@@ -686,7 +946,7 @@ func (r *Runner) cleanup(ctx context.Context) error {
 			return err
 		}
 	}
-	if r.checkpointTable != nil {
+	if r.checkpointStore != nil {
 		if err := r.dropCheckpoint(ctx); err != nil {
 			return err
 		}
@@ -694,8 +954,36 @@ func (r *Runner) cleanup(ctx context.Context) error {
 	return nil
 }
 
+// drainReplErrors counts the non-fatal errors from the replication
+// client until it is closed. The errors are already logged by the client.
+func (r *Runner) drainReplErrors(ctx context.Context, errs <-chan error) {
+	for err := range errs {
+		r.logger.Debugf("replication client error: %v", err)
+		m := &metrics.Metrics{
+			Values: []metrics.MetricValue{
+				{
+					Name:  metrics.BinlogErrorCountMetricName,
+					Type:  metrics.COUNTER,
+					Value: 1,
+				},
+			},
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, metrics.SinkTimeout)
+		if err := r.metricsSink.Send(sendCtx, m); err != nil {
+			r.logger.Errorf("error sending metrics from replication client: %v", err)
+		}
+		cancel()
+	}
+}
+
 func (r *Runner) Close() error {
 	r.setCurrentState(stateClose)
+	// The lease is released first, so that it is released even
+	// if closing something else fails, and before r.db is closed.
+	var leaseErr error
+	if r.lease != nil {
+		leaseErr = r.lease.Close()
+	}
 	if r.table != nil {
 		err := r.table.Close()
 		if err != nil {
@@ -717,6 +1005,12 @@ func (r *Runner) Close() error {
 			return err
 		}
 	}
+	if r.replicationDB != nil {
+		err := r.replicationDB.Close()
+		if err != nil {
+			return err
+		}
+	}
 	if r.db != nil {
 		err := r.db.Close()
 		if err != nil {
@@ -729,7 +1023,7 @@ func (r *Runner) Close() error {
 			return err
 		}
 	}
-	return nil
+	return leaseErr
 }
 
 func (r *Runner) resumeFromCheckpoint(ctx context.Context) error {
@@ -738,8 +1032,7 @@ func (r *Runner) resumeFromCheckpoint(ctx context.Context) error {
 
 	// The objects for these are not available until we confirm
 	// tables exist and we
-	newName := fmt.Sprintf(check.NameFormatNew, r.table.TableName)
-	cpName := fmt.Sprintf(check.NameFormatCheckpoint, r.table.TableName)
+	newName := r.tableNamer().NewName(r.table.TableName)
 
 	// Make sure we can read from the new table.
 	if err := dbconn.Exec(ctx, r.db, "SELECT * FROM %n.%n LIMIT 1",
@@ -747,22 +1040,23 @@ func (r *Runner) resumeFromCheckpoint(ctx context.Context) error {
 		return fmt.Errorf("could not find any checkpoints in table '%s'", newName)
 	}
 
-	// We intentionally SELECT * FROM the checkpoint table because if the structure
-	// changes, we want this operation to fail. This will indicate that the checkpoint
-	// was created by either an earlier or later version of spirit, in which case
-	// we do not support recovery.
-	query := fmt.Sprintf("SELECT * FROM `%s`.`%s` ORDER BY id DESC LIMIT 1",
-		r.stmt.Schema, cpName)
-	var copierWatermark, binlogName, alterStatement string
-	var id, binlogPos int
-	var rowsCopied, rowsCopiedLogical uint64
-	err := r.db.QueryRow(query).Scan(&id, &copierWatermark, &r.checksumWatermark, &binlogName, &binlogPos, &rowsCopied, &rowsCopiedLogical, &alterStatement)
+	cp, err := r.checkpoints().Load(ctx)
 	if err != nil {
-		return fmt.Errorf("could not read from table '%s', err:%v", cpName, err)
+		return err
 	}
-	if r.stmt.Alter != alterStatement {
+	r.checksumWatermark = cp.ChecksumWatermark
+	if r.stmt.Alter != cp.AlterStatement {
 		return ErrMismatchedAlter
 	}
+	r.originalDDL = cp.OriginalDDL
+	r.deferredIndexes = splitDeferredIndexes(cp.DeferredIndexes)
+	if r.originalDDL == "" {
+		// The checkpoint store did not record it. The table has
+		// not been changed since, so it can be captured now.
+		if err := r.captureOriginalDDL(ctx); err != nil {
+			return err
+		}
+	}
 	// Populate the objects that would have been set in the other funcs.
 	r.newTable = table.NewTableInfo(r.db, r.stmt.Schema, newName)
 	if err := r.newTable.SetInfo(ctx); err != nil {
@@ -780,39 +1074,48 @@ func (r *Runner) resumeFromCheckpoint(ctx context.Context) error {
 	r.copier, err = row.NewCopierFromCheckpoint(r.db, r.table, r.newTable, &row.CopierConfig{
 		Concurrency:     r.migration.Threads,
 		TargetChunkTime: r.migration.TargetChunkTime,
-		FinalChecksum:   r.migration.Checksum,
+		ChecksumMode:    r.checksumMode(),
 		Throttler:       &throttler.Noop{},
 		Logger:          r.logger,
 		MetricsSink:     r.metricsSink,
 		DBConfig:        r.dbConfig,
-	}, copierWatermark, rowsCopied, rowsCopiedLogical)
+		ConnLimiter:     r.connLimiter,
+		QueryComment:    r.migration.QueryComment,
+		MaxLoad:         r.migration.MaxLoad,
+		CriticalLoad:    r.migration.CriticalLoad,
+	}, cp.CopierWatermark, cp.RowsCopied, cp.RowsCopiedLogical)
 	if err != nil {
 		return err
 	}
 
 	// Set the binlog position.
 	// Create a binlog subscriber
-	r.replClient = repl.NewClient(r.db, r.migration.Host, r.table, r.newTable, r.migration.Username, r.migration.Password, &repl.ClientConfig{
-		Logger:          r.logger,
-		Concurrency:     r.migration.Threads,
-		TargetBatchTime: r.migration.TargetChunkTime,
+	replUsername, replPassword := r.replicationCredentials()
+	r.replClient = repl.NewClient(r.db, r.migration.Host, r.table, r.newTable, replUsername, replPassword, &repl.ClientConfig{
+		Logger:              r.logger,
+		Concurrency:         r.migration.Threads,
+		TargetBatchTime:     r.migration.TargetChunkTime,
+		ConnLimiter:         r.connLimiter,
+		QueryComment:        r.migration.QueryComment,
+		FlushFailurePolicy:  repl.FlushFailurePolicy(r.migration.FlushFailurePolicy),
+		ApplyOrder:          repl.ApplyOrder(r.migration.FlushApplyOrder),
+		UnknownActionPolicy: repl.UnknownActionPolicy(r.migration.UnknownActionPolicy),
+		MetricsSink:         r.metricsSink,
 	})
 	r.replClient.SetPos(mysql.Position{
-		Name: binlogName,
-		Pos:  uint32(binlogPos),
+		Name: cp.BinlogName,
+		Pos:  cp.BinlogPos,
 	})
 
-	r.checkpointTable = table.NewTableInfo(r.db, r.table.SchemaName, cpName)
-
 	// Start the replClient now. This is because if the checkpoint is so old there
 	// are no longer binary log files, we want to abandon resume-from-checkpoint
 	// and still be able to start from scratch.
 	// Start the binary log feed just before copy rows starts.
 	if err := r.replClient.Run(); err != nil {
-		r.logger.Warnf("resuming from checkpoint failed because resuming from the previous binlog position failed. log-file: %s log-pos: %d", binlogName, binlogPos)
+		r.logger.Warnf("resuming from checkpoint failed because resuming from the previous binlog position failed. log-file: %s log-pos: %d", cp.BinlogName, cp.BinlogPos)
 		return err
 	}
-	r.logger.Warnf("resuming from checkpoint. copier-watermark: %s checksum-watermark: %s log-file: %s log-pos: %d copy-rows: %d", copierWatermark, r.checksumWatermark, binlogName, binlogPos, rowsCopied)
+	r.logger.Warnf("resuming from checkpoint. copier-watermark: %s checksum-watermark: %s log-file: %s log-pos: %d copy-rows: %d", cp.CopierWatermark, r.checksumWatermark, cp.BinlogName, cp.BinlogPos, cp.RowsCopied)
 	r.usedResumeFromCheckpoint = true
 	return nil
 }
@@ -831,7 +1134,15 @@ func (r *Runner) checksum(ctx context.Context) error {
 	}
 	r.db.SetMaxOpenConns(r.dbConfig.MaxOpenConnections + 2)
 	var err error
-	for i := range 3 { // try the checksum up to 3 times.
+	policy := ChecksumFailurePolicy(r.migration.ChecksumFailurePolicy)
+	attempts := checksumAttempts
+	if r.isSmallTable() {
+		// Each checksum applies the changes under a lock before it starts,
+		// so for a small table the checksum is a tight loop of applying
+		// changes and verifying them, which we retry until it is clean.
+		attempts = smallTableChecksumAttempts
+	}
+	for i := range attempts {
 		if i > 0 {
 			r.checksumWatermark = "" // reset the watermark if we are retrying.
 		}
@@ -841,8 +1152,10 @@ func (r *Runner) checksum(ctx context.Context) error {
 			TargetChunkTime: r.migration.TargetChunkTime,
 			DBConfig:        r.dbConfig,
 			Logger:          r.logger,
-			FixDifferences:  true, // we want to repair the differences.
+			FixDifferences:  policy == ChecksumFailurePolicyRecopy, // the default is to repair the differences.
 			Watermark:       r.checksumWatermark,
+			Throttler:       r.throttler,
+			Mode:            r.checksumMode(),
 		})
 		r.checkerLock.Unlock()
 		if err != nil {
@@ -857,22 +1170,31 @@ func (r *Runner) checksum(ctx context.Context) error {
 		// But we don't know if differences were found and chunks were recopied.
 		// We want to know it passed without one.
 		if r.checker.DifferencesFound() == 0 {
+			r.logger.Info("checksum passed")
 			break // success!
 		}
-		if i >= 2 {
+		// The differences were not repaired, so there is no point in retrying.
+		if policy == ChecksumFailurePolicyAbort {
+			return fmt.Errorf("%w in %d chunks: %s", ErrChecksumDifferences, r.checker.DifferencesFound(), strings.Join(r.checker.Mismatches(), "; "))
+		}
+		if policy == ChecksumFailurePolicyWarn {
+			r.logger.Warnf("checksum found differences in %d chunks, continuing because the checksum failure policy is %q: %s",
+				r.checker.DifferencesFound(), policy, strings.Join(r.checker.Mismatches(), "; "))
+			break
+		}
+		if i >= attempts-1 {
 			// This used to say "checksum failed, this should never happen" but that's not entirely true.
 			// If the user attempts a lossy schema change such as adding a UNIQUE INDEX to non-unique data,
 			// then the checksum will fail. This is entirely expected, and not considered a bug. We should
 			// do our best-case to differentiate that we believe this ALTER statement is lossy, and
 			// customize the returned error based on it.
 			if err := r.stmt.AlterContainsAddUnique(); err != nil {
-				return errors.New("checksum failed after 3 attempts. Check that the ALTER statement is not adding a UNIQUE INDEX to non-unique data")
+				return fmt.Errorf("checksum failed after %d attempts. Check that the ALTER statement is not adding a UNIQUE INDEX to non-unique data", attempts)
 			}
-			return errors.New("checksum failed after 3 attempts. This likely indicates either a bug in Spirit, or a manual modification to the _new table outside of Spirit. Please report @ github.com/cashapp/spirit")
+			return fmt.Errorf("checksum failed after %d attempts. This likely indicates either a bug in Spirit, or a manual modification to the _new table outside of Spirit. Please report @ github.com/cashapp/spirit", attempts)
 		}
-		r.logger.Errorf("checksum failed, retrying %d/%d times", i+1, 3)
+		r.logger.Errorf("checksum failed, retrying %d/%d times", i+1, attempts)
 	}
-	r.logger.Info("checksum passed")
 
 	// A long checksum extends the binlog deltas
 	// So if we've called this optional checksum, we need one more state
@@ -881,6 +1203,22 @@ func (r *Runner) checksum(ctx context.Context) error {
 	return r.replClient.Flush(ctx)
 }
 
+// isSmallTable returns true if the table has at most SmallTableMaxRows
+// estimated rows, so it is copied in a single chunk.
+func (r *Runner) isSmallTable() bool {
+	return r.migration.SmallTableMaxRows > 0 && r.table.EstimatedRows <= r.migration.SmallTableMaxRows
+}
+
+// maxChunkSize returns the maximum number of rows in a chunk of the copy,
+// or zero if it is the maximum of the chunker. A small table is copied
+// in chunks of SmallTableMaxRows.
+func (r *Runner) maxChunkSize() uint64 {
+	if r.table != nil && r.isSmallTable() {
+		return r.migration.SmallTableMaxRows
+	}
+	return 0
+}
+
 func (r *Runner) getCurrentState() migrationState {
 	return migrationState(atomic.LoadInt32((*int32)(&r.currentState)))
 }
@@ -922,17 +1260,17 @@ func (r *Runner) dumpCheckpoint(ctx context.Context) error {
 	// We believe this is OK but may change it in the future. Please do not
 	// add any other fields to this log line.
 	r.logger.Infof("checkpoint: low-watermark=%s log-file=%s log-pos=%d rows-copied=%d rows-copied-logical=%d", copierWatermark, binlog.Name, binlog.Pos, copyRows, logicalCopyRows)
-	return dbconn.Exec(ctx, r.db, "INSERT INTO %n.%n (copier_watermark, checksum_watermark, binlog_name, binlog_pos, rows_copied, rows_copied_logical, alter_statement) VALUES (%?, %?, %?, %?, %?, %?, %?)",
-		r.checkpointTable.SchemaName,
-		r.checkpointTable.TableName,
-		copierWatermark,
-		checksumWatermark,
-		binlog.Name,
-		binlog.Pos,
-		copyRows,
-		logicalCopyRows,
-		r.stmt.Alter,
-	)
+	return r.checkpoints().Save(ctx, &Checkpoint{
+		CopierWatermark:   copierWatermark,
+		ChecksumWatermark: checksumWatermark,
+		BinlogName:        binlog.Name,
+		BinlogPos:         binlog.Pos,
+		RowsCopied:        copyRows,
+		RowsCopiedLogical: logicalCopyRows,
+		AlterStatement:    r.stmt.Alter,
+		OriginalDDL:       r.originalDDL,
+		DeferredIndexes:   strings.Join(r.deferredIndexes, "\n"),
+	})
 }
 
 func (r *Runner) dumpCheckpointContinuously(ctx context.Context) {
@@ -971,7 +1309,7 @@ func (r *Runner) dumpStatus(ctx context.Context) {
 			case stateCopyRows:
 				// Status for copy rows
 
-				r.logger.Infof("migration status: state=%s copy-progress=%s binlog-deltas=%v total-time=%s copier-time=%s copier-remaining-time=%v copier-is-throttled=%v conns-in-use=%d",
+				r.logger.Infof("migration status: state=%s copy-progress=%s binlog-deltas=%v total-time=%s copier-time=%s copier-remaining-time=%v copier-is-throttled=%v copier-throttler-state=%q conns-in-use=%d",
 					r.getCurrentState().String(),
 					r.copier.GetProgress(),
 					r.replClient.GetDeltaLen(),
@@ -979,6 +1317,16 @@ func (r *Runner) dumpStatus(ctx context.Context) {
 					time.Since(r.copier.StartTime()).Round(time.Second),
 					r.copier.GetETA(),
 					r.copier.Throttler.IsThrottled(),
+					r.copier.Throttler.State(),
+					r.db.Stats().InUse,
+				)
+			case stateAddIndexes:
+				r.logger.Infof("migration status: state=%s deferred-indexes=%d index-build-progress=%q binlog-deltas=%v total-time=%s conns-in-use=%d",
+					r.getCurrentState().String(),
+					len(r.deferredIndexes),
+					r.indexBuildProgress(),
+					r.replClient.GetDeltaLen(),
+					time.Since(r.startTime).Round(time.Second),
 					r.db.Stats().InUse,
 				)
 			case stateWaitingOnSentinelTable:
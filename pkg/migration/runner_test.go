@@ -50,6 +50,112 @@ func TestVarcharNonBinaryComparable(t *testing.T) {
 
 // TestPartitioningSyntax tests that ALTERs that don't support ALGORITHM assertion
 // are still supported. From https://github.com/cashapp/spirit/issues/277
+func TestEmptyTable(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS emptytablet1, _emptytablet1_new, _emptytablet1_old, _emptytablet1_chkpnt`)
+	testutils.RunSQL(t, `CREATE TABLE emptytablet1 (
+		id int NOT NULL AUTO_INCREMENT,
+		name varchar(255) NOT NULL,
+		PRIMARY KEY (id)
+	)`)
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+
+	m, err := NewRunner(&Migration{
+		Host:     cfg.Addr,
+		Username: cfg.User,
+		Password: cfg.Passwd,
+		Database: cfg.DBName,
+		Threads:  1,
+		Table:    "emptytablet1",
+		Alter:    "ADD INDEX (name)",
+		Checksum: true,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Run(context.Background()))
+	assert.False(t, m.usedInstantDDL)
+	assert.Equal(t, "0/0 100.00%", m.copier.GetProgress())
+	assert.NoError(t, m.Close())
+
+	// The table has been cut over to the new definition.
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name='emptytablet1' AND index_name='name'").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestReplicationUser(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS repluser1, _repluser1_new, _repluser1_old, _repluser1_chkpnt`)
+	testutils.RunSQL(t, `CREATE TABLE repluser1 (
+		id int NOT NULL AUTO_INCREMENT,
+		name varchar(255) NOT NULL,
+		PRIMARY KEY (id)
+	)`)
+	testutils.RunSQL(t, `INSERT INTO repluser1 (name) VALUES ('a'), ('b'), ('c')`)
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	rootDB, err := dbconn.New(fmt.Sprintf("root:%s@tcp(%s)/%s", cfg.Passwd, cfg.Addr, cfg.DBName), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer rootDB.Close()
+	_, err = rootDB.Exec("DROP USER IF EXISTS spiritrepluser")
+	assert.NoError(t, err)
+	_, err = rootDB.Exec("CREATE USER spiritrepluser IDENTIFIED BY 'replpass'")
+	assert.NoError(t, err)
+
+	newMigration := func() *Migration {
+		return &Migration{
+			Host:                cfg.Addr,
+			Username:            cfg.User,
+			Password:            cfg.Passwd,
+			ReplicationUsername: "spiritrepluser",
+			ReplicationPassword: "replpass",
+			Database:            cfg.DBName,
+			Threads:             1,
+			Table:               "repluser1",
+			Alter:               "ADD INDEX (name)",
+		}
+	}
+
+	// The replication user is checked before starting.
+	m, err := NewRunner(newMigration())
+	assert.NoError(t, err)
+	assert.ErrorContains(t, m.Run(context.Background()), "insufficient privileges to read the binary log")
+	assert.NoError(t, m.Close())
+
+	// The binary log is read as the replication user, and the
+	// rows are copied and changes applied as the migration user.
+	_, err = rootDB.Exec("GRANT REPLICATION SLAVE, REPLICATION CLIENT ON *.* TO spiritrepluser")
+	assert.NoError(t, err)
+	m, err = NewRunner(newMigration())
+	assert.NoError(t, err)
+	assert.ErrorContains(t, m.Run(context.Background()), "SELECT on "+cfg.DBName+".repluser1")
+	assert.NoError(t, m.Close())
+	_, err = rootDB.Exec(fmt.Sprintf("GRANT SELECT ON `%s`.`repluser1` TO spiritrepluser", cfg.DBName))
+	assert.NoError(t, err)
+	m, err = NewRunner(newMigration())
+	assert.NoError(t, err)
+	username, password := m.replicationCredentials()
+	assert.Equal(t, "spiritrepluser", username)
+	assert.Equal(t, "replpass", password)
+	assert.NoError(t, m.Run(context.Background()))
+	var currentUser string
+	assert.NoError(t, m.db.QueryRow("SELECT SUBSTRING_INDEX(CURRENT_USER(), '@', 1)").Scan(&currentUser))
+	assert.Equal(t, cfg.User, currentUser)
+	assert.NoError(t, m.replicationDB.QueryRow("SELECT SUBSTRING_INDEX(CURRENT_USER(), '@', 1)").Scan(&currentUser))
+	assert.Equal(t, "spiritrepluser", currentUser)
+	assert.NoError(t, m.Close())
+
+	// Without a replication user, the migration user reads the binary log.
+	migration := newMigration()
+	migration.ReplicationUsername = ""
+	m, err = NewRunner(migration)
+	assert.NoError(t, err)
+	username, password = m.replicationCredentials()
+	assert.Equal(t, cfg.User, username)
+	assert.Equal(t, cfg.Passwd, password)
+}
+
 func TestPartitioningSyntax(t *testing.T) {
 	testutils.RunSQL(t, `DROP TABLE IF EXISTS partt1, _partt1_new`)
 	table := `CREATE TABLE partt1 (
@@ -357,6 +463,7 @@ func TestTableLength(t *testing.T) {
 func TestMigrationStateString(t *testing.T) {
 	assert.Equal(t, "initial", stateInitial.String())
 	assert.Equal(t, "copyRows", stateCopyRows.String())
+	assert.Equal(t, "addIndexes", stateAddIndexes.String())
 	assert.Equal(t, "waitingOnSentinelTable", stateWaitingOnSentinelTable.String())
 	assert.Equal(t, "applyChangeset", stateApplyChangeset.String())
 	assert.Equal(t, "checksum", stateChecksum.String())
@@ -391,6 +498,98 @@ func TestBadOptions(t *testing.T) {
 	})
 	assert.Error(t, err)
 	assert.ErrorContains(t, err, "alter statement is required")
+	_, err = NewRunner(&Migration{
+		Host:            cfg.Addr,
+		Database:        "mytable",
+		Table:           "mytable",
+		Alter:           "ENGINE=InnoDB",
+		SkipCheckScopes: []string{"preflight", "sometimes"},
+	})
+	assert.ErrorContains(t, err, `unknown check scope "sometimes"`)
+	_, err = NewRunner(&Migration{
+		Host:                  cfg.Addr,
+		Database:              "mytable",
+		Table:                 "mytable",
+		Alter:                 "ENGINE=InnoDB",
+		ChecksumFailurePolicy: "ignore",
+	})
+	assert.ErrorContains(t, err, `unknown checksum failure policy "ignore"`)
+	_, err = NewRunner(&Migration{
+		Host:               cfg.Addr,
+		Database:           "mytable",
+		Table:              "mytable",
+		Alter:              "ENGINE=InnoDB",
+		FlushFailurePolicy: "ignore",
+	})
+	assert.ErrorContains(t, err, `unknown flush failure policy "ignore"`)
+	_, err = NewRunner(&Migration{
+		Host:                cfg.Addr,
+		Database:            "mytable",
+		Table:               "mytable",
+		Alter:               "ENGINE=InnoDB",
+		UnknownActionPolicy: "ignore",
+	})
+	assert.ErrorContains(t, err, `invalid unknown action policy "ignore"`)
+	_, err = NewRunner(&Migration{
+		Host:             cfg.Addr,
+		Database:         "mytable",
+		Table:            "mytable",
+		Alter:            "ENGINE=InnoDB",
+		CutOverAlgorithm: "view-swap",
+	})
+	assert.ErrorContains(t, err, `unknown cutover algorithm "view-swap"`)
+	_, err = NewRunner(&Migration{
+		Host:               cfg.Addr,
+		Database:           "mytable",
+		Table:              "mytable",
+		Alter:              "ENGINE=InnoDB",
+		ExplainChunkPolicy: "ignore",
+	})
+	assert.ErrorContains(t, err, `unknown explain chunk policy "ignore"`)
+	_, err = NewRunner(&Migration{
+		Host:            cfg.Addr,
+		Database:        "mytable",
+		Table:           "mytable",
+		Alter:           "ENGINE=InnoDB",
+		FlushApplyOrder: "random",
+	})
+	assert.ErrorContains(t, err, `unknown flush apply order "random"`)
+	_, err = NewRunner(&Migration{
+		Host:                  cfg.Addr,
+		Database:              "mytable",
+		Table:                 "mytable",
+		Alter:                 "ENGINE=InnoDB",
+		ConcurrencyLeaseTable: "spirit.leases",
+		ConcurrencyLeaseSlots: -1,
+	})
+	assert.ErrorContains(t, err, "concurrency-lease-slots must be greater than zero")
+	_, err = NewRunner(&Migration{
+		Host:               cfg.Addr,
+		Database:           "mytable",
+		Table:              "mytable",
+		Alter:              "ENGINE=InnoDB",
+		ConsistentSnapshot: true,
+		MaxChangesetDepth:  1000,
+	})
+	assert.ErrorContains(t, err, "consistent-snapshot can not be combined with max-changeset-depth")
+	_, err = NewRunner(&Migration{
+		Host:         cfg.Addr,
+		Database:     "mytable",
+		Table:        "mytable",
+		Alter:        "ENGINE=InnoDB",
+		Checksum:     true,
+		ChecksumMode: "sample",
+	})
+	assert.ErrorContains(t, err, `unknown checksum mode "sample"`)
+	_, err = NewRunner(&Migration{
+		Host:         cfg.Addr,
+		Database:     "mytable",
+		Table:        "mytable",
+		Alter:        "ENGINE=InnoDB",
+		Checksum:     false,
+		ChecksumMode: "row-count",
+	})
+	assert.ErrorContains(t, err, `checksum mode "row-count" can not be used when the checksum is disabled`)
 }
 
 func TestBadAlter(t *testing.T) {
@@ -678,6 +877,19 @@ func TestChangeNonIntPK(t *testing.T) {
 	assert.NoError(t, m.Close())
 }
 
+// engagedThrottler is always engaged, but does not block.
+type engagedThrottler struct {
+	throttler.Noop
+}
+
+func (t *engagedThrottler) IsThrottled() bool {
+	return true
+}
+
+func (t *engagedThrottler) State() string {
+	return "engaged: replica lag 12s (max 10s)"
+}
+
 type testLogger struct {
 	sync.Mutex
 	logrus.FieldLogger
@@ -758,7 +970,7 @@ func TestCheckpoint(t *testing.T) {
 	// So we proceed with the initial steps.
 	assert.NoError(t, r.createNewTable(context.TODO()))
 	assert.NoError(t, r.alterNewTable(context.TODO()))
-	assert.NoError(t, r.createCheckpointTable(context.TODO()))
+	assert.NoError(t, r.createCheckpoint(context.TODO()))
 	r.replClient = repl.NewClient(r.db, r.migration.Host, r.table, r.newTable, r.migration.Username, r.migration.Password, &repl.ClientConfig{
 		Logger:          logrus.New(), // don't use the logger for migration since we feed status to it.
 		Concurrency:     4,
@@ -905,7 +1117,7 @@ func TestCheckpointRestore(t *testing.T) {
 	// So we proceed with the initial steps.
 	assert.NoError(t, r.createNewTable(context.TODO()))
 	assert.NoError(t, r.alterNewTable(context.TODO()))
-	assert.NoError(t, r.createCheckpointTable(context.TODO()))
+	assert.NoError(t, r.createCheckpoint(context.TODO()))
 
 	r.replClient = repl.NewClient(r.db, r.migration.Host, r.table, r.newTable, r.migration.Username, r.migration.Password, &repl.ClientConfig{
 		Logger:          logrus.New(),
@@ -925,8 +1137,8 @@ func TestCheckpointRestore(t *testing.T) {
 	(copier_watermark, checksum_watermark, binlog_name, binlog_pos, rows_copied, rows_copied_logical, alter_statement)
 	VALUES
 	(%?, %?, %?, %?, %?, %?, %?)`,
-		r.checkpointTable.SchemaName,
-		r.checkpointTable.TableName,
+		r.table.SchemaName,
+		r.tableNamer().CheckpointName(r.table.TableName),
 		watermark,
 		"",
 		binlog.Name,
@@ -1077,7 +1289,7 @@ func TestCheckpointDifferentRestoreOptions(t *testing.T) {
 	// So we proceed with the initial steps.
 	assert.NoError(t, m.createNewTable(context.TODO()))
 	assert.NoError(t, m.alterNewTable(context.TODO()))
-	assert.NoError(t, m.createCheckpointTable(context.TODO()))
+	assert.NoError(t, m.createCheckpoint(context.TODO()))
 	logger := logrus.New()
 	m.replClient = repl.NewClient(m.db, m.migration.Host, m.table, m.newTable, m.migration.Username, m.migration.Password, &repl.ClientConfig{
 		Logger:          logger,
@@ -1273,7 +1485,7 @@ func TestE2EBinlogSubscribingCompositeKey(t *testing.T) {
 	// So we proceed with the initial steps.
 	assert.NoError(t, m.createNewTable(context.TODO()))
 	assert.NoError(t, m.alterNewTable(context.TODO()))
-	assert.NoError(t, m.createCheckpointTable(context.TODO()))
+	assert.NoError(t, m.createCheckpoint(context.TODO()))
 	logger := logrus.New()
 	m.replClient = repl.NewClient(m.db, m.migration.Host, m.table, m.newTable, m.migration.Username, m.migration.Password, &repl.ClientConfig{
 		Logger:          logger,
@@ -1283,7 +1495,7 @@ func TestE2EBinlogSubscribingCompositeKey(t *testing.T) {
 	m.copier, err = row.NewCopier(m.db, m.table, m.newTable, &row.CopierConfig{
 		Concurrency:     m.migration.Threads,
 		TargetChunkTime: m.migration.TargetChunkTime,
-		FinalChecksum:   m.migration.Checksum,
+		ChecksumMode:    m.checksumMode(),
 		Throttler:       &throttler.Noop{},
 		Logger:          m.logger,
 		MetricsSink:     &metrics.NoopSink{},
@@ -1328,6 +1540,11 @@ func TestE2EBinlogSubscribingCompositeKey(t *testing.T) {
 	assert.NoError(t, m.copier.CopyChunk(context.TODO(), chunk))
 	assert.Equal(t, Progress{CurrentState: stateCopyRows.String(), Summary: "1201/1200 100.08% copyRows ETA DUE"}, m.GetProgress())
 
+	// The reason is included when the throttler is engaged.
+	m.copier.SetThrottler(&engagedThrottler{})
+	assert.Equal(t, Progress{CurrentState: stateCopyRows.String(), Summary: "1201/1200 100.08% copyRows ETA DUE, throttler engaged: replica lag 12s (max 10s)"}, m.GetProgress())
+	m.copier.SetThrottler(&throttler.Noop{})
+
 	// Now insert some data.
 	// This should be picked up by the binlog subscription
 	// because it is within chunk size range of the second chunk.
@@ -1402,7 +1619,7 @@ func TestE2EBinlogSubscribingNonCompositeKey(t *testing.T) {
 	// So we proceed with the initial steps.
 	assert.NoError(t, m.createNewTable(context.TODO()))
 	assert.NoError(t, m.alterNewTable(context.TODO()))
-	assert.NoError(t, m.createCheckpointTable(context.TODO()))
+	assert.NoError(t, m.createCheckpoint(context.TODO()))
 	logger := logrus.New()
 	m.replClient = repl.NewClient(m.db, m.migration.Host, m.table, m.newTable, m.migration.Username, m.migration.Password, &repl.ClientConfig{
 		Logger:          logger,
@@ -1412,7 +1629,7 @@ func TestE2EBinlogSubscribingNonCompositeKey(t *testing.T) {
 	m.copier, err = row.NewCopier(m.db, m.table, m.newTable, &row.CopierConfig{
 		Concurrency:     m.migration.Threads,
 		TargetChunkTime: m.migration.TargetChunkTime,
-		FinalChecksum:   m.migration.Checksum,
+		ChecksumMode:    m.checksumMode(),
 		Throttler:       &throttler.Noop{},
 		Logger:          m.logger,
 		MetricsSink:     &metrics.NoopSink{},
@@ -1498,6 +1715,72 @@ func TestE2EBinlogSubscribingNonCompositeKey(t *testing.T) {
 	// All done!
 }
 
+func TestChecksumFailurePolicy(t *testing.T) {
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	tests := []struct {
+		policy   ChecksumFailurePolicy
+		err      string
+		repaired bool
+	}{
+		{ChecksumFailurePolicyRecopy, "", true},
+		{ChecksumFailurePolicyAbort, "checksum found differences between the table and the new table in 1 chunks", false},
+		{ChecksumFailurePolicyWarn, "", false},
+	}
+	for _, test := range tests {
+		testutils.RunSQL(t, `DROP TABLE IF EXISTS cspolicyt1, _cspolicyt1_new`)
+		testutils.RunSQL(t, `CREATE TABLE cspolicyt1 (id INT NOT NULL PRIMARY KEY, b INT NOT NULL)`)
+		testutils.RunSQL(t, `INSERT INTO cspolicyt1 VALUES (1, 1), (2, 2), (3, 3)`)
+
+		m, err := NewRunner(&Migration{
+			Host:                  cfg.Addr,
+			Username:              cfg.User,
+			Password:              cfg.Passwd,
+			Database:              cfg.DBName,
+			Threads:               1,
+			Table:                 "cspolicyt1",
+			Alter:                 "ENGINE=InnoDB",
+			ChecksumFailurePolicy: string(test.policy),
+		})
+		assert.NoError(t, err)
+		m.db, err = dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+		assert.NoError(t, err)
+		m.table = table.NewTableInfo(m.db, m.migration.Database, m.migration.Table)
+		assert.NoError(t, m.table.SetInfo(context.TODO()))
+		assert.NoError(t, m.createNewTable(context.TODO()))
+		assert.NoError(t, m.alterNewTable(context.TODO()))
+		m.replClient = repl.NewClient(m.db, m.migration.Host, m.table, m.newTable, m.migration.Username, m.migration.Password, &repl.ClientConfig{
+			Logger:          logrus.New(),
+			Concurrency:     1,
+			TargetBatchTime: m.migration.TargetChunkTime,
+		})
+		assert.NoError(t, m.replClient.Run())
+
+		// Copy the rows, but plant a difference.
+		testutils.RunSQL(t, `INSERT INTO _cspolicyt1_new SELECT * FROM cspolicyt1`)
+		testutils.RunSQL(t, `UPDATE _cspolicyt1_new SET b = 20 WHERE id = 2`)
+
+		m.setCurrentState(stateChecksum)
+		err = m.checksum(context.TODO())
+		if test.err != "" {
+			assert.ErrorIs(t, err, ErrChecksumDifferences)
+			assert.ErrorContains(t, err, test.err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, "postChecksum", m.getCurrentState().String())
+		}
+		var b int
+		assert.NoError(t, m.db.QueryRow(`SELECT b FROM _cspolicyt1_new WHERE id = 2`).Scan(&b))
+		if test.repaired {
+			assert.Equal(t, 2, b, test.policy)
+		} else {
+			assert.Equal(t, 20, b, test.policy)
+		}
+		m.replClient.Close()
+		assert.NoError(t, m.db.Close())
+	}
+}
+
 // TestForRemainingTableArtifacts tests that the table is left after
 // the migration is complete, but no _chkpnt or _new or _old table.
 func TestForRemainingTableArtifacts(t *testing.T) {
@@ -1565,6 +1848,59 @@ func TestDropColumn(t *testing.T) {
 	assert.NoError(t, m.Close())
 }
 
+func TestAddColumnToPrimaryKey(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS addpkcol, _addpkcol_new`)
+	table := `CREATE TABLE addpkcol (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		tenant int NOT NULL,
+		name varchar(255) NOT NULL,
+		PRIMARY KEY (id)
+	)`
+	testutils.RunSQL(t, table)
+	testutils.RunSQL(t, `insert into addpkcol (tenant, name) values (1, 'a'), (1, 'b'), (2, 'c')`)
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+
+	m, err := NewRunner(&Migration{
+		Host:     cfg.Addr,
+		Username: cfg.User,
+		Password: cfg.Passwd,
+		Database: cfg.DBName,
+		Threads:  4,
+		Table:    "addpkcol",
+		Alter:    "DROP PRIMARY KEY, ADD PRIMARY KEY (id, tenant)",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Run(context.Background()))
+	assert.False(t, m.usedInstantDDL)
+
+	// All rows are copied and the new key is in place.
+	var count int
+	var pk string
+	assert.NoError(t, m.db.QueryRow("SELECT COUNT(*) FROM addpkcol").Scan(&count))
+	assert.Equal(t, 3, count)
+	assert.NoError(t, m.db.QueryRow(`SELECT GROUP_CONCAT(column_name ORDER BY seq_in_index) FROM information_schema.statistics
+		WHERE table_schema=DATABASE() AND table_name='addpkcol' AND index_name='PRIMARY'`).Scan(&pk))
+	assert.Equal(t, "id,tenant", pk)
+	assert.NoError(t, m.Close())
+
+	// Replacing the primary key with one that
+	// does not contain the existing key is not supported.
+	m, err = NewRunner(&Migration{
+		Host:     cfg.Addr,
+		Username: cfg.User,
+		Password: cfg.Passwd,
+		Database: cfg.DBName,
+		Threads:  4,
+		Table:    "addpkcol",
+		Alter:    "DROP PRIMARY KEY, ADD PRIMARY KEY (tenant, name)",
+	})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, m.Run(context.Background()), "new primary key contains all columns of the existing primary key")
+	assert.NoError(t, m.Close())
+}
+
 func TestDefaultPort(t *testing.T) {
 	m, err := NewRunner(&Migration{
 		Host:     "localhost",
@@ -2112,7 +2448,7 @@ func TestE2ERogueValues(t *testing.T) {
 	// So we proceed with the initial steps.
 	assert.NoError(t, m.createNewTable(context.TODO()))
 	assert.NoError(t, m.alterNewTable(context.TODO()))
-	assert.NoError(t, m.createCheckpointTable(context.TODO()))
+	assert.NoError(t, m.createCheckpoint(context.TODO()))
 	logger := logrus.New()
 	m.replClient = repl.NewClient(m.db, m.migration.Host, m.table, m.newTable, m.migration.Username, m.migration.Password, &repl.ClientConfig{
 		Logger:          logger,
@@ -2122,7 +2458,7 @@ func TestE2ERogueValues(t *testing.T) {
 	m.copier, err = row.NewCopier(m.db, m.table, m.newTable, &row.CopierConfig{
 		Concurrency:     m.migration.Threads,
 		TargetChunkTime: m.migration.TargetChunkTime,
-		FinalChecksum:   m.migration.Checksum,
+		ChecksumMode:    m.checksumMode(),
 		Throttler:       &throttler.Noop{},
 		Logger:          m.logger,
 		MetricsSink:     &metrics.NoopSink{},
@@ -2148,7 +2484,7 @@ func TestE2ERogueValues(t *testing.T) {
 	chunk, err := m.copier.Next4Test()
 	assert.NoError(t, err)
 	assert.NotNil(t, chunk)
-	assert.Contains(t, chunk.String(), ` < "819 \". "`)
+	assert.Contains(t, chunk.String(), " < X'38313920222e20'")
 	assert.NoError(t, m.copier.CopyChunk(context.TODO(), chunk))
 
 	// Now insert some data, for binary type it will always say its
@@ -2159,7 +2495,7 @@ func TestE2ERogueValues(t *testing.T) {
 	// Second chunk
 	chunk, err = m.copier.Next4Test()
 	assert.NoError(t, err)
-	assert.Equal(t, "((`datetime` > \"819 \\\". \")\n OR (`datetime` = \"819 \\\". \" AND `col2` >= 1))", chunk.String())
+	assert.Equal(t, "((`datetime` > X'38313920222e20')\n OR (`datetime` = X'38313920222e20' AND `col2` >= 1))", chunk.String())
 	assert.NoError(t, m.copier.CopyChunk(context.TODO(), chunk))
 
 	// Now insert some data.
@@ -2276,7 +2612,7 @@ func TestResumeFromCheckpointPhantom(t *testing.T) {
 	assert.NoError(t, m.table.SetInfo(ctx))
 	assert.NoError(t, m.createNewTable(ctx))
 	assert.NoError(t, m.alterNewTable(ctx))
-	assert.NoError(t, m.createCheckpointTable(ctx))
+	assert.NoError(t, m.createCheckpoint(ctx))
 	logger := logrus.New()
 	m.replClient = repl.NewClient(m.db, m.migration.Host, m.table, m.newTable, m.migration.Username, m.migration.Password, &repl.ClientConfig{
 		Logger:          logger,
@@ -2286,7 +2622,7 @@ func TestResumeFromCheckpointPhantom(t *testing.T) {
 	m.copier, err = row.NewCopier(m.db, m.table, m.newTable, &row.CopierConfig{
 		Concurrency:     m.migration.Threads,
 		TargetChunkTime: m.migration.TargetChunkTime,
-		FinalChecksum:   m.migration.Checksum,
+		ChecksumMode:    m.checksumMode(),
 		Throttler:       &throttler.Noop{},
 		Logger:          m.logger,
 		MetricsSink:     &metrics.NoopSink{},
@@ -3086,3 +3422,176 @@ func TestStatementWorkflowStillInstant(t *testing.T) {
 	assert.True(t, m.usedInstantDDL) // expected to count as instant.
 	assert.NoError(t, m.Close())
 }
+
+func TestIsSmallTable(t *testing.T) {
+	r := &Runner{migration: &Migration{}, table: table.NewTableInfo(nil, "test", "t1")}
+	r.table.EstimatedRows = 100
+	assert.False(t, r.isSmallTable()) // disabled by default.
+	r.migration.SmallTableMaxRows = 99
+	assert.False(t, r.isSmallTable())
+	r.migration.SmallTableMaxRows = 100
+	assert.True(t, r.isSmallTable())
+}
+
+func TestChecksumMode(t *testing.T) {
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	for _, tc := range []struct {
+		checksum bool
+		mode     string
+		expected row.ChecksumMode
+	}{
+		{true, "", row.ChecksumModeFull},
+		{false, "", row.ChecksumModeNone},
+		{true, "full", row.ChecksumModeFull},
+		{true, "row-count", row.ChecksumModeRowCount},
+		{true, "none", row.ChecksumModeNone},
+	} {
+		r, err := NewRunner(&Migration{
+			Host:         cfg.Addr,
+			Database:     "test",
+			Table:        "t1",
+			Alter:        "ENGINE=InnoDB",
+			Checksum:     tc.checksum,
+			ChecksumMode: tc.mode,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, r.checksumMode(), "checksum=%v mode=%q", tc.checksum, tc.mode)
+		assert.Equal(t, tc.expected != row.ChecksumModeNone, r.migration.Checksum)
+	}
+
+	// A checksum that is forced, i.e. when resuming from
+	// a checkpoint, is the full checksum.
+	r, err := NewRunner(&Migration{
+		Host:         cfg.Addr,
+		Database:     "test",
+		Table:        "t1",
+		Alter:        "ENGINE=InnoDB",
+		ChecksumMode: "none",
+	})
+	assert.NoError(t, err)
+	r.migration.Checksum = true
+	assert.Equal(t, row.ChecksumModeFull, r.checksumMode())
+}
+
+func TestChecksumModeRowCount(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS rowcountt1, _rowcountt1_new, _rowcountt1_old, _rowcountt1_chkpnt`)
+	testutils.RunSQL(t, `CREATE TABLE rowcountt1 (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, b INT NOT NULL)`)
+	testutils.RunSQL(t, `INSERT INTO rowcountt1 (b) VALUES (1), (2), (2), (3)`)
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+
+	// The rows discarded by a new UNIQUE index are found by the row count.
+	m, err := NewRunner(&Migration{
+		Host:                  cfg.Addr,
+		Username:              cfg.User,
+		Password:              cfg.Passwd,
+		Database:              cfg.DBName,
+		Threads:               1,
+		Checksum:              true,
+		ChecksumMode:          "row-count",
+		ChecksumFailurePolicy: "abort",
+		Table:                 "rowcountt1",
+		Alter:                 "ADD UNIQUE (b)",
+	})
+	assert.NoError(t, err)
+	err = m.Run(context.Background())
+	assert.ErrorIs(t, err, ErrChecksumDifferences)
+	assert.NoError(t, m.Close())
+
+	// Without duplicates, the row count passes.
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS _rowcountt1_new, _rowcountt1_chkpnt`)
+	testutils.RunSQL(t, `DELETE FROM rowcountt1 WHERE id = 3`)
+	m, err = NewRunner(&Migration{
+		Host:         cfg.Addr,
+		Username:     cfg.User,
+		Password:     cfg.Passwd,
+		Database:     cfg.DBName,
+		Threads:      1,
+		Checksum:     true,
+		ChecksumMode: "row-count",
+		Table:        "rowcountt1",
+		Alter:        "ADD UNIQUE (b)",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Run(context.Background()))
+	assert.NotNil(t, m.checker)
+	assert.Equal(t, uint64(0), m.checker.DifferencesFound())
+	assert.NoError(t, m.Close())
+}
+
+func TestChecksumModeNone(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS nochecksumt1, _nochecksumt1_new, _nochecksumt1_old, _nochecksumt1_chkpnt`)
+	testutils.RunSQL(t, `CREATE TABLE nochecksumt1 (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, b INT NOT NULL)`)
+	testutils.RunSQL(t, `INSERT INTO nochecksumt1 (b) VALUES (1), (2), (3)`)
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	m, err := NewRunner(&Migration{
+		Host:         cfg.Addr,
+		Username:     cfg.User,
+		Password:     cfg.Passwd,
+		Database:     cfg.DBName,
+		Threads:      1,
+		Checksum:     true,
+		ChecksumMode: "none",
+		Table:        "nochecksumt1",
+		Alter:        "ENGINE=InnoDB",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Run(context.Background()))
+	assert.Nil(t, m.checker) // the checksum did not run.
+	assert.NoError(t, m.Close())
+}
+
+func TestSmallHotTable(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS smallhott1, _smallhott1_new, _smallhott1_old, _smallhott1_chkpnt`)
+	testutils.RunSQL(t, `CREATE TABLE smallhott1 (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, b INT NOT NULL)`)
+	testutils.RunSQL(t, `INSERT INTO smallhott1 (b) SELECT 1 FROM dual`)
+	testutils.RunSQL(t, `INSERT INTO smallhott1 (b) SELECT 1 FROM smallhott1 a, smallhott1 b, smallhott1 c LIMIT 1000`)
+	testutils.RunSQL(t, `INSERT INTO smallhott1 (b) SELECT 1 FROM smallhott1 a, smallhott1 b LIMIT 1000`)
+	testutils.RunSQL(t, `ANALYZE TABLE smallhott1`)
+
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	m, err := NewRunner(&Migration{
+		Host:              cfg.Addr,
+		Username:          cfg.User,
+		Password:          cfg.Passwd,
+		Database:          cfg.DBName,
+		Threads:           4,
+		Checksum:          false, // force enabled for a small table.
+		Table:             "smallhott1",
+		Alter:             "ENGINE=InnoDB",
+		SmallTableMaxRows: 10000,
+	})
+	assert.NoError(t, err)
+
+	// Write to the table for the duration of the migration.
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			// The statements fail while the table is renamed, which is fine.
+			_, _ = db.ExecContext(ctx, `UPDATE smallhott1 SET b = b + 1 ORDER BY RAND() LIMIT 10`)
+			_, _ = db.ExecContext(ctx, `INSERT INTO smallhott1 (b) VALUES (1)`)
+			_, _ = db.ExecContext(ctx, `DELETE FROM smallhott1 ORDER BY RAND() LIMIT 1`)
+		}
+	}()
+
+	assert.NoError(t, m.Run(context.Background()))
+	cancel()
+	wg.Wait()
+	assert.True(t, m.isSmallTable())
+	assert.True(t, m.migration.Checksum)
+	// The chunks are: below the minimum key, the keys of the table, and above the maximum key.
+	assert.LessOrEqual(t, m.copier.CopyChunksCount, uint64(3))
+	assert.Equal(t, uint64(0), m.checker.DifferencesFound())
+	assert.NoError(t, m.Close())
+}
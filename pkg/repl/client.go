@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,6 +17,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/metrics"
 	"github.com/cashapp/spirit/pkg/table"
 	"github.com/cashapp/spirit/pkg/utils"
 	"github.com/go-mysql-org/go-mysql/canal"
@@ -44,6 +46,90 @@ const (
 	DefaultFlushInterval = 30 * time.Second
 	// DefaultTimeout is how long BlockWait is supposed to wait before returning errors.
 	DefaultTimeout = 10 * time.Second
+	// DefaultEventMetricsInterval is how frequently StartEventMetrics sends the event metrics.
+	DefaultEventMetricsInterval = 10 * time.Second
+	// flushPauseCheckInterval is how frequently a paused flush, or a rows
+	// event that is held back by MaxPausedChanges, checks if it can resume.
+	flushPauseCheckInterval = 100 * time.Millisecond
+	// errorsCapacity is the number of errors buffered in the Errors channel.
+	// Errors are dropped if it is full, since the channel might not be drained.
+	errorsCapacity = 100
+)
+
+var (
+	// readyFlushDelay is how long WaitUntilReady waits for an event before
+	// it rotates the binary log, so that an idle server sends one.
+	readyFlushDelay = time.Second
+	// readyTimeout is how long WaitUntilReady waits for an event in total.
+	readyTimeout = DefaultTimeout
+	// finalPositionTimeout is how long the final flush waits for gtid_executed.
+	finalPositionTimeout = time.Second
+)
+
+var (
+	// ErrBinlogDisabled is returned when the source does not have binary logging enabled.
+	ErrBinlogDisabled = errors.New("binary logging is not enabled on the source")
+	// ErrBinlogPurged is returned when the binary log file to resume from no longer exists.
+	// Callers can use errors.Is to detect it and start again from scratch.
+	ErrBinlogPurged = errors.New("binlog position is impossible, the source may have already purged it")
+	// ErrPositionImpossible is returned when the binary log position to resume from can not be verified.
+	ErrPositionImpossible = errors.New("binlog position is impossible, could not verify it exists on the source")
+	// ErrCanalFailed is returned by Err when the binary log subscription has stopped.
+	ErrCanalFailed = errors.New("canal has failed")
+	// ErrNotStreaming is returned by WaitUntilReady when it could not confirm
+	// that the binary log is being streamed.
+	ErrNotStreaming = errors.New("could not confirm that the binary log is being streamed")
+)
+
+// FlushFailurePolicy is what the client does when a statement that
+// applies a batch of changes to the new table fails, after retries.
+type FlushFailurePolicy string
+
+const (
+	// FlushFailurePolicyFail fails the flush. This is the default.
+	FlushFailurePolicyFail FlushFailurePolicy = "fail"
+	// FlushFailurePolicyRetry applies each key of the failed batch with its
+	// own statement, and fails the flush only if one of them fails.
+	FlushFailurePolicyRetry FlushFailurePolicy = "retry"
+	// FlushFailurePolicySkip applies each key of the failed batch with its
+	// own statement, and skips the keys that still fail. The skipped keys
+	// are reported, and can be retrieved with SkippedKeys. Keys are not
+	// skipped in FlushUnderTableLock, and the cutover fails if any were.
+	FlushFailurePolicySkip FlushFailurePolicy = "skip"
+)
+
+// UnknownActionPolicy is what the client does when a rows
+// event from the binary log has an action it does not know.
+type UnknownActionPolicy string
+
+const (
+	// UnknownActionPolicyFail reports an error, which fails
+	// the migration. This is the default.
+	UnknownActionPolicyFail UnknownActionPolicy = "fail"
+	// UnknownActionPolicySkip discards the rows of the event. The events are
+	// counted, and the count is sent with the event metrics. A change to the
+	// table may be missed, so the new table should be checksummed.
+	UnknownActionPolicySkip UnknownActionPolicy = "skip"
+)
+
+// ApplyOrder is the order in which a flush of the changeset applies the
+// keys that were deleted and the keys that were inserted or updated. Since
+// the changeset has one change per key, the deletes and the replaces are of
+// distinct rows, and the final state of the new table does not depend on
+// the order: a REPLACE that conflicts on a unique secondary index with a
+// row that is deleted in the same flush removes that row itself. The order
+// matters for the intermediate states, which are visible to triggers or to
+// checks that were added to the new table.
+type ApplyOrder string
+
+const (
+	// ApplyOrderDeletesFirst applies all of the deletes before any of
+	// the replaces, so a replaced row never conflicts with a row that is
+	// about to be deleted. This is the default.
+	ApplyOrderDeletesFirst ApplyOrder = "deletes-first"
+	// ApplyOrderReplacesFirst applies all of the replaces before
+	// any of the deletes.
+	ApplyOrderReplacesFirst ApplyOrder = "replaces-first"
 )
 
 type queuedChange struct {
@@ -52,15 +138,27 @@ type queuedChange struct {
 }
 
 type statement struct {
-	numKeys int
-	stmt    string
+	numKeys  int
+	before   string // executed in the same transaction, before stmt
+	stmt     string
+	keys     []string // used to apply the keys individually if stmt fails
+	isDelete bool
+}
+
+// statements returns the non-empty statements
+// that need to be executed in a transaction.
+func (s statement) statements() []string {
+	if s.before != "" {
+		return []string{s.before, s.stmt}
+	}
+	return []string{s.stmt}
 }
 
 func extractStmt(stmts []statement) []string {
 	var trimmed []string
 	for _, stmt := range stmts {
 		if stmt.stmt != "" {
-			trimmed = append(trimmed, stmt.stmt)
+			trimmed = append(trimmed, stmt.statements()...)
 		}
 	}
 	return trimmed
@@ -76,6 +174,7 @@ type Client struct {
 	binlogChangeset      map[string]bool // bool is deleted
 	binlogChangesetDelta int64           // a special "fix" for keys that have been popped off, use atomic get/set
 	binlogPosSynced      mysql.Position  // safely written to new table
+	finalPosition        *FinalPosition  // applied by the last flush under the table lock
 
 	queuedChanges []queuedChange // used when disableDeltaMap is true
 
@@ -84,14 +183,50 @@ type Client struct {
 	changesetRowsCount      int64
 	changesetRowsEventCount int64 // eliminated by optimizations
 
-	db *sql.DB // connection to run queries like SHOW MASTER STATUS
+	// Statistics of completed flushes, protected by flushLock.
+	flushCount         uint64
+	flushTime          time.Duration
+	lastFlushRows      int64
+	lastFlushTime      time.Duration
+	lastFlushUnderLock bool
+
+	db        *sql.DB // connection to apply changes to the new table
+	controlDB *sql.DB // connection to run queries like SHOW MASTER STATUS
 
 	// Infoschema version of table.
 	table    *table.TableInfo
 	newTable *table.TableInfo
+	columns  string // the columns in both tables, see utils.IntersectNonGeneratedColumns
 
 	enableKeyAboveWatermark bool
 	disableDeltaMap         bool // use queue instead
+	compactKeys             bool // use utils.PackKey instead of utils.HashKey
+	primaryKeyChanged       bool // the new table has a different PRIMARY KEY
+	forcePrimaryIndex       bool // add FORCE INDEX (PRIMARY) to REPLACE statements
+	eventCacheCount         int  // capacity of canal's event buffer, zero for the default
+	connLimiter             *dbconn.ConnLimiter
+	trackActions            []string // canal actions added to the changeset, nil for all
+	queryComment            string
+	flushFailurePolicy      FlushFailurePolicy
+	applyOrder              ApplyOrder
+	unknownActionPolicy     UnknownActionPolicy
+	unknownActionsCount     int64           // events skipped under UnknownActionPolicySkip
+	unknownActionsSent      int64           // unknownActionsCount when the event metrics were last sent
+	keyRange                *table.KeyRange // changes to keys outside of it are discarded, nil for none
+	changeSink              ChangeSink      // receives the changes of each flush, nil for none
+	publishOnly             bool            // only publish changes to changeSink, do not apply them
+	maxPausedChanges        int             // rows events block while flush is paused with this many changes, zero for no limit
+	maxFlushBatchBytes      uint64          // estimated bytes of rows in each flush transaction, zero for no limit
+	metricsSink             metrics.Sink
+
+	// Durations of the batches applied since the event metrics were sent,
+	// in milliseconds. It is nil if there is no MetricsSink.
+	flushBatchTimesLock sync.Mutex
+	flushBatchTimes     *metrics.Histogram
+
+	// Keys that could not be applied, under FlushFailurePolicySkip.
+	skippedKeysLock sync.Mutex
+	skippedKeys     []string
 
 	TableChangeNotificationCallback func()
 	KeyAboveCopierCallback          func(interface{}) bool
@@ -112,15 +247,58 @@ type Client struct {
 	periodicFlushLock    sync.Mutex
 	periodicFlushEnabled bool
 
+	// flushPaused stops changes from being applied, see PauseFlush.
+	flushPaused atomic.Bool
+
+	// The flush lock serializes flushes. Each flush applies the changes that were
+	// read up to when it started, so flushes must complete in the order they started.
+	// Otherwise a DELETE from an earlier flush could be applied after a REPLACE
+	// of the same key from a later one, and the applied position could go backwards.
+	flushLock sync.Mutex
+
+	// errs receives non-fatal errors from the binary log subscription.
+	// It has its own lock since canal may log errors while Close holds
+	// the client lock.
+	errsLock   sync.Mutex
+	errs       chan error
+	errsClosed bool
+
+	// failure is the error that stopped the binary log subscription.
+	// failed is closed when it is set.
+	failureLock sync.Mutex
+	failure     error
+	failed      chan struct{}
+
+	// ready is closed once the binary log is being streamed.
+	readyOnce        sync.Once
+	ready            chan struct{}
+	readyGracePeriod time.Duration
+
 	logger loggers.Advanced
 }
 
+// NewClient returns a client that streams the binary log from host as
+// username, and applies the changes to newTable with db. The binary log
+// user may differ from the user of db, and only needs REPLICATION SLAVE
+// and REPLICATION CLIENT. The user of db needs SELECT on table, and INSERT
+// and DELETE on newTable. Reading the binary log position also requires
+// REPLICATION CLIENT, which is needed by the user of ControlDB if it is set.
 func NewClient(db *sql.DB, host string, table, newTable *table.TableInfo, username, password string, config *ClientConfig) *Client {
+	controlDB := config.ControlDB
+	if controlDB == nil {
+		controlDB = db
+	}
+	var flushBatchTimes *metrics.Histogram
+	if config.MetricsSink != nil {
+		flushBatchTimes = metrics.NewHistogram(metrics.DefaultLatencyBuckets)
+	}
 	return &Client{
 		db:              db,
+		controlDB:       controlDB,
 		host:            host,
 		table:           table,
 		newTable:        newTable,
+		columns:         utils.IntersectNonGeneratedColumns(table, newTable),
 		username:        username,
 		password:        password,
 		binlogChangeset: make(map[string]bool),
@@ -128,6 +306,30 @@ func NewClient(db *sql.DB, host string, table, newTable *table.TableInfo, userna
 		targetBatchTime: config.TargetBatchTime,
 		targetBatchSize: DefaultBatchSize, // initial starting value.
 		concurrency:     config.Concurrency,
+		compactKeys:     config.CompactKeys,
+		// The new table's PRIMARY KEY may contain additional columns
+		// (this is validated by the primarykey check). We still identify rows
+		// by the original PRIMARY KEY columns, which remain unique.
+		primaryKeyChanged:   !slices.Equal(table.KeyColumns, newTable.KeyColumns),
+		forcePrimaryIndex:   !config.DisableForcePrimaryIndex,
+		eventCacheCount:     config.EventCacheCount,
+		connLimiter:         config.ConnLimiter,
+		trackActions:        config.TrackActions,
+		queryComment:        config.QueryComment,
+		flushFailurePolicy:  config.FlushFailurePolicy,
+		unknownActionPolicy: config.UnknownActionPolicy,
+		keyRange:            config.KeyRange,
+		changeSink:          config.ChangeSink,
+		publishOnly:         config.PublishOnly,
+		maxPausedChanges:    config.MaxPausedChanges,
+		maxFlushBatchBytes:  config.MaxFlushBatchBytes,
+		applyOrder:          config.ApplyOrder,
+		metricsSink:         config.MetricsSink,
+		flushBatchTimes:     flushBatchTimes,
+		errs:                make(chan error, errorsCapacity),
+		failed:              make(chan struct{}),
+		ready:               make(chan struct{}),
+		readyGracePeriod:    config.ReadyGracePeriod,
 	}
 }
 
@@ -135,6 +337,83 @@ type ClientConfig struct {
 	TargetBatchTime time.Duration
 	Concurrency     int
 	Logger          loggers.Advanced
+	// CompactKeys stores keys in the changeset in a binary packed
+	// format. This reduces memory for tables with wide composite
+	// primary keys and a high rate of change.
+	CompactKeys bool
+	// DisableForcePrimaryIndex removes FORCE INDEX (PRIMARY) when
+	// reading changed rows from the source table. The hint is added
+	// by default.
+	DisableForcePrimaryIndex bool
+	// EventCacheCount is the number of binary log events that are buffered
+	// between reading them from the source and adding their keys to the
+	// changeset. A larger buffer absorbs bursts on high-throughput sources,
+	// at the cost of memory for tables with large rows. Zero uses the
+	// default of the binary log reader (10240).
+	EventCacheCount int
+	// ConnLimiter bounds the flush statements that run concurrently together
+	// with other components that share it. Nil does not limit them.
+	ConnLimiter *dbconn.ConnLimiter
+	// TrackActions are the canal actions (canal.InsertAction, canal.UpdateAction
+	// and canal.DeleteAction) whose rows are added to the changeset. Rows of
+	// other actions are discarded. This is only safe if the discarded actions
+	// do not occur on the table, or their changes are not wanted in the new
+	// table, i.e. for an append-only table. Nil tracks all actions.
+	TrackActions []string
+	// QueryComment is prepended to each statement that applies changes as a
+	// comment, so it can be identified by DBAs. The placeholder {table} is
+	// replaced by the table, and {chunk} by "flush". Empty adds none.
+	QueryComment string
+	// ReadyGracePeriod is an additional delay for WaitUntilReady after the
+	// binary log is being streamed, to let the subscription settle before
+	// the copy starts. Zero does not delay.
+	ReadyGracePeriod time.Duration
+	// FlushFailurePolicy is what to do when a statement that applies a batch
+	// of changes fails, i.e. because one of the rows violates a constraint of
+	// the new table. Empty is FlushFailurePolicyFail.
+	FlushFailurePolicy FlushFailurePolicy
+	// MetricsSink receives the number of row events read from the binary
+	// log and their rate, and the duration of each batch of changes that is
+	// applied, while StartEventMetrics is running. Nil does not send them.
+	MetricsSink metrics.Sink
+	// ControlDB runs the queries that read the state of the binary log,
+	// such as SHOW MASTER STATUS, so they do not queue behind the statements
+	// that apply changes when the pool is busy flushing. A small pool is
+	// sufficient. Nil runs them on the same pool that applies changes.
+	ControlDB *sql.DB
+	// UnknownActionPolicy is what to do with a rows event whose action is
+	// not an insert, update or delete. Empty is UnknownActionPolicyFail.
+	UnknownActionPolicy UnknownActionPolicy
+	// KeyRange discards the changes to rows outside of the range, for a copy
+	// that is restricted to it (see row.CopierConfig.StartKey). Nil applies
+	// the changes to all rows.
+	KeyRange *table.KeyRange
+	// ChangeSink receives the changes of each flush before they are applied
+	// to the new table, i.e. to publish them to a message queue. Nil
+	// publishes none.
+	ChangeSink ChangeSink
+	// PublishOnly publishes the changes to ChangeSink instead of applying
+	// them to the new table, to use the client for change data capture.
+	PublishOnly bool
+	// MaxPausedChanges bounds the memory of the changeset while flushing is
+	// paused (see PauseFlush). Once the changeset has this many changes, the
+	// binary log is not read until flushing is resumed. Zero does not bound
+	// it.
+	MaxPausedChanges int
+	// MaxFlushBatchBytes bounds the size of each transaction that applies
+	// changes, estimated as the number of keys times the average row length
+	// of the table. Batches of wide rows are split into smaller statements,
+	// and the statements of a flush of the queue (which are otherwise applied
+	// in one transaction) are split into several transactions. Zero only
+	// bounds them by the batch size.
+	MaxFlushBatchBytes uint64
+	// ApplyOrder is the order in which a flush of the changeset applies
+	// deletes and replaces. The statements of each are applied concurrently,
+	// but all of the statements of the first kind complete before any of the
+	// second kind start. It does not apply to a flush of the queue, which
+	// applies the changes in the order of the binary log. Empty is
+	// ApplyOrderDeletesFirst.
+	ApplyOrder ApplyOrder
 }
 
 // NewClientDefaultConfig returns a default config for the copier.
@@ -155,6 +434,70 @@ func NewClientDefaultConfig() *ClientConfig {
 // We only need to add the PK + if the operation was a delete.
 // This will be used after copy rows to apply any changes that have been made.
 func (c *Client) OnRow(e *canal.RowsEvent) error {
+	var deleted bool
+	switch e.Action {
+	case canal.InsertAction, canal.UpdateAction:
+		deleted = false
+	case canal.DeleteAction:
+		deleted = true
+	default:
+		if c.unknownActionPolicy == UnknownActionPolicySkip {
+			atomic.AddInt64(&c.unknownActionsCount, 1)
+			c.logger.Warnf("skipping rows event with unknown action: %v", e.Action)
+			return nil
+		}
+		// The change can not be applied, so the new table would be
+		// missing it. Stop the subscription, which fails the migration.
+		err := fmt.Errorf("unknown action: %v", e.Action)
+		c.logger.Errorf("%v", err)
+		c.setFailure(err)
+		return err
+	}
+	if c.trackActions != nil && !slices.Contains(c.trackActions, e.Action) {
+		return nil // the action is filtered out
+	}
+	keys, err := c.rowsEventKeys(e)
+	if err != nil {
+		return err
+	}
+	c.waitWhileChangesetFull()
+	atomic.AddInt64(&c.changesetRowsEventCount, int64(len(keys)))
+	// The KeyAboveWatermark optimization has to be enabled
+	// We enable it once all the setup has been done (since we create a repl client
+	// earlier in setup to ensure binary logs are available).
+	// We then disable the optimization after the copier phase has finished.
+	keyAboveWatermarkEnabled := c.KeyAboveWatermarkEnabled()
+	changed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if c.ignoreKey(key, keyAboveWatermarkEnabled) {
+			continue
+		}
+		changed = append(changed, c.hashKey(key))
+	}
+	// The keys for the whole event are added under a single lock,
+	// since an event can contain many rows.
+	c.keysHaveChanged(changed, deleted)
+	return nil
+}
+
+// ignoreKey returns true if a change to key does not need to be applied to
+// the new table: the key is outside of the KeyRange, so the row is never
+// copied, or the key is above the watermark of the copier, which copies
+// the current version of the row later.
+func (c *Client) ignoreKey(key []interface{}, keyAboveWatermarkEnabled bool) bool {
+	if c.keyRange != nil && !c.keyRange.Contains(key[0]) {
+		return true
+	}
+	if keyAboveWatermarkEnabled && c.KeyAboveCopierCallback(key[0]) {
+		c.logger.Debugf("key above watermark: %v", key[0])
+		return true
+	}
+	return false
+}
+
+// rowsEventKeys returns the PRIMARY KEY of each row modified by the event.
+func (c *Client) rowsEventKeys(e *canal.RowsEvent) ([][]interface{}, error) {
+	var keys [][]interface{}
 	var i = 0
 	for _, row := range e.Rows {
 		// For UpdateAction there is always a before and after image (i.e. e.Rows is always in pairs.)
@@ -168,31 +511,14 @@ func (c *Client) OnRow(e *canal.RowsEvent) error {
 		}
 		key, err := c.table.PrimaryKeyValues(row)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if len(key) == 0 {
-			return fmt.Errorf("no primary key found for row: %#v", row)
-		}
-		atomic.AddInt64(&c.changesetRowsEventCount, 1)
-
-		// The KeyAboveWatermark optimization has to be enabled
-		// We enable it once all the setup has been done (since we create a repl client
-		// earlier in setup to ensure binary logs are available).
-		// We then disable the optimization after the copier phase has finished.
-		if c.KeyAboveWatermarkEnabled() && c.KeyAboveCopierCallback(key[0]) {
-			c.logger.Debugf("key above watermark: %v", key[0])
-			continue // key can be ignored
-		}
-		switch e.Action {
-		case canal.InsertAction, canal.UpdateAction:
-			c.keyHasChanged(key, false)
-		case canal.DeleteAction:
-			c.keyHasChanged(key, true)
-		default:
-			c.logger.Errorf("unknown action: %v", e.Action)
+			return nil, fmt.Errorf("no primary key found for row: %#v", row)
 		}
+		keys = append(keys, key)
 	}
-	return nil
+	return keys, nil
 }
 
 // KeyAboveWatermarkEnabled returns true if the key above watermark optimization is enabled.
@@ -251,6 +577,73 @@ func (c *Client) GetBinlogApplyPosition() mysql.Position {
 	return c.binlogPosSynced
 }
 
+// ClientStatus is a point in time summary of the replication client.
+// It is designed for embedders to serialize, i.e. as JSON.
+type ClientStatus struct {
+	DeltaLen        int            `json:"delta_len"`
+	AppliedPosition mysql.Position `json:"applied_position"` // safely written to the new table
+	ReadPosition    mysql.Position `json:"read_position"`    // read from the source, but maybe not applied
+	Lag             time.Duration  `json:"lag"`              // delay between the source and the subscription
+	FlushPaused     bool           `json:"flush_paused"`
+	RowsApplied     int64          `json:"rows_applied"`
+	RowEvents       int64          `json:"row_events"`
+	BatchSize       int64          `json:"batch_size"`
+}
+
+// Status returns the current status of the replication client.
+func (c *Client) Status() ClientStatus {
+	status := ClientStatus{
+		DeltaLen:        c.GetDeltaLen(),
+		AppliedPosition: c.GetBinlogApplyPosition(),
+		RowsApplied:     atomic.LoadInt64(&c.changesetRowsCount),
+		RowEvents:       atomic.LoadInt64(&c.changesetRowsEventCount),
+		BatchSize:       atomic.LoadInt64(&c.targetBatchSize),
+		FlushPaused:     c.IsFlushPaused(),
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.canal != nil {
+		status.ReadPosition = c.canal.SyncedPosition()
+		status.Lag = time.Duration(c.canal.GetDelay()) * time.Second
+	}
+	return status
+}
+
+// ClientSummary is a report of the changes applied by the replication
+// client, i.e. for printing at the end of a migration.
+type ClientSummary struct {
+	RowsApplied        int64         `json:"rows_applied"`
+	RowEvents          int64         `json:"row_events"`
+	Flushes            uint64        `json:"flushes"`
+	FlushTime          time.Duration `json:"flush_time"` // the total time of all flushes
+	LastFlushRows      int64         `json:"last_flush_rows"`
+	LastFlushTime      time.Duration `json:"last_flush_time"`
+	LastFlushUnderLock bool          `json:"last_flush_under_lock"` // true if it was the final flush of the cutover
+}
+
+// String formats the summary as a single line.
+func (s ClientSummary) String() string {
+	return fmt.Sprintf("rows-applied=%d row-events=%d flushes=%d flush-time=%s last-flush-rows=%d last-flush-time=%s last-flush-under-lock=%v",
+		s.RowsApplied, s.RowEvents, s.Flushes, s.FlushTime.Round(time.Millisecond),
+		s.LastFlushRows, s.LastFlushTime.Round(time.Millisecond), s.LastFlushUnderLock)
+}
+
+// Summary returns a report of the changes applied by the replication client.
+// It waits for a flush in progress to complete.
+func (c *Client) Summary() ClientSummary {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+	return ClientSummary{
+		RowsApplied:        atomic.LoadInt64(&c.changesetRowsCount),
+		RowEvents:          atomic.LoadInt64(&c.changesetRowsEventCount),
+		Flushes:            c.flushCount,
+		FlushTime:          c.flushTime,
+		LastFlushRows:      c.lastFlushRows,
+		LastFlushTime:      c.lastFlushTime,
+		LastFlushUnderLock: c.lastFlushUnderLock,
+	}
+}
+
 func (c *Client) GetDeltaLen() int {
 	c.Lock()
 	defer c.Unlock()
@@ -263,14 +656,40 @@ func (c *Client) GetDeltaLen() int {
 
 // pksToRowValueConstructor constructs a statement like this:
 // DELETE FROM x WHERE (s_i_id,s_w_id) in ((7,10),(1,5));
+// A NULL column is rendered as NULL, which IN never matches.
+// Use keysCondition for keys that might contain a NULL.
 func (c *Client) pksToRowValueConstructor(d []string) string {
 	var pkValues []string
 	for _, v := range d {
-		pkValues = append(pkValues, utils.UnhashKey(v))
+		pkValues = append(pkValues, c.unhashKey(v))
 	}
 	return strings.Join(pkValues, ",")
 }
 
+// keysCondition returns a condition that matches the rows of keys.
+// Keys are matched with IN, except keys with a NULL column, which
+// are matched with the NULL-safe <=> instead:
+// (a,b) IN ((1,'x'),(2,'y')) OR (a,b) <=> (3,NULL)
+func (c *Client) keysCondition(keys []string) string {
+	columns := table.QuoteColumns(c.table.KeyColumns)
+	var inKeys, nullKeys []string
+	for _, key := range keys {
+		if slices.Contains(c.splitKey(key), utils.NullKeyValue) {
+			nullKeys = append(nullKeys, key)
+		} else {
+			inKeys = append(inKeys, key)
+		}
+	}
+	var conds []string
+	if len(inKeys) > 0 {
+		conds = append(conds, fmt.Sprintf("(%s) IN (%s)", columns, c.pksToRowValueConstructor(inKeys)))
+	}
+	for _, key := range nullKeys {
+		conds = append(conds, fmt.Sprintf("(%s) <=> %s", columns, c.unhashKey(key)))
+	}
+	return strings.Join(conds, " OR ")
+}
+
 func (c *Client) getCurrentBinlogPosition() (mysql.Position, error) {
 	var binlogFile, fake string
 	var binlogPos uint32
@@ -278,8 +697,12 @@ func (c *Client) getCurrentBinlogPosition() (mysql.Position, error) {
 	if c.isMySQL84 {
 		binlogPosStmt = "SHOW BINARY LOG STATUS"
 	}
-	err := c.db.QueryRow(binlogPosStmt).Scan(&binlogFile, &binlogPos, &fake, &fake, &fake) //nolint: execinquery
+	err := c.controlDB.QueryRow(binlogPosStmt).Scan(&binlogFile, &binlogPos, &fake, &fake, &fake) //nolint: execinquery
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// SHOW MASTER STATUS returns an empty set when binary logging is disabled.
+			return mysql.Position{}, ErrBinlogDisabled
+		}
 		return mysql.Position{}, err
 	}
 	return mysql.Position{
@@ -295,24 +718,10 @@ func (c *Client) Run() (err error) {
 	if err := c.table.PrimaryKeyIsMemoryComparable(); err != nil {
 		c.disableDeltaMap = true
 	}
-	cfg := canal.NewDefaultConfig()
-	cfg.Addr = c.host
-	cfg.User = c.username
-	cfg.Password = c.password
-	cfg.Logger = NewLogWrapper(c.logger) // wrapper to filter the noise.
-	cfg.IncludeTableRegex = []string{fmt.Sprintf("^%s\\.%s$", c.table.SchemaName, c.table.TableName)}
-	cfg.Dump.ExecutionPath = "" // skip dump
-	if dbconn.IsRDSHost(cfg.Addr) {
-		// create a new TLSConfig for RDS
-		// It needs to be a copy because sharing a global pointer
-		// is not thread safe when spirit is used as a library.
-		cfg.TLSConfig = dbconn.NewTLSConfig()
-		cfg.TLSConfig.ServerName = utils.StripPort(cfg.Addr)
-	}
-	if dbconn.IsMySQL84(c.db) { // handle MySQL 8.4
+	if dbconn.IsMySQL84(c.controlDB) { // handle MySQL 8.4
 		c.isMySQL84 = true
 	}
-	c.canal, err = canal.NewCanal(cfg)
+	c.canal, err = c.newCanal()
 	if err != nil {
 		return err
 	}
@@ -325,12 +734,12 @@ func (c *Client) Run() (err error) {
 	if c.binlogPosSynced.Name == "" {
 		c.binlogPosSynced, err = c.getCurrentBinlogPosition()
 		if err != nil {
-			return errors.New("failed to get binlog position, check binary is enabled")
+			return fmt.Errorf("failed to get binlog position, check binary is enabled: %w", err)
 		}
-	} else if c.binlogPositionIsImpossible() {
+	} else if err := c.binlogPositionIsImpossible(c.binlogPosSynced); err != nil {
 		// Canal needs to be called as a go routine, so before we do check that the binary log
 		// Position is not impossible so we can return a synchronous error.
-		return errors.New("binlog position is impossible, the source may have already purged it")
+		return err
 	}
 
 	// Call start canal as a go routine.
@@ -338,26 +747,61 @@ func (c *Client) Run() (err error) {
 	return nil
 }
 
-func (c *Client) binlogPositionIsImpossible() bool {
-	rows, err := c.db.Query("SHOW BINARY LOGS") //nolint: execinquery
+// newCanal returns a canal that subscribes to changes on the source table.
+// Binary log events are not read until it is started.
+func (c *Client) newCanal() (*canal.Canal, error) {
+	return canal.NewCanal(c.canalConfig())
+}
+
+// canalConfig returns the config of the canal that streams the binary
+// log, which connects with the credentials passed to NewClient.
+func (c *Client) canalConfig() *canal.Config {
+	cfg := canal.NewDefaultConfig()
+	cfg.Addr = c.host
+	cfg.User = c.username
+	cfg.Password = c.password
+	logWrapper := NewLogWrapper(c.logger) // wrapper to filter the noise.
+	logWrapper.onError = c.reportError
+	cfg.Logger = logWrapper
+	cfg.IncludeTableRegex = []string{fmt.Sprintf("^%s\\.%s$", c.table.SchemaName, c.table.TableName)}
+	cfg.Dump.ExecutionPath = "" // skip dump
+	if c.eventCacheCount > 0 {
+		cfg.EventCacheCount = c.eventCacheCount
+	}
+	if dbconn.IsRDSHost(cfg.Addr) {
+		// create a new TLSConfig for RDS
+		// It needs to be a copy because sharing a global pointer
+		// is not thread safe when spirit is used as a library.
+		cfg.TLSConfig = dbconn.NewTLSConfig()
+		cfg.TLSConfig.ServerName = utils.StripPort(cfg.Addr)
+	}
+	return cfg
+}
+
+// binlogPositionIsImpossible returns nil if the binlog position can be resumed from.
+// Otherwise it returns ErrBinlogPurged if the log file no longer exists,
+// or ErrPositionImpossible if it could not be determined.
+func (c *Client) binlogPositionIsImpossible(pos mysql.Position) error {
+	rows, err := c.controlDB.Query("SHOW BINARY LOGS") //nolint: execinquery
 	if err != nil {
-		return true // if we can't get the logs, its already impossible
+		// if we can't get the logs, its already impossible
+		return fmt.Errorf("%w: %v", ErrPositionImpossible, err)
 	}
 	defer rows.Close()
 
 	var logname, size, encrypted string
 	for rows.Next() {
 		if err := rows.Scan(&logname, &size, &encrypted); err != nil {
-			return true
+			return fmt.Errorf("%w: %v", ErrPositionImpossible, err)
 		}
-		if logname == c.binlogPosSynced.Name {
-			return false // We just need presence of the log file for success
+		if logname == pos.Name {
+			return nil // We just need presence of the log file for success
 		}
 	}
 	if rows.Err() != nil {
-		return true // can't determine.
+		return fmt.Errorf("%w: %v", ErrPositionImpossible, rows.Err()) // can't determine.
 	}
-	return true
+	return ErrBinlogPurged
 }
 
 // Called as a go routine.
@@ -378,17 +822,139 @@ func (c *Client) startCanal() {
 		}
 
 		c.logger.Errorf("canal has failed. error: %v, table: %s", err, c.table.TableName)
-		panic("canal has failed")
+		c.reportError(err)
+		c.setFailure(err)
 	}
 }
 
+// OnPosSynced is called by canal each time it has processed an event that
+// advances the position, i.e. a committed transaction or a rotate. The first
+// call confirms that the binary log is being streamed.
+func (c *Client) OnPosSynced(*replication.EventHeader, mysql.Position, mysql.GTIDSet, bool) error {
+	c.setReady()
+	return nil
+}
+
+// setReady records that the binary log is being streamed.
+func (c *Client) setReady() {
+	c.readyOnce.Do(func() {
+		c.logger.Debugf("binary log subscription is streaming. table: %s", c.table.TableName)
+		close(c.ready)
+	})
+}
+
+// WaitUntilReady blocks until the binary log is being streamed, and then for
+// the ReadyGracePeriod. Changes are read from the position the client was
+// started at, so none are missed while waiting, but the copy should not start
+// until the subscription is confirmed, since the copier relies on it to apply
+// changes to rows it has copied. Streaming is confirmed by the first event
+// that canal processes. If there is none after readyFlushDelay, the binary
+// log is rotated so that an idle server sends one. If streaming is still
+// not confirmed after readyTimeout it returns ErrNotStreaming. It returns
+// the error of the subscription if it fails first.
+func (c *Client) WaitUntilReady(ctx context.Context) error {
+	flushTimer := time.NewTimer(readyFlushDelay)
+	defer flushTimer.Stop()
+	timeout := time.NewTimer(readyTimeout)
+	defer timeout.Stop()
+	for ready := false; !ready; {
+		select {
+		case <-c.ready:
+			ready = true
+		case <-flushTimer.C:
+			// The user that runs the migration might not have RELOAD
+			// if the binary log is read by a separate replication user.
+			if _, err := c.controlDB.ExecContext(ctx, "FLUSH BINARY LOGS"); err != nil {
+				c.logger.Warnf("could not rotate the binary log to confirm the subscription: %v", err)
+			}
+		case <-timeout.C:
+			return fmt.Errorf("%w within %v", ErrNotStreaming, readyTimeout)
+		case <-c.failed:
+			return c.Err()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if c.readyGracePeriod <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(c.readyGracePeriod)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.failed:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setFailure records the error that stopped the binary log subscription.
+// Only the first failure is kept.
+func (c *Client) setFailure(err error) {
+	c.failureLock.Lock()
+	defer c.failureLock.Unlock()
+	if c.failure != nil {
+		return
+	}
+	c.failure = fmt.Errorf("%w: %w", ErrCanalFailed, err)
+	close(c.failed)
+}
+
+// Err returns the error that stopped the binary log subscription,
+// or nil if it has not stopped. Once set, changes are no longer read,
+// so the client can not be used to complete a migration.
+func (c *Client) Err() error {
+	c.failureLock.Lock()
+	defer c.failureLock.Unlock()
+	return c.failure
+}
+
+// Failed returns a channel that is closed when the binary log subscription
+// stops with an error. The error is returned by Err.
+func (c *Client) Failed() <-chan struct{} {
+	return c.failed
+}
+
 func (c *Client) Close() {
+	// Resume flushing, so a rows event that is held back
+	// by MaxPausedChanges does not prevent canal from closing.
+	c.flushPaused.Store(false)
 	c.Lock()
 	defer c.Unlock()
 	c.isClosed = true
 	if c.canal != nil {
 		c.canal.Close()
 	}
+	c.errsLock.Lock()
+	defer c.errsLock.Unlock()
+	if !c.errsClosed {
+		c.errsClosed = true
+		close(c.errs)
+	}
+}
+
+// Errors returns a channel of errors from the binary log subscription that did
+// not stop it, i.e. a dropped connection that canal reconnected from. It can be
+// drained for logging or metrics, and is closed by Close. Errors are dropped
+// if the channel is full.
+func (c *Client) Errors() <-chan error {
+	return c.errs
+}
+
+// reportError sends a non-fatal error to the Errors channel.
+func (c *Client) reportError(err error) {
+	c.errsLock.Lock()
+	defer c.errsLock.Unlock()
+	if c.errsClosed {
+		return
+	}
+	select {
+	case c.errs <- err:
+	default:
+		c.logger.Debugf("errors channel is full, dropping error: %v", err)
+	}
 }
 
 // FlushUnderTableLock is a final flush under an exclusive table lock using the connection
@@ -408,14 +974,82 @@ func (c *Client) FlushUnderTableLock(ctx context.Context, lock *dbconn.TableLock
 		return err
 	}
 	// Do a final flush
-	return c.flush(ctx, true, lock)
+	if err := c.flush(ctx, true, lock); err != nil {
+		return err
+	}
+	c.setFinalPosition(ctx)
+	return nil
+}
+
+// FinalPosition is where the changes to the table were applied up to when
+// the table was locked for the cutover. Since the table is locked, there are
+// no changes to it after this point, so a consumer of the binary log that
+// starts here, i.e. to capture the changes of the new table, misses none.
+type FinalPosition struct {
+	Position mysql.Position `json:"position"`
+	// GTIDSet is gtid_executed when the position was recorded. It can be
+	// ahead of Position, but not by changes to the table. It is empty if
+	// GTIDs are not enabled, or if it could not be read.
+	GTIDSet string `json:"gtid_set"`
+}
+
+// setFinalPosition records the position applied by the final flush under
+// the table lock. The position is only for auditing, so gtid_executed is
+// read on the control connection with a short timeout, so that it does not
+// extend how long the table is locked, and a failure is only logged.
+func (c *Client) setFinalPosition(ctx context.Context) {
+	pos := &FinalPosition{Position: c.GetBinlogApplyPosition()}
+	gtidCtx, cancel := context.WithTimeout(ctx, finalPositionTimeout)
+	defer cancel()
+	if err := c.controlDB.QueryRowContext(gtidCtx, "SELECT @@global.gtid_executed").Scan(&pos.GTIDSet); err != nil {
+		c.logger.Warnf("could not read gtid_executed for the final position: %v", err)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.finalPosition = pos
+}
+
+// FinalPosition returns the position of the last flush under the table lock,
+// which after a successful cutover is the position at which the tables were
+// swapped. It returns false if there has not been a flush under the lock.
+func (c *Client) FinalPosition() (FinalPosition, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.finalPosition == nil {
+		return FinalPosition{}, false
+	}
+	return *c.finalPosition, true
 }
 
 func (c *Client) flush(ctx context.Context, underLock bool, lock *dbconn.TableLock) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	// The final flush under the table lock is not paused,
+	// since the lock must not be held while waiting.
+	if !underLock && c.IsFlushPaused() {
+		c.logger.Debug("flush is paused, not applying changes")
+		return nil
+	}
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+	startTime := time.Now()
+	rowsBefore := atomic.LoadInt64(&c.changesetRowsCount)
+	var err error
 	if c.disableDeltaMap {
-		return c.flushQueue(ctx, underLock, lock)
+		err = c.flushQueue(ctx, underLock, lock)
+	} else {
+		err = c.flushMap(ctx, underLock, lock)
 	}
-	return c.flushMap(ctx, underLock, lock)
+	if err != nil {
+		return err
+	}
+	c.flushCount++
+	c.lastFlushTime = time.Since(startTime)
+	c.flushTime += c.lastFlushTime
+	c.lastFlushRows = atomic.LoadInt64(&c.changesetRowsCount) - rowsBefore
+	c.lastFlushUnderLock = underLock
+	return nil
 }
 
 // flushQueue flushes the FIFO queue that is used when the PRIMARY KEY
@@ -438,16 +1072,24 @@ func (c *Client) flushQueue(ctx context.Context, underLock bool, lock *dbconn.Ta
 		c.SetPos(posOfFlush)
 		return nil
 	}
+	if err := c.publishChanges(ctx, changesToFlush); err != nil {
+		return err
+	}
+	if c.publishOnly {
+		atomic.AddInt64(&c.changesetRowsCount, int64(len(changesToFlush)))
+		c.SetPos(posOfFlush)
+		return nil
+	}
 
 	// Otherwise, flush the changes.
 	var stmts []statement
 	var buffer []string
 	prevKey := changesToFlush[0] // for initialization
-	target := int(atomic.LoadInt64(&c.targetBatchSize))
+	target := c.batchSize()
 	for _, change := range changesToFlush {
 		// We are changing from DELETE to REPLACE
 		// or vice versa, *or* the buffer is getting very large.
-		if change.isDelete != prevKey.isDelete || len(buffer) > target {
+		if change.isDelete != prevKey.isDelete || len(buffer) >= target {
 			if prevKey.isDelete {
 				stmts = append(stmts, c.createDeleteStmt(buffer))
 			} else {
@@ -468,16 +1110,36 @@ func (c *Client) flushQueue(ctx context.Context, underLock bool, lock *dbconn.Ta
 		// Execute under lock means it is a final flush
 		// We need to use the lock connection to do this
 		// so there is no parallelism.
+		startTime := time.Now()
 		if err := lock.ExecUnderLock(ctx, extractStmt(stmts)...); err != nil {
-			return err
+			if err := c.execStatementsIndividually(ctx, stmts, err, true, lock.ExecUnderLock); err != nil {
+				return err
+			}
 		}
+		c.recordFlushBatchTime(time.Since(startTime))
 	} else {
 		// Execute the statements in a transaction.
 		// They still need to be single threaded.
-		if _, err := dbconn.RetryableTransaction(ctx, c.db, true, dbconn.NewDBConfig(), extractStmt(stmts)...); err != nil {
+		exec := func(ctx context.Context, stmts ...string) error {
+			if err := c.connLimiter.Acquire(ctx); err != nil {
+				return err
+			}
+			defer c.connLimiter.Release()
+			_, err := dbconn.RetryableTransaction(ctx, c.db, true, dbconn.NewDBConfig(), stmts...)
 			return err
 		}
+		for _, txnStmts := range c.splitTransactions(stmts) {
+			startTime := time.Now()
+			err := exec(ctx, extractStmt(txnStmts)...)
+			c.recordFlushBatchTime(time.Since(startTime))
+			if err != nil {
+				if err := c.execStatementsIndividually(ctx, txnStmts, err, false, exec); err != nil {
+					return err
+				}
+			}
+		}
 	}
+	atomic.AddInt64(&c.changesetRowsCount, int64(len(changesToFlush)))
 	c.SetPos(posOfFlush)
 	return nil
 }
@@ -501,56 +1163,40 @@ func (c *Client) flushMap(ctx context.Context, underLock bool, lock *dbconn.Tabl
 		atomic.StoreInt64(&c.binlogChangesetDelta, int64(0)) // reset the delta
 	}()
 
-	// We must now apply the changeset setToFlush to the new table.
-	var deleteKeys []string
-	var replaceKeys []string
-	var stmts []statement
-	var i int64
-	target := atomic.LoadInt64(&c.targetBatchSize)
-	for key, isDelete := range setToFlush {
-		i++
-		if isDelete {
-			deleteKeys = append(deleteKeys, key)
-		} else {
-			replaceKeys = append(replaceKeys, key)
-		}
-		if (i % target) == 0 {
-			stmts = append(stmts, c.createDeleteStmt(deleteKeys))
-			stmts = append(stmts, c.createReplaceStmt(replaceKeys))
-			deleteKeys = []string{}
-			replaceKeys = []string{}
-			atomic.AddInt64(&c.binlogChangesetDelta, -target)
+	if c.changeSink != nil {
+		if err := c.publishChanges(ctx, c.changesetToChanges(setToFlush)); err != nil {
+			return err
 		}
 	}
-	stmts = append(stmts, c.createDeleteStmt(deleteKeys))
-	stmts = append(stmts, c.createReplaceStmt(replaceKeys))
+	if c.publishOnly {
+		c.SetPos(posOfFlush)
+		return nil
+	}
+
+	// We must now apply the changeset setToFlush to the new table.
+	stmts := c.changesetToStatements(setToFlush)
 
 	if underLock {
 		// Execute under lock means it is a final flush
 		// We need to use the lock connection to do this
 		// so there is no parallelism.
+		startTime := time.Now()
 		if err := lock.ExecUnderLock(ctx, extractStmt(stmts)...); err != nil {
-			return err
+			if err := c.execStatementsIndividually(ctx, stmts, err, true, lock.ExecUnderLock); err != nil {
+				return err
+			}
 		}
+		c.recordFlushBatchTime(time.Since(startTime))
 	} else {
-		// Execute the statements in parallel
+		// Execute the statements of each phase in parallel.
 		// They should not conflict and order should not matter
 		// because they come from a consistent view of a map,
-		// which is distinct keys.
-		g, errGrpCtx := errgroup.WithContext(ctx)
-		g.SetLimit(c.concurrency)
-		for _, stmt := range stmts {
-			s := stmt
-			g.Go(func() error {
-				startTime := time.Now()
-				_, err := dbconn.RetryableTransaction(errGrpCtx, c.db, false, dbconn.NewDBConfig(), s.stmt)
-				c.feedback(s.numKeys, time.Since(startTime))
+		// which is distinct keys. The phases are executed in
+		// the ApplyOrder, so it holds for the intermediate states.
+		for _, phase := range statementPhases(stmts) {
+			if err := c.execStatementsConcurrently(ctx, phase); err != nil {
 				return err
-			})
-		}
-		// wait for all work to finish
-		if err := g.Wait(); err != nil {
-			return err
+			}
 		}
 	}
 	// Update the synced binlog position to the posOfFlush
@@ -559,37 +1205,254 @@ func (c *Client) flushMap(ctx context.Context, underLock bool, lock *dbconn.Tabl
 	return nil
 }
 
+// execStatementsConcurrently executes each statement in its own
+// transaction, up to the concurrency of the client at a time.
+func (c *Client) execStatementsConcurrently(ctx context.Context, stmts []statement) error {
+	g, errGrpCtx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+	for _, stmt := range stmts {
+		s := stmt
+		g.Go(func() error {
+			err := c.execStatement(errGrpCtx, s)
+			atomic.AddInt64(&c.binlogChangesetDelta, -int64(s.numKeys))
+			return err
+		})
+	}
+	// wait for all work to finish
+	return g.Wait()
+}
+
+// execStatement executes a statement in a transaction, within the limit
+// of concurrent queries. If it fails, the keys are applied individually
+// according to the FlushFailurePolicy.
+func (c *Client) execStatement(ctx context.Context, s statement) error {
+	if err := c.connLimiter.Acquire(ctx); err != nil {
+		return err
+	}
+	defer c.connLimiter.Release()
+	exec := func(ctx context.Context, stmts ...string) error {
+		_, err := dbconn.RetryableTransaction(ctx, c.db, false, dbconn.NewDBConfig(), stmts...)
+		return err
+	}
+	startTime := time.Now()
+	err := exec(ctx, s.statements()...)
+	c.feedback(s.numKeys, time.Since(startTime))
+	c.recordFlushBatchTime(time.Since(startTime))
+	if err != nil {
+		err = c.execKeysIndividually(ctx, s, err, false, exec)
+	}
+	return err
+}
+
+// changesetToStatements converts a changeset into batches of DELETE and REPLACE
+// statements, in the ApplyOrder. The keys are sorted in primary key order, so
+// that each statement accesses the B-tree in order rather than randomly. This
+// improves buffer pool locality on large flushes.
+func (c *Client) changesetToStatements(changeset map[string]bool) []statement {
+	var deleteKeys []string
+	var replaceKeys []string
+	for key, isDelete := range changeset {
+		if isDelete {
+			deleteKeys = append(deleteKeys, key)
+		} else {
+			replaceKeys = append(replaceKeys, key)
+		}
+	}
+	c.sortKeys(deleteKeys)
+	c.sortKeys(replaceKeys)
+
+	var deleteStmts, replaceStmts []statement
+	target := c.batchSize()
+	for batch := range slices.Chunk(deleteKeys, target) {
+		deleteStmts = append(deleteStmts, c.createDeleteStmt(batch))
+	}
+	for batch := range slices.Chunk(replaceKeys, target) {
+		replaceStmts = append(replaceStmts, c.createReplaceStmt(batch))
+	}
+	if c.applyOrder == ApplyOrderReplacesFirst {
+		return append(replaceStmts, deleteStmts...)
+	}
+	return append(deleteStmts, replaceStmts...)
+}
+
+// statementPhases splits the statements of a flush of the changeset into
+// runs of statements of the same kind, in order, i.e. the deletes and then
+// the replaces. The statements of a phase can be applied concurrently.
+func statementPhases(stmts []statement) [][]statement {
+	var phases [][]statement
+	for i, stmt := range stmts {
+		if i == 0 || stmt.isDelete != stmts[i-1].isDelete {
+			phases = append(phases, nil)
+		}
+		phases[len(phases)-1] = append(phases[len(phases)-1], stmt)
+	}
+	return phases
+}
+
+// batchSize returns the number of keys in each statement: the target batch
+// size, reduced so that a batch does not exceed MaxFlushBatchBytes.
+func (c *Client) batchSize() int {
+	target := int(atomic.LoadInt64(&c.targetBatchSize))
+	if c.maxFlushBatchBytes == 0 || c.table.AvgRowLength == 0 {
+		return target
+	}
+	return max(min(target, int(c.maxFlushBatchBytes/c.table.AvgRowLength)), 1)
+}
+
+// splitTransactions groups the statements of a flush of the queue into
+// transactions, in order. Unless MaxFlushBatchBytes is set, all of them are
+// in one transaction. Otherwise a transaction ends before the statement that
+// would make it exceed the limit, so each has at least one statement.
+func (c *Client) splitTransactions(stmts []statement) [][]statement {
+	if c.maxFlushBatchBytes == 0 || c.table.AvgRowLength == 0 {
+		return [][]statement{stmts}
+	}
+	var txns [][]statement
+	var txn []statement
+	var txnBytes uint64
+	for _, stmt := range stmts {
+		stmtBytes := uint64(stmt.numKeys) * c.table.AvgRowLength
+		if len(txn) > 0 && txnBytes+stmtBytes > c.maxFlushBatchBytes {
+			txns = append(txns, txn)
+			txn, txnBytes = nil, 0
+		}
+		txn = append(txn, stmt)
+		txnBytes += stmtBytes
+	}
+	return append(txns, txn)
+}
+
+// sortKeys sorts hashed keys in primary key order.
+// The comparison is type aware, i.e. 9 sorts before 10 on an INT column.
+func (c *Client) sortKeys(keys []string) {
+	type splitKey struct {
+		key    string
+		values []string
+	}
+	split := make([]splitKey, len(keys))
+	for i, key := range keys {
+		split[i] = splitKey{key: key, values: c.splitKey(key)}
+	}
+	slices.SortFunc(split, func(a, b splitKey) int {
+		return c.table.CompareKeyValues(a.values, b.values)
+	})
+	for i := range split {
+		keys[i] = split[i].key
+	}
+}
+
 func (c *Client) createDeleteStmt(deleteKeys []string) statement {
 	var deleteStmt string
 	if len(deleteKeys) > 0 {
-		deleteStmt = fmt.Sprintf("DELETE FROM %s WHERE (%s) IN (%s)",
+		deleteStmt = fmt.Sprintf("%sDELETE FROM %s WHERE %s",
+			utils.QueryComment(c.queryComment, c.table.QuotedName, "flush"),
 			c.newTable.QuotedName,
-			table.QuoteColumns(c.table.KeyColumns),
-			c.pksToRowValueConstructor(deleteKeys),
+			c.keysCondition(deleteKeys),
 		)
 	}
 	return statement{
-		numKeys: len(deleteKeys),
-		stmt:    deleteStmt,
+		numKeys:  len(deleteKeys),
+		stmt:     deleteStmt,
+		keys:     deleteKeys,
+		isDelete: true,
 	}
 }
 
 func (c *Client) createReplaceStmt(replaceKeys []string) statement {
-	var replaceStmt string
+	var replaceStmt, deleteStmt string
 	if len(replaceKeys) > 0 {
-		replaceStmt = fmt.Sprintf("REPLACE INTO %s (%s) SELECT %s FROM %s FORCE INDEX (PRIMARY) WHERE (%s) IN (%s)",
+		var indexHint string
+		if c.forcePrimaryIndex {
+			indexHint = " FORCE INDEX (PRIMARY)"
+		}
+		replaceStmt = fmt.Sprintf("%sREPLACE INTO %s (%s) SELECT %s FROM %s%s WHERE %s",
+			utils.QueryComment(c.queryComment, c.table.QuotedName, "flush"),
 			c.newTable.QuotedName,
-			utils.IntersectNonGeneratedColumns(c.table, c.newTable),
-			utils.IntersectNonGeneratedColumns(c.table, c.newTable),
+			c.columns,
+			c.columns,
 			c.table.QuotedName,
-			table.QuoteColumns(c.table.KeyColumns),
-			c.pksToRowValueConstructor(replaceKeys),
+			indexHint,
+			c.keysCondition(replaceKeys),
 		)
+		// If the PRIMARY KEY has changed, an UPDATE to one of the added
+		// key columns would REPLACE into a new row and leave the previous
+		// version behind. We delete the previous version first.
+		if c.primaryKeyChanged {
+			deleteStmt = c.createDeleteStmt(replaceKeys).stmt
+		}
 	}
 	return statement{
 		numKeys: len(replaceKeys),
+		before:  deleteStmt,
 		stmt:    replaceStmt,
+		keys:    replaceKeys,
+	}
+}
+
+// execStatementsIndividually is called when executing stmts together failed
+// with err. Unless the FlushFailurePolicy is to fail, it executes each of
+// them on its own, in order. The statements are idempotent, so it is safe
+// to execute the statements that had already succeeded again.
+func (c *Client) execStatementsIndividually(ctx context.Context, stmts []statement, err error, underLock bool, exec func(ctx context.Context, stmts ...string) error) error {
+	if c.flushFailurePolicy == "" || c.flushFailurePolicy == FlushFailurePolicyFail {
+		return err
+	}
+	for _, s := range stmts {
+		if s.stmt == "" {
+			continue
+		}
+		if err := exec(ctx, s.statements()...); err != nil {
+			if err := c.execKeysIndividually(ctx, s, err, underLock, exec); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
+
+// execKeysIndividually is called when executing s failed with err. Unless
+// the FlushFailurePolicy is to fail, it applies each of the keys of s with
+// its own statement, so that a key that can not be applied does not prevent
+// the others from being applied. Keys are never skipped underLock, since that
+// is the final flush before the cutover, so the new table must be complete.
+func (c *Client) execKeysIndividually(ctx context.Context, s statement, err error, underLock bool, exec func(ctx context.Context, stmts ...string) error) error {
+	if c.flushFailurePolicy == "" || c.flushFailurePolicy == FlushFailurePolicyFail {
+		return err
+	}
+	c.logger.Warnf("could not apply a batch of %d keys, applying them individually: %v", s.numKeys, err)
+	for _, key := range s.keys {
+		var single statement
+		if s.isDelete {
+			single = c.createDeleteStmt([]string{key})
+		} else {
+			single = c.createReplaceStmt([]string{key})
+		}
+		if err := exec(ctx, single.statements()...); err != nil {
+			if c.flushFailurePolicy != FlushFailurePolicySkip || underLock || ctx.Err() != nil {
+				return fmt.Errorf("could not apply key %s: %w", c.unhashKey(key), err)
+			}
+			c.skipKey(key, err)
+		}
+	}
+	return nil
+}
+
+// skipKey records and reports a key that could not be applied.
+func (c *Client) skipKey(key string, err error) {
+	c.skippedKeysLock.Lock()
+	c.skippedKeys = append(c.skippedKeys, c.unhashKey(key))
+	c.skippedKeysLock.Unlock()
+	c.logger.Errorf("skipping key %s, it could not be applied to the new table: %v", c.unhashKey(key), err)
+	c.reportError(fmt.Errorf("skipped key %s: %w", c.unhashKey(key), err))
+}
+
+// SkippedKeys returns the keys that were skipped because they could not be
+// applied to the new table, under FlushFailurePolicySkip. Each key is
+// formatted as a value that can be used in a query, i.e. '1' or ('1','abc').
+func (c *Client) SkippedKeys() []string {
+	c.skippedKeysLock.Lock()
+	defer c.skippedKeysLock.Unlock()
+	return slices.Clone(c.skippedKeys)
 }
 
 // feedback provides feedback on the apply time of changesets.
@@ -636,6 +1499,9 @@ func (c *Client) feedback(numberOfKeys int, d time.Duration) {
 func (c *Client) Flush(ctx context.Context) error {
 	c.logger.Info("starting to flush changeset")
 	for {
+		if err := c.waitWhileFlushPaused(ctx); err != nil {
+			return err
+		}
 		// Repeat in a loop until the changeset length is trivial
 		if err := c.flush(ctx, false, nil); err != nil {
 			return err
@@ -662,6 +1528,57 @@ func (c *Client) Flush(ctx context.Context) error {
 	return nil
 }
 
+// PauseFlush stops changes from being applied to the new table, i.e. during
+// maintenance of the server. The binary log is still read, and the changes
+// accumulate in the changeset (see ClientConfig.MaxPausedChanges). Periodic
+// flushes do nothing, and Flush waits until ResumeFlush is called. The final
+// flush under the table lock is not paused. The copier is paused separately.
+func (c *Client) PauseFlush() {
+	if !c.flushPaused.Swap(true) {
+		c.logger.Info("pausing flushing of the binary log changeset")
+	}
+}
+
+// ResumeFlush resumes applying changes after a call to PauseFlush. The
+// changes that accumulated are applied by the next flush, i.e. the next
+// periodic flush, or a Flush that was waiting.
+func (c *Client) ResumeFlush() {
+	if c.flushPaused.Swap(false) {
+		c.logger.Infof("resuming flushing of the binary log changeset: delta-len=%d", c.GetDeltaLen())
+	}
+}
+
+// IsFlushPaused returns true if PauseFlush has been called
+// and ResumeFlush has not been called since.
+func (c *Client) IsFlushPaused() bool {
+	return c.flushPaused.Load()
+}
+
+// waitWhileFlushPaused blocks until flushing is
+// resumed, or the context is cancelled.
+func (c *Client) waitWhileFlushPaused(ctx context.Context) error {
+	for c.IsFlushPaused() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flushPauseCheckInterval):
+		}
+	}
+	return nil
+}
+
+// waitWhileChangesetFull blocks a rows event while flushing is paused and
+// the changeset has reached MaxPausedChanges. The binary log is not read
+// while it waits, which bounds the memory used by the changeset.
+func (c *Client) waitWhileChangesetFull() {
+	if c.maxPausedChanges <= 0 {
+		return
+	}
+	for c.IsFlushPaused() && c.GetDeltaLen() >= c.maxPausedChanges {
+		time.Sleep(flushPauseCheckInterval)
+	}
+}
+
 // StopPeriodicFlush disables the periodic flush, also guaranteeing
 // when it returns there is no current flush running
 func (c *Client) StopPeriodicFlush() {
@@ -707,6 +1624,87 @@ func (c *Client) StartPeriodicFlush(ctx context.Context, interval time.Duration)
 	}
 }
 
+// StartEventMetrics sends the number of row events read from the binary log
+// since the last interval as a counter, and their rate per second and the
+// depth of the changeset as gauges, to the MetricsSink every interval. The
+// durations of the batches of changes applied since the last interval are
+// sent as the counters of a histogram, see metrics.Histogram.Values.
+// It returns when ctx is cancelled.
+func (c *Client) StartEventMetrics(ctx context.Context, interval time.Duration) {
+	if c.metricsSink == nil {
+		return
+	}
+	prevCount := atomic.LoadInt64(&c.changesetRowsEventCount)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			prevCount = c.sendEventMetrics(ctx, prevCount, interval)
+		}
+	}
+}
+
+// sendEventMetrics sends the row events since prevCount, and their rate over
+// interval. It returns the current count, to be used as the next prevCount.
+func (c *Client) sendEventMetrics(ctx context.Context, prevCount int64, interval time.Duration) int64 {
+	count := atomic.LoadInt64(&c.changesetRowsEventCount)
+	events := float64(count - prevCount)
+	unknownActions := atomic.LoadInt64(&c.unknownActionsCount)
+	prevUnknownActions := atomic.SwapInt64(&c.unknownActionsSent, unknownActions)
+	m := &metrics.Metrics{
+		Values: []metrics.MetricValue{
+			{
+				Name:  metrics.BinlogRowEventsCountMetricName,
+				Type:  metrics.COUNTER,
+				Value: events,
+			},
+			{
+				Name:  metrics.BinlogRowEventsRateMetricName,
+				Type:  metrics.GAUGE,
+				Value: events / interval.Seconds(),
+			},
+			{
+				Name:  metrics.BinlogUnknownActionsMetricName,
+				Type:  metrics.COUNTER,
+				Value: float64(unknownActions - prevUnknownActions),
+			},
+			{
+				Name:  metrics.BinlogChangesetDepthMetricName,
+				Type:  metrics.GAUGE,
+				Value: float64(c.GetDeltaLen()),
+			},
+		},
+	}
+	// The batch times are only sent if there were any since the last send.
+	c.flushBatchTimesLock.Lock()
+	if batchTimes := c.flushBatchTimes; batchTimes != nil && batchTimes.Count() > 0 {
+		c.flushBatchTimes = metrics.NewHistogram(metrics.DefaultLatencyBuckets)
+		m.Values = append(m.Values, batchTimes.Values(metrics.BinlogFlushBatchTimeMetricName)...)
+	}
+	c.flushBatchTimesLock.Unlock()
+	// We don't want to stop the client if sending metrics fails.
+	sendCtx, cancel := context.WithTimeout(ctx, metrics.SinkTimeout)
+	defer cancel()
+	if err := c.metricsSink.Send(sendCtx, m); err != nil {
+		c.logger.Errorf("error sending metrics from replication client: %v", err)
+	}
+	return count
+}
+
+// recordFlushBatchTime adds the duration of a batch of changes applied by
+// a flush to the histogram that is sent with the next event metrics.
+// Nothing is recorded if there is no MetricsSink.
+func (c *Client) recordFlushBatchTime(d time.Duration) {
+	c.flushBatchTimesLock.Lock()
+	defer c.flushBatchTimesLock.Unlock()
+	if c.flushBatchTimes != nil {
+		c.flushBatchTimes.Observe(float64(d.Milliseconds()))
+	}
+}
+
 // BlockWait blocks until the *canal position* has caught up to the current binlog position.
 // This is usually called by Flush() which then ensures the changes are flushed.
 // Calling it directly is usually only used by the test-suite!
@@ -715,16 +1713,56 @@ func (c *Client) StartPeriodicFlush(ctx context.Context, interval time.Duration)
 // **Caveat** Unless you are calling this from Flush(), calling this DOES NOT ensure that
 // changes have been applied to the database.
 func (c *Client) BlockWait(ctx context.Context) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
 	return c.canal.CatchMasterPos(DefaultTimeout)
 }
 
 func (c *Client) keyHasChanged(key []interface{}, deleted bool) {
+	c.keysHaveChanged([]string{c.hashKey(key)}, deleted)
+}
+
+// keysHaveChanged adds keys (as returned by hashKey) to the changeset.
+func (c *Client) keysHaveChanged(keys []string, deleted bool) {
+	if len(keys) == 0 {
+		return
+	}
 	c.Lock()
 	defer c.Unlock()
 
-	if c.disableDeltaMap {
-		c.queuedChanges = append(c.queuedChanges, queuedChange{key: utils.HashKey(key), isDelete: deleted})
-		return
+	for _, key := range keys {
+		if c.disableDeltaMap {
+			c.queuedChanges = append(c.queuedChanges, queuedChange{key: key, isDelete: deleted})
+			continue
+		}
+		c.binlogChangeset[key] = deleted
+	}
+}
+
+// hashKey converts a key into the string representation
+// used by the changeset, based on the configured encoding.
+func (c *Client) hashKey(key []interface{}) string {
+	if c.compactKeys {
+		return utils.PackKey(key)
+	}
+	return utils.HashKey(key)
+}
+
+// splitKey returns the string representation of
+// each column in a key returned by hashKey.
+func (c *Client) splitKey(key string) []string {
+	if c.compactKeys {
+		return utils.SplitPackedKey(key)
+	}
+	return utils.SplitHashedKey(key)
+}
+
+// unhashKey is the inverse of hashKey, returning
+// a string that can be used in a query.
+func (c *Client) unhashKey(key string) string {
+	if c.compactKeys {
+		return utils.UnpackKey(key)
 	}
-	c.binlogChangeset[utils.HashKey(key)] = deleted
+	return utils.UnhashKey(key)
 }
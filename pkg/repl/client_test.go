@@ -3,12 +3,18 @@ package repl
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/metrics"
 	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/cashapp/spirit/pkg/utils"
+	"github.com/go-mysql-org/go-mysql/canal"
 	"github.com/go-mysql-org/go-mysql/mysql"
 	mysql2 "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
@@ -49,6 +55,10 @@ func TestReplClient(t *testing.T) {
 	// There is no chunker attached, so the key above watermark can't apply.
 	// We should observe there are now rows in the changeset.
 	assert.Equal(t, 1, client.GetDeltaLen())
+	status := client.Status()
+	assert.Equal(t, 1, status.DeltaLen)
+	assert.Equal(t, int64(1), status.RowEvents)
+	assert.NotEmpty(t, status.ReadPosition.Name)
 	assert.NoError(t, client.Flush(context.TODO()))
 
 	// We should observe there is a row in t2.
@@ -56,6 +66,280 @@ func TestReplClient(t *testing.T) {
 	err = db.QueryRow("SELECT COUNT(*) FROM replt2").Scan(&count)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
+
+	status = client.Status()
+	assert.Equal(t, 0, status.DeltaLen)
+	assert.Equal(t, int64(1), status.RowsApplied)
+	assert.Equal(t, status.ReadPosition.Name, status.AppliedPosition.Name)
+}
+
+// TestReplClientDeleteReinsert is a regression test for a row that is
+// copied, deleted and then re-inserted with the same key. The changes are
+// applied in separate flushes, and also in the same flush, and the new
+// table must match the source each time.
+func TestReplClientDeleteReinsert(t *testing.T) {
+	for _, disableDeltaMap := range []bool{false, true} {
+		db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+		assert.NoError(t, err)
+
+		testutils.RunSQL(t, "DROP TABLE IF EXISTS reinsertt1, _reinsertt1_new")
+		testutils.RunSQL(t, "CREATE TABLE reinsertt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+		testutils.RunSQL(t, "CREATE TABLE _reinsertt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+		testutils.RunSQL(t, "INSERT INTO reinsertt1 VALUES (1, 1), (2, 2)")
+
+		t1 := table.NewTableInfo(db, "test", "reinsertt1")
+		assert.NoError(t, t1.SetInfo(context.TODO()))
+		t2 := table.NewTableInfo(db, "test", "_reinsertt1_new")
+		assert.NoError(t, t2.SetInfo(context.TODO()))
+
+		cfg, err := mysql2.ParseDSN(testutils.DSN())
+		assert.NoError(t, err)
+		client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, NewClientDefaultConfig())
+		assert.NoError(t, client.Run())
+		client.disableDeltaMap = disableDeltaMap
+
+		rowsInNewTable := func() string {
+			var rows string
+			assert.NoError(t, db.QueryRow("SELECT IFNULL(GROUP_CONCAT(CONCAT(a, ':', b) ORDER BY a), '') FROM _reinsertt1_new").Scan(&rows))
+			return rows
+		}
+
+		// The chunk copies the rows.
+		testutils.RunSQL(t, "INSERT INTO _reinsertt1_new SELECT * FROM reinsertt1")
+
+		// The row is deleted, and the delete is flushed.
+		testutils.RunSQL(t, "DELETE FROM reinsertt1 WHERE a = 1")
+		assert.NoError(t, client.BlockWait(context.TODO()))
+		assert.NoError(t, client.flush(context.TODO(), false, nil))
+		assert.Equal(t, "2:2", rowsInNewTable())
+
+		// The row is re-inserted in the next flush window.
+		testutils.RunSQL(t, "INSERT INTO reinsertt1 VALUES (1, 10)")
+		assert.NoError(t, client.BlockWait(context.TODO()))
+		assert.NoError(t, client.flush(context.TODO(), false, nil))
+		assert.Equal(t, "1:10,2:2", rowsInNewTable())
+
+		// Delete and re-insert within the same flush window.
+		testutils.RunSQL(t, "DELETE FROM reinsertt1 WHERE a = 1")
+		testutils.RunSQL(t, "INSERT INTO reinsertt1 VALUES (1, 20)")
+		assert.NoError(t, client.BlockWait(context.TODO()))
+		assert.NoError(t, client.flush(context.TODO(), false, nil))
+		assert.Equal(t, "1:20,2:2", rowsInNewTable())
+
+		// Delete, re-insert and delete again within the same flush window.
+		testutils.RunSQL(t, "DELETE FROM reinsertt1 WHERE a = 2")
+		testutils.RunSQL(t, "INSERT INTO reinsertt1 VALUES (2, 30)")
+		testutils.RunSQL(t, "DELETE FROM reinsertt1 WHERE a = 2")
+		assert.NoError(t, client.BlockWait(context.TODO()))
+		assert.NoError(t, client.flush(context.TODO(), false, nil))
+		assert.Equal(t, "1:20", rowsInNewTable())
+
+		// A flush does not start until the previous flush has completed,
+		// so a later window can not be applied before an earlier one.
+		testutils.RunSQL(t, "DELETE FROM reinsertt1 WHERE a = 1")
+		assert.NoError(t, client.BlockWait(context.TODO()))
+		client.flushLock.Lock() // an earlier flush is running
+		flushed := make(chan error)
+		go func() {
+			flushed <- client.flush(context.TODO(), false, nil)
+		}()
+		select {
+		case <-flushed:
+			t.Fatal("flush did not wait for the earlier flush")
+		case <-time.After(100 * time.Millisecond):
+		}
+		client.flushLock.Unlock()
+		assert.NoError(t, <-flushed)
+		assert.Equal(t, "", rowsInNewTable())
+
+		client.Close()
+		db.Close()
+	}
+}
+
+func TestReplClientConnLimiter(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS connlimitreplt1, _connlimitreplt1_new")
+	testutils.RunSQL(t, "CREATE TABLE connlimitreplt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _connlimitreplt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+
+	t1 := table.NewTableInfo(db, "test", "connlimitreplt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_connlimitreplt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	limiter := dbconn.NewConnLimiter(1)
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	clientConfig := NewClientDefaultConfig()
+	clientConfig.ConnLimiter = limiter
+	client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, clientConfig)
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	testutils.RunSQL(t, "INSERT INTO connlimitreplt1 VALUES (1, 1), (2, 2)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+
+	// Another component holds the only slot, so the flush waits.
+	assert.NoError(t, limiter.Acquire(context.Background()))
+	flushed := make(chan error)
+	go func() {
+		flushed <- client.flush(context.TODO(), false, nil)
+	}()
+	select {
+	case <-flushed:
+		t.Fatal("changes were flushed without a slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+	limiter.Release()
+	assert.NoError(t, <-flushed)
+	assert.Equal(t, 0, limiter.InUse())
+
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _connlimitreplt1_new").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestReplClientSummary(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS summaryreplt1, _summaryreplt1_new")
+	testutils.RunSQL(t, "CREATE TABLE summaryreplt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _summaryreplt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+
+	t1 := table.NewTableInfo(db, "test", "summaryreplt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_summaryreplt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, NewClientDefaultConfig())
+	assert.NoError(t, client.Run())
+	defer client.Close()
+	assert.Equal(t, ClientSummary{}, client.Summary())
+
+	testutils.RunSQL(t, "INSERT INTO summaryreplt1 VALUES (1, 1), (2, 2), (3, 3)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.NoError(t, client.flush(context.TODO(), false, nil))
+	testutils.RunSQL(t, "DELETE FROM summaryreplt1 WHERE a = 1")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.NoError(t, client.flush(context.TODO(), false, nil))
+
+	summary := client.Summary()
+	assert.Equal(t, int64(4), summary.RowsApplied)
+	assert.Equal(t, client.Status().RowsApplied, summary.RowsApplied)
+	assert.Equal(t, uint64(2), summary.Flushes)
+	assert.Equal(t, int64(1), summary.LastFlushRows)
+	assert.Positive(t, summary.LastFlushTime)
+	assert.GreaterOrEqual(t, summary.FlushTime, summary.LastFlushTime)
+	assert.False(t, summary.LastFlushUnderLock)
+	assert.Contains(t, summary.String(), "rows-applied=4 row-events=")
+	assert.Contains(t, summary.String(), "flushes=2")
+}
+
+func TestReplClientCompactKeys(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS replcompactt1, replcompactt2, _replcompactt1_chkpnt")
+	testutils.RunSQL(t, "CREATE TABLE replcompactt1 (a BIGINT NOT NULL, b VARCHAR(255) NOT NULL, c INT, PRIMARY KEY (a, b))")
+	testutils.RunSQL(t, "CREATE TABLE replcompactt2 (a BIGINT NOT NULL, b VARCHAR(255) NOT NULL, c INT, PRIMARY KEY (a, b))")
+	testutils.RunSQL(t, "CREATE TABLE _replcompactt1_chkpnt (a int)") // just used to advance binlog
+
+	t1 := table.NewTableInfo(db, "test", "replcompactt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "replcompactt2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	clientConfig := NewClientDefaultConfig()
+	clientConfig.CompactKeys = true
+	client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, clientConfig)
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	testutils.RunSQL(t, "INSERT INTO replcompactt1 VALUES (-1234567890123, 'it''s', 1), (1, 'a-#-b', 2), (2, 'c', 3)")
+	testutils.RunSQL(t, "DELETE FROM replcompactt1 WHERE a = 2")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.Equal(t, 3, client.GetDeltaLen())
+	assert.NoError(t, client.Flush(context.TODO()))
+
+	// The two remaining rows must have been applied exactly.
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM replcompactt2 WHERE (a, b) IN ((-1234567890123, 'it''s'), (1, 'a-#-b'))").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestReplClientReprocessFrom(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS replreprocesst1, replreprocesst2, _replreprocesst1_chkpnt")
+	testutils.RunSQL(t, "CREATE TABLE replreprocesst1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE replreprocesst2 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _replreprocesst1_chkpnt (a int)") // just used to advance binlog
+
+	t1 := table.NewTableInfo(db, "test", "replreprocesst1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "replreprocesst2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, NewClientDefaultConfig())
+	assert.NoError(t, client.Run())
+	defer client.Close()
+	start := client.GetBinlogApplyPosition()
+
+	testutils.RunSQL(t, "INSERT INTO replreprocesst1 VALUES (1, 1, 1), (2, 2, 2), (3, 3, 3)")
+	testutils.RunSQL(t, "UPDATE replreprocesst1 SET b = 20 WHERE a = 2")
+	testutils.RunSQL(t, "DELETE FROM replreprocesst1 WHERE a = 3")
+	testutils.RunSQL(t, "INSERT INTO replreprocesst1 VALUES (3, 30, 30)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.NoError(t, client.Flush(context.TODO()))
+	applied := client.GetBinlogApplyPosition()
+
+	// Simulate the subscription having mishandled events.
+	testutils.RunSQL(t, "DELETE FROM replreprocesst2 WHERE a = 1")
+	testutils.RunSQL(t, "UPDATE replreprocesst2 SET b = 0 WHERE a = 2")
+	testutils.RunSQL(t, "INSERT INTO replreprocesst2 VALUES (4, 4, 4)") // not in the range
+
+	checksum := func(tbl string) string {
+		var sum string
+		err := db.QueryRow(fmt.Sprintf("SELECT IFNULL(BIT_XOR(CRC32(CONCAT_WS('#', a, b, c))), 0) FROM %s WHERE a < 4", tbl)).Scan(&sum)
+		assert.NoError(t, err)
+		return sum
+	}
+	assert.NotEqual(t, checksum("replreprocesst1"), checksum("replreprocesst2"))
+
+	// Re-applying is idempotent, so it can be repeated.
+	for range 2 {
+		assert.NoError(t, client.ReprocessFrom(context.TODO(), &start, &applied))
+		assert.Equal(t, checksum("replreprocesst1"), checksum("replreprocesst2"))
+	}
+
+	// Keys outside of the range are not modified.
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM replreprocesst2 WHERE a = 4").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	// The primary subscription is not disturbed.
+	assert.Equal(t, applied, client.GetBinlogApplyPosition())
+	testutils.RunSQL(t, "INSERT INTO replreprocesst1 VALUES (5, 5, 5)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.Equal(t, 1, client.GetDeltaLen())
+
+	// An empty range is a no-op.
+	assert.NoError(t, client.ReprocessFrom(context.TODO(), &applied, &start))
+	assert.Error(t, client.ReprocessFrom(context.TODO(), nil, nil))
 }
 
 func TestReplClientComplex(t *testing.T) {
@@ -161,6 +445,19 @@ func TestReplClientResumeFromImpossible(t *testing.T) {
 	})
 	err = client.Run()
 	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrBinlogPurged)
+
+	// If SHOW BINARY LOGS can not be read, the position can't be verified.
+	closedDB, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, closedDB.Close())
+	client.controlDB = closedDB
+	assert.ErrorIs(t, client.binlogPositionIsImpossible(client.binlogPosSynced), ErrPositionImpossible)
+
+	// Reading the current position from a closed DB is not a disabled binlog.
+	_, err = client.getCurrentBinlogPosition()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrBinlogDisabled)
 }
 
 func TestReplClientResumeFromPoint(t *testing.T) {
@@ -194,6 +491,201 @@ func TestReplClientResumeFromPoint(t *testing.T) {
 	client.Close()
 }
 
+func TestReplClientControlDB(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "replcontrolt1")
+	t2 := table.NewTableInfo(nil, "test", "_replcontrolt1_new")
+	applyDB, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer applyDB.Close()
+
+	// Without a ControlDB the control queries use the apply pool.
+	client := NewClient(applyDB, "", t1, t2, "", "", NewClientDefaultConfig())
+	assert.Equal(t, applyDB, client.controlDB)
+
+	// With a ControlDB the control queries do not use the apply pool,
+	// so they succeed even though it has been closed.
+	dbConfig := dbconn.NewDBConfig()
+	dbConfig.MaxOpenConnections = 1
+	controlDB, err := dbconn.New(testutils.DSN(), dbConfig)
+	assert.NoError(t, err)
+	defer controlDB.Close()
+	closedDB, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, closedDB.Close())
+	config := NewClientDefaultConfig()
+	config.ControlDB = controlDB
+	client = NewClient(closedDB, "", t1, t2, "", "", config)
+	if dbconn.IsMySQL84(controlDB) { // handle MySQL 8.4
+		client.isMySQL84 = true
+	}
+	pos, err := client.getCurrentBinlogPosition()
+	assert.NoError(t, err)
+	assert.NoError(t, client.binlogPositionIsImpossible(pos))
+}
+
+func TestReplClientCredentials(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "replcredst1")
+	t2 := table.NewTableInfo(nil, "test", "_replcredst1_new")
+	applyDB, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer applyDB.Close()
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+
+	// The binary log is streamed with the credentials passed to
+	// NewClient, and changes are applied as the user of the db.
+	client := NewClient(applyDB, cfg.Addr, t1, t2, "repluser", "replpass", NewClientDefaultConfig())
+	canalConfig := client.canalConfig()
+	assert.Equal(t, cfg.Addr, canalConfig.Addr)
+	assert.Equal(t, "repluser", canalConfig.User)
+	assert.Equal(t, "replpass", canalConfig.Password)
+	var currentUser string
+	assert.NoError(t, client.db.QueryRow("SELECT SUBSTRING_INDEX(CURRENT_USER(), '@', 1)").Scan(&currentUser))
+	assert.Equal(t, cfg.User, currentUser)
+}
+
+func TestClientErr(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "clientfailt1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_clientfailt1_new")
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	assert.NoError(t, client.Err())
+	select {
+	case <-client.Failed():
+		t.Fatal("client failed before it started")
+	default:
+	}
+
+	client.setFailure(errors.New("connection refused"))
+	client.setFailure(errors.New("only the first failure is kept"))
+	<-client.Failed()
+	assert.ErrorIs(t, client.Err(), ErrCanalFailed)
+	assert.EqualError(t, client.Err(), "canal has failed: connection refused")
+	// The client can no longer be used.
+	assert.ErrorIs(t, client.flush(context.TODO(), false, nil), ErrCanalFailed)
+	assert.ErrorIs(t, client.BlockWait(context.TODO()), ErrCanalFailed)
+	assert.ErrorIs(t, client.Flush(context.TODO()), ErrCanalFailed)
+}
+
+func TestClientWaitUntilReady(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "readyt1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_readyt1_new")
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+
+	// It is not ready until the first event is received.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.WaitUntilReady(ctx), context.DeadlineExceeded)
+
+	// Canal's log messages do not confirm it, only events that it processes.
+	logWrapper := NewLogWrapper(logrus.New())
+	logWrapper.Infof("received fake rotate event, next log name is %s", "binlog.000001")
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.WaitUntilReady(ctx), context.DeadlineExceeded)
+
+	assert.NoError(t, client.OnPosSynced(nil, mysql.Position{Name: "binlog.000001", Pos: 4}, nil, true))
+	assert.NoError(t, client.WaitUntilReady(context.Background()))
+
+	// The grace period delays it further.
+	config := NewClientDefaultConfig()
+	config.ReadyGracePeriod = 200 * time.Millisecond
+	client = NewClient(nil, "", t1, t2, "", "", config)
+	client.setReady()
+	start := time.Now()
+	assert.NoError(t, client.WaitUntilReady(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	// A failed subscription will never be ready.
+	client = NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	client.setFailure(errors.New("connection refused"))
+	assert.ErrorIs(t, client.WaitUntilReady(context.Background()), ErrCanalFailed)
+
+	// The wait is bounded, even if the context is not.
+	readyFlushDelay = time.Hour
+	readyTimeout = 100 * time.Millisecond
+	defer func() {
+		readyFlushDelay = time.Second
+		readyTimeout = DefaultTimeout
+	}()
+	client = NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	err := client.WaitUntilReady(context.Background())
+	assert.ErrorIs(t, err, ErrNotStreaming)
+	assert.EqualError(t, err, "could not confirm that the binary log is being streamed within 100ms")
+}
+
+func TestReplClientWaitUntilReady(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS replreadyt1, _replreadyt1_new")
+	testutils.RunSQL(t, "CREATE TABLE replreadyt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _replreadyt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+
+	t1 := table.NewTableInfo(db, "test", "replreadyt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_replreadyt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, NewClientDefaultConfig())
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, client.WaitUntilReady(ctx))
+
+	// Changes from when it is ready are received.
+	testutils.RunSQL(t, "INSERT INTO replreadyt1 VALUES (1, 1)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.Equal(t, 1, client.GetDeltaLen())
+}
+
+func TestClientErrCanalFailure(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS canalfailt1, _canalfailt1_new")
+	testutils.RunSQL(t, "CREATE TABLE canalfailt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _canalfailt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+
+	t1 := table.NewTableInfo(db, "test", "canalfailt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_canalfailt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, NewClientDefaultConfig())
+	if dbconn.IsMySQL84(db) { // handle MySQL 8.4
+		client.isMySQL84 = true
+	}
+	// The file exists, but the position is past its end,
+	// so the source refuses to send any events.
+	pos, err := client.getCurrentBinlogPosition()
+	assert.NoError(t, err)
+	pos.Pos = 1 << 31
+	client.SetPos(pos)
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	// The failure is observable, instead of crashing the process.
+	select {
+	case <-client.Failed():
+	case <-time.After(30 * time.Second):
+		t.Fatal("canal did not fail")
+	}
+	assert.ErrorIs(t, client.Err(), ErrCanalFailed)
+	assert.ErrorIs(t, client.BlockWait(context.TODO()), ErrCanalFailed)
+}
+
 func TestReplClientOpts(t *testing.T) {
 	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
 	assert.NoError(t, err)
@@ -291,12 +783,12 @@ func TestReplClientQueue(t *testing.T) {
 	// Read from the copier
 	chk, err := copier.Next4Test()
 	assert.NoError(t, err)
-	prevUpperBound := chk.UpperBound.Value[0].String()
+	prevUpperBound := fmt.Sprintf("'%v'", chk.UpperBound.Value[0].Val)
 	assert.Equal(t, "`a` < "+prevUpperBound, chk.String())
 	// read again
 	chk, err = copier.Next4Test()
 	assert.NoError(t, err)
-	assert.Equal(t, fmt.Sprintf("`a` >= %s AND `a` < %s", prevUpperBound, chk.UpperBound.Value[0].String()), chk.String())
+	assert.Equal(t, fmt.Sprintf("`a` >= %s AND `a` < '%v'", prevUpperBound, chk.UpperBound.Value[0].Val), chk.String())
 
 	// Accumulate more deltas
 	testutils.RunSQL(t, "INSERT INTO replqueuet1 (a, b, c) SELECT UUID(), 1, 1 FROM replqueuet1 LIMIT 501")
@@ -317,6 +809,746 @@ func TestReplClientQueue(t *testing.T) {
 	assert.Equal(t, 0, client.GetDeltaLen())
 }
 
+// TestKeyAboveWatermarkBoundary is a regression test for changes to the key
+// at the exclusive upper bound of the last chunk, which is the watermark.
+// The change is discarded, since the next chunk copies the key. Changes to
+// the key below it are applied, including while its chunk is being copied.
+func TestKeyAboveWatermarkBoundary(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS replboundaryt1, _replboundaryt1_new")
+	testutils.RunSQL(t, "CREATE TABLE replboundaryt1 (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _replboundaryt1_new (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO replboundaryt1 (b) SELECT 1 FROM (SELECT a.N + b.N * 10 + c.N * 100 AS n FROM (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) a, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) b, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) c, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2) d) nums")
+
+	t1 := table.NewTableInfo(db, "test", "replboundaryt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_replboundaryt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	client := NewClient(db, cfg.Addr, t1, t1new, cfg.User, cfg.Passwd, NewClientDefaultConfig())
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	copier, err := row.NewCopier(db, t1, t1new, row.NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	client.KeyAboveCopierCallback = copier.KeyAboveHighWatermark
+	client.SetKeyAboveWatermarkOptimization(true)
+	assert.NoError(t, copier.Open4Test())
+
+	chunk, err := copier.Next4Test()
+	assert.NoError(t, err)
+	assert.Equal(t, "`a` < 1", chunk.String())
+	assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+	chunk, err = copier.Next4Test()
+	assert.NoError(t, err)
+	assert.Equal(t, "`a` >= 1 AND `a` < 1001", chunk.String())
+
+	// The chunk has been returned, but not copied. The change to the last
+	// key of the chunk is tracked, and the change to the key at its upper
+	// bound is discarded.
+	testutils.RunSQL(t, "UPDATE replboundaryt1 SET b = 2 WHERE a IN (1000, 1001)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.Equal(t, 1, client.GetDeltaLen())
+	assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+
+	// The same applies once the chunk has been copied.
+	testutils.RunSQL(t, "UPDATE replboundaryt1 SET b = 3 WHERE a IN (1000, 1001)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.Equal(t, 1, client.GetDeltaLen())
+
+	for {
+		chunk, err = copier.Next4Test()
+		if err == table.ErrTableIsRead {
+			break
+		}
+		assert.NoError(t, err)
+		assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+	}
+	assert.NoError(t, client.Flush(context.TODO()))
+
+	// Neither change is lost or applied twice.
+	var count, matching int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _replboundaryt1_new").Scan(&count))
+	assert.Equal(t, 3000, count)
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM replboundaryt1 t1 JOIN _replboundaryt1_new t2 USING (a) WHERE t1.b = t2.b").Scan(&matching))
+	assert.Equal(t, 3000, matching)
+	var b1000, b1001 int
+	assert.NoError(t, db.QueryRow("SELECT (SELECT b FROM _replboundaryt1_new WHERE a = 1000), (SELECT b FROM _replboundaryt1_new WHERE a = 1001)").Scan(&b1000, &b1001))
+	assert.Equal(t, 3, b1000)
+	assert.Equal(t, 3, b1001)
+}
+
+func TestReplClientPauseFlush(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS replpauset1, _replpauset1_new")
+	testutils.RunSQL(t, "CREATE TABLE replpauset1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _replpauset1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	t1 := table.NewTableInfo(db, "test", "replpauset1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_replpauset1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	config := NewClientDefaultConfig()
+	config.MaxPausedChanges = 5
+	client := NewClient(db, cfg.Addr, t1, t1new, cfg.User, cfg.Passwd, config)
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	// While flushing is paused, changes accumulate and are not applied.
+	client.PauseFlush()
+	assert.True(t, client.Status().FlushPaused)
+	testutils.RunSQL(t, "INSERT INTO replpauset1 VALUES (1, 1), (2, 2), (3, 3)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.NoError(t, client.flush(context.TODO(), false, nil))
+	assert.Equal(t, 3, client.GetDeltaLen())
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _replpauset1_new").Scan(&count))
+	assert.Equal(t, 0, count)
+
+	// Once the changeset is full, the binary log is not read.
+	testutils.RunSQL(t, "INSERT INTO replpauset1 VALUES (4, 4), (5, 5)")
+	testutils.RunSQL(t, "INSERT INTO replpauset1 VALUES (6, 6)")
+	testutils.RunSQL(t, "UPDATE replpauset1 SET b = b + 10")
+	time.Sleep(time.Second)
+	assert.Equal(t, 5, client.GetDeltaLen())
+
+	// Flush waits until flushing is resumed, and then
+	// applies all of the changes that accumulated.
+	flushed := make(chan error)
+	go func() {
+		flushed <- client.Flush(context.TODO())
+	}()
+	select {
+	case err := <-flushed:
+		t.Fatalf("flush returned while paused: %v", err)
+	case <-time.After(time.Second):
+	}
+	client.ResumeFlush()
+	assert.False(t, client.IsFlushPaused())
+	assert.NoError(t, <-flushed)
+	assert.True(t, client.AllChangesFlushed())
+	var mismatched int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM replpauset1 t1 LEFT JOIN _replpauset1_new t2 USING (a) WHERE t2.b IS NULL OR t1.b != t2.b").Scan(&mismatched))
+	assert.Equal(t, 0, mismatched)
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _replpauset1_new").Scan(&count))
+	assert.Equal(t, 6, count)
+
+	// A Flush that is waiting can be cancelled.
+	client.PauseFlush()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.Flush(ctx), context.DeadlineExceeded)
+}
+
+func TestFlushKeyOrder(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS keyordert1, keyordert2")
+	testutils.RunSQL(t, "CREATE TABLE keyordert1 (a INT NOT NULL, b VARBINARY(255) NOT NULL, c INT, PRIMARY KEY (a, b))")
+	testutils.RunSQL(t, "CREATE TABLE keyordert2 (a INT NOT NULL, b VARBINARY(255) NOT NULL, c INT, PRIMARY KEY (a, b))")
+
+	t1 := table.NewTableInfo(db, "test", "keyordert1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "keyordert2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	client := NewClient(db, "", t1, t2, "", "", NewClientDefaultConfig())
+	client.keyHasChanged([]interface{}{10, "a"}, false)
+	client.keyHasChanged([]interface{}{9, "b"}, false)
+	client.keyHasChanged([]interface{}{-1, "c"}, true)
+	client.keyHasChanged([]interface{}{9, "a"}, false)
+	client.keyHasChanged([]interface{}{-20, "c"}, true)
+
+	// Keys are applied in primary key order, using numeric comparison
+	// for the INT column.
+	stmts := client.changesetToStatements(client.binlogChangeset)
+	assert.Len(t, stmts, 2)
+	assert.Contains(t, stmts[0].stmt, "DELETE FROM `test`.`keyordert2` WHERE (`a`, `b`) IN (('-20','c'),('-1','c'))")
+	assert.Contains(t, stmts[1].stmt, "WHERE (`a`, `b`) IN (('9','a'),('9','b'),('10','a'))")
+
+	// Batches are split in order as well.
+	client.targetBatchSize = 2
+	stmts = client.changesetToStatements(client.binlogChangeset)
+	assert.Len(t, stmts, 3)
+	assert.Contains(t, stmts[1].stmt, "IN (('9','a'),('9','b'))")
+	assert.Contains(t, stmts[2].stmt, "IN (('10','a'))")
+}
+
+func TestMaxFlushBatchBytes(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "widet1")
+	t1.KeyColumns = []string{"a"}
+	t1.AvgRowLength = 1 << 20 // 1MiB rows
+	t2 := table.NewTableInfo(nil, "test", "_widet1_new")
+	config := NewClientDefaultConfig()
+	config.MaxFlushBatchBytes = 4 << 20
+	client := NewClient(nil, "", t1, t2, "", "", config)
+	for i := range 10 {
+		client.keyHasChanged([]interface{}{i}, false)
+	}
+
+	// The batches are split by the estimated bytes,
+	// well before the batch size of 1000 keys.
+	assert.Equal(t, 4, client.batchSize())
+	stmts := client.changesetToStatements(client.binlogChangeset)
+	assert.Len(t, stmts, 3)
+	assert.Equal(t, []int{4, 4, 2}, []int{stmts[0].numKeys, stmts[1].numKeys, stmts[2].numKeys})
+	assert.Contains(t, stmts[0].stmt, "IN ('0','1','2','3')")
+
+	// Each statement of a flush of the queue is in its own transaction,
+	// unless several fit within the limit.
+	assert.Len(t, client.splitTransactions(stmts), 3)
+	client.maxFlushBatchBytes = 9 << 20
+	txns := client.splitTransactions(stmts)
+	assert.Len(t, txns, 2)
+	assert.Len(t, txns[0], 2)
+	assert.Len(t, txns[1], 1)
+
+	// A row larger than the limit is still applied, one key at a time.
+	client.maxFlushBatchBytes = 1 << 10
+	assert.Equal(t, 1, client.batchSize())
+
+	// Narrow rows are only limited by the batch size, as is
+	// every table if there is no limit.
+	client.maxFlushBatchBytes = 4 << 20
+	t1.AvgRowLength = 100
+	assert.Equal(t, DefaultBatchSize, client.batchSize())
+	client.maxFlushBatchBytes = 0
+	t1.AvgRowLength = 1 << 20
+	assert.Equal(t, DefaultBatchSize, client.batchSize())
+	assert.Len(t, client.splitTransactions(stmts), 1)
+}
+
+func BenchmarkChangesetToStatements(b *testing.B) {
+	t1 := table.NewTableInfo(nil, "test", "bencht1")
+	t1.KeyColumns = []string{"a", "b"}
+	t2 := table.NewTableInfo(nil, "test", "bencht2")
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	changeset := make(map[string]bool)
+	for i := range 100000 {
+		changeset[client.hashKey([]interface{}{i * 7919 % 100000, "us-west-2"})] = i%10 == 0
+	}
+	b.ResetTimer()
+	for range b.N {
+		client.changesetToStatements(changeset)
+	}
+}
+
+type testMetricsSink struct {
+	sync.Mutex
+	values []metrics.MetricValue
+}
+
+func (s *testMetricsSink) Send(ctx context.Context, m *metrics.Metrics) error {
+	s.Lock()
+	defer s.Unlock()
+	s.values = append(s.values, m.Values...)
+	return nil
+}
+
+func TestEventMetrics(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "eventmetricst1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_eventmetricst1_new")
+	sink := &testMetricsSink{}
+	config := NewClientDefaultConfig()
+	config.MetricsSink = sink
+	client := NewClient(nil, "", t1, t2, "", "", config)
+
+	// A burst of 50 events with 2 rows each.
+	for i := range 50 {
+		assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{2 * i, "a"}, {2*i + 1, "b"}}}))
+	}
+	count := client.sendEventMetrics(context.Background(), 0, 10*time.Second)
+	assert.Equal(t, int64(100), count)
+	// Nothing happened since the last interval.
+	assert.Equal(t, int64(100), client.sendEventMetrics(context.Background(), count, 10*time.Second))
+	assert.Equal(t, []metrics.MetricValue{
+		{Name: metrics.BinlogRowEventsCountMetricName, Type: metrics.COUNTER, Value: 100},
+		{Name: metrics.BinlogRowEventsRateMetricName, Type: metrics.GAUGE, Value: 10},
+		{Name: metrics.BinlogUnknownActionsMetricName, Type: metrics.COUNTER, Value: 0},
+		{Name: metrics.BinlogChangesetDepthMetricName, Type: metrics.GAUGE, Value: 100},
+		{Name: metrics.BinlogRowEventsCountMetricName, Type: metrics.COUNTER, Value: 0},
+		{Name: metrics.BinlogRowEventsRateMetricName, Type: metrics.GAUGE, Value: 0},
+		{Name: metrics.BinlogUnknownActionsMetricName, Type: metrics.COUNTER, Value: 0},
+		{Name: metrics.BinlogChangesetDepthMetricName, Type: metrics.GAUGE, Value: 100},
+	}, sink.values)
+
+	// The loop sends the metrics on each tick.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.StartEventMetrics(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		sink.Lock()
+		defer sink.Unlock()
+		return len(sink.values) >= 12
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestFlushBatchTimeMetrics(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "batchtimet1")
+	t2 := table.NewTableInfo(nil, "test", "_batchtimet1_new")
+
+	// Without a sink the durations are not recorded.
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	client.recordFlushBatchTime(time.Millisecond)
+	assert.Nil(t, client.flushBatchTimes)
+
+	sink := &testMetricsSink{}
+	config := NewClientDefaultConfig()
+	config.MetricsSink = sink
+	client = NewClient(nil, "", t1, t2, "", "", config)
+	for _, d := range []time.Duration{2 * time.Millisecond, 3 * time.Millisecond, 20 * time.Millisecond, 200 * time.Millisecond, 2 * time.Second, time.Minute} {
+		client.recordFlushBatchTime(d)
+	}
+	client.sendEventMetrics(context.Background(), 0, 10*time.Second)
+
+	// The durations are sent as the buckets of a histogram.
+	// Buckets: 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, +Inf
+	batchTimes := func() map[string]float64 {
+		values := make(map[string]float64)
+		for _, v := range sink.values {
+			if strings.HasPrefix(v.Name, metrics.BinlogFlushBatchTimeMetricName) {
+				assert.Equal(t, metrics.COUNTER, v.Type)
+				values[v.Name] = v.Value
+			}
+		}
+		sink.values = nil
+		return values
+	}
+	values := batchTimes()
+	assert.Len(t, values, 16)
+	assert.Equal(t, float64(0), values["binlog_flush_batch_time_bucket_le_1"])
+	assert.Equal(t, float64(2), values["binlog_flush_batch_time_bucket_le_5"])
+	assert.Equal(t, float64(4), values["binlog_flush_batch_time_bucket_le_250"])
+	assert.Equal(t, float64(5), values["binlog_flush_batch_time_bucket_le_30000"])
+	assert.Equal(t, float64(6), values["binlog_flush_batch_time_bucket_le_inf"])
+	assert.Equal(t, float64(6), values["binlog_flush_batch_time_count"])
+	assert.Equal(t, float64(62225), values["binlog_flush_batch_time_sum"])
+
+	// The next send only has the durations since the last send.
+	client.recordFlushBatchTime(7 * time.Millisecond)
+	client.sendEventMetrics(context.Background(), 0, 10*time.Second)
+	values = batchTimes()
+	assert.Equal(t, float64(0), values["binlog_flush_batch_time_bucket_le_5"])
+	assert.Equal(t, float64(1), values["binlog_flush_batch_time_bucket_le_10"])
+	assert.Equal(t, float64(1), values["binlog_flush_batch_time_count"])
+
+	// Nothing is sent if there were no batches.
+	client.sendEventMetrics(context.Background(), 0, 10*time.Second)
+	assert.Empty(t, batchTimes())
+}
+
+func TestOnRowBatched(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "onrowt1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_onrowt1_new")
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	client.KeyAboveCopierCallback = func(key interface{}) bool {
+		return key.(int) >= 100 // not yet copied
+	}
+	client.SetKeyAboveWatermarkOptimization(true)
+
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{1, "a"}, {2, "b"}, {100, "c"}}}))
+	// Updates have a before and after image, only the before image is used.
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.UpdateAction, Rows: [][]interface{}{{3, "a"}, {3, "b"}, {4, "a"}, {4, "b"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.DeleteAction, Rows: [][]interface{}{{2, "b"}, {200, "c"}}}))
+
+	assert.Equal(t, map[string]bool{
+		client.hashKey([]interface{}{1}): false,
+		client.hashKey([]interface{}{2}): true,
+		client.hashKey([]interface{}{3}): false,
+		client.hashKey([]interface{}{4}): false,
+	}, client.binlogChangeset)
+	assert.Equal(t, int64(7), client.Status().RowEvents)
+
+	// The queue preserves the order of the changes.
+	client = NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	client.disableDeltaMap = true
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{1, "a"}, {2, "b"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.DeleteAction, Rows: [][]interface{}{{1, "a"}}}))
+	assert.Equal(t, []queuedChange{
+		{key: client.hashKey([]interface{}{1}), isDelete: false},
+		{key: client.hashKey([]interface{}{2}), isDelete: false},
+		{key: client.hashKey([]interface{}{1}), isDelete: true},
+	}, client.queuedChanges)
+}
+
+func TestKeysConditionNull(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "nullkeyt1")
+	t1.Columns = []string{"a", "b", "c"}
+	t1.NullableColumns = []string{"b"}
+	t1.KeyColumns = []string{"a", "b"}
+	t2 := table.NewTableInfo(nil, "test", "_nullkeyt1_new")
+	for _, compact := range []bool{false, true} {
+		config := NewClientDefaultConfig()
+		config.CompactKeys = compact
+		client := NewClient(nil, "", t1, t2, "", "", config)
+
+		assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{1, "x", 1}, {1, "", 2}, {1, nil, 3}}}))
+		assert.Len(t, client.binlogChangeset, 3)
+
+		keys := []string{
+			client.hashKey([]interface{}{1, "x"}),
+			client.hashKey([]interface{}{1, ""}),
+			client.hashKey([]interface{}{1, nil}),
+		}
+		assert.Equal(t, "(`a`, `b`) IN (('1','x'),('1','')) OR (`a`, `b`) <=> ('1',NULL)", client.keysCondition(keys))
+		assert.Equal(t, "(`a`, `b`) <=> ('1',NULL)", client.keysCondition(keys[2:]))
+		assert.Contains(t, client.createDeleteStmt(keys[2:]).stmt, "DELETE FROM `test`.`_nullkeyt1_new` WHERE (`a`, `b`) <=> ('1',NULL)")
+	}
+}
+
+func TestReplClientNullableKey(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS replnullt1, _replnullt1_new")
+	testutils.RunSQL(t, "CREATE TABLE replnullt1 (a INT NOT NULL, b VARCHAR(10), c INT, UNIQUE KEY (a, b))")
+	testutils.RunSQL(t, "CREATE TABLE _replnullt1_new (a INT NOT NULL, b VARCHAR(10), c INT, UNIQUE KEY (a, b))")
+	testutils.RunSQL(t, "INSERT INTO replnullt1 VALUES (1, 'x', 1), (1, '', 2), (1, NULL, 3)")
+	testutils.RunSQL(t, "INSERT INTO _replnullt1_new SELECT * FROM replnullt1")
+
+	t1 := table.NewTableInfo(db, "test", "replnullt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_replnullt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+	// Key the changes on the unique key, since there is no primary key.
+	t1.KeyColumns = []string{"a", "b"}
+	assert.Equal(t, []string{"b", "c"}, t1.NullableColumns)
+
+	cfg := NewClientDefaultConfig()
+	cfg.DisableForcePrimaryIndex = true
+	client := NewClient(db, testutils.DSN(), t1, t2, "", "", cfg)
+	client.keysHaveChanged([]string{client.hashKey([]interface{}{1, nil})}, false)
+	client.keysHaveChanged([]string{client.hashKey([]interface{}{1, ""})}, true)
+	testutils.RunSQL(t, "UPDATE replnullt1 SET c = 30 WHERE a = 1 AND b IS NULL")
+	testutils.RunSQL(t, "DELETE FROM replnullt1 WHERE a = 1 AND b = ''")
+	assert.NoError(t, client.Flush(context.TODO()))
+
+	var c int
+	assert.NoError(t, db.QueryRow("SELECT c FROM _replnullt1_new WHERE a = 1 AND b IS NULL").Scan(&c))
+	assert.Equal(t, 30, c)
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _replnullt1_new").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestReplClientFlushFailurePolicy(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The new table has a constraint that one of the rows violates.
+	setup := func() (*table.TableInfo, *table.TableInfo) {
+		testutils.RunSQL(t, "DROP TABLE IF EXISTS replfailt1, _replfailt1_new")
+		testutils.RunSQL(t, "CREATE TABLE replfailt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+		testutils.RunSQL(t, "CREATE TABLE _replfailt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a), CONSTRAINT b_max CHECK (b < 100))")
+		testutils.RunSQL(t, "INSERT INTO replfailt1 VALUES (1, 1), (2, 2), (3, 300), (4, 4), (5, 5)")
+		t1 := table.NewTableInfo(db, "test", "replfailt1")
+		assert.NoError(t, t1.SetInfo(context.TODO()))
+		t2 := table.NewTableInfo(db, "test", "_replfailt1_new")
+		assert.NoError(t, t2.SetInfo(context.TODO()))
+		return t1, t2
+	}
+	newClient := func(t1, t2 *table.TableInfo, policy FlushFailurePolicy) *Client {
+		cfg := NewClientDefaultConfig()
+		cfg.FlushFailurePolicy = policy
+		client := NewClient(db, testutils.DSN(), t1, t2, "", "", cfg)
+		var keys []string
+		for a := 1; a <= 5; a++ {
+			keys = append(keys, client.hashKey([]interface{}{a}))
+		}
+		client.keysHaveChanged(keys, false)
+		return client
+	}
+	countRows := func() int {
+		var count int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _replfailt1_new").Scan(&count))
+		return count
+	}
+
+	// By default the whole batch fails.
+	t1, t2 := setup()
+	client := newClient(t1, t2, "")
+	assert.ErrorContains(t, client.Flush(context.TODO()), "b_max")
+	assert.Equal(t, 0, countRows())
+
+	// Retrying the keys individually identifies the key that fails.
+	t1, t2 = setup()
+	client = newClient(t1, t2, FlushFailurePolicyRetry)
+	err = client.Flush(context.TODO())
+	assert.ErrorContains(t, err, "could not apply key '3'")
+	assert.ErrorContains(t, err, "b_max")
+	assert.Empty(t, client.SkippedKeys())
+
+	// Skipping applies the other keys, and reports the key that failed.
+	t1, t2 = setup()
+	client = newClient(t1, t2, FlushFailurePolicySkip)
+	assert.NoError(t, client.Flush(context.TODO()))
+	assert.Equal(t, 4, countRows())
+	assert.Equal(t, []string{"'3'"}, client.SkippedKeys())
+	select {
+	case err := <-client.Errors():
+		assert.ErrorContains(t, err, "skipped key '3'")
+	default:
+		t.Fatal("the skipped key was not reported")
+	}
+}
+
+func TestSkipKeysNotUnderLock(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "skiplockt1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_skiplockt1_new")
+	t2.Columns = []string{"a", "b"}
+	cfg := NewClientDefaultConfig()
+	cfg.FlushFailurePolicy = FlushFailurePolicySkip
+	client := NewClient(nil, "", t1, t2, "", "", cfg)
+
+	// Every statement for key 3 fails.
+	key := client.hashKey([]interface{}{3})
+	s := client.createReplaceStmt([]string{key})
+	exec := func(ctx context.Context, stmts ...string) error {
+		return errors.New("Check constraint 'b_max' is violated.")
+	}
+
+	// The key is not skipped under the table lock.
+	err := client.execStatementsIndividually(context.TODO(), []statement{s}, errors.New("batch failed"), true, exec)
+	assert.ErrorContains(t, err, "could not apply key '3'")
+	assert.Empty(t, client.SkippedKeys())
+
+	err = client.execStatementsIndividually(context.TODO(), []statement{s}, errors.New("batch failed"), false, exec)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"'3'"}, client.SkippedKeys())
+}
+
+func TestOnRowTrackActions(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "trackactionst1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_trackactionst1_new")
+	config := NewClientDefaultConfig()
+	config.TrackActions = []string{canal.InsertAction}
+	client := NewClient(nil, "", t1, t2, "", "", config)
+
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{1, "a"}, {2, "b"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.UpdateAction, Rows: [][]interface{}{{3, "a"}, {3, "b"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.DeleteAction, Rows: [][]interface{}{{1, "a"}}}))
+	assert.Equal(t, map[string]bool{
+		client.hashKey([]interface{}{1}): false,
+		client.hashKey([]interface{}{2}): false,
+	}, client.binlogChangeset)
+	assert.Equal(t, int64(2), client.Status().RowEvents)
+
+	// Unknown actions still fail the client.
+	assert.EqualError(t, client.OnRow(&canal.RowsEvent{Action: "truncate", Rows: [][]interface{}{{1, "a"}}}), "unknown action: truncate")
+	assert.ErrorContains(t, client.Err(), "unknown action: truncate")
+
+	// The queue is filtered too.
+	config.TrackActions = []string{canal.UpdateAction, canal.DeleteAction}
+	client = NewClient(nil, "", t1, t2, "", "", config)
+	client.disableDeltaMap = true
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{1, "a"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.UpdateAction, Rows: [][]interface{}{{2, "a"}, {2, "b"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.DeleteAction, Rows: [][]interface{}{{3, "a"}}}))
+	assert.Equal(t, []queuedChange{
+		{key: client.hashKey([]interface{}{2}), isDelete: false},
+		{key: client.hashKey([]interface{}{3}), isDelete: true},
+	}, client.queuedChanges)
+}
+
+func TestOnRowUnknownAction(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "unknownactiont1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_unknownactiont1_new")
+
+	// By default the unknown action fails the client.
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	assert.EqualError(t, client.OnRow(&canal.RowsEvent{Action: "truncate", Rows: [][]interface{}{{1, "a"}}}), "unknown action: truncate")
+	assert.ErrorIs(t, client.Err(), ErrCanalFailed)
+	assert.ErrorContains(t, client.Err(), "unknown action: truncate")
+	select {
+	case <-client.Failed():
+	default:
+		t.Fatal("client has not failed")
+	}
+
+	// When it is skipped it is counted instead, and sent with the metrics.
+	sink := &testMetricsSink{}
+	config := NewClientDefaultConfig()
+	config.UnknownActionPolicy = UnknownActionPolicySkip
+	config.MetricsSink = sink
+	client = NewClient(nil, "", t1, t2, "", "", config)
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: "truncate", Rows: [][]interface{}{{1, "a"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: "truncate", Rows: [][]interface{}{{2, "b"}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{3, "c"}}}))
+	select {
+	case err := <-client.Errors():
+		t.Fatalf("unexpected error: %v", err)
+	default:
+	}
+	assert.Equal(t, map[string]bool{client.hashKey([]interface{}{3}): false}, client.binlogChangeset)
+	assert.NoError(t, client.Err())
+	client.sendEventMetrics(context.Background(), 0, 10*time.Second)
+	assert.Contains(t, sink.values, metrics.MetricValue{Name: metrics.BinlogUnknownActionsMetricName, Type: metrics.COUNTER, Value: 2})
+	// The counter is sent as the events since the last send.
+	sink.values = nil
+	client.sendEventMetrics(context.Background(), 0, 10*time.Second)
+	assert.Contains(t, sink.values, metrics.MetricValue{Name: metrics.BinlogUnknownActionsMetricName, Type: metrics.COUNTER, Value: 0})
+}
+
+func TestOnRowKeyRange(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS keyranget1, _keyranget1_new")
+	testutils.RunSQL(t, "CREATE TABLE keyranget1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _keyranget1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	t1 := table.NewTableInfo(db, "test", "keyranget1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_keyranget1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	// Changes to rows outside of the range are not added to the changeset,
+	// so they are never applied to the new table.
+	keyRange, err := table.NewKeyRange(t1, "1000", "2000")
+	assert.NoError(t, err)
+	config := NewClientDefaultConfig()
+	config.KeyRange = keyRange
+	client := NewClient(db, "", t1, t2, "", "", config)
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.InsertAction, Rows: [][]interface{}{{int32(999), 1}, {int32(1000), 1}, {int32(1999), 1}, {int32(2000), 1}}}))
+	assert.NoError(t, client.OnRow(&canal.RowsEvent{Action: canal.DeleteAction, Rows: [][]interface{}{{int32(5), 1}}}))
+	assert.Equal(t, map[string]bool{
+		client.hashKey([]interface{}{int32(1000)}): false,
+		client.hashKey([]interface{}{int32(1999)}): false,
+	}, client.binlogChangeset)
+}
+
+func TestReprocessIgnoresKeys(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS reprocesskeyst1, _reprocesskeyst1_new")
+	testutils.RunSQL(t, "CREATE TABLE reprocesskeyst1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _reprocesskeyst1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	t1 := table.NewTableInfo(db, "test", "reprocesskeyst1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_reprocesskeyst1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	// Like the primary subscription, keys outside of the range
+	// and keys above the watermark of the copier are not re-applied.
+	keyRange, err := table.NewKeyRange(t1, "1000", "2000")
+	assert.NoError(t, err)
+	config := NewClientDefaultConfig()
+	config.KeyRange = keyRange
+	client := NewClient(db, "", t1, t2, "", "", config)
+	client.KeyAboveCopierCallback = func(key interface{}) bool { return key.(int32) >= 1500 }
+	client.SetKeyAboveWatermarkOptimization(true)
+	handler := &reprocessHandler{client: client, keys: make(map[string]struct{})}
+	assert.NoError(t, handler.OnRow(&canal.RowsEvent{Action: canal.UpdateAction, Rows: [][]interface{}{
+		{int32(999), 1}, {int32(999), 2},
+		{int32(1000), 1}, {int32(1000), 2},
+		{int32(1500), 1}, {int32(1500), 2},
+		{int32(2000), 1}, {int32(2000), 2},
+	}}))
+	assert.Equal(t, []string{client.hashKey([]interface{}{int32(1000)})}, handler.changedKeys())
+}
+
+func TestClientErrors(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "clienterrt1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_clienterrt1_new")
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+
+	// Errors that canal logs and recovers from are observable.
+	logWrapper := NewLogWrapper(logrus.New())
+	logWrapper.onError = client.reportError
+	logWrapper.Errorf("retry sync err: %v, wait 1s and retry again", errors.New("connection reset by peer"))
+	// The noisy error on close is not an error.
+	logWrapper.Errorf("canal start sync binlog err: %v", "Sync was closed")
+	assert.EqualError(t, <-client.Errors(), "retry sync err: connection reset by peer, wait 1s and retry again")
+
+	assert.Empty(t, client.Errors())
+
+	// Errors are dropped when the channel is full.
+	for range errorsCapacity + 10 {
+		logWrapper.Error("read initial handshake error")
+	}
+	assert.Len(t, client.Errors(), errorsCapacity)
+
+	// Close closes the channel, and errors after it are discarded.
+	client.Close()
+	logWrapper.Error("read initial handshake error")
+	var count int
+	for range client.Errors() {
+		count++
+	}
+	assert.Equal(t, errorsCapacity, count)
+}
+
+// BenchmarkOnRow compares adding the keys of a rows event to the changeset
+// under a single lock, to taking the lock for each row. Other goroutines
+// contend for the lock, as GetDeltaLen does while a migration is running.
+func BenchmarkOnRow(b *testing.B) {
+	t1 := table.NewTableInfo(nil, "test", "onrowbencht1")
+	t1.Columns = []string{"a", "b"}
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_onrowbencht1_new")
+	rows := make([][]interface{}, 100)
+	for i := range rows {
+		rows[i] = []interface{}{i, "us-west-2"}
+	}
+	e := &canal.RowsEvent{Action: canal.InsertAction, Rows: rows}
+	b.Run("batched", func(b *testing.B) {
+		client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := client.OnRow(e); err != nil {
+					b.Fatal(err)
+				}
+				client.GetDeltaLen()
+			}
+		})
+	})
+	b.Run("per-row", func(b *testing.B) {
+		client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				keys, err := client.rowsEventKeys(e)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, key := range keys {
+					client.keyHasChanged(key, false)
+				}
+				client.GetDeltaLen()
+			}
+		})
+	})
+}
+
 func TestFeedback(t *testing.T) {
 	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
 	assert.NoError(t, err)
@@ -413,3 +1645,216 @@ func TestBlockWait(t *testing.T) {
 	testutils.RunSQL(t, "ANALYZE TABLE blockwaitt1")
 	assert.NoError(t, client.BlockWait(ctx)) // should be quick
 }
+
+func TestReplClientForcePrimaryIndex(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "forceindext1")
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_forceindext1_new")
+	t2.KeyColumns = []string{"a"}
+	keys := []string{utils.HashKey([]interface{}{1})}
+
+	config := NewClientDefaultConfig()
+	client := NewClient(nil, "", t1, t2, "", "", config)
+	assert.Contains(t, client.createReplaceStmt(keys).stmt, "FROM `test`.`forceindext1` FORCE INDEX (PRIMARY) WHERE")
+
+	config.DisableForcePrimaryIndex = true
+	client = NewClient(nil, "", t1, t2, "", "", config)
+	stmt := client.createReplaceStmt(keys).stmt
+	assert.Contains(t, stmt, "FROM `test`.`forceindext1` WHERE (`a`) IN ('1')")
+	assert.NotContains(t, stmt, "FORCE INDEX")
+}
+
+func TestReplClientColumns(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "columnst1")
+	t1.KeyColumns = []string{"a"}
+	t1.NonGeneratedColumns = []string{"a", "b", "c"}
+	t2 := table.NewTableInfo(nil, "test", "_columnst1_new")
+	t2.KeyColumns = []string{"a"}
+	t2.NonGeneratedColumns = []string{"a", "c", "d"}
+	keys := []string{utils.HashKey([]interface{}{1})}
+
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	assert.Equal(t, utils.IntersectNonGeneratedColumns(t1, t2), client.columns)
+	assert.Equal(t, "REPLACE INTO `test`.`_columnst1_new` (`a`, `c`) SELECT `a`, `c` FROM `test`.`columnst1` FORCE INDEX (PRIMARY) WHERE (`a`) IN ('1')",
+		client.createReplaceStmt(keys).stmt)
+}
+
+func TestReplClientQueryComment(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "commentt1")
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_commentt1_new")
+	t2.KeyColumns = []string{"a"}
+	keys := []string{utils.HashKey([]interface{}{1})}
+
+	client := NewClient(nil, "", t1, t2, "", "", NewClientDefaultConfig())
+	assert.True(t, strings.HasPrefix(client.createReplaceStmt(keys).stmt, "REPLACE INTO"))
+	assert.True(t, strings.HasPrefix(client.createDeleteStmt(keys).stmt, "DELETE FROM"))
+
+	config := NewClientDefaultConfig()
+	config.QueryComment = "spirit migration=123 table={table} chunk={chunk} */"
+	client = NewClient(nil, "", t1, t2, "", "", config)
+	comment := "/* spirit migration=123 table=`test`.`commentt1` chunk=flush * / */ "
+	assert.True(t, strings.HasPrefix(client.createReplaceStmt(keys).stmt, comment+"REPLACE INTO `test`.`_commentt1_new`"))
+	assert.True(t, strings.HasPrefix(client.createDeleteStmt(keys).stmt, comment+"DELETE FROM `test`.`_commentt1_new`"))
+}
+
+func TestReplClientFinalPosition(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS replfinalpost1, _replfinalpost1_new")
+	testutils.RunSQL(t, "CREATE TABLE replfinalpost1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _replfinalpost1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+
+	t1 := table.NewTableInfo(db, "test", "replfinalpost1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_replfinalpost1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	client := NewClient(db, cfg.Addr, t1, t1new, cfg.User, cfg.Passwd, NewClientDefaultConfig())
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	// There is no final position before a flush under the table lock.
+	_, ok := client.FinalPosition()
+	assert.False(t, ok)
+
+	testutils.RunSQL(t, "INSERT INTO replfinalpost1 VALUES (1, 1), (2, 2)")
+	posOfInsert, err := client.getCurrentBinlogPosition()
+	assert.NoError(t, err)
+
+	lock, err := dbconn.NewTableLock(context.TODO(), db, t1, t1new, dbconn.NewDBConfig(), logrus.New())
+	assert.NoError(t, err)
+	assert.NoError(t, client.FlushUnderTableLock(context.TODO(), lock))
+	assert.NoError(t, lock.Close())
+
+	// The final position is the position that was applied, which
+	// includes the last change to the table.
+	final, ok := client.FinalPosition()
+	assert.True(t, ok)
+	assert.Equal(t, client.GetBinlogApplyPosition(), final.Position)
+	assert.GreaterOrEqual(t, final.Position.Compare(posOfInsert), 0)
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _replfinalpost1_new").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestSetFinalPositionBestEffort(t *testing.T) {
+	// The control connection is unreachable.
+	controlDB, err := sql.Open("mysql", "root@tcp(127.0.0.1:1)/test")
+	assert.NoError(t, err)
+	defer controlDB.Close()
+
+	t1 := table.NewTableInfo(nil, "test", "finalpost1")
+	t2 := table.NewTableInfo(nil, "test", "_finalpost1_new")
+	cfg := NewClientDefaultConfig()
+	cfg.ControlDB = controlDB
+	client := NewClient(nil, "", t1, t2, "", "", cfg)
+	client.SetPos(mysql.Position{Name: "binlog.000001", Pos: 4})
+
+	// The position is still recorded, without the GTID set.
+	client.setFinalPosition(context.TODO())
+	final, ok := client.FinalPosition()
+	assert.True(t, ok)
+	assert.Equal(t, mysql.Position{Name: "binlog.000001", Pos: 4}, final.Position)
+	assert.Empty(t, final.GTIDSet)
+}
+
+func TestApplyOrder(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "applyordert1")
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_applyordert1_new")
+	config := NewClientDefaultConfig()
+	client := NewClient(nil, "", t1, t2, "", "", config)
+	client.targetBatchSize = 2
+	for i := range 3 {
+		client.keyHasChanged([]interface{}{i}, false)
+		client.keyHasChanged([]interface{}{10 + i}, true)
+	}
+
+	// The deletes are applied first by default.
+	stmts := client.changesetToStatements(client.binlogChangeset)
+	assert.Equal(t, []bool{true, true, false, false}, []bool{stmts[0].isDelete, stmts[1].isDelete, stmts[2].isDelete, stmts[3].isDelete})
+	phases := statementPhases(stmts)
+	assert.Len(t, phases, 2)
+	assert.Equal(t, stmts[:2], phases[0])
+	assert.Equal(t, stmts[2:], phases[1])
+
+	client.applyOrder = ApplyOrderReplacesFirst
+	stmts = client.changesetToStatements(client.binlogChangeset)
+	assert.Equal(t, []bool{false, false, true, true}, []bool{stmts[0].isDelete, stmts[1].isDelete, stmts[2].isDelete, stmts[3].isDelete})
+	assert.Contains(t, stmts[0].stmt, "REPLACE INTO")
+	assert.Len(t, statementPhases(stmts), 2)
+
+	// A changeset of only one kind has one phase.
+	assert.Len(t, statementPhases(stmts[:2]), 1)
+	assert.Empty(t, statementPhases(nil))
+}
+
+func TestApplyOrderFinalState(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+
+	// Each interleaving of changes is applied in a single flush. The unique
+	// index means that the order of a delete and a replace of different
+	// rows could conflict, i.e. a value that moves from one row to another.
+	interleavings := map[string][]string{
+		"move a unique value to a new row": {
+			"DELETE FROM applyordert1 WHERE a = 1",
+			"INSERT INTO applyordert1 VALUES (10, 1)",
+		},
+		"swap unique values through a deleted row": {
+			"DELETE FROM applyordert1 WHERE a = 2",
+			"UPDATE applyordert1 SET b = 2 WHERE a = 3",
+			"INSERT INTO applyordert1 VALUES (11, 3)",
+		},
+		"delete and re-insert a row with another value": {
+			"DELETE FROM applyordert1 WHERE a = 4",
+			"INSERT INTO applyordert1 VALUES (4, 40)",
+			"UPDATE applyordert1 SET b = 4 WHERE a = 5",
+		},
+		"insert, update and delete the same row": {
+			"INSERT INTO applyordert1 VALUES (12, 12)",
+			"UPDATE applyordert1 SET b = 13 WHERE a = 12",
+			"DELETE FROM applyordert1 WHERE a = 12",
+			"UPDATE applyordert1 SET b = 12 WHERE a = 1",
+		},
+	}
+	rows := func(tbl string) string {
+		var rows string
+		assert.NoError(t, db.QueryRow(fmt.Sprintf("SELECT IFNULL(GROUP_CONCAT(CONCAT(a, ':', b) ORDER BY a), '') FROM %s", tbl)).Scan(&rows))
+		return rows
+	}
+	for _, order := range []ApplyOrder{ApplyOrderDeletesFirst, ApplyOrderReplacesFirst} {
+		for name, stmts := range interleavings {
+			testutils.RunSQL(t, "DROP TABLE IF EXISTS applyordert1, _applyordert1_new")
+			testutils.RunSQL(t, "CREATE TABLE applyordert1 (a INT NOT NULL, b INT NOT NULL, PRIMARY KEY (a), UNIQUE KEY (b))")
+			testutils.RunSQL(t, "CREATE TABLE _applyordert1_new (a INT NOT NULL, b INT NOT NULL, PRIMARY KEY (a), UNIQUE KEY (b))")
+			testutils.RunSQL(t, "INSERT INTO applyordert1 VALUES (1, 1), (2, 2), (3, 3), (4, 4), (5, 5)")
+			testutils.RunSQL(t, "INSERT INTO _applyordert1_new SELECT * FROM applyordert1")
+
+			t1 := table.NewTableInfo(db, "test", "applyordert1")
+			assert.NoError(t, t1.SetInfo(context.TODO()))
+			t2 := table.NewTableInfo(db, "test", "_applyordert1_new")
+			assert.NoError(t, t2.SetInfo(context.TODO()))
+			config := NewClientDefaultConfig()
+			config.ApplyOrder = order
+			client := NewClient(db, cfg.Addr, t1, t2, cfg.User, cfg.Passwd, config)
+			assert.NoError(t, client.Run())
+
+			for _, stmt := range stmts {
+				testutils.RunSQL(t, stmt)
+			}
+			assert.NoError(t, client.BlockWait(context.TODO()))
+			assert.NoError(t, client.flush(context.TODO(), false, nil), "%s: %s", order, name)
+			assert.Equal(t, rows("applyordert1"), rows("_applyordert1_new"), "%s: %s", order, name)
+			client.Close()
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package repl
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/siddontang/loggers"
 )
@@ -20,6 +22,15 @@ var _ loggers.Advanced = &LogWrapper{}
 
 type LogWrapper struct {
 	logger loggers.Advanced
+	// onError is called with each error that is logged, so that
+	// errors canal recovers from internally are observable.
+	onError func(error)
+}
+
+func (c *LogWrapper) reportError(err error) {
+	if c.onError != nil {
+		c.onError(err)
+	}
 }
 
 func (c *LogWrapper) Debugf(format string, args ...interface{}) {
@@ -48,6 +59,7 @@ func (c *LogWrapper) Errorf(format string, args ...interface{}) {
 		}
 	}
 	c.logger.Errorf(format, args...)
+	c.reportError(fmt.Errorf(format, args...))
 }
 
 func (c *LogWrapper) Fatalf(format string, args ...interface{}) {
@@ -68,6 +80,7 @@ func (c *LogWrapper) Warn(args ...interface{}) {
 
 func (c *LogWrapper) Error(args ...interface{}) {
 	c.logger.Error(args...)
+	c.reportError(errors.New(fmt.Sprint(args...)))
 }
 
 func (c *LogWrapper) Fatal(args ...interface{}) {
@@ -88,6 +101,7 @@ func (c *LogWrapper) Warnln(args ...interface{}) {
 
 func (c *LogWrapper) Errorln(args ...interface{}) {
 	c.logger.Errorln(args...)
+	c.reportError(errors.New(strings.TrimSuffix(fmt.Sprintln(args...), "\n")))
 }
 
 func (c *LogWrapper) Fatalln(args ...interface{}) {
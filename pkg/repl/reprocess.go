@@ -0,0 +1,144 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"golang.org/x/sync/errgroup"
+)
+
+// reprocessHandler collects the keys modified in a range of the binary log.
+type reprocessHandler struct {
+	canal.DummyEventHandler
+	sync.Mutex
+	client   *Client
+	until    mysql.Position
+	keys     map[string]struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (h *reprocessHandler) OnRow(e *canal.RowsEvent) error {
+	keys, err := h.client.rowsEventKeys(e)
+	if err != nil {
+		return err
+	}
+	keyAboveWatermarkEnabled := h.client.KeyAboveWatermarkEnabled()
+	h.Lock()
+	defer h.Unlock()
+	for _, key := range keys {
+		if h.client.ignoreKey(key, keyAboveWatermarkEnabled) {
+			continue // the same as the primary subscription.
+		}
+		h.keys[h.client.hashKey(key)] = struct{}{}
+	}
+	return nil
+}
+
+// OnPosSynced is called at transaction boundaries. Once we have read
+// up to the until position we signal that we are done. Events from
+// a transaction that spans the until position may also be included.
+func (h *reprocessHandler) OnPosSynced(_ *replication.EventHeader, pos mysql.Position, _ mysql.GTIDSet, _ bool) error {
+	if pos.Compare(h.until) >= 0 {
+		h.doneOnce.Do(func() { close(h.done) })
+	}
+	return nil
+}
+
+func (h *reprocessHandler) changedKeys() []string {
+	h.Lock()
+	defer h.Unlock()
+	keys := make([]string, 0, len(h.keys))
+	for key := range h.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ReprocessFrom re-reads the binary log from pos up to until (or the current
+// position if until is nil) and re-applies every key that was modified in that
+// range to the new table. It is intended for when the subscription is suspected
+// of having missed or mishandled events, i.e. around a reconnect.
+//
+// A temporary binary log subscription is used, so the primary subscription
+// and its applied position are not affected.
+//
+// This relies on the changes being idempotent: keys are not replayed with the
+// values from the binary log, but are re-copied from the current state of the
+// source table. For each key, the row is deleted from the new table and then
+// copied again with REPLACE .. SELECT in the same transaction, so a key that no
+// longer exists in the source table is removed regardless of the order of events.
+// Any change made after this reads the source table will produce a new binary
+// log event, which the primary subscription will apply. Like the primary
+// subscription, keys outside of the KeyRange and keys above the watermark of
+// the copier are not re-applied.
+func (c *Client) ReprocessFrom(ctx context.Context, pos *mysql.Position, until *mysql.Position) error {
+	if pos == nil {
+		return errors.New("reprocess position must be non-nil")
+	}
+	if until == nil {
+		current, err := c.getCurrentBinlogPosition()
+		if err != nil {
+			return fmt.Errorf("failed to get binlog position: %w", err)
+		}
+		until = &current
+	}
+	if pos.Compare(*until) >= 0 {
+		return nil // nothing to reprocess
+	}
+	if err := c.binlogPositionIsImpossible(*pos); err != nil {
+		return err
+	}
+	reprocessCanal, err := c.newCanal()
+	if err != nil {
+		return err
+	}
+	handler := &reprocessHandler{
+		client: c,
+		until:  *until,
+		keys:   make(map[string]struct{}),
+		done:   make(chan struct{}),
+	}
+	reprocessCanal.SetEventHandler(handler)
+	c.logger.Infof("reprocessing binary log from %s to %s", pos, until)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- reprocessCanal.RunFrom(*pos)
+	}()
+	select {
+	case <-handler.done:
+		reprocessCanal.Close()
+	case <-ctx.Done():
+		reprocessCanal.Close()
+		return ctx.Err()
+	case err := <-runErr:
+		reprocessCanal.Close()
+		return fmt.Errorf("failed to reprocess binary log: %w", err)
+	}
+	keys := handler.changedKeys()
+	c.logger.Infof("reprocessing %d keys from binary log", len(keys))
+	return c.reapplyKeys(ctx, keys)
+}
+
+// reapplyKeys re-copies keys from the source table to the new table,
+// deleting any version of the row in the new table first. The keys are
+// applied in batches like a flush, within the limit of concurrent queries.
+func (c *Client) reapplyKeys(ctx context.Context, keys []string) error {
+	c.sortKeys(keys)
+	g, errGrpCtx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+	for batch := range slices.Chunk(keys, c.batchSize()) {
+		s := c.createReplaceStmt(batch)
+		s.before = c.createDeleteStmt(batch).stmt
+		g.Go(func() error {
+			return c.execStatement(errGrpCtx, s)
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Change is a change to a row of the table that was read from the binary log.
+// It only identifies the row: a consumer that needs the values of the row
+// reads them from the table by its key. Since changes are only published
+// after they have been read, the row may have been changed again since.
+type Change struct {
+	// Key is the value of each PRIMARY KEY column of the row.
+	Key []string
+	// IsDelete is true if the row was deleted, and false if it
+	// was inserted or updated.
+	IsDelete bool
+}
+
+// ChangeSink receives the changes to the table each time the replication
+// client flushes, i.e. to publish them to a message queue for change data
+// capture. The changes to a key are published in the order they were read
+// from the binary log. When the PRIMARY KEY is memory comparable, changes
+// to the same key are merged before they are flushed, so only the last
+// change to each key is published.
+//
+// Publish is called before the binary log position of the flush is
+// recorded as applied. If it returns an error the flush fails, so a change
+// may be published more than once when a migration is resumed.
+type ChangeSink interface {
+	Publish(ctx context.Context, changes []Change) error
+}
+
+// MessageSender sends a message to a queue, such as a Kafka topic or an SQS
+// queue. The key can be used to partition the messages, since messages
+// with the same key must be delivered in order.
+type MessageSender interface {
+	Send(ctx context.Context, key string, body []byte) error
+}
+
+// QueueSink is a ChangeSink that sends each change as a JSON
+// message with a MessageSender. For example:
+//
+//	{"schema":"test","table":"t1","key":["1"],"delete":false}
+type QueueSink struct {
+	Sender     MessageSender
+	SchemaName string
+	TableName  string
+}
+
+var _ ChangeSink = &QueueSink{}
+
+// queueMessage is the body of a message sent by QueueSink.
+type queueMessage struct {
+	Schema string   `json:"schema"`
+	Table  string   `json:"table"`
+	Key    []string `json:"key"`
+	Delete bool     `json:"delete"`
+}
+
+// Publish sends each of the changes in order. It stops at the first
+// error, so the changes before it may already have been sent.
+func (s *QueueSink) Publish(ctx context.Context, changes []Change) error {
+	for _, change := range changes {
+		body, err := json.Marshal(queueMessage{
+			Schema: s.SchemaName,
+			Table:  s.TableName,
+			Key:    change.Key,
+			Delete: change.IsDelete,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.Sender.Send(ctx, strings.Join(change.Key, ","), body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishChanges publishes the changes to the ChangeSink, if there is one.
+func (c *Client) publishChanges(ctx context.Context, changes []queuedChange) error {
+	if c.changeSink == nil || len(changes) == 0 {
+		return nil
+	}
+	published := make([]Change, 0, len(changes))
+	for _, change := range changes {
+		published = append(published, Change{Key: c.splitKey(change.key), IsDelete: change.isDelete})
+	}
+	return c.changeSink.Publish(ctx, published)
+}
+
+// changesetToChanges returns the changes in a changeset of the delta
+// map, in PRIMARY KEY order. Each key is only changed once.
+func (c *Client) changesetToChanges(changeset map[string]bool) []queuedChange {
+	keys := make([]string, 0, len(changeset))
+	for key := range changeset {
+		keys = append(keys, key)
+	}
+	c.sortKeys(keys)
+	changes := make([]queuedChange, 0, len(keys))
+	for _, key := range keys {
+		changes = append(changes, queuedChange{key: key, isDelete: changeset[key]})
+	}
+	return changes
+}
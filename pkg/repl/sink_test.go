@@ -0,0 +1,133 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	mysql2 "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// memorySink records the changes that are published to it.
+type memorySink struct {
+	sync.Mutex
+	changes []Change
+}
+
+func (s *memorySink) Publish(_ context.Context, changes []Change) error {
+	s.Lock()
+	defer s.Unlock()
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+// memorySender records the messages that are sent with it.
+type memorySender struct {
+	keys   []string
+	bodies []string
+	err    error
+}
+
+func (s *memorySender) Send(_ context.Context, key string, body []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.keys = append(s.keys, key)
+	s.bodies = append(s.bodies, string(body))
+	return nil
+}
+
+func TestQueueSink(t *testing.T) {
+	sender := &memorySender{}
+	sink := &QueueSink{Sender: sender, SchemaName: "test", TableName: "t1"}
+	assert.NoError(t, sink.Publish(context.Background(), []Change{
+		{Key: []string{"1"}},
+		{Key: []string{"2", "abc"}, IsDelete: true},
+	}))
+	assert.Equal(t, []string{"1", "2,abc"}, sender.keys)
+	assert.Equal(t, []string{
+		`{"schema":"test","table":"t1","key":["1"],"delete":false}`,
+		`{"schema":"test","table":"t1","key":["2","abc"],"delete":true}`,
+	}, sender.bodies)
+
+	sender.err = errors.New("queue is unavailable")
+	assert.EqualError(t, sink.Publish(context.Background(), []Change{{Key: []string{"3"}}}), "queue is unavailable")
+}
+
+func TestReplClientChangeSink(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	cfg, err := mysql2.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+
+	// The PRIMARY KEY is not memory comparable, so every change is
+	// queued, and published in the order it was read. The changes
+	// are only published, and not applied to the new table.
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS sinkt1, _sinkt1_new")
+	testutils.RunSQL(t, "CREATE TABLE sinkt1 (a VARCHAR(255) NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _sinkt1_new (a VARCHAR(255) NOT NULL, b INT, PRIMARY KEY (a))")
+	t1 := table.NewTableInfo(db, "test", "sinkt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_sinkt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+	sink := &memorySink{}
+	config := NewClientDefaultConfig()
+	config.ChangeSink = sink
+	config.PublishOnly = true
+	client := NewClient(db, cfg.Addr, t1, t1new, cfg.User, cfg.Passwd, config)
+	assert.NoError(t, client.Run())
+	defer client.Close()
+
+	testutils.RunSQL(t, "INSERT INTO sinkt1 VALUES ('a', 1)")
+	testutils.RunSQL(t, "UPDATE sinkt1 SET b = 2 WHERE a = 'a'")
+	testutils.RunSQL(t, "DELETE FROM sinkt1 WHERE a = 'a'")
+	testutils.RunSQL(t, "INSERT INTO sinkt1 VALUES ('b', 1)")
+	testutils.RunSQL(t, "INSERT INTO sinkt1 VALUES ('a', 3)")
+	assert.NoError(t, client.BlockWait(context.TODO()))
+	assert.NoError(t, client.Flush(context.TODO()))
+	assert.Equal(t, []Change{
+		{Key: []string{"a"}},
+		{Key: []string{"a"}},
+		{Key: []string{"a"}, IsDelete: true},
+		{Key: []string{"b"}},
+		{Key: []string{"a"}},
+	}, sink.changes)
+	assert.True(t, client.AllChangesFlushed())
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _sinkt1_new").Scan(&count))
+	assert.Equal(t, 0, count)
+
+	// With the delta map the last change to each key is published in
+	// PRIMARY KEY order, and the changes are also applied.
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS sinkt2, _sinkt2_new")
+	testutils.RunSQL(t, "CREATE TABLE sinkt2 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _sinkt2_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	t2 := table.NewTableInfo(db, "test", "sinkt2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+	t2new := table.NewTableInfo(db, "test", "_sinkt2_new")
+	assert.NoError(t, t2new.SetInfo(context.TODO()))
+	sink = &memorySink{}
+	config = NewClientDefaultConfig()
+	config.ChangeSink = sink
+	client2 := NewClient(db, cfg.Addr, t2, t2new, cfg.User, cfg.Passwd, config)
+	assert.NoError(t, client2.Run())
+	defer client2.Close()
+
+	testutils.RunSQL(t, "INSERT INTO sinkt2 VALUES (10, 1), (9, 1), (8, 1)")
+	testutils.RunSQL(t, "DELETE FROM sinkt2 WHERE a = 9")
+	assert.NoError(t, client2.BlockWait(context.TODO()))
+	assert.NoError(t, client2.Flush(context.TODO()))
+	assert.Equal(t, []Change{
+		{Key: []string{"8"}},
+		{Key: []string{"9"}, IsDelete: true},
+		{Key: []string{"10"}},
+	}, sink.changes)
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _sinkt2_new").Scan(&count))
+	assert.Equal(t, 2, count)
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -17,6 +18,7 @@ import (
 	"github.com/cashapp/spirit/pkg/metrics"
 
 	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/dbconn/sqlescape"
 	"github.com/cashapp/spirit/pkg/table"
 	"github.com/cashapp/spirit/pkg/throttler"
 	"github.com/cashapp/spirit/pkg/utils"
@@ -26,8 +28,9 @@ import (
 )
 
 const (
-	copyEstimateInterval   = 10 * time.Second // how frequently to re-estimate copy speed
-	copyETAInitialWaitTime = 1 * time.Minute  // how long to wait before first estimating copy speed (to allow for fast start)
+	copyEstimateInterval   = 10 * time.Second       // how frequently to re-estimate copy speed
+	copyETAInitialWaitTime = 1 * time.Minute        // how long to wait before first estimating copy speed (to allow for fast start)
+	warmUpCheckInterval    = 100 * time.Millisecond // how frequently to check for a free slot during warm-up
 )
 
 type Copier struct {
@@ -37,11 +40,15 @@ type Copier struct {
 	newTable             *table.TableInfo
 	chunker              table.Chunker
 	concurrency          int
-	finalChecksum        bool
+	checksumMode         ChecksumMode
 	CopyRowsStartTime    time.Time
 	CopyRowsExecTime     time.Duration
 	CopyRowsCount        uint64 // used for estimates: the exact number of rows copied
 	CopyRowsLogicalCount uint64 // used for estimates on auto-inc PKs: rows copied including any gaps
+	CopyRowsIgnoredCount uint64 // rows not inserted by INSERT IGNORE, i.e. duplicates or gaps in the key
+	CopyBytesCount       uint64 // approximate: rows copied multiplied by the average row length
+	CopyRetriesCount     uint64 // retried copy transactions
+	ThrottleWaitTime     int64  // total time chunks were blocked by the throttler, in nanoseconds
 	CopyChunksCount      uint64
 	rowsPerSecond        uint64
 	isInvalid            bool
@@ -53,16 +60,204 @@ type Copier struct {
 	logger               loggers.Advanced
 	metricsSink          metrics.Sink
 	copierEtaHistory     *copierEtaHistory
+	isPaused             atomic.Bool
+	isSchedulePaused     atomic.Bool
+	schedule             []TimeWindow
+	clock                func() time.Time
+	slowChunkThreshold   time.Duration
+	chunkTimes           *metrics.QuantileSketch // processing time of each chunk, in milliseconds
+	forcePrimaryIndex    bool
+	backgroundLoops      sync.WaitGroup // estimate and schedule loops started by Run
+	ignoredRowsThreshold float64
+	ignoredRowsCount     uint64 // rows not inserted by INSERT IGNORE, outside of the resume window
+	expectedRowsCount    uint64 // rows expected to be inserted, outside of the resume window
+	resumeChunksLeft     int64  // chunks that may overlap with work done before resuming
+	ignoredRowsExceeded  atomic.Bool
+	connLimiter          *dbconn.ConnLimiter
+	incrementalColumn    string
+	since                string         // the lower bound of incrementalColumn for this pass
+	nextSince            sql.NullString // the max of incrementalColumn when this pass started
+	warmUp               time.Duration
+	queryComment         string
+	prefetch             bool
+	prefetchCount        uint64 // ranges that were prefetched successfully
+	targetChunkTime      time.Duration
+	maxLoad              map[string]uint64
+	criticalLoad         map[string]uint64
+	loadStatus           func(ctx context.Context, vars []string) (map[string]uint64, error)
+	splitChunks          bool
+	execChunk            func(ctx context.Context, chunk *table.Chunk) (int64, error)
+	splitChunksCount     uint64 // chunks that were split after a failure, used by tests
+	consistentSnapshot   bool
+	snapshotCommitted    atomic.Bool
+	maxRowsToCopy        uint64
+	chunkLockWaitTimeout int
+	onChunkError         func(chunk *table.Chunk, err error, willRetry bool)
+	explainPolicy        ExplainPolicy
+	config               *CopierConfig // used to resume from a checkpoint by SupervisedRun
+	columns              string        // the columns in both tables, see utils.IntersectNonGeneratedColumns
 }
 
+// ChecksumMode is how the new table is verified after the copy,
+// before cutover.
+type ChecksumMode string
+
+const (
+	// ChecksumModeFull compares a checksum of the values of every row in
+	// each chunk of the tables. It finds any row that is missing, extra or
+	// different. It reads and hashes every column, which typically adds
+	// 10-20% to the time of the migration.
+	ChecksumModeFull ChecksumMode = "full"
+	// ChecksumModeRowCount compares the number of rows in each chunk of the
+	// tables. It finds rows that are missing or extra, such as the rows
+	// discarded by a new UNIQUE index, but not rows with different values.
+	// It still reads every row, but does not hash the columns.
+	ChecksumModeRowCount ChecksumMode = "row-count"
+	// ChecksumModeNone does not verify the new table. A duplicate key
+	// error from INSERT IGNORE then fails the copy instead.
+	ChecksumModeNone ChecksumMode = "none"
+)
+
 type CopierConfig struct {
 	Concurrency     int
 	TargetChunkTime time.Duration
-	FinalChecksum   bool
-	Throttler       throttler.Throttler
-	Logger          loggers.Advanced
-	MetricsSink     metrics.Sink
-	DBConfig        *dbconn.DBConfig
+	// ChecksumMode is how the new table is verified after the copy. Unless
+	// it is ChecksumModeNone, duplicate key errors from INSERT IGNORE are
+	// ignored, since the verification finds any rows that were discarded.
+	// Whether any differences that are found block the cutover is decided
+	// by the caller. If empty, the mode is derived from FinalChecksum.
+	ChecksumMode ChecksumMode
+	// FinalChecksum is used when ChecksumMode is empty: true is
+	// ChecksumModeFull and false is ChecksumModeNone.
+	//
+	// Deprecated: use ChecksumMode.
+	FinalChecksum bool
+	Throttler     throttler.Throttler
+	Logger        loggers.Advanced
+	MetricsSink   metrics.Sink
+	DBConfig      *dbconn.DBConfig
+	Schedule      []TimeWindow // windows in which copying is paused
+	MinChunkSize  uint64       // the dynamic chunk size will never shrink below this (0 = default)
+	MaxChunkSize  uint64       // the dynamic chunk size will never grow above this (0 = default)
+	// SlowChunkThreshold logs a warning for any chunk that takes longer
+	// than this to copy. Zero disables slow chunk logging.
+	SlowChunkThreshold time.Duration
+	// DisableForcePrimaryIndex removes FORCE INDEX (PRIMARY) from the copy
+	// query, which lets the optimizer choose. On some versions that
+	// produces a better plan. The hint is added by default.
+	DisableForcePrimaryIndex bool
+	// IgnoredRowsThreshold enables tracking of the ratio of rows that INSERT IGNORE
+	// did not insert, compared to the chunk sizes. A warning is logged if the
+	// ratio exceeds the threshold (i.e. 0.1 for 10%). Zero disables tracking.
+	IgnoredRowsThreshold float64
+	// NewestFirstKeyRange copies the newest keys first: the copy starts this
+	// many key values below the current maximum key, and continues to the end
+	// of the table. It then backfills from the start of the table. While the
+	// newest keys are copied, changes to older rows are discarded by the
+	// replication client, since the backfill has not yet read them. This is
+	// only supported for tables with an auto_increment primary key, and is not
+	// used when resuming from a checkpoint. Zero copies in ascending order.
+	NewestFirstKeyRange uint64
+	// ConnLimiter bounds the chunks that are copied concurrently together
+	// with other components that share it. Nil does not limit them.
+	ConnLimiter *dbconn.ConnLimiter
+	// IncrementalColumn copies only the rows where this column is greater
+	// than or equal to Since, i.e. an updated_at column. It is used to keep
+	// the new table in sync by polling when the binary log is not available.
+	// Rows are copied with REPLACE, so rows that are already in the new table
+	// are updated. Rows deleted from the table are not removed from the new
+	// table. After Run, Copier.Since returns the value to use for the next
+	// pass. Empty copies all rows.
+	IncrementalColumn string
+	// Since is the lower bound of IncrementalColumn. Empty copies all rows.
+	Since string
+	// WarmUp starts copying with a concurrency of 1, and increases it
+	// stepwise to Concurrency over this duration. This avoids a sudden
+	// spike in load, and gives the throttler time to engage.
+	// Zero starts at full concurrency.
+	WarmUp time.Duration
+	// QueryComment is prepended to each copy statement as a comment, so it
+	// can be identified by DBAs. The placeholders {table} and {chunk} are
+	// replaced by the table and the bounds of the chunk. Empty adds none.
+	QueryComment string
+	// Prefetch reads the keys of the range that follows each chunk while the
+	// chunk is copied, so that its pages are already in the buffer pool when
+	// the next chunk is copied. This helps when the table is much larger than
+	// the buffer pool and copy latency is dominated by reads from disk. It adds
+	// a second read of every row, so it is off by default. The prefetch is
+	// skipped while the throttler is engaged, and holds a slot of the
+	// ConnLimiter while it runs. Its duration is sent as the
+	// chunk_prefetch_time metric; compare chunk_processing_time with and
+	// without Prefetch to measure the effect on a given table.
+	Prefetch bool
+	// MaxLoad pauses copying while any of these global status variables
+	// (i.e. Threads_running) exceeds its threshold, like the --max-load
+	// option of pt-online-schema-change. Nil does not pause.
+	MaxLoad map[string]uint64
+	// CriticalLoad fails the copy with a CriticalLoadError when any of these
+	// global status variables exceeds its threshold, like the --critical-load
+	// option of pt-online-schema-change. Nil never fails.
+	CriticalLoad map[string]uint64
+	// SplitChunks splits a chunk in two when copying it fails with an error
+	// that indicates the transaction is too large, such as a lock wait timeout,
+	// and copies the two halves instead. The halves are split again if they
+	// also fail. Otherwise the chunk is retried unchanged, and may keep failing.
+	SplitChunks bool
+	// ConsistentSnapshot copies all chunks in a single REPEATABLE READ
+	// transaction started WITH CONSISTENT SNAPSHOT, with a savepoint per
+	// chunk. The new table is then exactly the table as of the start of the
+	// transaction, and the changes after it are in the binary log from the
+	// position at which it started. The transaction is held for the whole
+	// copy, which prevents purging undo logs and holds the locks on every
+	// row inserted into the new table until it commits. Changes applied to
+	// the new table by the replication client block on those locks, so they
+	// must not be flushed until Run returns. Chunks are copied one at a time
+	// regardless of Concurrency, and no low watermark is available until the
+	// copy has committed. Because of this it is limited to tables with at
+	// most ConsistentSnapshotMaxRows estimated rows.
+	ConsistentSnapshot bool
+	// ConsistentSnapshotMaxRows is the largest table (by estimated rows) that
+	// can be copied with ConsistentSnapshot. Zero uses the default of
+	// DefaultConsistentSnapshotMaxRows.
+	ConsistentSnapshotMaxRows uint64
+	// MaxRowsToCopy stops the copy once this many logical rows (the sum of
+	// the chunk sizes) have been copied, e.g. for smoke tests and staged
+	// rollouts. Chunks that are in flight are completed, so slightly more
+	// rows may be copied. The low watermark can then be used to resume the
+	// copy from a checkpoint. Zero copies all rows.
+	MaxRowsToCopy uint64
+	// ChunkLockWaitTimeout is the innodb_lock_wait_timeout in seconds of the
+	// statements that copy chunks, set with a SET_VAR optimizer hint (MySQL
+	// 8.0). A short timeout stops a chunk that reads rows locked by the
+	// application from holding its own locks while it waits, and the chunk is
+	// retried after a backoff, up to the MaxRetries of the DBConfig. Rows are
+	// never skipped: SKIP LOCKED is not used, since a skipped row would be
+	// missing from the new table. The source rows are only locked when the
+	// TransactionIsolation is repeatable-read. In read-committed, the default,
+	// they are read with a consistent read, and only locks in the new table
+	// are waited on. Zero uses the innodb_lock_wait_timeout of the connection.
+	ChunkLockWaitTimeout int
+	// OnChunkError is called with every error copying a chunk, including
+	// the errors of attempts that are retried. willRetry is true if the
+	// chunk is retried, or split and its halves are copied instead. It is
+	// called on the goroutine that copies the chunk, so it must not block.
+	// It may be nil.
+	OnChunkError func(chunk *table.Chunk, err error, willRetry bool)
+	// StartKey and EndKey copy only the rows where the first column of the
+	// PRIMARY KEY is at least StartKey and less than EndKey, i.e. to migrate
+	// part of a very large table. The column must be an integer. An empty
+	// value leaves that side of the range unbounded. The replication client
+	// must be given the same range (see repl.ClientConfig.KeyRange), so the
+	// changes to rows outside of it are not applied.
+	StartKey string
+	EndKey   string
+	// ExplainPolicy runs EXPLAIN on the query that reads a representative
+	// chunk before the first chunk is copied, and warns or fails if it does
+	// not use the PRIMARY KEY when it is forced, reads the whole table, or
+	// is estimated to examine many more rows than the chunk size. This
+	// catches a bad plan before a long and slow copy. It is not run when
+	// resuming from a checkpoint. Empty is ExplainPolicyOff.
+	ExplainPolicy ExplainPolicy
 }
 
 // NewCopierDefaultConfig returns a default config for the copier.
@@ -90,23 +285,107 @@ func NewCopier(db *sql.DB, tbl, newTable *table.TableInfo, config *CopierConfig)
 	if config.DBConfig == nil {
 		return nil, errors.New("dbConfig must be non-nil")
 	}
-	return &Copier{
-		db:               db,
-		table:            tbl,
-		newTable:         newTable,
-		concurrency:      config.Concurrency,
-		finalChecksum:    config.FinalChecksum,
-		Throttler:        config.Throttler,
-		chunker:          chunker,
-		logger:           config.Logger,
-		metricsSink:      config.MetricsSink,
-		dbConfig:         config.DBConfig,
-		copierEtaHistory: newcopierEtaHistory(),
-	}, nil
+	if config.MinChunkSize > 0 || config.MaxChunkSize > 0 {
+		if err := chunker.SetChunkSizeBounds(config.MinChunkSize, config.MaxChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	if err := chunker.SetNewestFirst(config.NewestFirstKeyRange); err != nil {
+		return nil, err
+	}
+	if config.StartKey != "" || config.EndKey != "" {
+		keyRange, err := table.NewKeyRange(tbl, config.StartKey, config.EndKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := chunker.SetKeyRange(keyRange); err != nil {
+			return nil, err
+		}
+	}
+	if config.IncrementalColumn != "" && !slices.Contains(tbl.Columns, config.IncrementalColumn) {
+		return nil, fmt.Errorf("incremental column %q does not exist in table %s", config.IncrementalColumn, tbl.QuotedName)
+	}
+	switch config.ExplainPolicy {
+	case "", ExplainPolicyOff, ExplainPolicyWarn, ExplainPolicyFail:
+	default:
+		return nil, fmt.Errorf("unknown explain policy %q, must be one of: %s, %s, %s",
+			config.ExplainPolicy, ExplainPolicyOff, ExplainPolicyWarn, ExplainPolicyFail)
+	}
+	checksumMode := config.ChecksumMode
+	if checksumMode == "" {
+		checksumMode = ChecksumModeNone
+		if config.FinalChecksum {
+			checksumMode = ChecksumModeFull
+		}
+	}
+	switch checksumMode {
+	case ChecksumModeFull, ChecksumModeRowCount, ChecksumModeNone:
+	default:
+		return nil, fmt.Errorf("unknown checksum mode %q, must be one of: %s, %s, %s",
+			config.ChecksumMode, ChecksumModeFull, ChecksumModeRowCount, ChecksumModeNone)
+	}
+	if config.ConsistentSnapshot {
+		if err := checkConsistentSnapshot(tbl, config); err != nil {
+			return nil, err
+		}
+	}
+	// Count the retries of the copier, without affecting other
+	// users of the same config.
+	dbConfig := *config.DBConfig
+	c := &Copier{
+		db:                   db,
+		table:                tbl,
+		newTable:             newTable,
+		concurrency:          config.Concurrency,
+		checksumMode:         checksumMode,
+		Throttler:            config.Throttler,
+		chunker:              chunker,
+		logger:               config.Logger,
+		metricsSink:          metrics.NewCoalescingSink(config.MetricsSink),
+		dbConfig:             &dbConfig,
+		copierEtaHistory:     newcopierEtaHistory(),
+		schedule:             config.Schedule,
+		clock:                time.Now,
+		slowChunkThreshold:   config.SlowChunkThreshold,
+		chunkTimes:           metrics.NewQuantileSketch(metrics.DefaultQuantileAccuracy),
+		forcePrimaryIndex:    !config.DisableForcePrimaryIndex,
+		ignoredRowsThreshold: config.IgnoredRowsThreshold,
+		connLimiter:          config.ConnLimiter,
+		incrementalColumn:    config.IncrementalColumn,
+		since:                config.Since,
+		warmUp:               config.WarmUp,
+		queryComment:         config.QueryComment,
+		prefetch:             config.Prefetch,
+		targetChunkTime:      config.TargetChunkTime,
+		maxLoad:              lowerKeys(config.MaxLoad),
+		criticalLoad:         lowerKeys(config.CriticalLoad),
+		splitChunks:          config.SplitChunks,
+		explainPolicy:        config.ExplainPolicy,
+		consistentSnapshot:   config.ConsistentSnapshot,
+		maxRowsToCopy:        config.MaxRowsToCopy,
+		chunkLockWaitTimeout: config.ChunkLockWaitTimeout,
+		onChunkError:         config.OnChunkError,
+		config:               config,
+		columns:              utils.IntersectNonGeneratedColumns(tbl, newTable),
+	}
+	c.loadStatus = c.globalStatus
+	c.execChunk = c.execChunkQuery
+	dbConfig.OnRetry = func(err error) {
+		atomic.AddUint64(&c.CopyRetriesCount, 1)
+		if config.DBConfig.OnRetry != nil {
+			config.DBConfig.OnRetry(err)
+		}
+	}
+	return c, nil
 }
 
 // NewCopierFromCheckpoint creates a new copier object, from a checkpoint (copyRowsAt, copyRows)
 func NewCopierFromCheckpoint(db *sql.DB, tbl, newTable *table.TableInfo, config *CopierConfig, lowWatermark string, rowsCopied uint64, rowsCopiedLogical uint64) (*Copier, error) {
+	// The table may have changed since the checkpoint was written,
+	// in which case resuming would not be consistent.
+	if err := table.ValidateWatermark(tbl, lowWatermark); err != nil {
+		return nil, fmt.Errorf("could not resume from checkpoint, a fresh copy is required: %w", err)
+	}
 	c, err := NewCopier(db, tbl, newTable, config)
 	if err != nil {
 		return c, err
@@ -116,43 +395,58 @@ func NewCopierFromCheckpoint(db *sql.DB, tbl, newTable *table.TableInfo, config
 		return c, err
 	}
 	c.isOpen = true
+	// Chunks that were in progress when the checkpoint was written may have
+	// been copied already, so duplicates are expected in the first chunks.
+	c.resumeChunksLeft = int64(c.concurrency)
 	// Success from this point on
 	// Overwrite copy-rows
 	atomic.StoreUint64(&c.CopyRowsCount, rowsCopied)
 	atomic.StoreUint64(&c.CopyRowsLogicalCount, rowsCopiedLogical)
+	atomic.StoreUint64(&c.CopyBytesCount, rowsCopied*c.table.AvgRowLength)
 	return c, nil
 }
 
 // CopyChunk copies a chunk from the table to the newTable.
 // it is public so it can be used in tests incrementally.
 func (c *Copier) CopyChunk(ctx context.Context, chunk *table.Chunk) error {
-	c.Throttler.BlockWait()
+	if err := c.waitWhilePaused(ctx); err != nil {
+		return err
+	}
+	// Time spent blocked in the throttler is measured separately,
+	// so that it does not count towards the chunk's processing time.
+	throttleStartTime := time.Now()
+	if err := c.waitForLoad(ctx); err != nil {
+		return err
+	}
+	if err := c.Throttler.BlockWait(); err != nil {
+		return err
+	}
 	startTime := time.Now()
-	// INSERT INGORE because we can have duplicate rows in the chunk because in
-	// resuming from checkpoint we will be re-applying some of the previous executed work.
-	query := fmt.Sprintf("INSERT IGNORE INTO %s (%s) SELECT %s FROM %s FORCE INDEX (PRIMARY) WHERE %s",
-		c.newTable.QuotedName,
-		utils.IntersectNonGeneratedColumns(c.table, c.newTable),
-		utils.IntersectNonGeneratedColumns(c.table, c.newTable),
-		c.table.QuotedName,
-		chunk.String(),
-	)
-	c.logger.Debugf("running chunk: %s, query: %s", chunk.String(), query)
-	var affectedRows int64
-	var err error
-	if affectedRows, err = dbconn.RetryableTransaction(ctx, c.db, c.finalChecksum, c.dbConfig, query); err != nil {
+	throttleWaitTime := startTime.Sub(throttleStartTime)
+	atomic.AddInt64(&c.ThrottleWaitTime, int64(throttleWaitTime))
+	affectedRows, err := c.copyChunkRows(ctx, chunk)
+	if err != nil {
 		return err
 	}
 	atomic.AddUint64(&c.CopyRowsCount, uint64(affectedRows))
 	atomic.AddUint64(&c.CopyRowsLogicalCount, chunk.ChunkSize)
+	atomic.AddUint64(&c.CopyBytesCount, uint64(affectedRows)*c.table.AvgRowLength)
 	atomic.AddUint64(&c.CopyChunksCount, 1)
+	var ignoredRows uint64
+	if chunk.ChunkSize > uint64(affectedRows) {
+		ignoredRows = chunk.ChunkSize - uint64(affectedRows)
+	}
+	atomic.AddUint64(&c.CopyRowsIgnoredCount, ignoredRows)
 	// Send feedback which can be used by the chunker
 	// and infoschema to create a low watermark.
 	chunkProcessingTime := time.Since(startTime)
+	c.chunkTimes.Observe(float64(chunkProcessingTime) / float64(time.Millisecond))
 	c.chunker.Feedback(chunk, chunkProcessingTime)
+	c.reportSlowChunk(ctx, chunk, chunkProcessingTime, uint64(affectedRows), c.copyChunkQuery(chunk))
+	c.trackIgnoredRows(chunk, ignoredRows)
 
 	// Send metrics
-	err = c.sendMetrics(ctx, chunkProcessingTime, chunk.ChunkSize, uint64(affectedRows))
+	err = c.sendMetrics(ctx, chunkProcessingTime, throttleWaitTime, chunk.ChunkSize, uint64(affectedRows), ignoredRows)
 	if err != nil {
 		// we don't want to stop processing if metrics sending fails, log and continue
 		c.logger.Errorf("error sending metrics from copier: %v", err)
@@ -160,6 +454,216 @@ func (c *Copier) CopyChunk(ctx context.Context, chunk *table.Chunk) error {
 	return nil
 }
 
+// copyChunkRows copies the rows of chunk to the newTable, and returns the
+// number of rows that were inserted. If SplitChunks is enabled and the
+// transaction is too large, the chunk is split and its halves are copied.
+func (c *Copier) copyChunkRows(ctx context.Context, chunk *table.Chunk) (int64, error) {
+	affectedRows, err := c.execChunk(ctx, chunk)
+	if err == nil {
+		return affectedRows, nil
+	}
+	if !c.splitChunks || !dbconn.IsTransactionTooLargeError(err) {
+		c.chunkError(chunk, err, false)
+		return affectedRows, err
+	}
+	halves, splitErr := c.table.SplitChunk(ctx, chunk)
+	if splitErr != nil || halves == nil {
+		c.chunkError(chunk, err, false)
+		return 0, err // the chunk can not be split, return the original error.
+	}
+	c.chunkError(chunk, err, true)
+	c.logger.Warnf("splitting chunk %s after error: %v", chunk.String(), err)
+	atomic.AddUint64(&c.splitChunksCount, 1)
+	affectedRows = 0
+	for _, half := range halves {
+		rows, err := c.copyChunkRows(ctx, half)
+		if err != nil {
+			return 0, err
+		}
+		affectedRows += rows
+	}
+	return affectedRows, nil
+}
+
+// execChunkQuery copies the rows of chunk in a transaction.
+func (c *Copier) execChunkQuery(ctx context.Context, chunk *table.Chunk) (int64, error) {
+	query := c.copyChunkQuery(chunk)
+	c.logger.Debugf("running chunk: %s, query: %s", chunk.String(), query)
+	if err := c.connLimiter.Acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer c.connLimiter.Release()
+	// The retries of this chunk are reported with the chunk.
+	dbConfig := *c.dbConfig
+	dbConfig.OnRetry = func(err error) {
+		if c.dbConfig.OnRetry != nil {
+			c.dbConfig.OnRetry(err)
+		}
+		c.chunkError(chunk, err, true)
+	}
+	return dbconn.RetryableTransaction(ctx, c.db, c.checksumMode != ChecksumModeNone, &dbConfig, query)
+}
+
+// chunkError calls the OnChunkError hook, if it is set.
+func (c *Copier) chunkError(chunk *table.Chunk, err error, willRetry bool) {
+	if c.onChunkError != nil {
+		c.onChunkError(chunk, err, willRetry)
+	}
+}
+
+// copyChunkQuery returns the query that copies chunk to the newTable.
+func (c *Copier) copyChunkQuery(chunk *table.Chunk) string {
+	return utils.QueryComment(c.queryComment, c.table.QuotedName, chunk.String()) + c.copyChunkStatement(chunk)
+}
+
+// copyChunkStatement returns the statement of copyChunkQuery, without a comment.
+func (c *Copier) copyChunkStatement(chunk *table.Chunk) string {
+	var indexHint string
+	if c.forcePrimaryIndex {
+		indexHint = " FORCE INDEX (PRIMARY)"
+	}
+	if c.incrementalColumn != "" {
+		// Rows changed since the last pass must replace their
+		// previous version in the new table.
+		query := fmt.Sprintf("REPLACE%s INTO %s (%s) SELECT %s FROM %s%s WHERE %s",
+			c.lockWaitHint(),
+			c.newTable.QuotedName,
+			c.columns,
+			c.columns,
+			c.table.FromName(),
+			indexHint,
+			chunk.String(),
+		)
+		if c.since != "" {
+			query += fmt.Sprintf(" AND `%s` >= '%s'", c.incrementalColumn, sqlescape.EscapeString(c.since))
+		}
+		return query
+	}
+	// INSERT INGORE because we can have duplicate rows in the chunk because in
+	// resuming from checkpoint we will be re-applying some of the previous executed work.
+	// This remains safe when the primary key is changed, because the new key must
+	// contain all columns of the existing key: rows that are distinct in the old
+	// table are also distinct in the new table.
+	return fmt.Sprintf("INSERT%s IGNORE INTO %s (%s) SELECT %s FROM %s%s WHERE %s",
+		c.lockWaitHint(),
+		c.newTable.QuotedName,
+		c.columns,
+		c.columns,
+		c.table.FromName(),
+		indexHint,
+		chunk.String(),
+	)
+}
+
+// lockWaitHint returns the optimizer hint that sets the
+// ChunkLockWaitTimeout, or an empty string if it is not set.
+func (c *Copier) lockWaitHint() string {
+	if c.chunkLockWaitTimeout <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" /*+ SET_VAR(innodb_lock_wait_timeout=%d) */", c.chunkLockWaitTimeout)
+}
+
+// prefetchQuery returns a query that reads the keys of the range that
+// follows chunk, which is likely to be the next chunk. Because InnoDB stores
+// rows in the primary key, reading the keys loads the pages of the rows.
+// It returns an empty string if chunk is the last chunk.
+func (c *Copier) prefetchQuery(chunk *table.Chunk) string {
+	if chunk.UpperBound == nil {
+		return ""
+	}
+	next := &table.Chunk{
+		Key: chunk.Key,
+		LowerBound: &table.Boundary{
+			Value:     chunk.UpperBound.Value,
+			Inclusive: !chunk.UpperBound.Inclusive,
+		},
+		AdditionalConditions: chunk.AdditionalConditions,
+	}
+	return fmt.Sprintf("SELECT %s FROM %s FORCE INDEX (PRIMARY) WHERE %s ORDER BY %s LIMIT %d",
+		table.QuoteColumns(chunk.Key),
+		c.table.FromName(),
+		next.String(),
+		table.QuoteColumns(chunk.Key),
+		chunk.ChunkSize,
+	)
+}
+
+// prefetchAfter runs the prefetchQuery for chunk. It is only an
+// optimization, so it is skipped while the throttler is engaged,
+// and errors are logged and otherwise ignored.
+func (c *Copier) prefetchAfter(ctx context.Context, chunk *table.Chunk) {
+	query := c.prefetchQuery(chunk)
+	if query == "" || c.Throttler.IsThrottled() {
+		return
+	}
+	if err := c.connLimiter.Acquire(ctx); err != nil {
+		return
+	}
+	defer c.connLimiter.Release()
+	startTime := time.Now()
+	if err := c.execPrefetch(ctx, query); err != nil {
+		if ctx.Err() == nil {
+			c.logger.Warnf("could not prefetch after chunk %s: %v", chunk.String(), err)
+		}
+		return
+	}
+	atomic.AddUint64(&c.prefetchCount, 1)
+	m := &metrics.Metrics{
+		Values: []metrics.MetricValue{
+			{
+				Name:  metrics.ChunkPrefetchTimeMetricName,
+				Type:  metrics.GAUGE,
+				Value: float64(time.Since(startTime).Milliseconds()), // in milliseconds
+			},
+		},
+	}
+	contextWithTimeout, cancel := context.WithTimeout(ctx, metrics.SinkTimeout)
+	defer cancel()
+	if err := c.metricsSink.Send(contextWithTimeout, m); err != nil {
+		c.logger.Errorf("error sending metrics from copier: %v", err)
+	}
+}
+
+// execPrefetch runs the prefetch query and reads all of its rows.
+func (c *Copier) execPrefetch(ctx context.Context, query string) error {
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		// The rows are read only to load their pages.
+	}
+	return rows.Err()
+}
+
+// effectiveConcurrency returns the number of chunks that may be copied
+// concurrently after elapsed time. During the warm-up it increases by one
+// in evenly sized steps, from 1 to the configured concurrency.
+func (c *Copier) effectiveConcurrency(elapsed time.Duration) int {
+	if c.warmUp <= 0 || elapsed >= c.warmUp {
+		return c.concurrency
+	}
+	return min(1+int(int64(elapsed)*int64(c.concurrency)/int64(c.warmUp)), c.concurrency)
+}
+
+// waitForWarmUpSlot waits until fewer than the effective concurrency chunks
+// are in flight. It returns false if ctx is cancelled while waiting.
+func (c *Copier) waitForWarmUpSlot(ctx context.Context, inFlight *atomic.Int64) bool {
+	if c.warmUp <= 0 {
+		return true // the errgroup limit is sufficient.
+	}
+	for inFlight.Load() >= int64(c.effectiveConcurrency(time.Since(c.StartTime()))) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(warmUpCheckInterval):
+		}
+	}
+	return true
+}
+
 func (c *Copier) isHealthy(ctx context.Context) bool {
 	c.Lock()
 	defer c.Unlock()
@@ -185,19 +689,72 @@ func (c *Copier) Run(ctx context.Context) error {
 	if !c.isOpen {
 		// For practical reasons resume-from-checkpoint
 		// will already be open, new copy processes will not be.
+		// Incremental passes copy into the table from the previous pass,
+		// and partitions into the table with the earlier partitions.
+		if c.incrementalColumn != "" {
+			if err := c.startIncrementalPass(ctx); err != nil {
+				c.Unlock()
+				return err
+			}
+		} else if c.table.Partition == "" {
+			if err := c.newTableIsEmpty(ctx); err != nil {
+				c.Unlock()
+				return err
+			}
+		}
+		if err := c.validateChunkPlan(ctx); err != nil {
+			c.Unlock()
+			return err
+		}
 		if err := c.chunker.Open(); err != nil {
+			c.Unlock()
 			return err
 		}
 	}
 	c.Unlock()
-	go c.estimateRowsPerSecondLoop(ctx) // estimate rows while copying
+	// The background loops are stopped when Run returns,
+	// since the caller may not cancel ctx on success.
+	loopCtx, cancelLoops := context.WithCancel(ctx)
+	defer func() {
+		cancelLoops()
+		c.backgroundLoops.Wait()
+	}()
+	c.backgroundLoops.Add(1)
+	go func() {
+		defer c.backgroundLoops.Done()
+		c.estimateRowsPerSecondLoop(loopCtx) // estimate rows while copying
+	}()
+	if len(c.schedule) > 0 {
+		c.evaluateSchedule() // pause immediately if we are starting in a window
+		c.backgroundLoops.Add(1)
+		go func() {
+			defer c.backgroundLoops.Done()
+			c.scheduleLoop(loopCtx)
+		}()
+	}
+	if c.consistentSnapshot {
+		if err := c.runSnapshot(ctx); err != nil {
+			c.setInvalid(true)
+			return err
+		}
+		return nil
+	}
 	g, errGrpCtx := errgroup.WithContext(ctx)
 	g.SetLimit(c.concurrency)
-	for !c.chunker.IsRead() && c.isHealthy(errGrpCtx) {
+	var inFlight atomic.Int64
+	for !c.chunker.IsRead() && c.isHealthy(errGrpCtx) && !c.ReachedMaxRows() {
+		if !c.waitForWarmUpSlot(errGrpCtx, &inFlight) {
+			break
+		}
+		inFlight.Add(1)
 		g.Go(func() error {
+			defer inFlight.Add(-1)
 			c.logger.Info("Waiting for 5 seconds")
 
 			time.Sleep(5 * time.Second)
+			if c.ReachedMaxRows() {
+				return nil // chunks that were started before it was reached are completed.
+			}
 			chunk, err := c.chunker.Next()
 			if err != nil {
 				if err == table.ErrTableIsRead {
@@ -206,6 +763,13 @@ func (c *Copier) Run(ctx context.Context) error {
 				c.setInvalid(true)
 				return err
 			}
+			if c.prefetch {
+				c.backgroundLoops.Add(1)
+				go func() {
+					defer c.backgroundLoops.Done()
+					c.prefetchAfter(loopCtx, chunk)
+				}()
+			}
 			if err := c.CopyChunk(errGrpCtx, chunk); err != nil {
 				c.setInvalid(true)
 				return err
@@ -218,10 +782,58 @@ func (c *Copier) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-
+	if c.ReachedMaxRows() {
+		c.logger.Warnf("copy stopped after %d of the maximum of %d rows", atomic.LoadUint64(&c.CopyRowsLogicalCount), c.maxRowsToCopy)
+		return nil // the next pass must start from the same Since.
+	}
+	c.Lock()
+	if c.nextSince.Valid {
+		c.since = c.nextSince.String
+	}
+	c.Unlock()
 	return nil
 }
 
+// ReachedMaxRows returns true if MaxRowsToCopy is set, and at least that
+// many logical rows have been copied. The copy then stops before the
+// table has been read.
+func (c *Copier) ReachedMaxRows() bool {
+	return c.maxRowsToCopy > 0 && atomic.LoadUint64(&c.CopyRowsLogicalCount) >= c.maxRowsToCopy
+}
+
+// startIncrementalPass records the max value of the incremental column
+// before any rows are copied, which becomes Since for the next pass.
+// Because rows are copied where the column is >= Since, rows that change
+// while this pass is running are copied again by the next pass.
+func (c *Copier) startIncrementalPass(ctx context.Context) error {
+	query := fmt.Sprintf("SELECT MAX(`%s`) FROM %s", c.incrementalColumn, c.table.FromName())
+	return c.db.QueryRowContext(ctx, query).Scan(&c.nextSince)
+}
+
+// Since returns the lower bound of the incremental column for the next pass.
+// Before Run has completed successfully it is the configured Since.
+func (c *Copier) Since() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.since
+}
+
+// newTableIsEmpty returns an error if the new table already has rows at
+// the start of a new copy. Because the copy uses INSERT IGNORE, any rows
+// left behind (i.e. by an earlier run that was not cleaned up) would be
+// silently kept instead of being replaced by the rows in the table.
+func (c *Copier) newTableIsEmpty(ctx context.Context) error {
+	var exists int
+	err := c.db.QueryRowContext(ctx, "SELECT 1 FROM "+c.newTable.QuotedName+" LIMIT 1").Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("new table %s is not empty: drop it to start a new copy, or resume from a checkpoint", c.newTable.QuotedName)
+}
+
 func (c *Copier) setInvalid(newVal bool) {
 	c.Lock()
 	defer c.Unlock()
@@ -237,67 +849,237 @@ func (c *Copier) SetThrottler(throttler throttler.Throttler) {
 func (c *Copier) getCopyStats() (uint64, uint64, float64) {
 	if c.table.KeyIsAutoInc {
 		// If the table has an autoinc column we use a different estimation method,
-		// which tends to be more accurate. We use the maxValue as the estimated rows,
-		// and the "logical copied rows" (which is the sum of the chunk sizes) as the
-		// rows copied so far.
-		copyRows := atomic.LoadUint64(&c.CopyRowsLogicalCount)
+		// which tends to be more accurate. We use the maxValue as the logical size
+		// of the table, and the "logical copied rows" (which is the sum of the chunk
+		// sizes) as how much of it has been copied so far. Because the table
+		// may have gaps (i.e. after large deletes), the estimated rows are
+		// corrected by the density observed in the copied portion of the table.
+		copyRows := atomic.LoadUint64(&c.CopyRowsCount)
+		logicalCopyRows := atomic.LoadUint64(&c.CopyRowsLogicalCount)
 		maxValue, err := strconv.ParseUint(c.table.MaxValue().String(), 10, 64)
 		if err != nil {
 			maxValue = c.table.EstimatedRows
 		}
-		pct := float64(copyRows) / float64(maxValue) * 100
-		return copyRows, maxValue, pct
+		pct := copyPercent(logicalCopyRows, maxValue)
+		if copyRows == 0 || logicalCopyRows == 0 {
+			return copyRows, maxValue, pct // the density is not yet known.
+		}
+		density := float64(copyRows) / float64(logicalCopyRows)
+		return copyRows, uint64(float64(maxValue) * density), pct
 	}
 	// This is the legacy estimation method, which is not as accurate as the one above.
 	// It is required for scenarios like VARBINARY primary keys. The downside here is that
 	// the estimated rows can jump around a lot on a big table with a high variability of row size.
 	// Because we include the CopyRowsCount to users at least it will
 	// appear like it is always progressing.
-	pct := float64(atomic.LoadUint64(&c.CopyRowsCount)) / float64(c.table.EstimatedRows) * 100
-	return atomic.LoadUint64(&c.CopyRowsCount), c.table.EstimatedRows, pct
+	copyRows := atomic.LoadUint64(&c.CopyRowsCount)
+	return copyRows, c.table.EstimatedRows, copyPercent(copyRows, c.table.EstimatedRows)
 }
 
-// GetProgress returns the progress of the copier
-func (c *Copier) GetProgress() string {
+// copyPercent returns copied as a percentage of total. If total is zero,
+// i.e. because the table is empty, there is nothing to copy and the
+// percentage is 100 instead of a division by zero.
+func copyPercent(copied, total uint64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(copied) / float64(total) * 100
+}
+
+// BytesCopied returns the approximate number of bytes copied. It is
+// estimated from the rows copied and the average row length of the table.
+func (c *Copier) BytesCopied() uint64 {
+	return atomic.LoadUint64(&c.CopyBytesCount)
+}
+
+// BytesPercent returns the approximate percentage of the table copied,
+// measured in bytes rather than rows. It is capped at 100, since both the
+// bytes copied and the data length of the table are estimates.
+func (c *Copier) BytesPercent() float64 {
+	if c.table.EstimatedBytes == 0 {
+		return 0
+	}
+	return min(float64(c.BytesCopied())/float64(c.table.EstimatedBytes)*100, 100)
+}
+
+// CopierStatus is a point in time summary of the copier.
+// It is designed for embedders to serialize, i.e. as JSON.
+type CopierStatus struct {
+	CopiedRows    uint64        `json:"copied_rows"`
+	TotalRows     uint64        `json:"total_rows"`
+	Percent       float64       `json:"percent"`
+	ETA           time.Duration `json:"eta"` // zero if not yet known
+	RowsPerSecond uint64        `json:"rows_per_second"`
+	ChunksCopied  uint64        `json:"chunks_copied"`
+	IgnoredRows   uint64        `json:"ignored_rows"` // rows not inserted by INSERT IGNORE
+	CopiedBytes   uint64        `json:"copied_bytes"` // approximate, see BytesCopied
+	BytesPercent  float64       `json:"bytes_percent"`
+	StartTime     time.Time     `json:"start_time"`
+	IsThrottled   bool          `json:"is_throttled"`
+	// ThrottlerState describes why the throttler is engaged, if it is.
+	ThrottlerState string `json:"throttler_state"`
+	IsPaused       bool   `json:"is_paused"`
+	// ChunkTimeP50, P95 and P99 are percentiles of the processing time
+	// of the chunks copied so far. They are estimates, within 1%.
+	ChunkTimeP50 time.Duration `json:"chunk_time_p50"`
+	ChunkTimeP95 time.Duration `json:"chunk_time_p95"`
+	ChunkTimeP99 time.Duration `json:"chunk_time_p99"`
+}
+
+// Status returns the current status of the copier.
+func (c *Copier) Status() CopierStatus {
 	c.Lock()
 	defer c.Unlock()
 	copied, total, pct := c.getCopyStats()
-	return fmt.Sprintf("%d/%d %.2f%%", copied, total, pct)
+	eta, _ := c.estimateETA(copied, total, pct)
+	return CopierStatus{
+		CopiedRows:     copied,
+		TotalRows:      total,
+		Percent:        pct,
+		ETA:            eta,
+		RowsPerSecond:  atomic.LoadUint64(&c.rowsPerSecond),
+		ChunksCopied:   atomic.LoadUint64(&c.CopyChunksCount),
+		IgnoredRows:    atomic.LoadUint64(&c.CopyRowsIgnoredCount),
+		CopiedBytes:    c.BytesCopied(),
+		BytesPercent:   c.BytesPercent(),
+		StartTime:      c.startTime,
+		IsThrottled:    c.Throttler.IsThrottled(),
+		ThrottlerState: c.Throttler.State(),
+		IsPaused:       c.IsPaused(),
+		ChunkTimeP50:   c.chunkTimePercentile(0.5),
+		ChunkTimeP95:   c.chunkTimePercentile(0.95),
+		ChunkTimeP99:   c.chunkTimePercentile(0.99),
+	}
+}
+
+// chunkTimePercentile returns the estimate of the q
+// quantile of the processing time of the chunks.
+func (c *Copier) chunkTimePercentile(q float64) time.Duration {
+	return time.Duration(c.chunkTimes.Quantile(q) * float64(time.Millisecond))
+}
+
+// CopierSummary is a report of the work done by the copier, i.e.
+// for printing at the end of a migration.
+type CopierSummary struct {
+	CopiedRows       uint64        `json:"copied_rows"`
+	LogicalRows      uint64        `json:"logical_rows"` // including gaps in an auto_increment key
+	ChunksCopied     uint64        `json:"chunks_copied"`
+	IgnoredRows      uint64        `json:"ignored_rows"`
+	Retries          uint64        `json:"retries"`
+	Duration         time.Duration `json:"duration"`
+	RowsPerSecond    float64       `json:"rows_per_second"` // the average over Duration
+	ThrottleWaitTime time.Duration `json:"throttle_wait_time"`
+}
+
+// String formats the summary as a single line.
+func (s CopierSummary) String() string {
+	return fmt.Sprintf("copied-rows=%d logical-rows=%d chunks=%d ignored-rows=%d retries=%d duration=%s rows-per-second=%.0f throttle-wait-time=%s",
+		s.CopiedRows, s.LogicalRows, s.ChunksCopied, s.IgnoredRows, s.Retries,
+		s.Duration.Round(time.Millisecond), s.RowsPerSecond, s.ThrottleWaitTime.Round(time.Millisecond))
+}
+
+// Summary returns a report of the work done by the copier. If the copier
+// is still running, the duration is the time it has been running for.
+func (c *Copier) Summary() CopierSummary {
+	c.Lock()
+	duration := c.ExecTime
+	if duration == 0 && !c.startTime.IsZero() {
+		duration = time.Since(c.startTime)
+	}
+	c.Unlock()
+	summary := CopierSummary{
+		CopiedRows:       atomic.LoadUint64(&c.CopyRowsCount),
+		LogicalRows:      atomic.LoadUint64(&c.CopyRowsLogicalCount),
+		ChunksCopied:     atomic.LoadUint64(&c.CopyChunksCount),
+		IgnoredRows:      atomic.LoadUint64(&c.CopyRowsIgnoredCount),
+		Retries:          atomic.LoadUint64(&c.CopyRetriesCount),
+		Duration:         duration,
+		ThrottleWaitTime: time.Duration(atomic.LoadInt64(&c.ThrottleWaitTime)),
+	}
+	if duration > 0 {
+		summary.RowsPerSecond = float64(summary.CopiedRows) / duration.Seconds()
+	}
+	return summary
+}
+
+// ProgressUpdate is sent by RunWithProgress.
+type ProgressUpdate struct {
+	CopierStatus
+	Done bool  // true for the final update, sent when the copier has stopped
+	Err  error // the error the copier stopped with, only set on the final update
+}
+
+// RunWithProgress runs the copier and sends a ProgressUpdate to out every
+// interval, as an alternative to polling Status. Periodic updates are dropped
+// if out is not ready to receive them. A final update is sent when the copier
+// stops, and out is then closed. The caller must keep receiving from out until
+// it is closed, or cancel ctx. It returns the same error as Run.
+func (c *Copier) RunWithProgress(ctx context.Context, interval time.Duration, out chan<- ProgressUpdate) error {
+	defer close(out)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-runErr:
+			select {
+			case out <- ProgressUpdate{CopierStatus: c.Status(), Done: true, Err: err}:
+			case <-ctx.Done():
+			}
+			return err
+		case <-ticker.C:
+			select {
+			case out <- ProgressUpdate{CopierStatus: c.Status()}:
+			default: // the receiver is not ready
+			}
+		}
+	}
+}
+
+// GetProgress returns the progress of the copier
+func (c *Copier) GetProgress() string {
+	status := c.Status()
+	return fmt.Sprintf("%d/%d %.2f%%", status.CopiedRows, status.TotalRows, status.Percent)
 }
 
 func (c *Copier) GetETA() string {
 	c.Lock()
 	defer c.Unlock()
-	copiedRows, totalRows, pct := c.getCopyStats()
+	estimate, state := c.estimateETA(c.getCopyStats())
+	if state != "" {
+		return state
+	}
+	comparison := c.copierEtaHistory.addCurrentEstimateAndCompare(estimate)
+	if comparison != "" {
+		return fmt.Sprintf("%s (%s)", estimate.String(), comparison)
+	}
+	return estimate.String()
+}
+
+// estimateETA returns the estimated time remaining. If it can not
+// be estimated it instead returns a state of either DUE or TBD.
+func (c *Copier) estimateETA(copiedRows, totalRows uint64, pct float64) (time.Duration, string) {
 	rowsPerSecond := atomic.LoadUint64(&c.rowsPerSecond)
-	if pct > 99.99 {
-		return "DUE"
+	if pct > 99.99 || copiedRows >= totalRows {
+		return 0, "DUE"
 	}
 	if rowsPerSecond == 0 || time.Since(c.startTime) < copyETAInitialWaitTime {
-		return "TBD"
+		return 0, "TBD"
 	}
 	// divide the remaining rows by how many rows we copied in the last interval per second
-	// "remainingRows" might be the actual rows or the logical rows since
-	// c.getCopyStats() and rowsPerSecond change estimation method when the PK is auto-inc.
 	remainingRows := totalRows - copiedRows
 	remainingSeconds := math.Floor(float64(remainingRows) / float64(rowsPerSecond))
-
-	estimate := time.Duration(remainingSeconds * float64(time.Second))
-	comparison := c.copierEtaHistory.addCurrentEstimateAndCompare(estimate)
-	if comparison != "" {
-		return fmt.Sprintf("%s (%s)", estimate.String(), comparison)
-	}
-	return estimate.String()
+	return time.Duration(remainingSeconds * float64(time.Second)), ""
 }
 
 func (c *Copier) estimateRowsPerSecondLoop(ctx context.Context) {
 	// We take >10 second averages because with parallel copy it bounces around a lot.
-	// If it's an auto-inc key we use the "logical copy rows", because the estimate
-	// will be based on the max value of the auto-inc column.
+	// The actual rows are used for all tables, since c.getCopyStats() also
+	// estimates the actual rows remaining when the PK is auto-inc.
 	prevRowsCount := atomic.LoadUint64(&c.CopyRowsCount)
-	if c.table.KeyIsAutoInc {
-		prevRowsCount = atomic.LoadUint64(&c.CopyRowsLogicalCount)
-	}
 	ticker := time.NewTicker(copyEstimateInterval)
 	defer ticker.Stop()
 	for {
@@ -309,9 +1091,6 @@ func (c *Copier) estimateRowsPerSecondLoop(ctx context.Context) {
 				return
 			}
 			newRowsCount := atomic.LoadUint64(&c.CopyRowsCount)
-			if c.table.KeyIsAutoInc {
-				newRowsCount = atomic.LoadUint64(&c.CopyRowsLogicalCount)
-			}
 			rowsPerInterval := float64(newRowsCount - prevRowsCount)
 			intervalsDivisor := float64(copyEstimateInterval / time.Second) // should be something like 10 for 10 seconds
 			rowsPerSecond := uint64(rowsPerInterval / intervalsDivisor)
@@ -325,17 +1104,30 @@ func (c *Copier) estimateRowsPerSecondLoop(ctx context.Context) {
 // This is done, so we don't need to export the chunker,
 
 // KeyAboveHighWatermark returns true if the key is above where the chunker is currently at.
+// It is always false in ConsistentSnapshot mode, since a chunk is copied as of the
+// start of the snapshot, so the changes to its rows after that must still be applied.
 func (c *Copier) KeyAboveHighWatermark(key interface{}) bool {
+	if c.consistentSnapshot {
+		return false
+	}
 	return c.chunker.KeyAboveHighWatermark(key)
 }
 
+// ConsistentSnapshot returns true if the copier copies in ConsistentSnapshot mode.
+func (c *Copier) ConsistentSnapshot() bool {
+	return c.consistentSnapshot
+}
+
 // GetLowWatermark returns the low watermark of the chunker, i.e. the lowest key that has been
 // guaranteed to be written to the new table.
 func (c *Copier) GetLowWatermark() (string, error) {
+	if c.consistentSnapshot && !c.snapshotCommitted.Load() {
+		return "", errSnapshotNotCommitted
+	}
 	return c.chunker.GetLowWatermark()
 }
 
-func (c *Copier) sendMetrics(ctx context.Context, processingTime time.Duration, logicalRowsCount uint64, affectedRowsCount uint64) error {
+func (c *Copier) sendMetrics(ctx context.Context, processingTime time.Duration, throttleWaitTime time.Duration, logicalRowsCount uint64, affectedRowsCount uint64, ignoredRowsCount uint64) error {
 	m := &metrics.Metrics{
 		Values: []metrics.MetricValue{
 			{
@@ -343,6 +1135,11 @@ func (c *Copier) sendMetrics(ctx context.Context, processingTime time.Duration,
 				Type:  metrics.GAUGE,
 				Value: float64(processingTime.Milliseconds()), // in milliseconds
 			},
+			{
+				Name:  metrics.ChunkThrottleWaitTimeMetricName,
+				Type:  metrics.GAUGE,
+				Value: float64(throttleWaitTime.Milliseconds()), // in milliseconds
+			},
 			{
 				Name:  metrics.ChunkLogicalRowsCountMetricName,
 				Type:  metrics.COUNTER,
@@ -353,6 +1150,26 @@ func (c *Copier) sendMetrics(ctx context.Context, processingTime time.Duration,
 				Type:  metrics.COUNTER,
 				Value: float64(affectedRowsCount),
 			},
+			{
+				Name:  metrics.ChunkIgnoredRowsCountMetricName,
+				Type:  metrics.COUNTER,
+				Value: float64(ignoredRowsCount),
+			},
+			{
+				Name:  metrics.ChunkProcessingTimeP50MetricName,
+				Type:  metrics.GAUGE,
+				Value: c.chunkTimes.Quantile(0.5), // in milliseconds
+			},
+			{
+				Name:  metrics.ChunkProcessingTimeP95MetricName,
+				Type:  metrics.GAUGE,
+				Value: c.chunkTimes.Quantile(0.95), // in milliseconds
+			},
+			{
+				Name:  metrics.ChunkProcessingTimeP99MetricName,
+				Type:  metrics.GAUGE,
+				Value: c.chunkTimes.Quantile(0.99), // in milliseconds
+			},
 		},
 	}
 
@@ -362,12 +1179,81 @@ func (c *Copier) sendMetrics(ctx context.Context, processingTime time.Duration,
 	return c.metricsSink.Send(contextWithTimeout, m)
 }
 
+// reportSlowChunk logs a warning and increments the slow chunk counter
+// if the chunk took longer than the configured SlowChunkThreshold.
+// This helps identify ranges of the table that are hotspots.
+func (c *Copier) reportSlowChunk(ctx context.Context, chunk *table.Chunk, processingTime time.Duration, affectedRowsCount uint64, query string) {
+	if c.slowChunkThreshold <= 0 || processingTime <= c.slowChunkThreshold {
+		return
+	}
+	c.logger.Warnf("slow chunk: %s took %s (threshold: %s), affected rows: %d, query: %s",
+		chunk.String(), processingTime, c.slowChunkThreshold, affectedRowsCount, query)
+	m := &metrics.Metrics{
+		Values: []metrics.MetricValue{
+			{
+				Name:  metrics.ChunkSlowCountMetricName,
+				Type:  metrics.COUNTER,
+				Value: 1,
+			},
+		},
+	}
+	contextWithTimeout, cancel := context.WithTimeout(ctx, metrics.SinkTimeout)
+	defer cancel()
+	if err := c.metricsSink.Send(contextWithTimeout, m); err != nil {
+		c.logger.Errorf("error sending metrics from copier: %v", err)
+	}
+}
+
+// trackIgnoredRows tracks the rows that INSERT IGNORE did not insert, and warns
+// if the ratio to the expected rows exceeds the configured IgnoredRowsThreshold.
+// Duplicates are expected after resuming from a checkpoint, but otherwise they
+// may be a sign of data divergence. Note that for auto-increment keys the chunk
+// size is a range of values, so gaps in the key also count as ignored rows.
+func (c *Copier) trackIgnoredRows(chunk *table.Chunk, ignoredRows uint64) {
+	if c.ignoredRowsThreshold <= 0 {
+		return
+	}
+	if atomic.AddInt64(&c.resumeChunksLeft, -1) >= 0 {
+		return // in the resume window.
+	}
+	ignoredTotal := atomic.AddUint64(&c.ignoredRowsCount, ignoredRows)
+	expectedTotal := atomic.AddUint64(&c.expectedRowsCount, chunk.ChunkSize)
+	if expectedTotal == 0 {
+		return
+	}
+	ratio := float64(ignoredTotal) / float64(expectedTotal)
+	exceeded := ratio > c.ignoredRowsThreshold
+	if c.ignoredRowsExceeded.Swap(exceeded) != exceeded && exceeded {
+		c.logger.Warnf("ignored rows ratio %.2f exceeds threshold %.2f: %d of %d rows were not inserted. This could be a sign of data divergence. Last chunk: %s",
+			ratio, c.ignoredRowsThreshold, ignoredTotal, expectedTotal, chunk.String())
+	}
+}
+
 // Next4Test is typically only used in integration tests that don't want to actually migrate data,
 // but need to advance the chunker.
 func (c *Copier) Next4Test() (*table.Chunk, error) {
 	return c.chunker.Next()
 }
 
+// Skip4Test is typically only used in integration tests that need to position
+// the watermark at a specific point. It advances the chunker by n chunks without
+// copying them, and gives feedback as if they were copied in the target time,
+// so the watermark advances but the chunk size does not change.
+func (c *Copier) Skip4Test(n int) error {
+	target := c.targetChunkTime
+	if target == 0 {
+		target = table.ChunkerDefaultTarget
+	}
+	for range n {
+		chunk, err := c.chunker.Next()
+		if err != nil {
+			return err
+		}
+		c.chunker.Feedback(chunk, target)
+	}
+	return nil
+}
+
 // Open4Test is typically only used in integration tests that don't want to actually migrate data,
 // but need to open the chunker.
 func (c *Copier) Open4Test() error {
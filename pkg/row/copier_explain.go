@@ -0,0 +1,207 @@
+package row
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cashapp/spirit/pkg/table"
+)
+
+// ExplainPolicy is what the copier does when the plan of the
+// query that reads a chunk is not the plan that it expects.
+type ExplainPolicy string
+
+const (
+	// ExplainPolicyOff does not EXPLAIN a chunk. This is the default.
+	ExplainPolicyOff ExplainPolicy = "off"
+	// ExplainPolicyWarn logs a warning and continues the copy.
+	ExplainPolicyWarn ExplainPolicy = "warn"
+	// ExplainPolicyFail fails the copy before any rows are copied.
+	ExplainPolicyFail ExplainPolicy = "fail"
+)
+
+const (
+	// explainMaxChunks is the number of chunks that are read from
+	// the chunker to find a chunk that is bounded on both sides.
+	explainMaxChunks = 3
+	// explainRowsFactor is how many times more rows than the chunk size
+	// the optimizer may estimate before the plan is considered a bad one.
+	explainRowsFactor = 10
+)
+
+// ErrBadChunkPlan is returned by Run when the plan of a
+// chunk is not the expected one and ExplainPolicy is fail.
+var ErrBadChunkPlan = errors.New("the query plan of a chunk is not the expected plan")
+
+// explainRow is a row of the output of EXPLAIN in the traditional format.
+type explainRow struct {
+	table      string
+	accessType string // i.e. range, or ALL for a full table scan.
+	key        string // the index that is used, or empty for none.
+	rows       uint64 // the estimate of the rows that are examined.
+}
+
+// validateChunkPlan runs EXPLAIN on the query that reads a representative
+// chunk of the table, before the copy starts. A chunk that does not use the
+// PRIMARY KEY when it is forced, that is read with a full table scan, or
+// that is estimated to examine many more rows than the chunk size, makes
+// for a long and slow copy, so it follows the ExplainPolicy.
+func (c *Copier) validateChunkPlan(ctx context.Context) error {
+	if c.explainPolicy == "" || c.explainPolicy == ExplainPolicyOff {
+		return nil
+	}
+	chunk, err := c.representativeChunk()
+	if err != nil {
+		return err
+	}
+	if chunk == nil {
+		c.logger.Info("not validating the query plan of a chunk: the table has no chunk that is bounded on both sides")
+		return nil
+	}
+	plan, err := c.explainChunk(ctx, chunk)
+	if err != nil {
+		return err
+	}
+	problems := c.chunkPlanProblems(plan, chunk.ChunkSize)
+	if len(problems) == 0 {
+		c.logger.Infof("the query plan of chunk %s is as expected", chunk.String())
+		return nil
+	}
+	if c.explainPolicy == ExplainPolicyFail {
+		return fmt.Errorf("%w for chunk %s: %s", ErrBadChunkPlan, chunk.String(), strings.Join(problems, "; "))
+	}
+	c.logger.Warnf("the query plan of chunk %s is not the expected plan: %s", chunk.String(), strings.Join(problems, "; "))
+	return nil
+}
+
+// representativeChunk returns the first chunk of the table that is
+// bounded on both sides, from a chunker of its own so that the chunks of
+// the copy are not affected. It returns nil if there is no such chunk,
+// i.e. the table fits in a single chunk.
+func (c *Copier) representativeChunk() (*table.Chunk, error) {
+	chunker, err := table.NewChunker(c.table, c.targetChunkTime, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	if c.config != nil && (c.config.MinChunkSize > 0 || c.config.MaxChunkSize > 0) {
+		if err := chunker.SetChunkSizeBounds(c.config.MinChunkSize, c.config.MaxChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	if err := chunker.Open(); err != nil {
+		return nil, err
+	}
+	defer chunker.Close()
+	for range explainMaxChunks {
+		chunk, err := chunker.Next()
+		if errors.Is(err, table.ErrTableIsRead) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunk.LowerBound != nil && chunk.UpperBound != nil {
+			return chunk, nil
+		}
+	}
+	return nil, nil
+}
+
+// explainChunk returns the output of EXPLAIN for the
+// query with which the copy reads the rows of chunk.
+func (c *Copier) explainChunk(ctx context.Context, chunk *table.Chunk) ([]explainRow, error) {
+	var indexHint string
+	if c.forcePrimaryIndex {
+		indexHint = " FORCE INDEX (PRIMARY)"
+	}
+	query := fmt.Sprintf("EXPLAIN FORMAT=TRADITIONAL SELECT %s FROM %s%s WHERE %s",
+		c.columns,
+		c.table.FromName(),
+		indexHint,
+		chunk.String(),
+	)
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not explain chunk %s: %w", chunk.String(), err)
+	}
+	defer rows.Close()
+	return scanExplainRows(rows)
+}
+
+// scanExplainRows reads the table, type, key and rows columns of the
+// output of EXPLAIN. The other columns vary between versions.
+func scanExplainRows(rows *sql.Rows) ([]explainRow, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var plan []explainRow
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row, err := parseExplainRow(columns, values)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, row)
+	}
+	return plan, rows.Err()
+}
+
+// parseExplainRow returns the row of EXPLAIN output
+// with the values of the named columns.
+func parseExplainRow(columns []string, values []sql.NullString) (explainRow, error) {
+	var row explainRow
+	for i, column := range columns {
+		value := values[i].String
+		switch strings.ToLower(column) {
+		case "table":
+			row.table = value
+		case "type":
+			row.accessType = value
+		case "key":
+			row.key = value
+		case "rows":
+			if !values[i].Valid {
+				continue
+			}
+			if _, err := fmt.Sscan(value, &row.rows); err != nil {
+				return explainRow{}, fmt.Errorf("could not parse the rows %q of EXPLAIN: %w", value, err)
+			}
+		}
+	}
+	return row, nil
+}
+
+// chunkPlanProblems describes what is unexpected about the plan of a chunk
+// of chunkSize rows. The plan is expected to read the table with the
+// PRIMARY KEY if it is forced, and to not read the whole table.
+func (c *Copier) chunkPlanProblems(plan []explainRow, chunkSize uint64) []string {
+	var problems []string
+	for _, row := range plan {
+		if strings.EqualFold(row.accessType, "ALL") {
+			problems = append(problems, fmt.Sprintf("table %s is read with a full table scan", row.table))
+		}
+		if c.forcePrimaryIndex && row.key != "PRIMARY" {
+			key := row.key
+			if key == "" {
+				key = "no index"
+			}
+			problems = append(problems, fmt.Sprintf("table %s is read with %s instead of the PRIMARY KEY", row.table, key))
+		}
+		if maxRows := max(chunkSize, 1) * explainRowsFactor; row.rows > maxRows {
+			problems = append(problems, fmt.Sprintf("table %s is estimated to examine %d rows, which is more than %d times the chunk size of %d",
+				row.table, row.rows, explainRowsFactor, chunkSize))
+		}
+	}
+	return problems
+}
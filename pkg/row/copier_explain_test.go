@@ -0,0 +1,112 @@
+package row
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// explainColumns are the columns of EXPLAIN FORMAT=TRADITIONAL in MySQL 8.0.
+var explainColumns = []string{"id", "select_type", "table", "partitions", "type", "possible_keys", "key", "key_len", "ref", "rows", "filtered", "Extra"}
+
+// explainValues returns the values of a row of EXPLAIN output, with
+// NULL for the partitions, ref and the empty values.
+func explainValues(values ...string) []sql.NullString {
+	nullStrings := make([]sql.NullString, len(values))
+	for i, value := range values {
+		nullStrings[i] = sql.NullString{String: value, Valid: value != ""}
+	}
+	return nullStrings
+}
+
+func TestParseExplainRow(t *testing.T) {
+	row, err := parseExplainRow(explainColumns, explainValues("1", "SIMPLE", "explaint1", "", "range", "PRIMARY", "PRIMARY", "4", "", "1000", "100.00", "Using where"))
+	assert.NoError(t, err)
+	assert.Equal(t, explainRow{table: "explaint1", accessType: "range", key: "PRIMARY", rows: 1000}, row)
+
+	// A full table scan uses no index.
+	row, err = parseExplainRow(explainColumns, explainValues("1", "SIMPLE", "explaint1", "", "ALL", "", "", "", "", "5000000", "33.33", "Using where"))
+	assert.NoError(t, err)
+	assert.Equal(t, explainRow{table: "explaint1", accessType: "ALL", rows: 5000000}, row)
+
+	_, err = parseExplainRow(explainColumns, explainValues("1", "SIMPLE", "explaint1", "", "ALL", "", "", "", "", "many", "33.33", ""))
+	assert.ErrorContains(t, err, `could not parse the rows "many" of EXPLAIN`)
+}
+
+func TestChunkPlanProblems(t *testing.T) {
+	copier := &Copier{forcePrimaryIndex: true}
+	good := []explainRow{{table: "explaint1", accessType: "range", key: "PRIMARY", rows: 1200}}
+	assert.Empty(t, copier.chunkPlanProblems(good, 1000))
+
+	// The index hint was not used.
+	assert.Equal(t, []string{"table explaint1 is read with b instead of the PRIMARY KEY"},
+		copier.chunkPlanProblems([]explainRow{{table: "explaint1", accessType: "range", key: "b", rows: 1000}}, 1000))
+
+	// A full scan of a huge table.
+	assert.Equal(t, []string{
+		"table explaint1 is read with a full table scan",
+		"table explaint1 is read with no index instead of the PRIMARY KEY",
+		"table explaint1 is estimated to examine 5000000 rows, which is more than 10 times the chunk size of 1000",
+	}, copier.chunkPlanProblems([]explainRow{{table: "explaint1", accessType: "ALL", rows: 5000000}}, 1000))
+
+	// Without the index hint the optimizer may choose the index.
+	copier.forcePrimaryIndex = false
+	assert.Empty(t, copier.chunkPlanProblems([]explainRow{{table: "explaint1", accessType: "range", key: "b", rows: 1000}}, 1000))
+}
+
+func TestExplainPolicyUnknown(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "explainpolicyt1")
+	t2 := table.NewTableInfo(nil, "test", "_explainpolicyt1_new")
+	config := NewCopierDefaultConfig()
+	config.ExplainPolicy = "ignore"
+	_, err := NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, `unknown explain policy "ignore"`)
+}
+
+func TestCopierExplainPolicy(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS explaint1, _explaint1_new")
+	testutils.RunSQL(t, "CREATE TABLE explaint1 (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, b INT NOT NULL, KEY (b))")
+	testutils.RunSQL(t, "CREATE TABLE _explaint1_new (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, b INT NOT NULL, KEY (b))")
+	testutils.RunSQL(t, "INSERT INTO explaint1 (b) SELECT 1 FROM dual")
+	for range 12 {
+		testutils.RunSQL(t, "INSERT INTO explaint1 (b) SELECT b FROM explaint1")
+	}
+	testutils.RunSQL(t, "ANALYZE TABLE explaint1")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	t1 := table.NewTableInfo(db, "test", "explaint1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_explaint1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	config := NewCopierDefaultConfig()
+	config.ExplainPolicy = ExplainPolicyFail
+	copier, err := NewCopier(db, t1, t2, config)
+	assert.NoError(t, err)
+
+	// The chunks of the copy are read with a range of the PRIMARY KEY.
+	chunk, err := copier.representativeChunk()
+	assert.NoError(t, err)
+	assert.NotNil(t, chunk)
+	plan, err := copier.explainChunk(context.TODO(), chunk)
+	assert.NoError(t, err)
+	assert.Len(t, plan, 1)
+	assert.Equal(t, "explaint1", plan[0].table)
+	assert.Equal(t, "range", plan[0].accessType)
+	assert.Equal(t, "PRIMARY", plan[0].key)
+	assert.Empty(t, copier.chunkPlanProblems(plan, chunk.ChunkSize))
+
+	// The copy is not affected by the chunk that was explained.
+	assert.NoError(t, copier.Run(context.Background()))
+	var count, newCount int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM explaint1").Scan(&count))
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _explaint1_new").Scan(&newCount))
+	assert.Equal(t, count, newCount)
+}
@@ -0,0 +1,137 @@
+package row
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var loadCheckInterval = 1 * time.Second // how frequently a copier over the max load checks if it can resume
+
+// CriticalLoadError is returned by the copier when a global status
+// variable exceeds its CriticalLoad threshold.
+type CriticalLoadError struct {
+	Variable  string
+	Value     uint64
+	Threshold uint64
+}
+
+func (e *CriticalLoadError) Error() string {
+	return fmt.Sprintf("critical load exceeded: %s=%d (threshold %d)", e.Variable, e.Value, e.Threshold)
+}
+
+// lowerKeys returns thresholds with the variable names in lower case,
+// so that they match regardless of how they were specified.
+func lowerKeys(thresholds map[string]uint64) map[string]uint64 {
+	if thresholds == nil {
+		return nil
+	}
+	lowered := make(map[string]uint64, len(thresholds))
+	for name, threshold := range thresholds {
+		lowered[strings.ToLower(name)] = threshold
+	}
+	return lowered
+}
+
+// exceededLoad returns the first variable (in name order) whose value in
+// status is greater than its threshold, and false if there is none.
+func exceededLoad(status map[string]uint64, thresholds map[string]uint64) (string, bool) {
+	vars := make([]string, 0, len(thresholds))
+	for name := range thresholds {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+	for _, name := range vars {
+		if status[name] > thresholds[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// loadVariables returns the names of all variables in maxLoad and criticalLoad.
+func (c *Copier) loadVariables() []string {
+	var vars []string
+	for name := range c.maxLoad {
+		vars = append(vars, name)
+	}
+	for name := range c.criticalLoad {
+		if _, ok := c.maxLoad[name]; !ok {
+			vars = append(vars, name)
+		}
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// globalStatus reads the current value of the global status variables.
+// Variables that can not be found or are not numeric are an error, since
+// the thresholds could otherwise never be reached.
+func (c *Copier) globalStatus(ctx context.Context, vars []string) (map[string]uint64, error) {
+	args := make([]interface{}, len(vars))
+	for i, name := range vars {
+		args[i] = name
+	}
+	query := "SELECT variable_name, variable_value FROM performance_schema.global_status WHERE variable_name IN (?" + strings.Repeat(", ?", len(vars)-1) + ")"
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	status := make(map[string]uint64, len(vars))
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("status variable %s is not numeric: %q", name, value)
+		}
+		status[strings.ToLower(name)] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, name := range vars {
+		if _, ok := status[name]; !ok {
+			return nil, fmt.Errorf("status variable %s does not exist", name)
+		}
+	}
+	return status, nil
+}
+
+// waitForLoad returns a CriticalLoadError if any variable exceeds its
+// CriticalLoad threshold, and blocks while any variable exceeds its
+// MaxLoad threshold, in the style of pt-online-schema-change.
+func (c *Copier) waitForLoad(ctx context.Context) error {
+	if len(c.maxLoad) == 0 && len(c.criticalLoad) == 0 {
+		return nil
+	}
+	vars := c.loadVariables()
+	for logged := false; ; {
+		status, err := c.loadStatus(ctx, vars)
+		if err != nil {
+			return err
+		}
+		if name, ok := exceededLoad(status, c.criticalLoad); ok {
+			return &CriticalLoadError{Variable: name, Value: status[name], Threshold: c.criticalLoad[name]}
+		}
+		name, ok := exceededLoad(status, c.maxLoad)
+		if !ok {
+			return nil
+		}
+		if !logged {
+			c.logger.Warnf("max load exceeded, pausing copy: %s=%d (threshold %d)", name, status[name], c.maxLoad[name])
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(loadCheckInterval):
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package row
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExceededLoad(t *testing.T) {
+	thresholds := map[string]uint64{"threads_running": 25, "threads_connected": 100}
+	_, ok := exceededLoad(map[string]uint64{"threads_running": 25, "threads_connected": 100}, thresholds)
+	assert.False(t, ok) // equal to the threshold is not exceeding it.
+	name, ok := exceededLoad(map[string]uint64{"threads_running": 26, "threads_connected": 1}, thresholds)
+	assert.True(t, ok)
+	assert.Equal(t, "threads_running", name)
+	_, ok = exceededLoad(map[string]uint64{"threads_running": 100}, nil)
+	assert.False(t, ok)
+}
+
+func TestCopierMaxLoad(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "maxloadt1")
+	t2 := table.NewTableInfo(nil, "test", "_maxloadt1_new")
+	config := NewCopierDefaultConfig()
+	config.MaxLoad = map[string]uint64{"Threads_running": 10}
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"threads_running"}, copier.loadVariables())
+
+	// The copier waits while the load is above the threshold.
+	loadCheckInterval = time.Millisecond
+	var running, checks atomic.Int64
+	running.Store(50)
+	copier.loadStatus = func(ctx context.Context, vars []string) (map[string]uint64, error) {
+		checks.Add(1)
+		return map[string]uint64{"threads_running": uint64(running.Load())}, nil
+	}
+	done := make(chan error)
+	go func() {
+		done <- copier.waitForLoad(context.Background())
+	}()
+	assert.Eventually(t, func() bool { return checks.Load() > 3 }, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("waitForLoad returned while the load was above the threshold")
+	default:
+	}
+	running.Store(5)
+	assert.NoError(t, <-done)
+
+	// The wait can be cancelled.
+	running.Store(50)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, copier.waitForLoad(ctx), context.Canceled)
+}
+
+func TestCopierCriticalLoad(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "criticalloadt1")
+	t2 := table.NewTableInfo(nil, "test", "_criticalloadt1_new")
+	config := NewCopierDefaultConfig()
+	config.MaxLoad = map[string]uint64{"Threads_running": 10}
+	config.CriticalLoad = map[string]uint64{"Threads_running": 40, "Threads_connected": 1000}
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"threads_connected", "threads_running"}, copier.loadVariables())
+
+	copier.loadStatus = func(ctx context.Context, vars []string) (map[string]uint64, error) {
+		return map[string]uint64{"threads_running": 50, "threads_connected": 10}, nil
+	}
+	err = copier.waitForLoad(context.Background())
+	var criticalErr *CriticalLoadError
+	assert.True(t, errors.As(err, &criticalErr))
+	assert.Equal(t, "threads_running", criticalErr.Variable)
+	assert.Equal(t, uint64(50), criticalErr.Value)
+	assert.Equal(t, uint64(40), criticalErr.Threshold)
+	assert.EqualError(t, err, "critical load exceeded: threads_running=50 (threshold 40)")
+
+	// Errors checking the load fail the copy.
+	copier.loadStatus = func(ctx context.Context, vars []string) (map[string]uint64, error) {
+		return nil, errors.New("could not check load")
+	}
+	assert.EqualError(t, copier.waitForLoad(context.Background()), "could not check load")
+}
+
+func TestCopierCriticalLoadGlobalStatus(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS criticalloadt2, _criticalloadt2_new")
+	testutils.RunSQL(t, "CREATE TABLE criticalloadt2 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _criticalloadt2_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO criticalloadt2 VALUES (1, 1), (2, 2), (3, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "criticalloadt2")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_criticalloadt2_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	// At least the query checking the load is running,
+	// so a threshold of zero is always exceeded.
+	config := NewCopierDefaultConfig()
+	config.CriticalLoad = map[string]uint64{"Threads_running": 0}
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	var criticalErr *CriticalLoadError
+	assert.True(t, errors.As(copier.Run(context.TODO()), &criticalErr))
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&copier.CopyRowsCount))
+
+	// A variable that does not exist is an error.
+	config.CriticalLoad = map[string]uint64{"Threads_sleeping": 10}
+	copier, err = NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, copier.Run(context.TODO()), "status variable threads_sleeping does not exist")
+
+	config.CriticalLoad = map[string]uint64{"Threads_running": 1000}
+	copier, err = NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.TODO()))
+	assert.Equal(t, uint64(3), atomic.LoadUint64(&copier.CopyRowsCount))
+}
@@ -0,0 +1,103 @@
+package row
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	pauseCheckInterval    = 1 * time.Second  // how frequently a paused copier checks if it can resume
+	scheduleCheckInterval = 10 * time.Second // how frequently the schedule is re-evaluated
+)
+
+// TimeWindow is a daily window in which the copier should not copy rows.
+// Start and End are wall clock times of day in Location (UTC if nil),
+// as offsets from midnight. If End is before Start, the window wraps
+// around midnight.
+type TimeWindow struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// Contains returns true if the wall clock time of t falls inside the
+// window. The elapsed time since midnight is not used, since it differs
+// from the wall clock time on the days that daylight saving time changes.
+func (w TimeWindow) Contains(t time.Time) bool {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	hour, minute, sec := t.In(loc).Clock()
+	offset := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(t.Nanosecond())
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Pause stops the copier from starting any new chunks
+// until Resume is called. Chunks already in progress will complete.
+func (c *Copier) Pause() {
+	c.isPaused.Store(true)
+}
+
+// Resume resumes copying after a call to Pause.
+// It does not override a pause caused by the schedule.
+func (c *Copier) Resume() {
+	c.isPaused.Store(false)
+}
+
+// IsPaused returns true if the copier is paused, either
+// because Pause was called or because of the schedule.
+func (c *Copier) IsPaused() bool {
+	return c.isPaused.Load() || c.isSchedulePaused.Load()
+}
+
+// waitWhilePaused blocks until the copier is no longer paused,
+// or the context is cancelled.
+func (c *Copier) waitWhilePaused(ctx context.Context) error {
+	for c.IsPaused() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pauseCheckInterval):
+		}
+	}
+	return nil
+}
+
+// evaluateSchedule pauses the copier if the current time is
+// in one of the schedule windows, and resumes it otherwise.
+func (c *Copier) evaluateSchedule() {
+	now := c.clock()
+	inWindow := false
+	for _, w := range c.schedule {
+		if w.Contains(now) {
+			inWindow = true
+			break
+		}
+	}
+	if c.isSchedulePaused.Swap(inWindow) != inWindow {
+		if inWindow {
+			c.logger.Infof("copier paused by schedule at %s", now)
+		} else {
+			c.logger.Infof("copier resumed by schedule at %s", now)
+		}
+	}
+}
+
+// scheduleLoop re-evaluates the schedule until the context is cancelled.
+func (c *Copier) scheduleLoop(ctx context.Context) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.evaluateSchedule()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package row
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	business := TimeWindow{Start: 9 * time.Hour, End: 17 * time.Hour}
+	assert.False(t, business.Contains(time.Date(2024, 1, 1, 8, 59, 59, 0, time.UTC)))
+	assert.True(t, business.Contains(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)))
+	assert.True(t, business.Contains(time.Date(2024, 1, 1, 16, 59, 59, 0, time.UTC)))
+	assert.False(t, business.Contains(time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC)))
+
+	// Windows can wrap around midnight.
+	overnight := TimeWindow{Start: 22 * time.Hour, End: 2 * time.Hour}
+	assert.True(t, overnight.Contains(time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, overnight.Contains(time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, overnight.Contains(time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)))
+
+	// Windows are evaluated in their own timezone.
+	tz := time.FixedZone("UTC-5", -5*60*60)
+	business.Location = tz
+	assert.False(t, business.Contains(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)))
+	assert.True(t, business.Contains(time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC)))
+
+	// On the days that daylight saving time changes, the wall clock
+	// time is used rather than the time elapsed since midnight.
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data is not available: %v", err)
+	}
+	morning := TimeWindow{Start: 9 * time.Hour, End: 10 * time.Hour, Location: ny}
+	assert.True(t, morning.Contains(time.Date(2024, 3, 10, 9, 30, 0, 0, ny)))   // 8.5h after midnight
+	assert.False(t, morning.Contains(time.Date(2024, 3, 10, 10, 30, 0, 0, ny))) // 9.5h after midnight
+	assert.True(t, morning.Contains(time.Date(2024, 11, 3, 9, 30, 0, 0, ny)))   // 10.5h after midnight
+	assert.False(t, morning.Contains(time.Date(2024, 11, 3, 8, 30, 0, 0, ny)))  // 9.5h after midnight
+}
+
+func TestCopierSchedule(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "schedulet1")
+	t2 := table.NewTableInfo(nil, "test", "_schedulet1_new")
+	config := NewCopierDefaultConfig()
+	config.Schedule = []TimeWindow{{Start: 9 * time.Hour, End: 17 * time.Hour}}
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+
+	// Use a fake clock that starts just before the window.
+	now := time.Date(2024, 1, 1, 8, 59, 0, 0, time.UTC)
+	copier.clock = func() time.Time { return now }
+	copier.evaluateSchedule()
+	assert.False(t, copier.IsPaused())
+
+	// Cross into the window.
+	now = now.Add(2 * time.Minute)
+	copier.evaluateSchedule()
+	assert.True(t, copier.IsPaused())
+
+	// Resume does not override the schedule.
+	copier.Resume()
+	assert.True(t, copier.IsPaused())
+
+	// Cross out of the window.
+	now = time.Date(2024, 1, 1, 17, 0, 1, 0, time.UTC)
+	copier.evaluateSchedule()
+	assert.False(t, copier.IsPaused())
+
+	// A manual pause blocks until the context is cancelled.
+	copier.Pause()
+	assert.True(t, copier.IsPaused())
+	pauseCheckInterval = time.Millisecond
+	defer func() { pauseCheckInterval = time.Second }()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, copier.waitWhilePaused(ctx), context.DeadlineExceeded)
+
+	copier.Resume()
+	assert.False(t, copier.IsPaused())
+	assert.NoError(t, copier.waitWhilePaused(context.Background()))
+}
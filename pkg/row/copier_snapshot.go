@@ -0,0 +1,262 @@
+package row
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+)
+
+const (
+	// DefaultConsistentSnapshotMaxRows is the largest table (by estimated rows)
+	// that is copied in ConsistentSnapshot mode when no limit is configured.
+	DefaultConsistentSnapshotMaxRows = 1000000
+	snapshotMaxPlaceholders          = 65535     // the most placeholders MySQL accepts in one statement
+	snapshotPacketHeadroom           = 64 * 1024 // left of max_allowed_packet for the rest of the statement
+	snapshotSavepoint                = "spirit_chunk"
+)
+
+// errSnapshotNotCommitted is returned by GetLowWatermark in ConsistentSnapshot mode
+// until the transaction has committed. Before then no rows are visible to
+// other sessions, so none are guaranteed to be in the new table.
+var errSnapshotNotCommitted = errors.New("the consistent snapshot copy has not committed")
+
+// checkConsistentSnapshot returns an error if the table can not
+// be copied in ConsistentSnapshot mode with this config.
+func checkConsistentSnapshot(tbl *table.TableInfo, config *CopierConfig) error {
+	if config.IncrementalColumn != "" {
+		return errors.New("consistent snapshot mode can not be combined with an incremental column")
+	}
+	maxRows := config.ConsistentSnapshotMaxRows
+	if maxRows == 0 {
+		maxRows = DefaultConsistentSnapshotMaxRows
+	}
+	if tbl.EstimatedRows > maxRows {
+		return fmt.Errorf("table %s has an estimated %d rows, which is more than the %d rows that can be copied in consistent snapshot mode",
+			tbl.QuotedName, tbl.EstimatedRows, maxRows)
+	}
+	return nil
+}
+
+// runSnapshot copies all chunks in a single REPEATABLE READ transaction,
+// started WITH CONSISTENT SNAPSHOT. Every chunk is read with a consistent
+// read, so the new table contains the table exactly as it was when the
+// transaction started, regardless of concurrent writes. Each chunk is
+// copied under a savepoint, so a chunk that fails with a lock wait
+// timeout can be retried without rolling back the chunks before it.
+func (c *Copier) runSnapshot(ctx context.Context) error {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The connection is read committed by default. SET TRANSACTION (without
+	// SESSION) only applies to the next transaction, so the isolation level
+	// of the connection is unchanged when it is returned to the pool.
+	if _, err := conn.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+	// The rows of a chunk are inserted in batches that fit in max_allowed_packet.
+	var maxAllowedPacket int
+	if err := conn.QueryRowContext(ctx, "SELECT @@max_allowed_packet").Scan(&maxAllowedPacket); err != nil {
+		return err
+	}
+	maxBatchBytes := maxAllowedPacket - snapshotPacketHeadroom
+	for c.isHealthy(ctx) && !c.ReachedMaxRows() {
+		if err := c.waitWhilePaused(ctx); err != nil {
+			return err
+		}
+		throttleStartTime := time.Now()
+		if err := c.waitForLoad(ctx); err != nil {
+			return err
+		}
+		if err := c.Throttler.BlockWait(); err != nil {
+			return err
+		}
+		startTime := time.Now()
+		atomic.AddInt64(&c.ThrottleWaitTime, int64(startTime.Sub(throttleStartTime)))
+		chunk, err := c.chunker.Next()
+		if err != nil {
+			if err == table.ErrTableIsRead {
+				break
+			}
+			return err
+		}
+		affectedRows, err := c.copySnapshotChunkWithRetry(ctx, conn, chunk, maxBatchBytes)
+		if err != nil {
+			return err
+		}
+		atomic.AddUint64(&c.CopyRowsCount, uint64(affectedRows))
+		atomic.AddUint64(&c.CopyRowsLogicalCount, chunk.ChunkSize)
+		atomic.AddUint64(&c.CopyBytesCount, uint64(affectedRows)*c.table.AvgRowLength)
+		atomic.AddUint64(&c.CopyChunksCount, 1)
+		c.chunker.Feedback(chunk, time.Since(startTime))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return err
+	}
+	committed = true
+	c.snapshotCommitted.Store(true)
+	return nil
+}
+
+// copySnapshotChunkWithRetry copies chunk under a savepoint. A lock wait
+// timeout only rolls back the statement, so the chunk is rolled back to the
+// savepoint and retried. Any other error fails the copy, since errors such
+// as a deadlock roll back the whole transaction.
+func (c *Copier) copySnapshotChunkWithRetry(ctx context.Context, conn *sql.Conn, chunk *table.Chunk, maxBatchBytes int) (int64, error) {
+	var err error
+	attempts := max(c.dbConfig.MaxRetries, 1)
+	for i := 0; i < attempts; i++ {
+		if _, err = conn.ExecContext(ctx, "SAVEPOINT "+snapshotSavepoint); err != nil {
+			return 0, err
+		}
+		var affectedRows int64
+		affectedRows, err = c.copySnapshotChunk(ctx, conn, chunk, maxBatchBytes)
+		if err == nil {
+			_, err = conn.ExecContext(ctx, "RELEASE SAVEPOINT "+snapshotSavepoint)
+			return affectedRows, err
+		}
+		if !dbconn.IsLockWaitTimeoutError(err) {
+			c.chunkError(chunk, err, false)
+			return 0, err
+		}
+		if _, rollbackErr := conn.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+snapshotSavepoint); rollbackErr != nil {
+			c.chunkError(chunk, err, false)
+			return 0, rollbackErr
+		}
+		c.chunkError(chunk, err, i < attempts-1)
+		atomic.AddUint64(&c.CopyRetriesCount, 1)
+		c.logger.Warnf("retrying chunk %s in consistent snapshot after error: %v", chunk.String(), err)
+	}
+	return 0, err
+}
+
+// copySnapshotChunk reads the rows of chunk with a consistent read, and
+// inserts them into the new table. INSERT .. SELECT can not be used, since
+// in REPEATABLE READ its SELECT is a locking read of the latest version of
+// each row, not a read of the snapshot. The rows are inserted in batches
+// with an estimated size of at most maxBatchBytes.
+func (c *Copier) copySnapshotChunk(ctx context.Context, conn *sql.Conn, chunk *table.Chunk, maxBatchBytes int) (int64, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", c.columns, c.table.FromName(), chunk.String())
+	c.logger.Debugf("running chunk: %s, query: %s", chunk.String(), query)
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	// The rows must be read before inserting, since the
+	// connection can not be used while rows is open.
+	var values []interface{}
+	var rowSizes []int
+	for rows.Next() {
+		row := make([]interface{}, len(names))
+		dest := make([]interface{}, len(names))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		values = append(values, row...)
+		rowSizes = append(rowSizes, snapshotRowSize(row))
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	var affectedRows int64
+	for start := 0; start < len(rowSizes); {
+		end := snapshotBatchEnd(rowSizes, start, len(names), maxBatchBytes)
+		n := end - start
+		rowPlaceholders := "(?" + strings.Repeat(", ?", len(names)-1) + ")"
+		stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+			c.newTable.QuotedName,
+			c.columns,
+			rowPlaceholders+strings.Repeat(", "+rowPlaceholders, n-1),
+		)
+		res, err := conn.ExecContext(ctx, stmt, values[start*len(names):end*len(names)]...)
+		if err != nil {
+			return 0, err
+		}
+		if err := checkSnapshotWarnings(ctx, conn); err != nil {
+			return 0, err
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		affectedRows += inserted
+		start = end
+	}
+	return affectedRows, nil
+}
+
+// snapshotRowSize estimates the bytes that the values of row add to an
+// INSERT statement. Strings and bytes are counted twice, since they can
+// double in size when they are escaped.
+func snapshotRowSize(row []interface{}) int {
+	size := 0
+	for _, v := range row {
+		switch v := v.(type) {
+		case []byte:
+			size += 2*len(v) + 4
+		case string:
+			size += 2*len(v) + 4
+		default:
+			size += 32
+		}
+	}
+	return size
+}
+
+// snapshotBatchEnd returns the end of the batch of rows that starts at start.
+// A batch has at most snapshotMaxPlaceholders placeholders and an estimated
+// size of at most maxBatchBytes, but always at least one row.
+func snapshotBatchEnd(rowSizes []int, start, columns, maxBatchBytes int) int {
+	maxRows := max(snapshotMaxPlaceholders/columns, 1)
+	end, size := start+1, rowSizes[start]
+	for end < len(rowSizes) && end-start < maxRows && size+rowSizes[end] <= maxBatchBytes {
+		size += rowSizes[end]
+		end++
+	}
+	return end
+}
+
+// checkSnapshotWarnings returns an error if the last statement on conn
+// produced a warning. With sql_mode="" a value that does not fit the new
+// table is truncated with a warning instead of an error.
+func checkSnapshotWarnings(ctx context.Context, conn *sql.Conn) error {
+	var level, message string
+	var code int
+	err := conn.QueryRowContext(ctx, "SHOW WARNINGS LIMIT 1").Scan(&level, &code, &message)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("unsafe warning copying rows in consistent snapshot: %s %d: %s", level, code, message)
+}
@@ -0,0 +1,124 @@
+package row
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/cashapp/spirit/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConsistentSnapshot(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "snapshotcheckt1")
+	t2 := table.NewTableInfo(nil, "test", "_snapshotcheckt1_new")
+	t1.EstimatedRows = DefaultConsistentSnapshotMaxRows
+	config := NewCopierDefaultConfig()
+	config.ConsistentSnapshot = true
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+
+	// No low watermark is available until the copy has committed.
+	_, err = copier.GetLowWatermark()
+	assert.ErrorIs(t, err, errSnapshotNotCommitted)
+	// Changes above the high watermark must still be applied.
+	assert.True(t, copier.ConsistentSnapshot())
+	assert.False(t, copier.KeyAboveHighWatermark(1))
+
+	// Tables larger than the limit can not be copied in a snapshot.
+	t1.EstimatedRows = DefaultConsistentSnapshotMaxRows + 1
+	_, err = NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, "more than the 1000000 rows that can be copied in consistent snapshot mode")
+	config.ConsistentSnapshotMaxRows = 2 * DefaultConsistentSnapshotMaxRows
+	_, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+
+	config.IncrementalColumn = "b"
+	t1.Columns = []string{"a", "b"}
+	_, err = NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, "can not be combined with an incremental column")
+}
+
+func TestSnapshotBatchEnd(t *testing.T) {
+	assert.Equal(t, 36+8+32, snapshotRowSize([]interface{}{[]byte("0123456789abcdef"), "ab", int64(1)}))
+
+	// Batches are limited by their estimated size.
+	rowSizes := []int{100, 100, 100, 100, 100}
+	assert.Equal(t, 5, snapshotBatchEnd(rowSizes, 0, 3, 1000))
+	assert.Equal(t, 3, snapshotBatchEnd(rowSizes, 0, 3, 300))
+	assert.Equal(t, 5, snapshotBatchEnd(rowSizes, 3, 3, 300))
+	// A batch always has at least one row, even if it is too large.
+	assert.Equal(t, 1, snapshotBatchEnd(rowSizes, 0, 3, 50))
+	assert.Equal(t, 3, snapshotBatchEnd(rowSizes, 2, 3, -1))
+
+	// And by the number of placeholders.
+	rowSizes = make([]int, 100000)
+	assert.Equal(t, snapshotMaxPlaceholders/3, snapshotBatchEnd(rowSizes, 0, 3, 1<<30))
+	assert.Equal(t, 1, snapshotBatchEnd(rowSizes, 0, snapshotMaxPlaceholders+1, 1<<30))
+}
+
+// onceThrottler runs fn the first time the copier checks the throttler,
+// which in ConsistentSnapshot mode is after the snapshot has started.
+type onceThrottler struct {
+	throttler.Noop
+	once sync.Once
+	fn   func()
+}
+
+func (t *onceThrottler) BlockWait() error {
+	t.once.Do(t.fn)
+	return nil
+}
+
+func TestCopierConsistentSnapshot(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS snapshott1, _snapshott1_new")
+	testutils.RunSQL(t, "CREATE TABLE snapshott1 (a INT NOT NULL, b INT, c VARCHAR(255), PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _snapshott1_new (a INT NOT NULL, b INT, c VARCHAR(255), PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO snapshott1 SELECT n, n, CONCAT('row ', n) FROM (SELECT a.N + b.N * 10 + c.N * 100 + 1 AS n FROM (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) a, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) b, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) c) nums")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "snapshott1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_snapshott1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	// Rows are inserted, updated and deleted throughout the table after
+	// the snapshot has started. None of the changes are copied.
+	var written atomic.Bool
+	config := NewCopierDefaultConfig()
+	config.ConsistentSnapshot = true
+	config.Throttler = &onceThrottler{fn: func() {
+		testutils.RunSQL(t, "INSERT INTO snapshott1 VALUES (1001, 1001, 'new'), (1002, 1002, 'new')")
+		testutils.RunSQL(t, "UPDATE snapshott1 SET b = b + 1000000, c = 'updated' WHERE a % 10 = 0")
+		testutils.RunSQL(t, "DELETE FROM snapshott1 WHERE a % 10 = 1")
+		written.Store(true)
+	}}
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.TODO()))
+	assert.True(t, written.Load())
+	assert.Equal(t, uint64(1000), atomic.LoadUint64(&copier.CopyRowsCount))
+
+	var count, updated, maxA int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*), SUM(c = 'updated'), MAX(a) FROM _snapshott1_new").Scan(&count, &updated, &maxA))
+	assert.Equal(t, 1000, count)
+	assert.Equal(t, 0, updated)
+	assert.Equal(t, 1000, maxA)
+	var mismatched int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _snapshott1_new WHERE b != a OR c != CONCAT('row ', a)").Scan(&mismatched))
+	assert.Equal(t, 0, mismatched)
+
+	// The changes are visible in the table, and the low
+	// watermark is available now that the copy has committed.
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM snapshott1").Scan(&count))
+	assert.Equal(t, 902, count)
+	_, err = copier.GetLowWatermark()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,143 @@
+package row
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultSupervisorMaxAttempts = 3               // attempts to run the copy, including the first
+	DefaultSupervisorBackoff     = 5 * time.Second // wait before the second attempt, doubled for each attempt after it
+)
+
+// CopierCheckpoint is the progress of a copy, from which
+// it can be resumed with NewCopierFromCheckpoint.
+type CopierCheckpoint struct {
+	LowWatermark      string
+	RowsCopied        uint64
+	RowsCopiedLogical uint64
+}
+
+// SupervisorConfig configures SupervisedRun.
+type SupervisorConfig struct {
+	// MaxAttempts is the number of times the copy is run, including the
+	// first. Zero uses DefaultSupervisorMaxAttempts.
+	MaxAttempts int
+	// Backoff is how long to wait before the second attempt. It doubles
+	// for each attempt after that. Zero uses DefaultSupervisorBackoff.
+	Backoff time.Duration
+	// Checkpoint returns the checkpoint to resume from after an attempt
+	// fails, i.e. the checkpoint that was last persisted by the caller.
+	// Nil resumes from the low watermark of the copier that failed.
+	Checkpoint func(ctx context.Context) (CopierCheckpoint, error)
+	// IsRetryable returns true if the copy may succeed when it is resumed
+	// after err. Nil retries every error except when ctx is done, and a
+	// CriticalLoadError.
+	IsRetryable func(err error) bool
+	// OnResume is called with the new copier before it runs. Anything that
+	// refers to the copier that failed must be pointed at the new copier,
+	// e.g. the KeyAboveCopierCallback of the replication client.
+	OnResume func(c *Copier)
+}
+
+// Checkpoint returns the low watermark and the rows copied so far.
+func (c *Copier) Checkpoint() (CopierCheckpoint, error) {
+	lowWatermark, err := c.GetLowWatermark()
+	if err != nil {
+		return CopierCheckpoint{}, err
+	}
+	return CopierCheckpoint{
+		LowWatermark:      lowWatermark,
+		RowsCopied:        atomic.LoadUint64(&c.CopyRowsCount),
+		RowsCopiedLogical: atomic.LoadUint64(&c.CopyRowsLogicalCount),
+	}, nil
+}
+
+// SupervisedRun runs the copier, and when it fails with an error that is
+// retryable, resumes the copy from the last checkpoint with a new copier.
+// Unlike the retries of each chunk, the state of the copy is rebuilt: the
+// new copier has a new chunker, opened at the checkpoint, and the idle
+// connections of the pool are closed so that it copies on new connections.
+// It returns the copier of the last attempt, so its progress can be read
+// when it fails.
+func SupervisedRun(ctx context.Context, c *Copier, config SupervisorConfig) (*Copier, error) {
+	maxAttempts := config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultSupervisorMaxAttempts
+	}
+	backoff := config.Backoff
+	if backoff <= 0 {
+		backoff = DefaultSupervisorBackoff
+	}
+	isRetryable := config.IsRetryable
+	if isRetryable == nil {
+		isRetryable = isRetryableCopyError
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.Run(ctx)
+		if err == nil {
+			return c, nil
+		}
+		if attempt >= maxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return c, err
+		}
+		var checkpoint CopierCheckpoint
+		var checkpointErr error
+		if config.Checkpoint != nil {
+			checkpoint, checkpointErr = config.Checkpoint(ctx)
+		} else {
+			checkpoint, checkpointErr = c.Checkpoint()
+		}
+		if checkpointErr != nil {
+			return c, fmt.Errorf("copy failed, and there is no checkpoint to resume from: %w (checkpoint: %v)", err, checkpointErr)
+		}
+		c.logger.Warnf("copy failed on attempt %d of %d, resuming from checkpoint %s in %s: %v",
+			attempt, maxAttempts, checkpoint.LowWatermark, backoff, err)
+		select {
+		case <-ctx.Done():
+			return c, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		closeIdleConns(ctx, c.db)
+		resumed, err := NewCopierFromCheckpoint(c.db, c.table, c.newTable, c.config, checkpoint.LowWatermark,
+			checkpoint.RowsCopied, checkpoint.RowsCopiedLogical)
+		if err != nil {
+			return c, err
+		}
+		resumed.SetThrottler(c.Throttler)
+		if config.OnResume != nil {
+			config.OnResume(resumed)
+		}
+		c = resumed
+	}
+}
+
+// closeIdleConns closes the idle connections of db, so that they are not
+// reused by the resumed copy. The pool may be shared, so its configuration
+// is not changed: each idle connection is taken from the pool and discarded.
+func closeIdleConns(ctx context.Context, db *sql.DB) {
+	conns := make([]*sql.Conn, 0, db.Stats().Idle)
+	for range cap(conns) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			break
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+}
+
+// isRetryableCopyError returns false for the errors that resuming the copy
+// does not resolve: the load of the server is critical, or ctx is done.
+func isRetryableCopyError(err error) bool {
+	var criticalLoadErr *CriticalLoadError
+	return !errors.As(err, &criticalLoadErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,126 @@
+package row
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableCopyError(t *testing.T) {
+	assert.True(t, isRetryableCopyError(errors.New("invalid connection")))
+	assert.False(t, isRetryableCopyError(&CriticalLoadError{Variable: "threads_running", Value: 200, Threshold: 100}))
+	assert.False(t, isRetryableCopyError(fmt.Errorf("copy failed: %w", context.Canceled)))
+	assert.False(t, isRetryableCopyError(context.DeadlineExceeded))
+}
+
+func TestCloseIdleConns(t *testing.T) {
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(5)
+
+	// Fill the pool with 3 idle connections.
+	conns := make([]*sql.Conn, 3)
+	ids := make(map[int64]bool)
+	for i := range conns {
+		conns[i], err = db.Conn(context.TODO())
+		assert.NoError(t, err)
+		var id int64
+		assert.NoError(t, conns[i].QueryRowContext(context.TODO(), "SELECT CONNECTION_ID()").Scan(&id))
+		ids[id] = true
+	}
+	for _, conn := range conns {
+		assert.NoError(t, conn.Close())
+	}
+	assert.Equal(t, 3, db.Stats().Idle)
+
+	closeIdleConns(context.TODO(), db)
+	assert.Equal(t, 0, db.Stats().Idle)
+	var id int64
+	assert.NoError(t, db.QueryRow("SELECT CONNECTION_ID()").Scan(&id))
+	assert.False(t, ids[id])
+
+	// The limit of idle connections is not changed.
+	conns = make([]*sql.Conn, 5)
+	for i := range conns {
+		conns[i], err = db.Conn(context.TODO())
+		assert.NoError(t, err)
+	}
+	for _, conn := range conns {
+		assert.NoError(t, conn.Close())
+	}
+	assert.Equal(t, 5, db.Stats().Idle)
+}
+
+func TestSupervisedRun(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS supervisedt1, _supervisedt1_new")
+	testutils.RunSQL(t, "CREATE TABLE supervisedt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _supervisedt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO supervisedt1 SELECT n, n FROM (SELECT a.N + b.N * 10 + c.N * 100 + d.N * 1000 + 1 AS n FROM (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) a, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) b, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) c, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) d) nums")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "supervisedt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_supervisedt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	// The first attempt fails after two chunks have been copied.
+	config := NewCopierDefaultConfig()
+	config.Concurrency = 1
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	var chunks atomic.Int64
+	copier.execChunk = func(ctx context.Context, chunk *table.Chunk) (int64, error) {
+		if chunks.Add(1) > 2 {
+			return 0, errors.New("invalid connection")
+		}
+		return copier.execChunkQuery(ctx, chunk)
+	}
+	var checkpoints int
+	var onResume *Copier
+	resumed, err := SupervisedRun(context.TODO(), copier, SupervisorConfig{
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+		Checkpoint: func(ctx context.Context) (CopierCheckpoint, error) {
+			checkpoints++
+			return copier.Checkpoint()
+		},
+		OnResume: func(c *Copier) {
+			onResume = c
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, checkpoints)
+	assert.NotSame(t, copier, resumed)
+	assert.Same(t, resumed, onResume)
+	assert.Equal(t, int64(3), chunks.Load()) // the resumed copier does not use the failing execChunk.
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _supervisedt1_new").Scan(&count))
+	assert.Equal(t, 10000, count)
+
+	// An error that is not retryable is returned from the first attempt.
+	testutils.RunSQL(t, "TRUNCATE TABLE _supervisedt1_new")
+	copier, err = NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	copier.execChunk = func(ctx context.Context, chunk *table.Chunk) (int64, error) {
+		return 0, errors.New("invalid connection")
+	}
+	last, err := SupervisedRun(context.TODO(), copier, SupervisorConfig{
+		Backoff:     time.Millisecond,
+		IsRetryable: func(err error) bool { return false },
+	})
+	assert.ErrorContains(t, err, "invalid connection")
+	assert.Same(t, copier, last)
+}
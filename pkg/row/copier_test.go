@@ -2,7 +2,12 @@ package row
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,7 +17,10 @@ import (
 
 	"github.com/cashapp/spirit/pkg/table"
 	"github.com/cashapp/spirit/pkg/throttler"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/cashapp/spirit/pkg/utils"
+	"github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -20,13 +28,32 @@ import (
 type TestMetricsSink struct {
 	sync.Mutex
 	called int
+	values []metrics.MetricValue
+	err    error
 }
 
 func (t *TestMetricsSink) Send(ctx context.Context, m *metrics.Metrics) error {
 	t.Lock()
 	defer t.Unlock()
 	t.called += 1
-	return nil
+	t.values = append(t.values, m.Values...)
+	return t.err
+}
+
+// assertBackgroundLoopsExit checks that the background loops of copier
+// exit once Run completes, even though its context is never cancelled.
+func assertBackgroundLoopsExit(t *testing.T, copier *Copier) {
+	t.Helper()
+	loopsDone := make(chan struct{})
+	go func() {
+		copier.backgroundLoops.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+	case <-time.After(time.Second):
+		t.Fatal("background loops did not exit after copy completed")
+	}
 }
 
 func TestCopier(t *testing.T) {
@@ -51,12 +78,27 @@ func TestCopier(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, copier.Run(context.Background())) // works
 
+	assertBackgroundLoopsExit(t, copier)
+
 	// Verify that t2 has one row.
 	var count int
 	err = db.QueryRow("SELECT COUNT(*) FROM copiert2").Scan(&count)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
 
+	// Verify the status reflects the copy.
+	status := copier.Status()
+	assert.Equal(t, uint64(1), status.CopiedRows)
+	assert.Positive(t, status.ChunksCopied)
+	assert.False(t, status.StartTime.IsZero())
+	assert.False(t, status.IsThrottled)
+	assert.Equal(t, "clear", status.ThrottlerState)
+	assert.False(t, status.IsPaused)
+	assert.Positive(t, status.ChunkTimeP50)
+	assert.GreaterOrEqual(t, status.ChunkTimeP99, status.ChunkTimeP50)
+	_, err = json.Marshal(status)
+	assert.NoError(t, err)
+
 	// Verify that testMetricsSink.Send was called >0 times
 	// It will be 1 with the composite chunker, 3 with optimistic.
 	assert.Positive(t, testMetricsSink.called)
@@ -82,6 +124,8 @@ func TestThrottler(t *testing.T) {
 	copier.SetThrottler(&throttler.Noop{})
 	assert.NoError(t, copier.Run(context.Background())) // works
 
+	assertBackgroundLoopsExit(t, copier)
+
 	// Verify that t2 has one row.
 	var count int
 	err = db.QueryRow("SELECT COUNT(*) FROM throttlert2").Scan(&count)
@@ -106,7 +150,7 @@ func TestCopierUniqueDestination(t *testing.T) {
 
 	// if the checksum is FALSE, the unique violation will cause an error.
 	cfg := NewCopierDefaultConfig()
-	cfg.FinalChecksum = false
+	cfg.ChecksumMode = ChecksumModeNone
 	copier, err := NewCopier(db, t1, t2, cfg)
 	assert.NoError(t, err)
 	assert.Error(t, copier.Run(context.Background())) // fails
@@ -232,7 +276,6 @@ func TestLockWaitTimeoutRetryExceeded(t *testing.T) {
 	testutils.RunSQL(t, "CREATE TABLE lock2t1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
 	testutils.RunSQL(t, "CREATE TABLE lock2t2 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
 	testutils.RunSQL(t, "INSERT INTO lock2t1 VALUES (1, 2, 3)")
-	testutils.RunSQL(t, "INSERT INTO lock2t2 VALUES (1, 2, 3)")
 
 	config := dbconn.NewDBConfig()
 	config.MaxRetries = 2
@@ -256,7 +299,9 @@ func TestLockWaitTimeoutRetryExceeded(t *testing.T) {
 	go func() {
 		tx, err := db.Begin()
 		assert.NoError(t, err)
-		_, err = tx.Exec("SELECT * FROM lock2t2 WHERE a = 1 FOR UPDATE")
+		// The uncommitted row is locked, but the new table is still
+		// empty to the copier, which checks it before copying.
+		_, err = tx.Exec("INSERT INTO lock2t2 VALUES (1, 2, 3)")
 		assert.NoError(t, err)
 		wg.Done()
 		time.Sleep(60 * time.Second)
@@ -268,7 +313,83 @@ func TestLockWaitTimeoutRetryExceeded(t *testing.T) {
 	copier, err := NewCopier(db, t1, t2, NewCopierDefaultConfig())
 	assert.NoError(t, err)
 	err = copier.Run(context.Background())
-	assert.Error(t, err) // exceeded retry.
+	assert.ErrorContains(t, err, "Lock wait timeout exceeded") // exceeded retry.
+}
+
+func TestCopierChunkLockWaitTimeout(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS lock3t1, _lock3t1_new")
+	testutils.RunSQL(t, "CREATE TABLE lock3t1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _lock3t1_new (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO lock3t1 VALUES (1, 2, 3), (2, 3, 4), (3, 4, 5)")
+
+	// In repeatable-read the source rows are locked by INSERT .. SELECT.
+	dbConfig := dbconn.NewDBConfig()
+	dbConfig.TransactionIsolation = "repeatable-read"
+	db, err := dbconn.New(testutils.DSN(), dbConfig)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "lock3t1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_lock3t1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	config := NewCopierDefaultConfig()
+	config.DBConfig = dbConfig
+	config.ChunkLockWaitTimeout = 1
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	chunk := &table.Chunk{Key: []string{"a"}, AdditionalConditions: "a < 10"}
+	assert.True(t, strings.HasPrefix(copier.copyChunkQuery(chunk), "INSERT /*+ SET_VAR(innodb_lock_wait_timeout=1) */ IGNORE INTO `test`.`_lock3t1_new`"))
+
+	// The application holds a lock on a row for 2 seconds. The chunk
+	// times out after 1 second instead of 3, and is retried until the
+	// lock is released. The locked row is still copied.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		tx, err := db.Begin()
+		assert.NoError(t, err)
+		_, err = tx.Exec("SELECT * FROM lock3t1 WHERE a = 2 FOR UPDATE")
+		assert.NoError(t, err)
+		wg.Done()
+		time.Sleep(2 * time.Second)
+		assert.NoError(t, tx.Rollback())
+	}()
+	wg.Wait()
+	affectedRows, err := copier.execChunkQuery(context.Background(), chunk)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), affectedRows)
+	assert.Positive(t, atomic.LoadUint64(&copier.CopyRetriesCount))
+}
+
+func TestCopierNewTableNotEmpty(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS notemptyt1, _notemptyt1_new")
+	testutils.RunSQL(t, "CREATE TABLE notemptyt1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _notemptyt1_new (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO notemptyt1 VALUES (1, 2, 3), (2, 3, 4)")
+	testutils.RunSQL(t, "INSERT INTO _notemptyt1_new VALUES (1, 0, 0)") // stale row
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "notemptyt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_notemptyt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	copier, err := NewCopier(db, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.ErrorContains(t, copier.Run(context.Background()), "new table `test`.`_notemptyt1_new` is not empty")
+
+	// Once the new table is empty, the copy can start.
+	testutils.RunSQL(t, "TRUNCATE _notemptyt1_new")
+	copier, err = NewCopier(db, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.Background()))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _notemptyt1_new").Scan(&count))
+	assert.Equal(t, 2, count)
 }
 
 func TestCopierValidation(t *testing.T) {
@@ -332,18 +453,56 @@ func TestETA(t *testing.T) {
 	assert.Equal(t, uint64(1000), estimated)
 	assert.Equal(t, float64(9), pct)
 
+	// The estimate is corrected by the density of the copied rows.
 	copied, estimated, pct = copier2.getCopyStats()
-	assert.Equal(t, uint64(100), copied)
-	assert.Equal(t, uint64(10000), estimated)
+	assert.Equal(t, uint64(90), copied)
+	assert.Equal(t, uint64(9000), estimated)
 	assert.Equal(t, float64(1), pct) // 1%
 
 	copier1.rowsPerSecond = 10
 	copier2.rowsPerSecond = 10
 
 	assert.Equal(t, "1m31s", copier1.GetETA())
-	assert.Equal(t, "16m30s", copier2.GetETA())
+	assert.Equal(t, "14m51s", copier2.GetETA())
 	assert.Equal(t, "90/1000 9.00%", copier1.GetProgress())
-	assert.Equal(t, "100/10000 1.00%", copier2.GetProgress())
+	assert.Equal(t, "90/9000 1.00%", copier2.GetProgress())
+}
+
+func TestETASparseAutoInc(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS testetasparse, _testetasparse_new")
+	testutils.RunSQL(t, "CREATE TABLE testetasparse (a INT NOT NULL auto_increment, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _testetasparse_new (a INT NOT NULL auto_increment, b INT, c INT, PRIMARY KEY (a))")
+	// 10000 rows, with a max value of 100000.
+	testutils.RunSQL(t, "INSERT INTO testetasparse (a, b, c) SELECT (s1.n*100+s2.n+1)*10, 1, 1 FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 0 UNION ALL SELECT n+1 FROM seq WHERE n < 99) SELECT n FROM seq) s1, "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 0 UNION ALL SELECT n+1 FROM seq WHERE n < 99) SELECT n FROM seq) s2")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "testetasparse")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_testetasparse_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	copier, err := NewCopier(db, t1, t1new, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Open4Test())
+
+	// Copy the first half of the key space.
+	for copier.CopyRowsLogicalCount < 50000 {
+		chunk, err := copier.Next4Test()
+		assert.NoError(t, err)
+		assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+	}
+
+	// The estimate accounts for the table only being 10% dense,
+	// instead of assuming there is one row for each value.
+	copied, estimated, pct := copier.getCopyStats()
+	assert.InDelta(t, 5000, copied, 250)
+	assert.InDelta(t, 10000, estimated, 500)
+	assert.InDelta(t, 50, pct, 5)
+	assert.InDelta(t, pct, float64(copied)/float64(estimated)*100, 1)
 }
 
 func TestCopierFromCheckpoint(t *testing.T) {
@@ -373,6 +532,37 @@ func TestCopierFromCheckpoint(t *testing.T) {
 	assert.Equal(t, 10, count)
 }
 
+func TestCopierFromCheckpointStaleWatermark(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS copierstalet1, _copierstalet1_new")
+	testutils.RunSQL(t, "CREATE TABLE copierstalet1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _copierstalet1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO copierstalet1 VALUES (1, 1), (2, 2), (3, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "copierstalet1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_copierstalet1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	// The table was truncated and refilled since the checkpoint was written.
+	lowWatermark := `{"Key":["a"],"ChunkSize":1000,"LowerBound":{"Value":["5000"],"Inclusive":true},"UpperBound":{"Value":["6000"],"Inclusive":false}}`
+	_, err = NewCopierFromCheckpoint(db, t1, t1new, NewCopierDefaultConfig(), lowWatermark, 5000, 5000)
+	assert.ErrorContains(t, err, "a fresh copy is required: watermark 5000 is above the maximum value 3")
+
+	// The key of the table has changed.
+	lowWatermark = `{"Key":["b"],"ChunkSize":1,"LowerBound":{"Value":["1"],"Inclusive":true},"UpperBound":{"Value":["2"],"Inclusive":false}}`
+	_, err = NewCopierFromCheckpoint(db, t1, t1new, NewCopierDefaultConfig(), lowWatermark, 1, 1)
+	assert.ErrorContains(t, err, "does not match the key [a]")
+
+	// A watermark that does not parse as the key's type.
+	lowWatermark = `{"Key":["a"],"ChunkSize":1,"LowerBound":{"Value":["x"],"Inclusive":true},"UpperBound":{"Value":["y"],"Inclusive":false}}`
+	_, err = NewCopierFromCheckpoint(db, t1, t1new, NewCopierDefaultConfig(), lowWatermark, 1, 1)
+	assert.ErrorContains(t, err, `watermark value "x" is not valid for column a`)
+}
+
 func TestRangeOptimizationMustApply(t *testing.T) {
 	testutils.RunSQL(t, "DROP TABLE IF EXISTS rangeoptimizertest, _rangeoptimizertest_new")
 	testutils.RunSQL(t, "CREATE TABLE rangeoptimizertest (a INT NOT NULL auto_increment, b INT NOT NULL, c INT, PRIMARY KEY (a, b))")
@@ -410,3 +600,1156 @@ func TestRangeOptimizationMustApply(t *testing.T) {
 	err = copier.Run(context.Background())
 	assert.NoError(t, err) // works now.
 }
+
+func TestCopierChunkSizeBounds(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "boundst1")
+	t2 := table.NewTableInfo(nil, "test", "_boundst1_new")
+	config := NewCopierDefaultConfig()
+	config.MinChunkSize = 5000
+	config.MaxChunkSize = 100
+	_, err := NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, "must be less than or equal to maximum chunk size")
+
+	config.MaxChunkSize = 10000
+	_, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+}
+
+func TestCopierSlowChunk(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "slowchunkt1")
+	t2 := table.NewTableInfo(nil, "test", "_slowchunkt1_new")
+	logger, hook := test.NewNullLogger()
+	testMetricsSink := &TestMetricsSink{}
+	config := NewCopierDefaultConfig()
+	config.Logger = logger
+	config.MetricsSink = testMetricsSink
+	config.SlowChunkThreshold = 500 * time.Millisecond
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+
+	// Only the second chunk exceeds the threshold.
+	durations := []time.Duration{100 * time.Millisecond, 2 * time.Second, 500 * time.Millisecond}
+	for i, d := range durations {
+		chunk := &table.Chunk{Key: []string{"a"}, ChunkSize: 1000, AdditionalConditions: fmt.Sprintf("a >= %d AND a < %d", i*1000, (i+1)*1000)}
+		copier.reportSlowChunk(context.Background(), chunk, d, 1000, "INSERT IGNORE ...")
+	}
+	var warnings []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings = append(warnings, entry)
+		}
+	}
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0].Message, "(a >= 1000 AND a < 2000)")
+	assert.Contains(t, warnings[0].Message, "affected rows: 1000")
+	assert.Equal(t, []metrics.MetricValue{{Name: metrics.ChunkSlowCountMetricName, Type: metrics.COUNTER, Value: 1}}, testMetricsSink.values)
+
+	// A zero threshold disables slow chunk logging.
+	copier.slowChunkThreshold = 0
+	copier.reportSlowChunk(context.Background(), &table.Chunk{Key: []string{"a"}}, time.Hour, 0, "")
+	assert.Len(t, hook.AllEntries(), len(warnings))
+}
+
+func TestCopierForcePrimaryIndex(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "forceindext1")
+	t2 := table.NewTableInfo(nil, "test", "_forceindext1_new")
+	chunk := &table.Chunk{Key: []string{"a"}, AdditionalConditions: "a < 10"}
+
+	config := NewCopierDefaultConfig()
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Contains(t, copier.copyChunkQuery(chunk), "FROM `test`.`forceindext1` FORCE INDEX (PRIMARY) WHERE")
+
+	config.DisableForcePrimaryIndex = true
+	copier, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Contains(t, copier.copyChunkQuery(chunk), "FROM `test`.`forceindext1` WHERE")
+	assert.NotContains(t, copier.copyChunkQuery(chunk), "FORCE INDEX")
+
+	// A config that is not built from the defaults keeps the hint.
+	copier, err = NewCopier(nil, t1, t2, &CopierConfig{Logger: config.Logger, DBConfig: config.DBConfig})
+	assert.NoError(t, err)
+	assert.Contains(t, copier.copyChunkQuery(chunk), "FORCE INDEX (PRIMARY)")
+}
+
+func TestCopierConnLimiter(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS connlimitt1, _connlimitt1_new")
+	testutils.RunSQL(t, "CREATE TABLE connlimitt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _connlimitt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO connlimitt1 VALUES (1, 1), (2, 2), (3, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "connlimitt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_connlimitt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	limiter := dbconn.NewConnLimiter(1)
+	config := NewCopierDefaultConfig()
+	config.ConnLimiter = limiter
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Open4Test())
+	chunk, err := copier.Next4Test()
+	assert.NoError(t, err)
+
+	// Another component holds the only slot, so the chunk waits.
+	assert.NoError(t, limiter.Acquire(context.Background()))
+	copied := make(chan error)
+	go func() {
+		copied <- copier.CopyChunk(context.TODO(), chunk)
+	}()
+	select {
+	case <-copied:
+		t.Fatal("chunk was copied without a slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+	limiter.Release()
+	assert.NoError(t, <-copied)
+	assert.Equal(t, 0, limiter.InUse())
+	assert.Equal(t, uint64(3), copier.CopyRowsCount)
+}
+
+func TestCopierWarmUp(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "warmupt1")
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_warmupt1_new")
+
+	config := NewCopierDefaultConfig()
+	config.Concurrency = 4
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, copier.effectiveConcurrency(0)) // no warm-up
+
+	config.WarmUp = 40 * time.Second
+	copier, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, copier.effectiveConcurrency(0))
+	assert.Equal(t, 1, copier.effectiveConcurrency(9*time.Second))
+	assert.Equal(t, 2, copier.effectiveConcurrency(10*time.Second))
+	assert.Equal(t, 2, copier.effectiveConcurrency(19*time.Second))
+	assert.Equal(t, 3, copier.effectiveConcurrency(20*time.Second))
+	assert.Equal(t, 4, copier.effectiveConcurrency(30*time.Second))
+	assert.Equal(t, 4, copier.effectiveConcurrency(40*time.Second))
+	assert.Equal(t, 4, copier.effectiveConcurrency(time.Hour))
+
+	// During warm-up a slot is only free below the effective concurrency.
+	copier.startTime = time.Now()
+	var inFlight atomic.Int64
+	assert.True(t, copier.waitForWarmUpSlot(context.Background(), &inFlight))
+	inFlight.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	assert.False(t, copier.waitForWarmUpSlot(ctx, &inFlight))
+}
+
+func TestCopierIncrementalQuery(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "incrqueryt1")
+	t1.Columns = []string{"a", "updated_at"}
+	t2 := table.NewTableInfo(nil, "test", "_incrqueryt1_new")
+	chunk := &table.Chunk{Key: []string{"a"}, AdditionalConditions: "a < 10"}
+
+	config := NewCopierDefaultConfig()
+	config.IncrementalColumn = "missing"
+	_, err := NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, `incremental column "missing" does not exist`)
+
+	// The first pass copies all rows.
+	config.IncrementalColumn = "updated_at"
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(copier.copyChunkQuery(chunk), "REPLACE INTO `test`.`_incrqueryt1_new`"))
+	assert.NotContains(t, copier.copyChunkQuery(chunk), "updated_at` >=")
+
+	config.Since = "2024-01-01 00:00:00"
+	copier, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(copier.copyChunkQuery(chunk), " AND `updated_at` >= '2024-01-01 00:00:00'"))
+	assert.Equal(t, "2024-01-01 00:00:00", copier.Since())
+}
+
+func TestCopierQueryComment(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "commentt1")
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_commentt1_new")
+	chunk := &table.Chunk{Key: []string{"a"}, AdditionalConditions: "a < 10"}
+
+	copier, err := NewCopier(nil, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(copier.copyChunkQuery(chunk), "INSERT IGNORE INTO"))
+
+	config := NewCopierDefaultConfig()
+	config.QueryComment = "spirit migration=123 table={table} chunk={chunk}"
+	copier, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(copier.copyChunkQuery(chunk),
+		"/* spirit migration=123 table=`test`.`commentt1` chunk="+chunk.String()+" */ INSERT IGNORE INTO `test`.`_commentt1_new`"))
+
+	// The comment can not be closed by the template.
+	config.QueryComment = "migration=*/ DROP TABLE t1; --"
+	copier, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(copier.copyChunkQuery(chunk), "/* migration=* / DROP TABLE t1; -- */ INSERT IGNORE INTO"))
+}
+
+func TestCopierColumns(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "columnst1")
+	t1.NonGeneratedColumns = []string{"a", "b", "c"}
+	t2 := table.NewTableInfo(nil, "test", "_columnst1_new")
+	t2.NonGeneratedColumns = []string{"a", "c", "d"}
+	chunk := &table.Chunk{Key: []string{"a"}, AdditionalConditions: "a < 10"}
+
+	copier, err := NewCopier(nil, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, utils.IntersectNonGeneratedColumns(t1, t2), copier.columns)
+	assert.Equal(t, "INSERT IGNORE INTO `test`.`_columnst1_new` (`a`, `c`) SELECT `a`, `c` FROM `test`.`columnst1` FORCE INDEX (PRIMARY) WHERE "+chunk.String(),
+		copier.copyChunkStatement(chunk))
+}
+
+// BenchmarkCopyChunkStatement measures building the statement of a chunk,
+// which uses the columns computed when the copier is created.
+func BenchmarkCopyChunkStatement(b *testing.B) {
+	t1 := table.NewTableInfo(nil, "test", "benchcolumnst1")
+	t2 := table.NewTableInfo(nil, "test", "_benchcolumnst1_new")
+	for i := range 50 {
+		t1.NonGeneratedColumns = append(t1.NonGeneratedColumns, fmt.Sprintf("col%d", i))
+	}
+	t2.NonGeneratedColumns = t1.NonGeneratedColumns
+	chunk := &table.Chunk{Key: []string{"col0"}, AdditionalConditions: "col0 < 10"}
+	copier, err := NewCopier(nil, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		_ = copier.copyChunkStatement(chunk)
+	}
+}
+
+func TestCopierPrefetch(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS prefetcht1, _prefetcht1_new")
+	testutils.RunSQL(t, "CREATE TABLE prefetcht1 (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _prefetcht1_new (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO prefetcht1 (b) SELECT n FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 3000) SELECT n FROM seq) s")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "prefetcht1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_prefetcht1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	config := NewCopierDefaultConfig()
+	config.Prefetch = true
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+
+	// The range after the chunk is prefetched,
+	// but there is nothing after the last chunk.
+	assert.NoError(t, copier.Open4Test())
+	chunk, err := copier.Next4Test()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `a` FROM `test`.`prefetcht1` FORCE INDEX (PRIMARY) WHERE `a` >= 1001 ORDER BY `a` LIMIT 1000",
+		copier.prefetchQuery(chunk))
+	assert.Empty(t, copier.prefetchQuery(&table.Chunk{Key: []string{"a"}, AdditionalConditions: "a > 3000"}))
+
+	// A failed prefetch is not counted, and while the
+	// throttler is engaged the prefetch is skipped.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	copier.prefetchAfter(ctx, chunk)
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&copier.prefetchCount))
+	copier.SetThrottler(&engagedThrottler{})
+	copier.prefetchAfter(context.Background(), chunk)
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&copier.prefetchCount))
+	copier.SetThrottler(&throttler.Noop{})
+	copier.prefetchAfter(context.Background(), chunk)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&copier.prefetchCount))
+
+	copier, err = NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.TODO()))
+	assert.Positive(t, atomic.LoadUint64(&copier.prefetchCount))
+	assert.Equal(t, uint64(3000), atomic.LoadUint64(&copier.CopyRowsCount))
+}
+
+func TestCopierSkip4Test(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS skipt1, _skipt1_new")
+	testutils.RunSQL(t, "CREATE TABLE skipt1 (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _skipt1_new (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO skipt1 (b) SELECT n FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 10000) SELECT n FROM seq) s")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "skipt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_skipt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	copier, err := NewCopier(db, t1, t1new, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Open4Test())
+
+	// The first chunk is below the minimum value.
+	assert.NoError(t, copier.Skip4Test(3))
+	watermark, err := copier.GetLowWatermark()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Key":["a"],"ChunkSize":1000,"LowerBound":{"Value":["1001"],"Inclusive":true},"UpperBound":{"Value":["2001"],"Inclusive":false}}`, watermark)
+
+	assert.NoError(t, copier.Skip4Test(2))
+	watermark, err = copier.GetLowWatermark()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Key":["a"],"ChunkSize":1000,"LowerBound":{"Value":["3001"],"Inclusive":true},"UpperBound":{"Value":["4001"],"Inclusive":false}}`, watermark)
+
+	// Nothing was copied.
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&copier.CopyRowsCount))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _skipt1_new").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestCopierIncremental(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS incrt1, _incrt1_new")
+	testutils.RunSQL(t, "CREATE TABLE incrt1 (a INT NOT NULL, b INT, updated_at DATETIME NOT NULL, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _incrt1_new (a INT NOT NULL, b INT, updated_at DATETIME NOT NULL, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO incrt1 SELECT n, n, '2024-01-01 00:00:00' FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 10) SELECT n FROM seq) s")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "incrt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_incrt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	pass := func(since string) string {
+		config := NewCopierDefaultConfig()
+		config.IncrementalColumn = "updated_at"
+		config.Since = since
+		copier, err := NewCopier(db, t1, t1new, config)
+		assert.NoError(t, err)
+		assert.NoError(t, copier.Run(context.Background()))
+		return copier.Since()
+	}
+	valueOfB := func(a int) int {
+		var b int
+		assert.NoError(t, db.QueryRow("SELECT b FROM _incrt1_new WHERE a = ?", a).Scan(&b))
+		return b
+	}
+
+	// The first pass copies everything.
+	since := pass("")
+	assert.Equal(t, "2024-01-01 00:00:00", since)
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _incrt1_new").Scan(&count))
+	assert.Equal(t, 10, count)
+
+	// Modify a row in the new table only. If the next pass
+	// copies it again, the modification is overwritten.
+	testutils.RunSQL(t, "UPDATE _incrt1_new SET b = -1 WHERE a = 5")
+	testutils.RunSQL(t, "UPDATE incrt1 SET b = 100, updated_at = '2024-01-02 00:00:00' WHERE a IN (1, 2)")
+	testutils.RunSQL(t, "INSERT INTO incrt1 VALUES (11, 11, '2024-01-02 00:00:00')")
+
+	// The second pass only copies the changed rows.
+	since = pass(since)
+	assert.Equal(t, "2024-01-02 00:00:00", since)
+	assert.Equal(t, 100, valueOfB(1))
+	assert.Equal(t, 100, valueOfB(2))
+	assert.Equal(t, 11, valueOfB(11))
+	assert.Equal(t, -1, valueOfB(5))
+	assert.Equal(t, 3, valueOfB(3))
+}
+
+func TestCopierMetricsErrorsCoalesced(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "metricserrt1")
+	t2 := table.NewTableInfo(nil, "test", "_metricserrt1_new")
+	logger, hook := test.NewNullLogger()
+	testMetricsSink := &TestMetricsSink{err: errors.New("sink unavailable")}
+	config := NewCopierDefaultConfig()
+	config.Logger = logger
+	config.MetricsSink = testMetricsSink
+	config.SlowChunkThreshold = time.Millisecond
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+
+	for range 100 {
+		copier.reportSlowChunk(context.Background(), &table.Chunk{Key: []string{"a"}}, time.Second, 1, "")
+	}
+	var errorLogs int
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.ErrorLevel {
+			errorLogs++
+		}
+	}
+	// One error when the sink first fails, and one when
+	// the circuit breaker stops sending metrics to it.
+	assert.Equal(t, 2, errorLogs)
+	assert.Equal(t, metrics.CircuitBreakerThreshold, testMetricsSink.called)
+}
+
+func TestCopierIgnoredRows(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "ignoredrowst1")
+	t2 := table.NewTableInfo(nil, "test", "_ignoredrowst1_new")
+	logger, hook := test.NewNullLogger()
+	config := NewCopierDefaultConfig()
+	config.Logger = logger
+	config.IgnoredRowsThreshold = 0.1
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+
+	warnings := func() int {
+		var count int
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				count++
+			}
+		}
+		return count
+	}
+	chunk := &table.Chunk{Key: []string{"a"}, ChunkSize: 100}
+
+	// Duplicates in the resume window are expected.
+	copier.resumeChunksLeft = 2
+	copier.trackIgnoredRows(chunk, 100)
+	copier.trackIgnoredRows(chunk, 100)
+	assert.Equal(t, 0, warnings())
+	assert.Equal(t, uint64(0), copier.ignoredRowsCount)
+
+	// A few ignored rows are below the threshold.
+	for range 5 {
+		copier.trackIgnoredRows(chunk, 5)
+	}
+	assert.Equal(t, 0, warnings())
+
+	// An unexpectedly high ratio warns, but only once.
+	for range 5 {
+		copier.trackIgnoredRows(chunk, 90)
+	}
+	assert.Equal(t, 1, warnings())
+	assert.Contains(t, hook.LastEntry().Message, "ignored rows ratio 0.19 exceeds threshold 0.10: 115 of 600 rows")
+	assert.Equal(t, uint64(475), copier.ignoredRowsCount)
+
+	// Tracking is disabled by default.
+	copier.ignoredRowsThreshold = 0
+	copier.trackIgnoredRows(chunk, 100)
+	assert.Equal(t, uint64(475), copier.ignoredRowsCount)
+}
+
+func TestCopierIgnoredRowsCount(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS ignoredcountt1, _ignoredcountt1_new")
+	testutils.RunSQL(t, "CREATE TABLE ignoredcountt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _ignoredcountt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	// Exactly one chunk of rows, so the chunk size matches the rows in the table.
+	testutils.RunSQL(t, "INSERT INTO ignoredcountt1 SELECT n, n FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 1000) SELECT n FROM seq) s")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "ignoredcountt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_ignoredcountt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	testMetricsSink := &TestMetricsSink{}
+	config := NewCopierDefaultConfig()
+	config.MetricsSink = testMetricsSink
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Open4Test())
+
+	// Inject some duplicates, which INSERT IGNORE will skip.
+	testutils.RunSQL(t, "INSERT INTO _ignoredcountt1_new SELECT * FROM ignoredcountt1 WHERE a <= 10")
+	for {
+		chunk, err := copier.Next4Test()
+		if errors.Is(err, table.ErrTableIsRead) {
+			break
+		}
+		assert.NoError(t, err)
+		assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+	}
+	assert.Equal(t, uint64(990), copier.CopyRowsCount)
+	assert.Equal(t, uint64(10), copier.CopyRowsIgnoredCount)
+	assert.Equal(t, uint64(10), copier.Status().IgnoredRows)
+
+	var ignoredMetric float64
+	for _, v := range testMetricsSink.values {
+		if v.Name == metrics.ChunkIgnoredRowsCountMetricName {
+			assert.Equal(t, metrics.COUNTER, v.Type)
+			ignoredMetric += v.Value
+		}
+	}
+	assert.InDelta(t, 10.0, ignoredMetric, 0)
+}
+
+func TestCopierBytesCopied(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS bytescopiedt1, _bytescopiedt1_new")
+	testutils.RunSQL(t, "CREATE TABLE bytescopiedt1 (a INT NOT NULL, b VARCHAR(255), PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _bytescopiedt1_new (a INT NOT NULL, b VARCHAR(255), PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO bytescopiedt1 SELECT n, REPEAT('a', 200) FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 1000) SELECT n FROM seq) s")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "bytescopiedt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_bytescopiedt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+	assert.Positive(t, t1.AvgRowLength)
+	assert.Positive(t, t1.EstimatedBytes)
+
+	copier, err := NewCopier(db, t1, t1new, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Open4Test())
+	var prev uint64
+	for {
+		chunk, err := copier.Next4Test()
+		if errors.Is(err, table.ErrTableIsRead) {
+			break
+		}
+		assert.NoError(t, err)
+		assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+		assert.Greater(t, copier.BytesCopied(), prev) // bytes accumulate
+		prev = copier.BytesCopied()
+	}
+	assert.Equal(t, 1000*t1.AvgRowLength, copier.BytesCopied())
+	assert.Equal(t, copier.BytesCopied(), copier.Status().CopiedBytes)
+	// Both values are estimates, so the percent is only approximately 100.
+	assert.InDelta(t, 100, copier.BytesPercent(), 50)
+}
+
+func TestCopierBytesPercent(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "bytespctt1")
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_bytespctt1_new")
+	copier, err := NewCopier(nil, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, copier.BytesPercent(), 0) // the data length is unknown
+
+	t1.EstimatedBytes = 1000
+	copier.CopyBytesCount = 250
+	assert.InDelta(t, 25, copier.BytesPercent(), 0)
+	copier.CopyBytesCount = 2000
+	assert.InDelta(t, 100, copier.BytesPercent(), 0) // capped
+}
+
+func TestCopierSummary(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "summaryt1")
+	t1.KeyColumns = []string{"a"}
+	t2 := table.NewTableInfo(nil, "test", "_summaryt1_new")
+	copier, err := NewCopier(nil, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, CopierSummary{}, copier.Summary()) // not started
+
+	// Simulate a completed run.
+	copier.CopyRowsCount = 1000
+	copier.CopyRowsLogicalCount = 1200
+	copier.CopyChunksCount = 12
+	copier.CopyRowsIgnoredCount = 200
+	copier.ThrottleWaitTime = int64(1500 * time.Millisecond)
+	copier.ExecTime = 10 * time.Second
+	copier.dbConfig.OnRetry(errors.New("deadlock"))
+	copier.dbConfig.OnRetry(errors.New("deadlock"))
+
+	summary := copier.Summary()
+	assert.Equal(t, CopierSummary{
+		CopiedRows:       1000,
+		LogicalRows:      1200,
+		ChunksCopied:     12,
+		IgnoredRows:      200,
+		Retries:          2,
+		Duration:         10 * time.Second,
+		RowsPerSecond:    100,
+		ThrottleWaitTime: 1500 * time.Millisecond,
+	}, summary)
+	assert.Equal(t, "copied-rows=1000 logical-rows=1200 chunks=12 ignored-rows=200 retries=2 duration=10s rows-per-second=100 throttle-wait-time=1.5s", summary.String())
+}
+
+func TestCopierSummaryAfterRun(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS summaryrunt1, _summaryrunt1_new")
+	testutils.RunSQL(t, "CREATE TABLE summaryrunt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _summaryrunt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO summaryrunt1 VALUES (1, 1), (2, 2), (3, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "summaryrunt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_summaryrunt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	copier, err := NewCopier(db, t1, t1new, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.Background()))
+
+	summary := copier.Summary()
+	assert.Equal(t, copier.CopyRowsCount, summary.CopiedRows)
+	assert.Equal(t, uint64(3), summary.CopiedRows)
+	assert.Equal(t, copier.CopyChunksCount, summary.ChunksCopied)
+	assert.Equal(t, copier.ExecTime, summary.Duration)
+	assert.Equal(t, uint64(0), summary.Retries)
+	assert.Positive(t, summary.RowsPerSecond)
+}
+
+// blockingThrottler blocks for a fixed duration on every BlockWait.
+type blockingThrottler struct {
+	throttler.Noop
+	duration time.Duration
+}
+
+func (t *blockingThrottler) BlockWait() error {
+	time.Sleep(t.duration)
+	return nil
+}
+
+// engagedThrottler is always engaged, but does not block.
+type engagedThrottler struct {
+	throttler.Noop
+}
+
+func (t *engagedThrottler) IsThrottled() bool {
+	return true
+}
+
+func (t *engagedThrottler) State() string {
+	return "engaged: replica lag 12s (max 10s)"
+}
+
+func TestCopierStatusThrottlerState(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "throttlestatet1")
+	t2 := table.NewTableInfo(nil, "test", "_throttlestatet1_new")
+	config := NewCopierDefaultConfig()
+	config.Throttler = &engagedThrottler{}
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	status := copier.Status()
+	assert.True(t, status.IsThrottled)
+	assert.Equal(t, "engaged: replica lag 12s (max 10s)", status.ThrottlerState)
+}
+
+func TestCopierChecksumModeUnknown(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "checksummodet1")
+	t2 := table.NewTableInfo(nil, "test", "_checksummodet1_new")
+	config := NewCopierDefaultConfig()
+	config.ChecksumMode = "sample"
+	_, err := NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, `unknown checksum mode "sample"`)
+}
+
+func TestCopierFinalChecksum(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "finalchecksumt1")
+	t2 := table.NewTableInfo(nil, "test", "_finalchecksumt1_new")
+	config := NewCopierDefaultConfig()
+	copier, err := NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, ChecksumModeFull, copier.checksumMode)
+
+	config.FinalChecksum = false
+	copier, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, ChecksumModeNone, copier.checksumMode)
+
+	// ChecksumMode takes precedence over the deprecated FinalChecksum.
+	config.ChecksumMode = ChecksumModeRowCount
+	copier, err = NewCopier(nil, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, ChecksumModeRowCount, copier.checksumMode)
+}
+
+func TestCopierStatusChunkTimePercentiles(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "chunktimest1")
+	t2 := table.NewTableInfo(nil, "test", "_chunktimest1_new")
+	copier, err := NewCopier(nil, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	status := copier.Status()
+	assert.Zero(t, status.ChunkTimeP50) // no chunks copied yet
+	assert.Zero(t, status.ChunkTimeP99)
+
+	// Chunks from 1ms to 1000ms.
+	for i := 1; i <= 1000; i++ {
+		copier.chunkTimes.Observe(float64(i))
+	}
+	status = copier.Status()
+	assert.InEpsilon(t, 500*time.Millisecond, status.ChunkTimeP50, 0.01)
+	assert.InEpsilon(t, 950*time.Millisecond, status.ChunkTimeP95, 0.01)
+	assert.InEpsilon(t, 990*time.Millisecond, status.ChunkTimeP99, 0.01)
+}
+
+func TestCopierEmptyTableProgress(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "emptyprogresst1")
+	t2 := table.NewTableInfo(nil, "test", "_emptyprogresst1_new")
+	copier, err := NewCopier(nil, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, "0/0 100.00%", copier.GetProgress())
+	assert.Equal(t, "DUE", copier.GetETA())
+}
+
+func TestCopierEmptyTable(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS emptyt1, _emptyt1_new")
+	testutils.RunSQL(t, "CREATE TABLE emptyt1 (id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY, name VARCHAR(255))")
+	testutils.RunSQL(t, "CREATE TABLE _emptyt1_new (id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY, name VARCHAR(255))")
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS emptyt2, _emptyt2_new")
+	testutils.RunSQL(t, "CREATE TABLE emptyt2 (name VARCHAR(255) NOT NULL PRIMARY KEY)")
+	testutils.RunSQL(t, "CREATE TABLE _emptyt2_new (name VARCHAR(255) NOT NULL PRIMARY KEY)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Both the optimistic and the composite chunker
+	// complete the copy of an empty table.
+	for _, name := range []string{"emptyt1", "emptyt2"} {
+		t1 := table.NewTableInfo(db, "test", name)
+		assert.NoError(t, t1.SetInfo(context.TODO()))
+		t1new := table.NewTableInfo(db, "test", "_"+name+"_new")
+		assert.NoError(t, t1new.SetInfo(context.TODO()))
+		copier, err := NewCopier(db, t1, t1new, NewCopierDefaultConfig())
+		assert.NoError(t, err)
+		assert.NoError(t, copier.Run(context.TODO()))
+		assert.Equal(t, uint64(0), atomic.LoadUint64(&copier.CopyRowsCount))
+		assert.Equal(t, "0/0 100.00%", copier.GetProgress())
+		_, err = copier.GetLowWatermark()
+		assert.NoError(t, err)
+	}
+}
+
+func TestCopierThrottleWaitTime(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS throttlewaitt1, throttlewaitt2")
+	testutils.RunSQL(t, "CREATE TABLE throttlewaitt1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE throttlewaitt2 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO throttlewaitt1 VALUES (1, 2, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "throttlewaitt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "throttlewaitt2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	blockDuration := 500 * time.Millisecond
+	testMetricsSink := &TestMetricsSink{}
+	config := NewCopierDefaultConfig()
+	config.MetricsSink = testMetricsSink
+	config.Throttler = &blockingThrottler{duration: blockDuration}
+	// The throttle wait alone would exceed the target chunk time.
+	config.TargetChunkTime = 100 * time.Millisecond
+	copier, err := NewCopier(db, t1, t2, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.Background()))
+
+	var waitTimes, processingTimes []float64
+	for _, value := range testMetricsSink.values {
+		switch value.Name {
+		case metrics.ChunkThrottleWaitTimeMetricName:
+			waitTimes = append(waitTimes, value.Value)
+		case metrics.ChunkProcessingTimeMetricName:
+			processingTimes = append(processingTimes, value.Value)
+		}
+	}
+	assert.NotEmpty(t, waitTimes)
+	assert.Len(t, processingTimes, len(waitTimes))
+	for i := range waitTimes {
+		assert.GreaterOrEqual(t, waitTimes[i], float64(blockDuration.Milliseconds()))
+		// The time spent blocked is not counted towards the chunk's
+		// processing time, so the chunker does not shrink the chunk size.
+		assert.Less(t, processingTimes[i], float64(config.TargetChunkTime.Milliseconds()))
+	}
+}
+
+func TestCopierChangesetThrottler(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS changesetthrottlet1, changesetthrottlet2")
+	testutils.RunSQL(t, "CREATE TABLE changesetthrottlet1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE changesetthrottlet2 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO changesetthrottlet1 VALUES (1, 2, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "changesetthrottlet1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "changesetthrottlet2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	// The changeset has grown beyond its max depth, and
+	// is flushed after the copy has started.
+	var depth atomic.Int64
+	depth.Store(5000)
+	changesetThrottler, err := throttler.NewChangesetThrottler(func() int { return int(depth.Load()) }, 1000, logrus.New())
+	assert.NoError(t, err)
+	flushAfter := 1500 * time.Millisecond
+	testMetricsSink := &TestMetricsSink{}
+	config := NewCopierDefaultConfig()
+	config.MetricsSink = testMetricsSink
+	config.Throttler = changesetThrottler
+	copier, err := NewCopier(db, t1, t2, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "engaged: changeset depth 5000 (max 1000)", copier.Status().ThrottlerState)
+	go func() {
+		time.Sleep(flushAfter)
+		depth.Store(0)
+	}()
+	assert.NoError(t, copier.Run(context.Background()))
+	assert.Equal(t, uint64(1), copier.CopyRowsCount)
+
+	// The first chunk waited for the changeset to be flushed.
+	var waitTimes []float64
+	for _, value := range testMetricsSink.values {
+		if value.Name == metrics.ChunkThrottleWaitTimeMetricName {
+			waitTimes = append(waitTimes, value.Value)
+		}
+	}
+	assert.NotEmpty(t, waitTimes)
+	assert.GreaterOrEqual(t, waitTimes[0], float64(flushAfter.Milliseconds()))
+}
+
+func TestCopierNewestFirst(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS newestfirstt1, _newestfirstt1_new")
+	testutils.RunSQL(t, "CREATE TABLE newestfirstt1 (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, b INT)")
+	testutils.RunSQL(t, "CREATE TABLE _newestfirstt1_new (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, b INT)")
+	testutils.RunSQL(t, "INSERT INTO newestfirstt1 (b) VALUES (1)")
+	for range 13 {
+		testutils.RunSQL(t, "INSERT INTO newestfirstt1 (b) SELECT b FROM newestfirstt1")
+	}
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "newestfirstt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "_newestfirstt1_new")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	config := NewCopierDefaultConfig()
+	config.NewestFirstKeyRange = 2000
+	copier, err := NewCopier(db, t1, t2, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.Background()))
+
+	// Every row is copied exactly once.
+	var count, newCount int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM newestfirstt1").Scan(&count))
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _newestfirstt1_new").Scan(&newCount))
+	assert.Equal(t, count, newCount)
+	assert.Equal(t, uint64(count), copier.CopyRowsCount)
+}
+
+func TestCopierNewestFirstUnsupported(t *testing.T) {
+	// Tables without an auto_increment key use the composite chunker.
+	t1 := table.NewTableInfo(nil, "test", "newestfirstt2")
+	t2 := table.NewTableInfo(nil, "test", "_newestfirstt2_new")
+	config := NewCopierDefaultConfig()
+	config.NewestFirstKeyRange = 1000
+	_, err := NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, "newest-first ordering is only supported")
+}
+
+func TestCopierRunWithProgress(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS progresst1, progresst2")
+	testutils.RunSQL(t, "CREATE TABLE progresst1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE progresst2 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO progresst1 VALUES (1, 2, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "progresst1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "progresst2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+
+	copier, err := NewCopier(db, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	updates := make(chan ProgressUpdate)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- copier.RunWithProgress(context.Background(), 100*time.Millisecond, updates)
+	}()
+
+	// Updates are streamed until the copier is done,
+	// and then the channel is closed.
+	var received []ProgressUpdate
+	for update := range updates {
+		received = append(received, update)
+	}
+	assert.NoError(t, <-runErr)
+	assert.Greater(t, len(received), 1)
+	for _, update := range received[:len(received)-1] {
+		assert.False(t, update.Done)
+	}
+	final := received[len(received)-1]
+	assert.True(t, final.Done)
+	assert.NoError(t, final.Err)
+	assert.Equal(t, uint64(1), final.CopiedRows)
+}
+
+func TestCopierRunWithProgressError(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS progresserrt1, progresserrt2")
+	testutils.RunSQL(t, "CREATE TABLE progresserrt1 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE progresserrt2 (a INT NOT NULL, b INT, c INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO progresserrt1 VALUES (1, 2, 3)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+
+	t1 := table.NewTableInfo(db, "test", "progresserrt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t2 := table.NewTableInfo(db, "test", "progresserrt2")
+	assert.NoError(t, t2.SetInfo(context.TODO()))
+	testutils.RunSQL(t, "DROP TABLE progresserrt2") // the copy will fail
+
+	copier, err := NewCopier(db, t1, t2, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	updates := make(chan ProgressUpdate, 10)
+	err = copier.RunWithProgress(context.Background(), time.Second, updates)
+	assert.Error(t, err)
+
+	// The final update has the error, and the channel is closed.
+	var final ProgressUpdate
+	for update := range updates {
+		final = update
+	}
+	assert.True(t, final.Done)
+	assert.Equal(t, err, final.Err)
+}
+
+func TestCopierSplitChunks(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS splitchunkt1, _splitchunkt1_new")
+	testutils.RunSQL(t, "CREATE TABLE splitchunkt1 (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _splitchunkt1_new (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO splitchunkt1 (b) SELECT n FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 1000) SELECT n FROM seq) s")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "splitchunkt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_splitchunkt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	// Chunks of more than 250 rows hold more locks than fit in the buffer pool.
+	tooLarge := func(copier *Copier) {
+		copier.execChunk = func(ctx context.Context, chunk *table.Chunk) (int64, error) {
+			if chunk.ChunkSize > 250 {
+				return 0, &mysql.MySQLError{Number: 1206, Message: "The total number of locks exceeds the lock table size"}
+			}
+			return copier.execChunkQuery(ctx, chunk)
+		}
+	}
+
+	// Without splitting, the chunk fails.
+	copier, err := NewCopier(db, t1, t1new, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	tooLarge(copier)
+	assert.NoError(t, copier.Open4Test())
+	chunk, err := copier.Next4Test()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1000), chunk.ChunkSize)
+	assert.ErrorContains(t, copier.CopyChunk(context.TODO(), chunk), "lock table size")
+
+	// The chunk is split in half, and the halves are split again.
+	config := NewCopierDefaultConfig()
+	config.SplitChunks = true
+	copier, err = NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	tooLarge(copier)
+	assert.NoError(t, copier.Open4Test())
+	chunk, err = copier.Next4Test()
+	assert.NoError(t, err)
+	assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+	assert.Equal(t, uint64(3), atomic.LoadUint64(&copier.splitChunksCount))
+	assert.Equal(t, uint64(1000), atomic.LoadUint64(&copier.CopyRowsCount))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _splitchunkt1_new").Scan(&count))
+	assert.Equal(t, 1000, count)
+}
+
+type chunkErrorRecorder struct {
+	sync.Mutex
+	willRetry []bool
+	errs      []error
+}
+
+func (r *chunkErrorRecorder) onChunkError(_ *table.Chunk, err error, willRetry bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.willRetry = append(r.willRetry, willRetry)
+	r.errs = append(r.errs, err)
+}
+
+func TestCopierOnChunkError(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS chunkerrt1, _chunkerrt1_new")
+	testutils.RunSQL(t, "CREATE TABLE chunkerrt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _chunkerrt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO chunkerrt1 VALUES (1, 1), (2, 2)")
+
+	dbConfig := dbconn.NewDBConfig()
+	dbConfig.InnodbLockWaitTimeout = 1
+	db, err := dbconn.New(testutils.DSN(), dbConfig)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "chunkerrt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_chunkerrt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+	chunk := &table.Chunk{Key: []string{"a"}, ChunkSize: 2, AdditionalConditions: "a < 10"}
+
+	// A fatal error is reported once, and is not retried.
+	recorder := &chunkErrorRecorder{}
+	config := NewCopierDefaultConfig()
+	config.OnChunkError = recorder.onChunkError
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	fatalErr := &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}
+	copier.execChunk = func(ctx context.Context, chunk *table.Chunk) (int64, error) {
+		return 0, fatalErr
+	}
+	assert.ErrorIs(t, copier.CopyChunk(context.TODO(), chunk), fatalErr)
+	assert.Equal(t, []bool{false}, recorder.willRetry)
+	assert.Equal(t, []error{fatalErr}, recorder.errs)
+
+	// A lock wait timeout is reported each time the chunk is retried,
+	// and the chunk is copied once the row in the new table is unlocked.
+	recorder = &chunkErrorRecorder{}
+	config.DBConfig = dbConfig
+	config.OnChunkError = recorder.onChunkError
+	copier, err = NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		tx, err := db.Begin()
+		assert.NoError(t, err)
+		_, err = tx.Exec("INSERT INTO _chunkerrt1_new VALUES (1, 1)")
+		assert.NoError(t, err)
+		wg.Done()
+		time.Sleep(2 * time.Second)
+		assert.NoError(t, tx.Rollback())
+	}()
+	wg.Wait()
+	assert.NoError(t, copier.Open4Test())
+	chunk, err = copier.Next4Test()
+	assert.NoError(t, err)
+	assert.NoError(t, copier.CopyChunk(context.TODO(), chunk))
+	assert.NotEmpty(t, recorder.willRetry)
+	for i, willRetry := range recorder.willRetry {
+		assert.True(t, willRetry)
+		assert.True(t, dbconn.IsLockWaitTimeoutError(recorder.errs[i]))
+	}
+	assert.Equal(t, uint64(len(recorder.willRetry)), atomic.LoadUint64(&copier.CopyRetriesCount))
+}
+
+func TestCopierMaxRowsToCopy(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS maxrowst1, _maxrowst1_new")
+	testutils.RunSQL(t, "CREATE TABLE maxrowst1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _maxrowst1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO maxrowst1 SELECT n, n FROM (SELECT a.N + b.N * 10 + c.N * 100 + d.N * 1000 + 1 AS n FROM (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) a, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) b, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) c, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) d) nums")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "maxrowst1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_maxrowst1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+	// With a concurrency of 1 the copy stops after the first
+	// chunk, which is the starting chunk size.
+	config := NewCopierDefaultConfig()
+	config.Concurrency = 1
+	config.MaxRowsToCopy = table.StartingChunkSize
+	copier, err := NewCopier(db, t1, t1new, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.TODO()))
+	assert.True(t, copier.ReachedMaxRows())
+	assert.Equal(t, uint64(table.StartingChunkSize), atomic.LoadUint64(&copier.CopyRowsLogicalCount))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _maxrowst1_new").Scan(&count))
+	assert.Equal(t, table.StartingChunkSize, count)
+
+	// The copy can be resumed from the low watermark.
+	lowWatermark, err := copier.GetLowWatermark()
+	assert.NoError(t, err)
+	copier, err = NewCopierFromCheckpoint(db, t1, t1new, NewCopierDefaultConfig(), lowWatermark,
+		atomic.LoadUint64(&copier.CopyRowsCount), atomic.LoadUint64(&copier.CopyRowsLogicalCount))
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.TODO()))
+	assert.False(t, copier.ReachedMaxRows())
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _maxrowst1_new").Scan(&count))
+	assert.Equal(t, 10000, count)
+}
+
+func TestCopierKeyRange(t *testing.T) {
+	// The optimistic chunker is used for the auto_increment
+	// table, and the composite chunker for the other.
+	for _, tc := range []struct {
+		name       string
+		definition string
+	}{
+		{"keyranget1", "a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a)"},
+		{"keyranget2", "a INT NOT NULL, b INT, PRIMARY KEY (a)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.RunSQL(t, fmt.Sprintf("DROP TABLE IF EXISTS %s, _%s_new", tc.name, tc.name))
+			testutils.RunSQL(t, fmt.Sprintf("CREATE TABLE %s (%s)", tc.name, tc.definition))
+			testutils.RunSQL(t, fmt.Sprintf("CREATE TABLE _%s_new (%s)", tc.name, tc.definition))
+			testutils.RunSQL(t, fmt.Sprintf("INSERT INTO %s SELECT n, n FROM (SELECT a.N + b.N * 10 + c.N * 100 + d.N * 1000 + 1 AS n FROM (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) a, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) b, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) c, (SELECT 0 AS N UNION ALL SELECT 1 UNION ALL SELECT 2 UNION ALL SELECT 3 UNION ALL SELECT 4 UNION ALL SELECT 5 UNION ALL SELECT 6 UNION ALL SELECT 7 UNION ALL SELECT 8 UNION ALL SELECT 9) d) nums", tc.name))
+			// A row outside of the range that is already in the new table.
+			testutils.RunSQL(t, fmt.Sprintf("INSERT INTO _%s_new VALUES (5, -1)", tc.name))
+
+			db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+			assert.NoError(t, err)
+			defer db.Close()
+
+			t1 := table.NewTableInfo(db, "test", tc.name)
+			assert.NoError(t, t1.SetInfo(context.TODO()))
+			t1new := table.NewTableInfo(db, "test", "_"+tc.name+"_new")
+			assert.NoError(t, t1new.SetInfo(context.TODO()))
+
+			config := NewCopierDefaultConfig()
+			config.StartKey = "1000"
+			config.EndKey = "2000"
+			copier, err := NewCopier(db, t1, t1new, config)
+			assert.NoError(t, err)
+			assert.NoError(t, copier.Run(context.TODO()))
+
+			var count, minA, maxA int
+			assert.NoError(t, db.QueryRow(fmt.Sprintf("SELECT COUNT(*), MIN(a), MAX(a) FROM _%s_new WHERE a != 5", tc.name)).Scan(&count, &minA, &maxA))
+			assert.Equal(t, 1000, count)
+			assert.Equal(t, 1000, minA)
+			assert.Equal(t, 1999, maxA)
+			var b int
+			assert.NoError(t, db.QueryRow(fmt.Sprintf("SELECT b FROM _%s_new WHERE a = 5", tc.name)).Scan(&b))
+			assert.Equal(t, -1, b)
+		})
+	}
+}
+
+func TestCopierKeyRangeInvalid(t *testing.T) {
+	t1 := table.NewTableInfo(nil, "test", "keyrangeinvalidt1")
+	t2 := table.NewTableInfo(nil, "test", "_keyrangeinvalidt1_new")
+	config := NewCopierDefaultConfig()
+	config.StartKey = "1000"
+	// The PRIMARY KEY of the table is not known.
+	_, err := NewCopier(nil, t1, t2, config)
+	assert.ErrorContains(t, err, "a key range requires the table's PRIMARY KEY to be known")
+}
@@ -0,0 +1,65 @@
+package row
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cashapp/spirit/pkg/table"
+)
+
+// CopyPartitions copies a partitioned table to newTable one partition at a
+// time, in partition order. Each partition is chunked within its own range of
+// keys, which gives better locality for large range partitioned tables, and
+// empty partitions are skipped. Each partition is copied by a new Copier with
+// config, so it can not be resumed from a checkpoint.
+func CopyPartitions(ctx context.Context, db *sql.DB, tbl, newTable *table.TableInfo, config *CopierConfig) error {
+	partitions, err := tbl.Partitions(ctx)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("table %s is not partitioned", tbl.QuotedName)
+	}
+	for i, partition := range partitions {
+		partitionTbl, err := tbl.PartitionInfo(ctx, partition)
+		if err != nil {
+			return err
+		}
+		copier, err := NewCopier(db, partitionTbl, newTable, config)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			// Later partitions are copied into a table that is
+			// not empty, so the new table is checked only once.
+			if err := copier.newTableIsEmpty(ctx); err != nil {
+				return err
+			}
+		}
+		empty, err := partitionIsEmpty(ctx, db, partitionTbl)
+		if err != nil {
+			return err
+		}
+		if empty {
+			config.Logger.Infof("skipping empty partition %s", partition)
+			continue
+		}
+		config.Logger.Infof("copying partition %s", partition)
+		if err := copier.Run(ctx); err != nil {
+			return fmt.Errorf("could not copy partition %s: %w", partition, err)
+		}
+	}
+	return nil
+}
+
+// partitionIsEmpty returns true if the partition has no rows. The estimated
+// rows can not be used for this, since they may be out of date.
+func partitionIsEmpty(ctx context.Context, db *sql.DB, partitionTbl *table.TableInfo) (bool, error) {
+	var exists int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM "+partitionTbl.FromName()+" LIMIT 1").Scan(&exists)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,106 @@
+package row
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyPartitions(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS partcopyt1, _partcopyt1_new, _partcopyt1_whole")
+	testutils.RunSQL(t, `CREATE TABLE partcopyt1 (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))
+		PARTITION BY RANGE (a) (
+			PARTITION p0 VALUES LESS THAN (1000),
+			PARTITION p1 VALUES LESS THAN (2000),
+			PARTITION p2 VALUES LESS THAN (3000),
+			PARTITION pmax VALUES LESS THAN MAXVALUE)`)
+	testutils.RunSQL(t, "CREATE TABLE _partcopyt1_new (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _partcopyt1_whole (a INT NOT NULL AUTO_INCREMENT, b INT, PRIMARY KEY (a))")
+	// p2 is left empty.
+	testutils.RunSQL(t, "INSERT INTO partcopyt1 SELECT n, n FROM "+
+		"(WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 1999) SELECT n FROM seq) s")
+	testutils.RunSQL(t, "INSERT INTO partcopyt1 VALUES (5000, 5000), (5001, 5001)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "partcopyt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_partcopyt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+	t1whole := table.NewTableInfo(db, "test", "_partcopyt1_whole")
+	assert.NoError(t, t1whole.SetInfo(context.TODO()))
+
+	partitions, err := t1.Partitions(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p0", "p1", "p2", "pmax"}, partitions)
+
+	// Each partition is chunked within its own key range.
+	p1, err := t1.PartitionInfo(context.TODO(), "p1")
+	assert.NoError(t, err)
+	assert.Equal(t, "`test`.`partcopyt1` PARTITION (`p1`)", p1.FromName())
+	assert.Equal(t, "1999", p1.MaxValue().String())
+	_, err = t1.PartitionInfo(context.TODO(), "p9")
+	assert.ErrorContains(t, err, `partition "p9" does not exist in table`)
+
+	assert.NoError(t, CopyPartitions(context.Background(), db, t1, t1new, NewCopierDefaultConfig()))
+	copier, err := NewCopier(db, t1, t1whole, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.Background()))
+
+	// The union of the partitions equals a copy of the whole table.
+	var newCount, wholeCount, matching int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _partcopyt1_new").Scan(&newCount))
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _partcopyt1_whole").Scan(&wholeCount))
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _partcopyt1_new JOIN _partcopyt1_whole USING (a, b)").Scan(&matching))
+	assert.Equal(t, 2001, wholeCount)
+	assert.Equal(t, wholeCount, newCount)
+	assert.Equal(t, wholeCount, matching)
+
+	// The new table must be empty to start.
+	err = CopyPartitions(context.Background(), db, t1, t1new, NewCopierDefaultConfig())
+	assert.ErrorContains(t, err, "is not empty")
+
+	// A table that is not partitioned can not be copied by partition.
+	err = CopyPartitions(context.Background(), db, t1whole, t1new, NewCopierDefaultConfig())
+	assert.ErrorContains(t, err, "is not partitioned")
+}
+
+func TestCopierRunPartition(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS partrunt1, _partrunt1_new")
+	testutils.RunSQL(t, `CREATE TABLE partrunt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))
+		PARTITION BY RANGE (a) (
+			PARTITION p0 VALUES LESS THAN (100),
+			PARTITION p1 VALUES LESS THAN MAXVALUE)`)
+	testutils.RunSQL(t, "CREATE TABLE _partrunt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO partrunt1 VALUES (1, 1), (2, 2), (100, 100), (101, 101), (102, 102)")
+	// The rows of p0 were copied already.
+	testutils.RunSQL(t, "INSERT INTO _partrunt1_new VALUES (1, 1), (2, 2)")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "partrunt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_partrunt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+	p1, err := t1.PartitionInfo(context.TODO(), "p1")
+	assert.NoError(t, err)
+
+	// A partition without an incremental column is copied in full,
+	// into a new table that is not empty.
+	config := NewCopierDefaultConfig()
+	assert.Empty(t, config.IncrementalColumn)
+	copier, err := NewCopier(db, p1, t1new, config)
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.Background()))
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM _partrunt1_new").Scan(&count))
+	assert.Equal(t, 5, count)
+}
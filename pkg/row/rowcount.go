@@ -0,0 +1,39 @@
+package row
+
+import (
+	"context"
+	"fmt"
+)
+
+// CompareRowCounts counts the rows of the table and the new table. It is
+// much cheaper than a checksum, but only detects gross errors, such as
+// chunks that were not copied. While changes to the table are still being
+// applied from the binary log the counts are not expected to be equal:
+// the table may have rows that are inserted or deleted after it is counted,
+// and the new table may be missing the changes that have not been flushed.
+// Use CheckRowCounts with a tolerance to compare them. The counts are only
+// exact after the changes are flushed while the table is locked. If the
+// copier copies a partition, srcCount is the rows of the partition, but
+// newCount is the rows of the whole new table.
+func (c *Copier) CompareRowCounts(ctx context.Context) (srcCount, newCount uint64, err error) {
+	if err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+c.table.FromName()).Scan(&srcCount); err != nil {
+		return 0, 0, err
+	}
+	if err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+c.newTable.QuotedName).Scan(&newCount); err != nil {
+		return 0, 0, err
+	}
+	return srcCount, newCount, nil
+}
+
+// CheckRowCounts returns an error if newCount differs from srcCount by more
+// than tolerance, a fraction of srcCount, i.e. 0.01 allows a difference of
+// 1% for the rows that are changed while they are counted. A tolerance of
+// zero requires the counts to be equal.
+func CheckRowCounts(srcCount, newCount uint64, tolerance float64) error {
+	diff := max(srcCount, newCount) - min(srcCount, newCount)
+	if float64(diff) > tolerance*float64(srcCount) {
+		return fmt.Errorf("row counts differ by %d rows: the table has %d rows and the new table has %d rows, which is more than the tolerance of %.2f%%",
+			diff, srcCount, newCount, tolerance*100)
+	}
+	return nil
+}
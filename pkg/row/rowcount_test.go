@@ -0,0 +1,60 @@
+package row
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cashapp/spirit/pkg/dbconn"
+	"github.com/cashapp/spirit/pkg/table"
+	"github.com/cashapp/spirit/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRowCounts(t *testing.T) {
+	assert.NoError(t, CheckRowCounts(1000, 1000, 0))
+	assert.NoError(t, CheckRowCounts(0, 0, 0))
+	assert.ErrorContains(t, CheckRowCounts(1000, 999, 0), "row counts differ by 1 rows: the table has 1000 rows and the new table has 999 rows")
+
+	// Within the tolerance, in either direction.
+	assert.NoError(t, CheckRowCounts(1000, 990, 0.01))
+	assert.NoError(t, CheckRowCounts(1000, 1010, 0.01))
+	assert.ErrorContains(t, CheckRowCounts(1000, 989, 0.01), "more than the tolerance of 1.00%")
+	assert.ErrorContains(t, CheckRowCounts(1000, 1011, 0.01), "differ by 11 rows")
+
+	// A grossly mismatched count.
+	assert.Error(t, CheckRowCounts(1000, 500, 0.01))
+	assert.Error(t, CheckRowCounts(0, 10, 0.01))
+}
+
+func TestCompareRowCounts(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS rowcountt1, _rowcountt1_new")
+	testutils.RunSQL(t, "CREATE TABLE rowcountt1 (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "CREATE TABLE _rowcountt1_new (a INT NOT NULL, b INT, PRIMARY KEY (a))")
+	testutils.RunSQL(t, "INSERT INTO rowcountt1 SELECT n, n FROM (WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < 1000) SELECT n FROM seq) s")
+
+	db, err := dbconn.New(testutils.DSN(), dbconn.NewDBConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	t1 := table.NewTableInfo(db, "test", "rowcountt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	t1new := table.NewTableInfo(db, "test", "_rowcountt1_new")
+	assert.NoError(t, t1new.SetInfo(context.TODO()))
+	copier, err := NewCopier(db, t1, t1new, NewCopierDefaultConfig())
+	assert.NoError(t, err)
+	assert.NoError(t, copier.Run(context.TODO()))
+
+	srcCount, newCount, err := copier.CompareRowCounts(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1000), srcCount)
+	assert.Equal(t, uint64(1000), newCount)
+	assert.NoError(t, CheckRowCounts(srcCount, newCount, 0))
+
+	// Half of the rows are missing from the new table.
+	testutils.RunSQL(t, "DELETE FROM _rowcountt1_new WHERE a % 2 = 0")
+	srcCount, newCount, err = copier.CompareRowCounts(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1000), srcCount)
+	assert.Equal(t, uint64(500), newCount)
+	assert.ErrorContains(t, CheckRowCounts(srcCount, newCount, 0.01), "row counts differ by 500 rows")
+}
@@ -3,6 +3,7 @@ package table
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -133,6 +134,37 @@ func jsonStrings2Datums(ti *TableInfo, keys []string, vals []string) ([]Datum, e
 	return datums, nil
 }
 
+// ValidateWatermark checks that a low watermark from a checkpoint is still
+// a valid position in the table: it must be a chunk of the current key, its
+// values must parse as the key's types, and its lower bound must not be above
+// the current maximum value of the key. A lower bound below the minimum value
+// is permitted, since rows may have been deleted after the checkpoint.
+func ValidateWatermark(ti *TableInfo, watermark string) error {
+	var chunk JSONChunk
+	if err := json.Unmarshal([]byte(watermark), &chunk); err != nil {
+		return fmt.Errorf("could not parse watermark: %w", err)
+	}
+	if len(chunk.Key) == 0 || !slices.Equal(chunk.Key, ti.KeyColumns) {
+		return fmt.Errorf("watermark key %v does not match the key %v of table %s", chunk.Key, ti.KeyColumns, ti.QuotedName)
+	}
+	for _, bound := range []JSONBoundary{chunk.LowerBound, chunk.UpperBound} {
+		if len(bound.Value) != len(chunk.Key) {
+			return fmt.Errorf("watermark has %d values for a key of %d columns", len(bound.Value), len(chunk.Key))
+		}
+		for i, str := range bound.Value {
+			if _, err := datumValFromString(str, ti.datumTp(chunk.Key[i])); err != nil {
+				return fmt.Errorf("watermark value %q is not valid for column %s: %w", str, chunk.Key[i], err)
+			}
+		}
+	}
+	lower := newDatum(chunk.LowerBound.Value[0], ti.datumTp(chunk.Key[0]))
+	maxValue := ti.MaxValue()
+	if lower.IsNumeric() && !maxValue.IsNil() && !maxValue.GreaterThanOrEqual(lower) {
+		return fmt.Errorf("watermark %s is above the maximum value %s of table %s", lower, maxValue, ti.QuotedName)
+	}
+	return nil
+}
+
 func newChunkFromJSON(ti *TableInfo, jsonStr string) (*Chunk, error) {
 	var chunk JSONChunk
 	err := json.Unmarshal([]byte(jsonStr), &chunk)
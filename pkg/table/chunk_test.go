@@ -43,6 +43,33 @@ func TestChunk2String(t *testing.T) {
 	assert.Equal(t, "1=1", chunk.String())
 }
 
+func TestChunk2StringByKeyType(t *testing.T) {
+	// The bounds are rendered according to the MySQL type of the key column.
+	tests := []struct {
+		mysqlTp  string
+		lower    string
+		upper    string
+		expected string
+	}{
+		{"int", "100", "200", "`pk` >= 100 AND `pk` < 200"},
+		{"varchar", "abc", "it's", "`pk` >= 'abc' AND `pk` < 'it\\'s'"},
+		{"datetime", "2024-01-01 00:00:00", "2024-02-01 00:00:00", "`pk` >= '2024-01-01 00:00:00' AND `pk` < '2024-02-01 00:00:00'"},
+		{"varbinary", "\x00\xff", "a' OR 1=1 -- ", "`pk` >= X'00ff' AND `pk` < X'6127204f5220313d31202d2d20'"},
+	}
+	for _, test := range tests {
+		lower, err := newDatumFromMySQL(test.lower, test.mysqlTp)
+		assert.NoError(t, err)
+		upper, err := newDatumFromMySQL(test.upper, test.mysqlTp)
+		assert.NoError(t, err)
+		chunk := &Chunk{
+			Key:        []string{"pk"},
+			LowerBound: &Boundary{Value: []Datum{lower}, Inclusive: true},
+			UpperBound: &Boundary{Value: []Datum{upper}, Inclusive: false},
+		}
+		assert.Equal(t, test.expected, chunk.String(), test.mysqlTp)
+	}
+}
+
 func TestBoundary_ValueString(t *testing.T) {
 	boundary1 := &Boundary{
 		Value:     []Datum{newDatum(100, signedType), newDatum(200, signedType)},
@@ -102,7 +129,7 @@ func TestCompositeChunks(t *testing.T) {
 			Inclusive: false,
 		},
 	}
-	assert.Equal(t, "((`status` > \"ARCHIVED\")\n OR (`status` = \"ARCHIVED\" AND `id` >= 1234)) AND ((`status` < \"ARCHIVED\")\n OR (`status` = \"ARCHIVED\" AND `id` < 5412))", chunk.String())
+	assert.Equal(t, "((`status` > X'4152434849564544')\n OR (`status` = X'4152434849564544' AND `id` >= 1234)) AND ((`status` < X'4152434849564544')\n OR (`status` = X'4152434849564544' AND `id` < 5412))", chunk.String())
 }
 
 func TestComparesTo(t *testing.T) {
@@ -133,3 +160,25 @@ func TestComparesTo(t *testing.T) {
 	b2.Value = []Datum{newDatum(200, signedType), newDatum(400, signedType)}
 	assert.False(t, b1.comparesTo(b2))
 }
+
+func TestValidateWatermark(t *testing.T) {
+	ti := NewTableInfo(nil, "test", "t1")
+	ti.KeyColumns = []string{"id"}
+	ti.columnsMySQLTps = map[string]string{"id": "int", "name": "varchar"}
+	ti.maxValue = newDatum(100, signedType)
+
+	assert.NoError(t, ValidateWatermark(ti, `{"Key":["id"],"ChunkSize":10,"LowerBound":{"Value":["20"],"Inclusive":true},"UpperBound":{"Value":["30"],"Inclusive":false}}`))
+	// Rows below the watermark may have been deleted since.
+	ti.minValue = newDatum(50, signedType)
+	assert.NoError(t, ValidateWatermark(ti, `{"Key":["id"],"ChunkSize":10,"LowerBound":{"Value":["20"],"Inclusive":true},"UpperBound":{"Value":["30"],"Inclusive":false}}`))
+
+	assert.ErrorContains(t, ValidateWatermark(ti, `{"Key":["id"],`), "could not parse watermark")
+	assert.ErrorContains(t, ValidateWatermark(ti, `{"Key":["name"],"ChunkSize":10,"LowerBound":{"Value":["a"],"Inclusive":true},"UpperBound":{"Value":["b"],"Inclusive":false}}`),
+		"watermark key [name] does not match the key [id]")
+	assert.ErrorContains(t, ValidateWatermark(ti, `{"Key":["id"],"ChunkSize":10,"LowerBound":{"Value":[],"Inclusive":true},"UpperBound":{"Value":["30"],"Inclusive":false}}`),
+		"watermark has 0 values for a key of 1 columns")
+	assert.ErrorContains(t, ValidateWatermark(ti, `{"Key":["id"],"ChunkSize":10,"LowerBound":{"Value":["abc"],"Inclusive":true},"UpperBound":{"Value":["30"],"Inclusive":false}}`),
+		`watermark value "abc" is not valid for column id`)
+	assert.ErrorContains(t, ValidateWatermark(ti, `{"Key":["id"],"ChunkSize":10,"LowerBound":{"Value":["200"],"Inclusive":true},"UpperBound":{"Value":["210"],"Inclusive":false}}`),
+		"watermark 200 is above the maximum value 100")
+}
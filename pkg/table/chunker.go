@@ -37,6 +37,9 @@ type Chunker interface {
 	Feedback(chunk *Chunk, duration time.Duration)
 	GetLowWatermark() (string, error)
 	KeyAboveHighWatermark(key interface{}) bool
+	SetChunkSizeBounds(minRows, maxRows uint64) error
+	SetNewestFirst(keyRange uint64) error
+	SetKeyRange(keyRange *KeyRange) error
 }
 
 func NewChunker(t *TableInfo, chunkerTarget time.Duration, logger loggers.Advanced) (Chunker, error) {
@@ -28,6 +28,8 @@ type chunkerComposite struct {
 	// It uses *time* to determine the target chunk size.
 	chunkTimingInfo []time.Duration
 	ChunkerTarget   time.Duration // i.e. 500ms for target
+	minChunkSize    uint64        // overrides MinDynamicRowSize if non-zero
+	maxChunkSize    uint64        // overrides MaxDynamicRowSize if non-zero
 
 	// This is used for restore.
 	watermark *Chunk
@@ -69,7 +71,7 @@ func (t *chunkerComposite) Next() (*Chunk, error) {
 	// just below.
 	query := fmt.Sprintf("SELECT %s FROM %s FORCE INDEX (%s) %s ORDER BY %s LIMIT 1 OFFSET %d",
 		strings.Join(t.chunkKeys, ","),
-		t.Ti.QuotedName,
+		t.Ti.FromName(),
 		t.keyName,
 		t.additionalConditionsSQL(false),
 		strings.Join(t.chunkKeys, ","),
@@ -79,7 +81,7 @@ func (t *chunkerComposite) Next() (*Chunk, error) {
 		// This is not the first chunk, since we have pointers set.
 		query = fmt.Sprintf("SELECT %s FROM %s FORCE INDEX (%s) WHERE %s %s ORDER BY %s LIMIT 1 OFFSET %d",
 			strings.Join(t.chunkKeys, ","),
-			t.Ti.QuotedName,
+			t.Ti.FromName(),
 			t.keyName,
 			expandRowConstructorComparison(t.chunkKeys, OpGreaterThan, t.chunkPtrs),
 			t.additionalConditionsSQL(true),
@@ -348,7 +350,7 @@ func (t *chunkerComposite) open() (err error) {
 		t.keyName = "PRIMARY"
 	}
 	t.finalChunkSent = false
-	t.chunkSize = StartingChunkSize
+	t.chunkSize = t.clampChunkSize(StartingChunkSize)
 	return nil
 }
 
@@ -374,14 +376,35 @@ func (t *chunkerComposite) boundaryCheckTargetChunkSize(newTarget uint64) uint64
 		newTargetRows = float64(t.chunkSize) * MaxDynamicStepFactor
 	}
 
-	if newTargetRows > MaxDynamicRowSize {
-		newTargetRows = MaxDynamicRowSize
+	return t.clampChunkSize(uint64(newTargetRows))
+}
+
+// SetChunkSizeBounds sets the floor and ceiling that the dynamic chunk size
+// is allowed to reach. A zero value uses the default bound.
+func (t *chunkerComposite) SetChunkSizeBounds(minRows, maxRows uint64) error {
+	if minRows > 0 && maxRows > 0 && minRows > maxRows {
+		return fmt.Errorf("minimum chunk size %d must be less than or equal to maximum chunk size %d", minRows, maxRows)
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.minChunkSize = minRows
+	t.maxChunkSize = maxRows
+	if t.isOpen {
+		t.chunkSize = t.clampChunkSize(t.chunkSize)
 	}
+	return nil
+}
 
-	if newTargetRows < MinDynamicRowSize {
-		newTargetRows = MinDynamicRowSize
+// clampChunkSize returns the chunkSize within the min and max bounds.
+func (t *chunkerComposite) clampChunkSize(chunkSize uint64) uint64 {
+	maxRows, minRows := uint64(MaxDynamicRowSize), uint64(MinDynamicRowSize)
+	if t.maxChunkSize > 0 {
+		maxRows = t.maxChunkSize
 	}
-	return uint64(newTargetRows)
+	if t.minChunkSize > 0 {
+		minRows = t.minChunkSize
+	}
+	return max(min(chunkSize, maxRows), minRows)
 }
 
 func (t *chunkerComposite) calculateNewTargetChunkSize() uint64 {
@@ -392,6 +415,35 @@ func (t *chunkerComposite) calculateNewTargetChunkSize() uint64 {
 	return uint64(newTargetRows)
 }
 
+// SetNewestFirst is not supported by the composite chunker, since
+// it can not assume that the newest rows have the highest keys.
+func (t *chunkerComposite) SetNewestFirst(keyRange uint64) error {
+	if keyRange > 0 {
+		return errors.New("newest-first ordering is only supported for tables with an auto_increment primary key")
+	}
+	return nil
+}
+
+// SetKeyRange restricts the chunks to the rows in keyRange, by adding
+// the range to the additional WHERE conditions. It must be called
+// before the chunker is opened.
+func (t *chunkerComposite) SetKeyRange(keyRange *KeyRange) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.isOpen {
+		return errors.New("cannot set key range after table is open")
+	}
+	cond := keyRange.condition()
+	if cond == "" {
+		return nil
+	}
+	if t.where != "" {
+		cond = fmt.Sprintf("(%s) AND %s", t.where, cond)
+	}
+	t.where = cond
+	return nil
+}
+
 func (t *chunkerComposite) KeyAboveHighWatermark(key interface{}) bool {
 	return false
 }
@@ -57,12 +57,12 @@ func TestCompositeChunkerCompositeBinary(t *testing.T) {
 	upperBound = chunk.UpperBound.Value
 	require.NotEqual(t, previousUpperBound, upperBound)
 	assert.Equal(t, fmt.Sprintf("((`a` > %s)\n OR (`a` = %s AND `b` >= %s)) AND ((`a` < %s)\n OR (`a` = %s AND `b` < %s))",
-		previousUpperBound[0].String(),
-		previousUpperBound[0].String(),
-		previousUpperBound[1].String(),
-		upperBound[0].String(),
-		upperBound[0].String(),
-		upperBound[1].String()),
+		previousUpperBound[0].sqlValue(),
+		previousUpperBound[0].sqlValue(),
+		previousUpperBound[1].sqlValue(),
+		upperBound[0].sqlValue(),
+		upperBound[0].sqlValue(),
+		upperBound[1].sqlValue()),
 		chunk.String(),
 	)
 
@@ -72,12 +72,12 @@ func TestCompositeChunkerCompositeBinary(t *testing.T) {
 	upperBound = chunk.UpperBound.Value
 	require.NotEqual(t, previousUpperBound, upperBound)
 	assert.Equal(t, fmt.Sprintf("((`a` > %s)\n OR (`a` = %s AND `b` >= %s)) AND ((`a` < %s)\n OR (`a` = %s AND `b` < %s))",
-		previousUpperBound[0].String(),
-		previousUpperBound[0].String(),
-		previousUpperBound[1].String(),
-		upperBound[0].String(),
-		upperBound[0].String(),
-		upperBound[1].String()),
+		previousUpperBound[0].sqlValue(),
+		previousUpperBound[0].sqlValue(),
+		previousUpperBound[1].sqlValue(),
+		upperBound[0].sqlValue(),
+		upperBound[0].sqlValue(),
+		upperBound[1].sqlValue()),
 		chunk.String(),
 	)
 
@@ -88,12 +88,12 @@ func TestCompositeChunkerCompositeBinary(t *testing.T) {
 	upperBound = chunk.UpperBound.Value
 	require.NotEqual(t, previousUpperBound, upperBound)
 	assert.Equal(t, fmt.Sprintf("((`a` > %s)\n OR (`a` = %s AND `b` >= %s)) AND ((`a` < %s)\n OR (`a` = %s AND `b` < %s))",
-		previousUpperBound[0].String(),
-		previousUpperBound[0].String(),
-		previousUpperBound[1].String(),
-		upperBound[0].String(),
-		upperBound[0].String(),
-		upperBound[1].String()),
+		previousUpperBound[0].sqlValue(),
+		previousUpperBound[0].sqlValue(),
+		previousUpperBound[1].sqlValue(),
+		upperBound[0].sqlValue(),
+		upperBound[0].sqlValue(),
+		upperBound[1].sqlValue()),
 		chunk.String(),
 	)
 
@@ -470,12 +470,12 @@ func TestSetKey(t *testing.T) {
 	assert.NoError(t, chunker.Open())
 	chunk, err = chunker.Next()
 	assert.NoError(t, err)
-	assert.Equal(t, "((`status` < \"PENDING\")\n OR (`status` = \"PENDING\" AND `id` < 1008)) AND (status = 'PENDING' AND updated_at > NOW() - INTERVAL 1 DAY)", chunk.String())
+	assert.Equal(t, "((`status` < 'PENDING')\n OR (`status` = 'PENDING' AND `id` < 1008)) AND (status = 'PENDING' AND updated_at > NOW() - INTERVAL 1 DAY)", chunk.String())
 
 	// Check a chunk with both a lowerbound and upper bound.
 	chunk, err = chunker.Next()
 	assert.NoError(t, err)
-	assert.Equal(t, "((`status` > \"PENDING\")\n OR (`status` = \"PENDING\" AND `id` >= 1008)) AND ((`status` < \"PENDING\")\n OR (`status` = \"PENDING\" AND `id` < 2032)) AND (status = 'PENDING' AND updated_at > NOW() - INTERVAL 1 DAY)", chunk.String())
+	assert.Equal(t, "((`status` > 'PENDING')\n OR (`status` = 'PENDING' AND `id` >= 1008)) AND ((`status` < 'PENDING')\n OR (`status` = 'PENDING' AND `id` < 2032)) AND (status = 'PENDING' AND updated_at > NOW() - INTERVAL 1 DAY)", chunk.String())
 
 	// repeat ~10 more times without calling Feedback()
 	for range 8 {
@@ -484,7 +484,7 @@ func TestSetKey(t *testing.T) {
 	}
 	chunk, err = chunker.Next()
 	assert.NoError(t, err)
-	assert.Equal(t, "((`status` > \"PENDING\")\n OR (`status` = \"PENDING\" AND `id` >= 10040)) AND (status = 'PENDING' AND updated_at > NOW() - INTERVAL 1 DAY)", chunk.String())
+	assert.Equal(t, "((`status` > 'PENDING')\n OR (`status` = 'PENDING' AND `id` >= 10040)) AND (status = 'PENDING' AND updated_at > NOW() - INTERVAL 1 DAY)", chunk.String())
 
 	_, err = chunker.Next()
 	assert.ErrorIs(t, err, ErrTableIsRead)
@@ -23,6 +23,8 @@ type chunkerOptimistic struct {
 	// It uses *time* to determine the target chunk size.
 	chunkTimingInfo []time.Duration
 	ChunkerTarget   time.Duration // i.e. 500ms for target
+	minChunkSize    uint64        // overrides MinDynamicRowSize if non-zero
+	maxChunkSize    uint64        // overrides MaxDynamicRowSize if non-zero
 
 	disableDynamicChunker bool // only used by the test suite
 
@@ -37,6 +39,16 @@ type chunkerOptimistic struct {
 	// that there are very large gaps in the sequence.
 	chunkPrefetchingEnabled bool
 
+	// Newest-first ordering copies the newest keys first, starting
+	// at newestFirstStart and ascending to the end of the table.
+	// It then backfills from the start of the table up to newestFirstStart.
+	newestFirstKeyRange uint64
+	newestFirstStart    Datum // nil if newest-first ordering is not used
+	backfilling         bool
+
+	// keyRange restricts the chunks to part of the table. Nil copies all of it.
+	keyRange *KeyRange
+
 	logger loggers.Advanced
 }
 
@@ -49,7 +61,7 @@ var _ Chunker = &chunkerOptimistic{}
 func (t *chunkerOptimistic) nextChunkByPrefetching() (*Chunk, error) {
 	key := t.Ti.KeyColumns[0]
 	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s > ? ORDER BY %s LIMIT 1 OFFSET %d",
-		key, t.Ti.QuotedName, key, key, t.chunkSize,
+		key, t.Ti.FromName(), key, key, t.chunkSize,
 	)
 	rows, err := t.Ti.db.Query(query, t.chunkPtr.String())
 	if err != nil {
@@ -104,7 +116,49 @@ func (t *chunkerOptimistic) Next() (*Chunk, error) {
 	if !t.isOpen {
 		return nil, ErrTableNotOpen
 	}
+	chunk, err := t.next()
+	if err == nil && t.keyRange != nil {
+		t.boundToKeyRange(chunk)
+	}
+	if err != nil || t.newestFirstStart.IsNil() {
+		return chunk, err
+	}
+	if !t.backfilling {
+		// The final chunk of the newest keys has been sent.
+		// Reset the chunkPtr so that the backfill starts
+		// from the beginning of the table.
+		if t.finalChunkSent {
+			t.finalChunkSent = false
+			t.backfilling = true
+			t.chunkPtr = NewNilDatum(t.chunkPtr.Tp)
+		}
+		return chunk, nil
+	}
+	// The backfill ends where the newest keys started.
+	if chunk.UpperBound == nil || chunk.UpperBound.Value[0].GreaterThanOrEqual(t.newestFirstStart) {
+		chunk.UpperBound = &Boundary{[]Datum{t.newestFirstStart}, false}
+		t.finalChunkSent = true
+	}
+	return chunk, nil
+}
+
+// boundToKeyRange restricts chunk to the key range. The first chunk starts
+// at the start of the range, and the chunk that reaches the end of the
+// range is the final chunk.
+func (t *chunkerOptimistic) boundToKeyRange(chunk *Chunk) {
+	if chunk.LowerBound == nil && !t.keyRange.Start.IsNil() {
+		chunk.LowerBound = &Boundary{[]Datum{t.keyRange.Start}, true}
+	}
+	if t.keyRange.End.IsNil() {
+		return
+	}
+	if chunk.UpperBound == nil || chunk.UpperBound.Value[0].GreaterThanOrEqual(t.keyRange.End) {
+		chunk.UpperBound = &Boundary{[]Datum{t.keyRange.End}, false}
+		t.finalChunkSent = true
+	}
+}
 
+func (t *chunkerOptimistic) next() (*Chunk, error) {
 	// If there is a minimum value, we attempt to apply
 	// the minimum value optimization.
 	if t.chunkPtr.IsNil() {
@@ -163,7 +217,14 @@ func (t *chunkerOptimistic) Open() (err error) {
 	t.Lock()
 	defer t.Unlock()
 
-	return t.open()
+	if err := t.open(); err != nil {
+		return err
+	}
+	if !t.newestFirstStart.IsNil() {
+		t.chunkPtr = t.newestFirstStart
+		t.logger.Infof("copying newest keys first: starting at %s, then backfilling from the start of the table", t.newestFirstStart)
+	}
+	return nil
 }
 
 func (t *chunkerOptimistic) setDynamicChunking(newValue bool) {
@@ -182,6 +243,11 @@ func (t *chunkerOptimistic) OpenAtWatermark(cp string, highPtr Datum) error {
 	if err := t.open(); err != nil {
 		return err
 	}
+	// A checkpoint is always resumed in ascending order. The low watermark
+	// only advances once the backfill has started, so all of the newest
+	// keys are copied again, which INSERT IGNORE makes safe.
+	t.newestFirstStart = NewNilDatum(t.chunkPtr.Tp)
+	t.backfilling = false
 	// Because this chunker only supports single-column primary keys,
 	// we can safely set the checkpointHighPtr as a single value like this.
 	t.checkpointHighPtr = highPtr // set the high pointer.
@@ -283,7 +349,7 @@ func (t *chunkerOptimistic) bumpWatermark(chunk *Chunk) {
 	}
 	// Check if this is the first chunk or it's the special restored chunk.
 	// If so, set the watermark and then go on to applying any stored chunks.
-	if (t.watermark == nil && chunk.LowerBound == nil) || t.isSpecialRestoredChunk(chunk) {
+	if (t.watermark == nil && (chunk.LowerBound == nil || t.isKeyRangeStart(chunk.LowerBound))) || t.isSpecialRestoredChunk(chunk) {
 		t.watermark = chunk
 		goto applyStoredChunks
 	}
@@ -323,6 +389,12 @@ applyStoredChunks:
 	}
 }
 
+// isKeyRangeStart returns true if the boundary is the start of the key
+// range, which is the lower bound of the first chunk.
+func (t *chunkerOptimistic) isKeyRangeStart(b *Boundary) bool {
+	return t.keyRange != nil && !t.keyRange.Start.IsNil() && b.comparesTo(&Boundary{[]Datum{t.keyRange.Start}, true})
+}
+
 func (t *chunkerOptimistic) waterMarkMapNotEmpty() bool {
 	return len(t.lowerBoundWatermarkMap) != 0
 }
@@ -343,8 +415,28 @@ func (t *chunkerOptimistic) open() (err error) {
 	t.isOpen = true
 	t.chunkPtr = NewNilDatum(t.Ti.keyDatums[0])
 	t.finalChunkSent = false
-	t.chunkSize = StartingChunkSize
+	t.chunkSize = t.clampChunkSize(StartingChunkSize)
+	t.newestFirstStart = NewNilDatum(t.Ti.keyDatums[0])
+	t.backfilling = false
+
+	// Newest-first ordering is only used if the table has a known
+	// key range that is larger than the range of the newest keys.
+	if t.newestFirstKeyRange > 0 && !t.Ti.minValue.IsNil() && !t.Ti.maxValue.IsNil() {
+		if keyRange := t.Ti.maxValue.Range(t.Ti.minValue); keyRange > t.newestFirstKeyRange {
+			t.newestFirstStart = t.Ti.minValue.Add(keyRange - t.newestFirstKeyRange)
+		}
+	}
 
+	// If the table was empty when its statistics were read, the first
+	// chunk copies nothing and the second is the final chunk, which copies
+	// any rows that were inserted since. Otherwise the chunker would step
+	// through the entire range of the key's type.
+	if t.Ti.minValue.IsNil() && t.Ti.maxValue.IsNil() {
+		t.Ti.minValue = t.chunkPtr.MinValue()
+		t.Ti.maxValue = t.Ti.minValue
+		t.clampToKeyRange()
+		return nil
+	}
 	// Make sure min/max value are always specified
 	// To simplify the code in NextChunk funcs.
 	if t.Ti.minValue.IsNil() {
@@ -353,6 +445,56 @@ func (t *chunkerOptimistic) open() (err error) {
 	if t.Ti.maxValue.IsNil() {
 		t.Ti.maxValue = t.chunkPtr.MaxValue()
 	}
+	t.clampToKeyRange()
+	return nil
+}
+
+// clampToKeyRange narrows the min and max values to the key range, so
+// the chunks only step through the range. It is called under a mutex.
+func (t *chunkerOptimistic) clampToKeyRange() {
+	if t.keyRange == nil {
+		return
+	}
+	if !t.keyRange.Start.IsNil() && !t.Ti.minValue.GreaterThanOrEqual(t.keyRange.Start) {
+		t.Ti.minValue = t.keyRange.Start
+	}
+	if !t.keyRange.End.IsNil() && t.Ti.maxValue.GreaterThanOrEqual(t.keyRange.End) {
+		t.Ti.maxValue = t.keyRange.End
+	}
+	if !t.Ti.maxValue.GreaterThanOrEqual(t.Ti.minValue) {
+		t.Ti.maxValue = t.Ti.minValue
+	}
+}
+
+// SetNewestFirst enables newest-first ordering: the chunker first copies the
+// keyRange newest keys in ascending order, then backfills the rest of the
+// table from the start. A keyRange of zero uses strictly ascending order.
+// It must be called before the chunker is opened.
+func (t *chunkerOptimistic) SetNewestFirst(keyRange uint64) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.isOpen {
+		return errors.New("cannot set newest-first ordering after table is open")
+	}
+	if keyRange > 0 && t.keyRange != nil {
+		return errors.New("newest-first ordering can not be combined with a key range")
+	}
+	t.newestFirstKeyRange = keyRange
+	return nil
+}
+
+// SetKeyRange restricts the chunks to the rows in keyRange.
+// It must be called before the chunker is opened.
+func (t *chunkerOptimistic) SetKeyRange(keyRange *KeyRange) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.isOpen {
+		return errors.New("cannot set key range after table is open")
+	}
+	if t.newestFirstKeyRange > 0 {
+		return errors.New("newest-first ordering can not be combined with a key range")
+	}
+	t.keyRange = keyRange
 	return nil
 }
 
@@ -378,14 +520,35 @@ func (t *chunkerOptimistic) boundaryCheckTargetChunkSize(newTarget uint64) uint6
 		newTargetRows = float64(t.chunkSize) * MaxDynamicStepFactor
 	}
 
-	if newTargetRows > MaxDynamicRowSize {
-		newTargetRows = MaxDynamicRowSize
+	return t.clampChunkSize(uint64(newTargetRows))
+}
+
+// SetChunkSizeBounds sets the floor and ceiling that the dynamic chunk size
+// is allowed to reach. A zero value uses the default bound.
+func (t *chunkerOptimistic) SetChunkSizeBounds(minRows, maxRows uint64) error {
+	if minRows > 0 && maxRows > 0 && minRows > maxRows {
+		return fmt.Errorf("minimum chunk size %d must be less than or equal to maximum chunk size %d", minRows, maxRows)
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.minChunkSize = minRows
+	t.maxChunkSize = maxRows
+	if t.isOpen {
+		t.chunkSize = t.clampChunkSize(t.chunkSize)
 	}
+	return nil
+}
 
-	if newTargetRows < MinDynamicRowSize {
-		newTargetRows = MinDynamicRowSize
+// clampChunkSize returns the chunkSize within the min and max bounds.
+func (t *chunkerOptimistic) clampChunkSize(chunkSize uint64) uint64 {
+	maxRows, minRows := uint64(MaxDynamicRowSize), uint64(MinDynamicRowSize)
+	if t.maxChunkSize > 0 {
+		maxRows = t.maxChunkSize
 	}
-	return uint64(newTargetRows)
+	if t.minChunkSize > 0 {
+		minRows = t.minChunkSize
+	}
+	return max(min(chunkSize, maxRows), minRows)
 }
 
 func (t *chunkerOptimistic) calculateNewTargetChunkSize() uint64 {
@@ -411,10 +574,22 @@ func (t *chunkerOptimistic) calculateNewTargetChunkSize() uint64 {
 // KeyAboveHighWatermark returns true if the key is above the high watermark.
 // TRUE means that the row will be discarded so if there is any ambiguity,
 // it's important to return FALSE.
+//
+// The high watermark is the chunkPtr, which is the exclusive upper bound of
+// the last chunk returned by Next, and the inclusive lower bound of the next
+// chunk. A key equal to the chunkPtr is above the watermark: no chunk that
+// contains it has been returned, so the chunk that copies it reads the row
+// after the change. A key below the chunkPtr is not, since the chunk that
+// contains it may already have read the row. After resuming from a
+// checkpoint, keys up to and including the checkpoint's high pointer (the
+// largest key in the new table) are never above, since they were copied.
 func (t *chunkerOptimistic) KeyAboveHighWatermark(key interface{}) bool {
 	t.Lock()
 	defer t.Unlock()
-	if t.chunkPtr.IsNil() && t.checkpointHighPtr.IsNil() {
+	if t.keyRange != nil && !t.keyRange.Contains(key) {
+		return true // keys outside of the key range are never copied.
+	}
+	if t.chunkPtr.IsNil() && t.checkpointHighPtr.IsNil() && !t.backfilling {
 		return true // every key is above because we haven't started copying.
 	}
 	if t.finalChunkSent {
@@ -429,6 +604,18 @@ func (t *chunkerOptimistic) KeyAboveHighWatermark(key interface{}) bool {
 	if !t.checkpointHighPtr.IsNil() && t.checkpointHighPtr.GreaterThanOrEqual(keyDatum) {
 		return false
 	}
+	// With newest-first ordering, keys below the newest keys are not
+	// copied until the backfill. Once the backfill has started, the newest
+	// keys have all been copied, and only the keys between the chunkPtr
+	// and the start of the newest keys remain to be copied.
+	if !t.newestFirstStart.IsNil() {
+		if !keyDatum.GreaterThanOrEqual(t.newestFirstStart) {
+			return !t.backfilling || t.chunkPtr.IsNil() || keyDatum.GreaterThanOrEqual(t.chunkPtr)
+		}
+		if t.backfilling {
+			return false
+		}
+	}
 	// Finally we check the chunkPtr.
 	return keyDatum.GreaterThanOrEqual(t.chunkPtr)
 }
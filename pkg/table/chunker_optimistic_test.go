@@ -3,6 +3,7 @@ package table
 import (
 	"context"
 	"database/sql"
+	"math"
 	"testing"
 	"time"
 
@@ -74,6 +75,41 @@ func TestOptimisticChunkerBasic(t *testing.T) {
 	assert.NoError(t, chunker.Close())
 }
 
+func TestOptimisticChunkerEmptyTable(t *testing.T) {
+	t1 := &TableInfo{
+		minValue:          NewNilDatum(signedType),
+		maxValue:          NewNilDatum(signedType),
+		SchemaName:        "test",
+		TableName:         "emptyt1",
+		QuotedName:        "`test`.`emptyt1`",
+		KeyColumns:        []string{"id"},
+		keyColumnsMySQLTp: []string{"bigint"},
+		keyDatums:         []datumTp{signedType},
+		KeyIsAutoInc:      true,
+		Columns:           []string{"id", "name"},
+	}
+	t1.statisticsLastUpdated = time.Now()
+	chunker := &chunkerOptimistic{
+		Ti:            t1,
+		ChunkerTarget: ChunkerDefaultTarget,
+		logger:        logrus.New(),
+	}
+	assert.NoError(t, chunker.Open())
+
+	// The first chunk copies nothing, and the second copies
+	// any rows that were inserted since the table was empty.
+	chunk, err := chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` < -9223372036854775808", chunk.String())
+	assert.True(t, chunker.KeyAboveHighWatermark(1))
+	chunk, err = chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= -9223372036854775808", chunk.String())
+	assert.False(t, chunker.KeyAboveHighWatermark(1))
+	_, err = chunker.Next()
+	assert.ErrorIs(t, err, ErrTableIsRead)
+}
+
 func TestLowWatermark(t *testing.T) {
 	t1 := newTableInfo4Test("test", "t1")
 	t1.minValue = newDatum(1, signedType)
@@ -311,3 +347,230 @@ func TestOptimisticPrefetchChunking(t *testing.T) {
 	}
 	assert.True(t, chunker.chunkPrefetchingEnabled)
 }
+
+func TestOptimisticChunkSizeBounds(t *testing.T) {
+	t1 := &TableInfo{
+		minValue:          newDatum(1, signedType),
+		maxValue:          newDatum(1000000, signedType),
+		EstimatedRows:     1000000,
+		SchemaName:        "test",
+		TableName:         "t1",
+		QuotedName:        "`test`.`t1`",
+		KeyColumns:        []string{"id"},
+		keyColumnsMySQLTp: []string{"int"},
+		keyDatums:         []datumTp{signedType},
+		KeyIsAutoInc:      true,
+		Columns:           []string{"id", "name"},
+	}
+	t1.statisticsLastUpdated = time.Now()
+	chunker := &chunkerOptimistic{
+		Ti:                     t1,
+		ChunkerTarget:          100 * time.Millisecond,
+		lowerBoundWatermarkMap: make(map[string]*Chunk),
+		logger:                 logrus.New(),
+	}
+	assert.Error(t, chunker.SetChunkSizeBounds(500, 100)) // min > max
+	assert.NoError(t, chunker.SetChunkSizeBounds(200, 1200))
+	assert.NoError(t, chunker.Open())
+
+	// Force high latency, which would usually shrink the chunk size
+	// toward MinDynamicRowSize. It should clamp at the floor instead.
+	for range 5 {
+		chunk, err := chunker.Next()
+		assert.NoError(t, err)
+		chunker.Feedback(chunk, 10*time.Second)
+	}
+	assert.Equal(t, uint64(200), chunker.chunkSize)
+
+	// Very fast chunks should clamp at the ceiling.
+	for range 100 {
+		chunk, err := chunker.Next()
+		assert.NoError(t, err)
+		chunker.Feedback(chunk, time.Millisecond)
+	}
+	assert.Equal(t, uint64(1200), chunker.chunkSize)
+}
+
+func TestOptimisticNewestFirst(t *testing.T) {
+	t1 := &TableInfo{
+		minValue:          newDatum(1, signedType),
+		maxValue:          newDatum(100000, signedType),
+		EstimatedRows:     100000,
+		SchemaName:        "test",
+		TableName:         "t1",
+		QuotedName:        "`test`.`t1`",
+		KeyColumns:        []string{"id"},
+		keyColumnsMySQLTp: []string{"int"},
+		keyDatums:         []datumTp{signedType},
+		KeyIsAutoInc:      true,
+		Columns:           []string{"id", "name"},
+	}
+	t1.statisticsLastUpdated = time.Now()
+	chunker := &chunkerOptimistic{
+		Ti:                     t1,
+		ChunkerTarget:          ChunkerDefaultTarget,
+		lowerBoundWatermarkMap: make(map[string]*Chunk),
+		logger:                 logrus.New(),
+	}
+	chunker.setDynamicChunking(false)
+	assert.NoError(t, chunker.SetNewestFirst(5000))
+	assert.NoError(t, chunker.Open())
+	assert.Error(t, chunker.SetNewestFirst(5000)) // can't set after open.
+	assert.True(t, chunker.KeyAboveHighWatermark(1))
+	assert.True(t, chunker.KeyAboveHighWatermark(99000))
+
+	// The newest keys are copied first.
+	var chunks []*Chunk
+	chunk, err := chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= 95000 AND `id` < 96000", chunk.String())
+	chunks = append(chunks, chunk)
+
+	// Changes to older rows are discarded, since they
+	// are copied by the backfill.
+	assert.True(t, chunker.KeyAboveHighWatermark(1))
+	assert.True(t, chunker.KeyAboveHighWatermark(94999))
+	assert.False(t, chunker.KeyAboveHighWatermark(95000))
+	assert.False(t, chunker.KeyAboveHighWatermark(95999))
+	assert.True(t, chunker.KeyAboveHighWatermark(96000))
+
+	for range 5 {
+		chunk, err = chunker.Next()
+		assert.NoError(t, err)
+		chunks = append(chunks, chunk)
+	}
+	assert.Equal(t, "`id` >= 100000", chunk.String()) // final chunk of the newest keys
+	assert.False(t, chunker.IsRead())
+
+	// The newest keys have been copied, so the only changes
+	// that are discarded are to rows not yet backfilled.
+	assert.True(t, chunker.KeyAboveHighWatermark(1))
+	assert.False(t, chunker.KeyAboveHighWatermark(95000))
+	assert.False(t, chunker.KeyAboveHighWatermark(200000))
+
+	// The backfill starts from the beginning of the table.
+	chunk, err = chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` < 1", chunk.String())
+	chunks = append(chunks, chunk)
+	chunk, err = chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= 1 AND `id` < 1001", chunk.String())
+	chunks = append(chunks, chunk)
+	assert.False(t, chunker.KeyAboveHighWatermark(500))
+	assert.True(t, chunker.KeyAboveHighWatermark(1001))
+	assert.True(t, chunker.KeyAboveHighWatermark(94999))
+	assert.False(t, chunker.KeyAboveHighWatermark(95000))
+
+	for !chunker.IsRead() {
+		chunk, err = chunker.Next()
+		assert.NoError(t, err)
+		chunks = append(chunks, chunk)
+	}
+	// The backfill ends where the newest keys started.
+	assert.Equal(t, "`id` >= 94001 AND `id` < 95000", chunk.String())
+	_, err = chunker.Next()
+	assert.ErrorIs(t, err, ErrTableIsRead)
+	assert.False(t, chunker.KeyAboveHighWatermark(1001))
+
+	// The chunks cover the whole table, so the low watermark
+	// advances through the newest keys once the backfill is complete.
+	for _, chunk := range chunks {
+		chunker.Feedback(chunk, time.Second)
+	}
+	watermark, err := chunker.GetLowWatermark()
+	assert.NoError(t, err)
+	assert.JSONEq(t, "{\"Key\":[\"id\"],\"ChunkSize\":1000,\"LowerBound\":{\"Value\": [\"99000\"],\"Inclusive\":true},\"UpperBound\":{\"Value\": [\"100000\"],\"Inclusive\":false}}", watermark)
+}
+
+func TestOptimisticNewestFirstSmallTable(t *testing.T) {
+	t1 := &TableInfo{
+		minValue:          newDatum(1, signedType),
+		maxValue:          newDatum(100, signedType),
+		EstimatedRows:     100,
+		SchemaName:        "test",
+		TableName:         "t1",
+		QuotedName:        "`test`.`t1`",
+		KeyColumns:        []string{"id"},
+		keyColumnsMySQLTp: []string{"int"},
+		keyDatums:         []datumTp{signedType},
+		KeyIsAutoInc:      true,
+		Columns:           []string{"id", "name"},
+	}
+	t1.statisticsLastUpdated = time.Now()
+	chunker := &chunkerOptimistic{
+		Ti:                     t1,
+		ChunkerTarget:          ChunkerDefaultTarget,
+		lowerBoundWatermarkMap: make(map[string]*Chunk),
+		logger:                 logrus.New(),
+	}
+	// The key range is smaller than the newest keys,
+	// so the table is copied in ascending order.
+	assert.NoError(t, chunker.SetNewestFirst(5000))
+	assert.NoError(t, chunker.Open())
+	chunk, err := chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` < 1", chunk.String())
+}
+
+func TestOptimisticKeyAboveHighWatermarkBoundary(t *testing.T) {
+	t1 := &TableInfo{
+		minValue:          newDatum(1, signedType),
+		maxValue:          newDatum(5000, signedType),
+		EstimatedRows:     5000,
+		SchemaName:        "test",
+		TableName:         "t1",
+		QuotedName:        "`test`.`t1`",
+		KeyColumns:        []string{"id"},
+		keyColumnsMySQLTp: []string{"int"},
+		keyDatums:         []datumTp{signedType},
+		KeyIsAutoInc:      true,
+		Columns:           []string{"id", "name"},
+		columnsMySQLTps:   map[string]string{"id": "int"},
+	}
+	t1.statisticsLastUpdated = time.Now()
+	chunker := &chunkerOptimistic{
+		Ti:                     t1,
+		ChunkerTarget:          ChunkerDefaultTarget,
+		lowerBoundWatermarkMap: make(map[string]*Chunk),
+		logger:                 logrus.New(),
+	}
+	chunker.setDynamicChunking(false)
+	assert.NoError(t, chunker.Open())
+
+	// After each chunk is returned, the key at its exclusive upper bound is
+	// above the watermark, since it is copied by the next chunk. Every key
+	// of the chunk, including its inclusive lower bound, is below.
+	for {
+		chunk, err := chunker.Next()
+		if err == ErrTableIsRead {
+			break
+		}
+		assert.NoError(t, err)
+		if chunk.LowerBound != nil {
+			assert.False(t, chunker.KeyAboveHighWatermark(chunk.LowerBound.Value[0].Val), chunk.String())
+		}
+		if chunk.UpperBound == nil {
+			continue // the final chunk
+		}
+		upper := chunk.UpperBound.Value[0].Val.(int64)
+		assert.False(t, chunker.KeyAboveHighWatermark(upper-1), chunk.String())
+		assert.True(t, chunker.KeyAboveHighWatermark(upper), chunk.String())
+		assert.True(t, chunker.KeyAboveHighWatermark(int32(upper)), chunk.String()) // as read from the binary log
+	}
+	assert.False(t, chunker.KeyAboveHighWatermark(int64(math.MaxInt64)))
+
+	// When resuming, the high pointer is the largest key in the new table,
+	// so it is not above the watermark, but the key after it is.
+	watermark := `{"Key":["id"],"ChunkSize":1000,"LowerBound":{"Value": ["1001"],"Inclusive":true},"UpperBound":{"Value": ["2001"],"Inclusive":false}}`
+	chunker = &chunkerOptimistic{
+		Ti:                     t1,
+		ChunkerTarget:          ChunkerDefaultTarget,
+		lowerBoundWatermarkMap: make(map[string]*Chunk),
+		logger:                 logrus.New(),
+	}
+	assert.NoError(t, chunker.OpenAtWatermark(watermark, newDatum(2500, signedType)))
+	assert.False(t, chunker.KeyAboveHighWatermark(1000))
+	assert.False(t, chunker.KeyAboveHighWatermark(2500))
+	assert.True(t, chunker.KeyAboveHighWatermark(2501))
+}
@@ -172,6 +172,24 @@ func (d Datum) String() string {
 	return fmt.Sprintf("%v", d.Val)
 }
 
+// sqlValue renders the datum as a literal that can be used in a query,
+// according to its type. Unlike String, binary values are rendered in
+// hex, so they are compared byte for byte regardless of the connection
+// character set, and other values are single quoted, which is also
+// correct when the sql_mode includes ANSI_QUOTES.
+func (d Datum) sqlValue() string {
+	if d.IsNil() {
+		return "NULL"
+	}
+	switch d.Tp { //nolint: exhaustive
+	case signedType, unsignedType:
+		return fmt.Sprintf("%v", d.Val)
+	case binaryType:
+		return fmt.Sprintf("X'%x'", d.Val)
+	}
+	return "'" + sqlescape.EscapeString(fmt.Sprint(d.Val)) + "'"
+}
+
 // IsNumeric checks if it's signed or unsigned
 func (d Datum) IsNumeric() bool {
 	return d.Tp == signedType || d.Tp == unsignedType
@@ -0,0 +1,76 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyRange bounds the rows that are copied to those where the first column
+// of the PRIMARY KEY is at least Start and less than End. A nil Datum
+// leaves that side of the range unbounded.
+type KeyRange struct {
+	Column string
+	Start  Datum
+	End    Datum
+}
+
+// NewKeyRange returns the range from start (inclusive) to end (exclusive) of
+// the first PRIMARY KEY column of t. An empty string leaves that side of the
+// range unbounded. The column must be an integer type, and start must be
+// less than end.
+func NewKeyRange(t *TableInfo, start, end string) (*KeyRange, error) {
+	if len(t.KeyColumns) == 0 || len(t.keyDatums) == 0 {
+		return nil, errors.New("a key range requires the table's PRIMARY KEY to be known")
+	}
+	tp := t.keyDatums[0]
+	if tp != signedType && tp != unsignedType {
+		return nil, fmt.Errorf("a key range is only supported when the first PRIMARY KEY column is an integer, column %q is %s",
+			t.KeyColumns[0], t.keyColumnsMySQLTp[0])
+	}
+	r := &KeyRange{
+		Column: t.KeyColumns[0],
+		Start:  NewNilDatum(tp),
+		End:    NewNilDatum(tp),
+	}
+	if start != "" {
+		val, err := datumValFromString(start, tp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start key %q for column %q: %w", start, r.Column, err)
+		}
+		r.Start = newDatum(val, tp)
+	}
+	if end != "" {
+		val, err := datumValFromString(end, tp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end key %q for column %q: %w", end, r.Column, err)
+		}
+		r.End = newDatum(val, tp)
+	}
+	if !r.Start.IsNil() && !r.End.IsNil() && r.Start.GreaterThanOrEqual(r.End) {
+		return nil, fmt.Errorf("start key %s must be less than end key %s", r.Start, r.End)
+	}
+	return r, nil
+}
+
+// Contains returns true if key, the value of the first PRIMARY KEY
+// column of a row, is within the range.
+func (r *KeyRange) Contains(key interface{}) bool {
+	keyDatum := newDatum(key, r.Start.Tp)
+	if !r.Start.IsNil() && !keyDatum.GreaterThanOrEqual(r.Start) {
+		return false
+	}
+	return r.End.IsNil() || !keyDatum.GreaterThanOrEqual(r.End)
+}
+
+// condition returns the range as a WHERE condition.
+func (r *KeyRange) condition() string {
+	var conds []string
+	if !r.Start.IsNil() {
+		conds = append(conds, expandRowConstructorComparison([]string{r.Column}, OpGreaterEqual, []Datum{r.Start}))
+	}
+	if !r.End.IsNil() {
+		conds = append(conds, expandRowConstructorComparison([]string{r.Column}, OpLessThan, []Datum{r.End}))
+	}
+	return strings.Join(conds, " AND ")
+}
@@ -0,0 +1,109 @@
+package table
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKeyRange(t *testing.T) {
+	t1 := &TableInfo{
+		KeyColumns:        []string{"id"},
+		keyColumnsMySQLTp: []string{"int"},
+		keyDatums:         []datumTp{signedType},
+	}
+	r, err := NewKeyRange(t1, "1000", "2000")
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= 1000 AND `id` < 2000", r.condition())
+	assert.False(t, r.Contains(999))
+	assert.True(t, r.Contains(1000))
+	assert.True(t, r.Contains(int32(1999)))
+	assert.False(t, r.Contains(2000))
+
+	// Either side can be unbounded.
+	r, err = NewKeyRange(t1, "", "2000")
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` < 2000", r.condition())
+	assert.True(t, r.Contains(-5))
+	r, err = NewKeyRange(t1, "1000", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= 1000", r.condition())
+	assert.True(t, r.Contains(int64(1)<<40))
+
+	// The bounds must be ordered, and valid for the type of the key.
+	_, err = NewKeyRange(t1, "2000", "2000")
+	assert.EqualError(t, err, "start key 2000 must be less than end key 2000")
+	_, err = NewKeyRange(t1, "2000", "1000")
+	assert.EqualError(t, err, "start key 2000 must be less than end key 1000")
+	_, err = NewKeyRange(t1, "abc", "")
+	assert.ErrorContains(t, err, `invalid start key "abc" for column "id"`)
+	t1.keyColumnsMySQLTp[0] = "int unsigned"
+	t1.keyDatums[0] = unsignedType
+	_, err = NewKeyRange(t1, "", "-1")
+	assert.ErrorContains(t, err, `invalid end key "-1" for column "id"`)
+	t1.keyColumnsMySQLTp[0] = "varbinary"
+	t1.keyDatums[0] = binaryType
+	_, err = NewKeyRange(t1, "a", "b")
+	assert.EqualError(t, err, `a key range is only supported when the first PRIMARY KEY column is an integer, column "id" is varbinary`)
+}
+
+func TestOptimisticChunkerKeyRange(t *testing.T) {
+	t1 := &TableInfo{
+		minValue:          newDatum(1, signedType),
+		maxValue:          newDatum(10000, signedType),
+		EstimatedRows:     10000,
+		SchemaName:        "test",
+		TableName:         "t1",
+		QuotedName:        "`test`.`t1`",
+		KeyColumns:        []string{"id"},
+		keyColumnsMySQLTp: []string{"int"},
+		keyDatums:         []datumTp{signedType},
+		KeyIsAutoInc:      true,
+		Columns:           []string{"id", "name"},
+	}
+	t1.statisticsLastUpdated = time.Now()
+	chunker := &chunkerOptimistic{
+		Ti:                     t1,
+		ChunkerTarget:          ChunkerDefaultTarget,
+		lowerBoundWatermarkMap: make(map[string]*Chunk),
+		logger:                 logrus.New(),
+	}
+	chunker.setDynamicChunking(false)
+	keyRange, err := NewKeyRange(t1, "1000", "2500")
+	assert.NoError(t, err)
+	assert.NoError(t, chunker.SetKeyRange(keyRange))
+	assert.EqualError(t, chunker.SetNewestFirst(100), "newest-first ordering can not be combined with a key range")
+	assert.NoError(t, chunker.Open())
+	assert.EqualError(t, chunker.SetKeyRange(keyRange), "cannot set key range after table is open")
+
+	// Keys outside of the range are never copied, so they are always above.
+	assert.True(t, chunker.KeyAboveHighWatermark(999))
+	assert.True(t, chunker.KeyAboveHighWatermark(2500))
+
+	chunk, err := chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= 1000 AND `id` < 1000", chunk.String())
+	chunker.Feedback(chunk, time.Second)
+	chunk, err = chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= 1000 AND `id` < 2000", chunk.String())
+	chunker.Feedback(chunk, time.Second)
+	assert.False(t, chunker.KeyAboveHighWatermark(1500))
+	assert.True(t, chunker.KeyAboveHighWatermark(2200))
+
+	// The chunk that reaches the end of the range is the final chunk.
+	chunk, err = chunker.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "`id` >= 2000 AND `id` < 2500", chunk.String())
+	chunker.Feedback(chunk, time.Second)
+	_, err = chunker.Next()
+	assert.ErrorIs(t, err, ErrTableIsRead)
+	assert.True(t, chunker.KeyAboveHighWatermark(1))
+	assert.False(t, chunker.KeyAboveHighWatermark(2200))
+
+	watermark, err := chunker.GetLowWatermark()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Key":["id"],"ChunkSize":1000,"LowerBound":{"Value": ["2000"],"Inclusive":true},"UpperBound":{"Value": ["2500"],"Inclusive":false}}`, watermark)
+}
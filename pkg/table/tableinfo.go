@@ -3,10 +3,14 @@
 package table
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -28,11 +32,15 @@ type TableInfo struct {
 	sync.Mutex
 	db                          *sql.DB
 	EstimatedRows               uint64
+	EstimatedBytes              uint64 // the data length of the table
+	AvgRowLength                uint64 // the average length of a row in bytes
 	SchemaName                  string
 	TableName                   string
 	QuotedName                  string
+	Partition                   string            // if set, the TableInfo is of this partition only
 	Columns                     []string          // all the column names
 	NonGeneratedColumns         []string          // all the non-generated column names
+	NullableColumns             []string          // the column names that allow NULL
 	Indexes                     []string          // all the index names
 	columnsMySQLTps             map[string]string // map from column name to MySQL type
 	KeyColumns                  []string          // the column names of the primaryKey
@@ -44,6 +52,11 @@ type TableInfo struct {
 	statisticsLastUpdated       time.Time
 	statisticsLock              sync.Mutex
 	DisableAutoUpdateStatistics atomic.Bool
+	// ReadOnly estimates the rows without running ANALYZE TABLE first,
+	// which is written to the binary log, so that no writes are issued
+	// when db may be connected to a read-only node. The estimate is then
+	// from the statistics that InnoDB last updated automatically.
+	ReadOnly bool
 }
 
 func NewTableInfo(db *sql.DB, schema, table string) *TableInfo {
@@ -60,12 +73,13 @@ func NewTableInfo(db *sql.DB, schema, table string) *TableInfo {
 // position of primary key columns (there might be more than one).
 // For minimal row image, you need to send the before image to extract the PK.
 // This is because in the after image, the PK might be nil.
+// Only a key column that allows NULL (i.e. of a unique key) may be nil.
 func (t *TableInfo) PrimaryKeyValues(row interface{}) ([]interface{}, error) {
 	var pkCols []interface{}
 	for _, pCol := range t.KeyColumns {
 		for i, col := range t.Columns {
 			if col == pCol {
-				if row.([]interface{})[i] == nil {
+				if row.([]interface{})[i] == nil && !slices.Contains(t.NullableColumns, col) {
 					return nil, errors.New("primary key column is NULL, possibly a bug sending after-image instead of before")
 				}
 				pkCols = append(pkCols, row.([]interface{})[i])
@@ -97,11 +111,20 @@ func (t *TableInfo) SetInfo(ctx context.Context) error {
 // setRowEstimate is a separate function so it can be repeated continuously
 // Since if a schema migration takes 14 days, it could change.
 func (t *TableInfo) setRowEstimate(ctx context.Context) error {
-	_, err := t.db.ExecContext(ctx, "ANALYZE TABLE "+t.QuotedName)
-	if err != nil {
+	var err error
+	if !t.ReadOnly {
+		if _, err = t.db.ExecContext(ctx, "ANALYZE TABLE "+t.QuotedName); err != nil {
+			return err
+		}
+	}
+	if t.Partition != "" {
+		err = t.db.QueryRowContext(ctx, "SELECT IFNULL(table_rows,0), IFNULL(data_length,0), IFNULL(avg_row_length,0) FROM information_schema.partitions WHERE table_schema=? AND table_name=? AND partition_name=?", t.SchemaName, t.TableName, t.Partition).Scan(&t.EstimatedRows, &t.EstimatedBytes, &t.AvgRowLength)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("partition %s of table %s.%s does not exist", t.Partition, t.SchemaName, t.TableName)
+		}
 		return err
 	}
-	err = t.db.QueryRowContext(ctx, "SELECT IFNULL(table_rows,0) FROM information_schema.tables WHERE table_schema=? AND table_name=?", t.SchemaName, t.TableName).Scan(&t.EstimatedRows)
+	err = t.db.QueryRowContext(ctx, "SELECT IFNULL(table_rows,0), IFNULL(data_length,0), IFNULL(avg_row_length,0) FROM information_schema.tables WHERE table_schema=? AND table_name=?", t.SchemaName, t.TableName).Scan(&t.EstimatedRows, &t.EstimatedBytes, &t.AvgRowLength)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return fmt.Errorf("table %s.%s does not exist", t.SchemaName, t.TableName)
@@ -135,7 +158,7 @@ func (t *TableInfo) setIndexes(ctx context.Context) error {
 }
 
 func (t *TableInfo) setColumns(ctx context.Context) error {
-	rows, err := t.db.QueryContext(ctx, "SELECT column_name, column_type, GENERATION_EXPRESSION FROM information_schema.columns WHERE table_schema=? AND table_name=? ORDER BY ORDINAL_POSITION",
+	rows, err := t.db.QueryContext(ctx, "SELECT column_name, column_type, GENERATION_EXPRESSION, is_nullable FROM information_schema.columns WHERE table_schema=? AND table_name=? ORDER BY ORDINAL_POSITION",
 		t.SchemaName,
 		t.TableName,
 	)
@@ -145,13 +168,17 @@ func (t *TableInfo) setColumns(ctx context.Context) error {
 	defer rows.Close()
 	t.Columns = []string{}
 	t.NonGeneratedColumns = []string{}
+	t.NullableColumns = []string{}
 	t.columnsMySQLTps = make(map[string]string)
 	for rows.Next() {
-		var col, tp, expression string
-		if err := rows.Scan(&col, &tp, &expression); err != nil {
+		var col, tp, expression, nullable string
+		if err := rows.Scan(&col, &tp, &expression, &nullable); err != nil {
 			return err
 		}
 		t.Columns = append(t.Columns, col)
+		if nullable == "YES" {
+			t.NullableColumns = append(t.NullableColumns, col)
+		}
 		t.columnsMySQLTps[col] = tp
 		if expression == "" {
 			t.NonGeneratedColumns = append(t.NonGeneratedColumns, col)
@@ -248,6 +275,40 @@ func (t *TableInfo) PrimaryKeyIsMemoryComparable() error {
 	return nil
 }
 
+// CompareKeyValues compares two primary keys, where each value is the
+// string representation of the column. Numeric columns are compared numerically
+// and all other columns are compared bytewise. It returns -1, 0 or +1.
+func (t *TableInfo) CompareKeyValues(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		var tp datumTp
+		if i < len(t.keyDatums) {
+			tp = t.keyDatums[i]
+		}
+		if cmp := compareValues(a[i], b[i], tp); cmp != 0 {
+			return cmp
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func compareValues(a, b string, tp datumTp) int {
+	switch tp { //nolint: exhaustive
+	case signedType:
+		ai, errA := strconv.ParseInt(a, 10, 64)
+		bi, errB := strconv.ParseInt(b, 10, 64)
+		if errA == nil && errB == nil {
+			return cmp.Compare(ai, bi)
+		}
+	case unsignedType:
+		au, errA := strconv.ParseUint(a, 10, 64)
+		bu, errB := strconv.ParseUint(b, 10, 64)
+		if errA == nil && errB == nil {
+			return cmp.Compare(au, bu)
+		}
+	}
+	return strings.Compare(a, b)
+}
+
 // setMinMax is a separate function so it can be repeated continuously
 // Since if a schema migration takes 14 days, it could change.
 // It only really applies to KeyColumns[0], since across composite keys
@@ -256,7 +317,7 @@ func (t *TableInfo) setMinMax(ctx context.Context) error {
 	if t.keyDatums[0] == binaryType {
 		return nil // we don't min/max binary types for now.
 	}
-	query := fmt.Sprintf("SELECT IFNULL(min(%s),'0'), IFNULL(max(%s),'0') FROM %s", t.KeyColumns[0], t.KeyColumns[0], t.QuotedName)
+	query := fmt.Sprintf("SELECT IFNULL(min(%s),'0'), IFNULL(max(%s),'0') FROM %s", t.KeyColumns[0], t.KeyColumns[0], t.FromName())
 	var minimum, maximum string
 	err := t.db.QueryRowContext(ctx, query).Scan(&minimum, &maximum)
 	if err != nil {
@@ -323,6 +384,113 @@ func (t *TableInfo) updateTableStatistics(ctx context.Context) error {
 	return nil
 }
 
+// Partitions returns the names of the partitions of the table, in
+// partition order. It returns nil if the table is not partitioned.
+func (t *TableInfo) Partitions(ctx context.Context) ([]string, error) {
+	rows, err := t.db.QueryContext(ctx, `SELECT partition_name FROM information_schema.partitions
+		WHERE table_schema=? AND table_name=? AND partition_name IS NOT NULL
+		GROUP BY partition_name, partition_ordinal_position ORDER BY partition_ordinal_position`, t.SchemaName, t.TableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
+
+// PartitionInfo returns the TableInfo of a single partition of the table.
+// Its statistics, including the minimum and maximum value of the key, are
+// of the partition only, so a chunker only iterates the partition's keys.
+func (t *TableInfo) PartitionInfo(ctx context.Context, partition string) (*TableInfo, error) {
+	partitions, err := t.Partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(partitions, partition) {
+		return nil, fmt.Errorf("partition %q does not exist in table %s", partition, t.QuotedName)
+	}
+	ti := NewTableInfo(t.db, t.SchemaName, t.TableName)
+	ti.Partition = partition
+	if err := ti.SetInfo(ctx); err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
+
+// FromName returns the quoted name of the table for a FROM clause.
+// If the TableInfo is of a partition, it selects only that partition.
+func (t *TableInfo) FromName() string {
+	if t.Partition == "" {
+		return t.QuotedName
+	}
+	return fmt.Sprintf("%s PARTITION (`%s`)", t.QuotedName, t.Partition)
+}
+
+// SplitChunk splits chunk into two chunks with about half of its rows
+// each, by finding the key of the row in the middle of the chunk. It
+// returns nil if the chunk has fewer than two rows, and can not be split.
+func (t *TableInfo) SplitChunk(ctx context.Context, chunk *Chunk) ([]*Chunk, error) {
+	var count uint64
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", t.FromName(), chunk.String())
+	if err := t.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count < 2 {
+		return nil, nil
+	}
+	query = fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT 1 OFFSET %d",
+		QuoteColumns(chunk.Key),
+		t.FromName(),
+		chunk.String(),
+		QuoteColumns(chunk.Key),
+		count/2,
+	)
+	values := make([]sql.NullString, len(chunk.Key))
+	pointers := make([]interface{}, len(chunk.Key))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := t.db.QueryRowContext(ctx, query).Scan(pointers...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // the rows changed since they were counted.
+		}
+		return nil, err
+	}
+	middle := make([]Datum, len(chunk.Key))
+	for i, col := range chunk.Key {
+		if !values[i].Valid {
+			return nil, nil // a NULL can not be used as a boundary.
+		}
+		middle[i] = newDatum(values[i].String, t.datumTp(col))
+	}
+	// The chunk size is used for estimates, so it is divided
+	// in the same proportion as the rows.
+	lowerSize := chunk.ChunkSize / 2
+	return []*Chunk{
+		{
+			Key:                  chunk.Key,
+			ChunkSize:            lowerSize,
+			LowerBound:           chunk.LowerBound,
+			UpperBound:           &Boundary{Value: middle, Inclusive: false},
+			AdditionalConditions: chunk.AdditionalConditions,
+		},
+		{
+			Key:                  chunk.Key,
+			ChunkSize:            chunk.ChunkSize - lowerSize,
+			LowerBound:           &Boundary{Value: middle, Inclusive: true},
+			UpperBound:           chunk.UpperBound,
+			AdditionalConditions: chunk.AdditionalConditions,
+		},
+	}, nil
+}
+
 // MaxValue as a datum
 func (t *TableInfo) MaxValue() Datum {
 	t.statisticsLock.Lock()
@@ -330,11 +498,18 @@ func (t *TableInfo) MaxValue() Datum {
 	return t.maxValue
 }
 
+// WrapCastType returns col converted to a type that compares equal when the
+// values are semantically equal, for use in a checksum. JSON values are cast
+// to JSON, which normalizes their formatting. Spatial values can not be cast,
+// so they are compared as their SRID followed by their well-known binary.
 func (t *TableInfo) WrapCastType(col string) string {
 	tp, ok := t.columnsMySQLTps[col] // the tp keeps the width in this context.
 	if !ok {
 		panic("column not found")
 	}
+	if isSpatialTp(tp) {
+		return fmt.Sprintf("CONCAT(ST_SRID(`%s`), ST_AsBinary(`%s`))", col, col)
+	}
 	return fmt.Sprintf("CAST(`%s` AS %s)", col, castableTp(tp))
 }
 
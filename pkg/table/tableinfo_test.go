@@ -3,12 +3,13 @@ package table
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/cashapp/spirit/pkg/testutils"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -98,6 +99,37 @@ func TestDiscovery(t *testing.T) {
 	assert.Len(t, t1.Columns, 2)
 }
 
+func TestDiscoveryReadOnly(t *testing.T) {
+	testutils.RunSQL(t, `DROP TABLE IF EXISTS readonlyt1`)
+	testutils.RunSQL(t, `CREATE TABLE readonlyt1 (id int NOT NULL AUTO_INCREMENT PRIMARY KEY, name varchar(255) NOT NULL)`)
+	testutils.RunSQL(t, `INSERT INTO readonlyt1 VALUES (1, 'a'), (2, 'b'), (3, 'c')`)
+	cfg, err := mysql.ParseDSN(testutils.DSN())
+	assert.NoError(t, err)
+	rootDB, err := sql.Open("mysql", fmt.Sprintf("root:%s@tcp(%s)/%s", cfg.Passwd, cfg.Addr, cfg.DBName))
+	assert.NoError(t, err)
+	defer rootDB.Close()
+	_, err = rootDB.Exec("DROP USER IF EXISTS readonlyuser")
+	assert.NoError(t, err)
+	_, err = rootDB.Exec("CREATE USER readonlyuser")
+	assert.NoError(t, err)
+	_, err = rootDB.Exec("GRANT SELECT ON test.* TO readonlyuser")
+	assert.NoError(t, err)
+
+	// A user that can only read can not run ANALYZE TABLE,
+	// which needs INSERT, so it can only discover the table
+	// in read-only mode.
+	db, err := sql.Open("mysql", fmt.Sprintf("readonlyuser:@tcp(%s)/%s", cfg.Addr, cfg.DBName))
+	assert.NoError(t, err)
+	defer db.Close()
+	t1 := NewTableInfo(db, "test", "readonlyt1")
+	assert.Error(t, t1.SetInfo(context.TODO()))
+	t1 = NewTableInfo(db, "test", "readonlyt1")
+	t1.ReadOnly = true
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+	assert.Equal(t, []string{"id"}, t1.KeyColumns)
+	assert.Equal(t, "3", t1.maxValue.String())
+}
+
 func TestDiscoveryUInt(t *testing.T) {
 	testutils.RunSQL(t, `DROP TABLE IF EXISTS discoveryuintt1`)
 	table := `CREATE TABLE discoveryuintt1 (
@@ -299,6 +331,22 @@ func TestKeyColumnsValuesExtraction(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPrimaryKeyValuesNull(t *testing.T) {
+	t1 := NewTableInfo(nil, "test", "nullkeyt1")
+	t1.Columns = []string{"a", "b", "c"}
+	t1.KeyColumns = []string{"a", "b"}
+
+	// Primary key columns can not be NULL.
+	_, err := t1.PrimaryKeyValues([]interface{}{1, nil, "x"})
+	assert.Error(t, err)
+
+	// But columns of a unique key can.
+	t1.NullableColumns = []string{"b", "c"}
+	pkVals, err := t1.PrimaryKeyValues([]interface{}{1, nil, "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1, nil}, pkVals)
+}
+
 func TestDiscoveryGeneratedCols(t *testing.T) {
 	testutils.RunSQL(t, `DROP TABLE IF EXISTS generatedcolst1`)
 	table := `CREATE TABLE generatedcolst1 (
@@ -324,3 +372,57 @@ func TestDiscoveryGeneratedCols(t *testing.T) {
 	assert.Equal(t, []string{"id", "name", "b", "c1", "c2", "c3", "d"}, t1.Columns)
 	assert.Equal(t, []string{"id", "name", "b", "d"}, t1.NonGeneratedColumns)
 }
+
+func TestCompareKeyValues(t *testing.T) {
+	t1 := NewTableInfo(nil, "test", "t1")
+	t1.KeyColumns = []string{"id", "name", "age"}
+	t1.keyDatums = []datumTp{signedType, binaryType, unsignedType}
+
+	assert.Equal(t, -1, t1.CompareKeyValues([]string{"9", "a", "1"}, []string{"10", "a", "1"}))
+	assert.Equal(t, 1, t1.CompareKeyValues([]string{"-1", "a", "1"}, []string{"-10", "a", "1"}))
+	assert.Equal(t, -1, t1.CompareKeyValues([]string{"1", "B", "1"}, []string{"1", "a", "1"}))
+	assert.Equal(t, -1, t1.CompareKeyValues([]string{"1", "a", "2"}, []string{"1", "a", "18446744073709551615"}))
+	assert.Equal(t, 0, t1.CompareKeyValues([]string{"1", "a", "1"}, []string{"1", "a", "1"}))
+}
+
+func TestFromName(t *testing.T) {
+	t1 := NewTableInfo(nil, "test", "fromnamet1")
+	assert.Equal(t, "`test`.`fromnamet1`", t1.FromName())
+	t1.Partition = "p0"
+	assert.Equal(t, "`test`.`fromnamet1` PARTITION (`p0`)", t1.FromName())
+}
+
+func TestSplitChunk(t *testing.T) {
+	testutils.RunSQL(t, "DROP TABLE IF EXISTS splitt1")
+	testutils.RunSQL(t, "CREATE TABLE splitt1 (a INT NOT NULL, b VARCHAR(10) NOT NULL, PRIMARY KEY (a, b))")
+	testutils.RunSQL(t, "INSERT INTO splitt1 VALUES (1, 'a'), (1, 'b'), (2, 'a'), (2, 'b'), (3, 'a'), (4, 'a')")
+
+	db, err := sql.Open("mysql", testutils.DSN())
+	assert.NoError(t, err)
+	defer db.Close()
+	t1 := NewTableInfo(db, "test", "splitt1")
+	assert.NoError(t, t1.SetInfo(context.TODO()))
+
+	chunk := &Chunk{
+		Key:        []string{"a", "b"},
+		ChunkSize:  5,
+		LowerBound: &Boundary{Value: []Datum{newDatum(1, signedType), newDatum("b", unknownType)}, Inclusive: true},
+		UpperBound: &Boundary{Value: []Datum{newDatum(4, signedType), newDatum("a", unknownType)}, Inclusive: false},
+	}
+	halves, err := t1.SplitChunk(context.TODO(), chunk)
+	assert.NoError(t, err)
+	assert.Len(t, halves, 2)
+	// The 4 rows in the chunk are split at the third row.
+	assert.Equal(t, "((`a` > 1)\n OR (`a` = 1 AND `b` >= 'b')) AND ((`a` < 2)\n OR (`a` = 2 AND `b` < 'b'))", halves[0].String())
+	assert.Equal(t, "((`a` > 2)\n OR (`a` = 2 AND `b` >= 'b')) AND ((`a` < 4)\n OR (`a` = 4 AND `b` < 'a'))", halves[1].String())
+	assert.Equal(t, uint64(2), halves[0].ChunkSize)
+	assert.Equal(t, uint64(3), halves[1].ChunkSize)
+
+	// A chunk with a single row can not be split.
+	halves, err = t1.SplitChunk(context.TODO(), &Chunk{
+		Key:        []string{"a", "b"},
+		LowerBound: &Boundary{Value: []Datum{newDatum(4, signedType), newDatum("a", unknownType)}, Inclusive: true},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, halves)
+}
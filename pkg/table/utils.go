@@ -52,6 +52,16 @@ func castableTp(tp string) string {
 	}
 }
 
+// isSpatialTp returns true if tp is a spatial data type.
+func isSpatialTp(tp string) bool {
+	switch removeWidth(tp) {
+	case "geometry", "point", "linestring", "polygon", "multipoint",
+		"multilinestring", "multipolygon", "geometrycollection", "geomcollection":
+		return true
+	}
+	return false
+}
+
 func removeWidth(s string) string {
 	regex := regexp.MustCompile(`\([0-9]+\)`)
 	s = regex.ReplaceAllString(s, "")
@@ -95,7 +105,7 @@ func expandRowConstructorComparison(cols []string, operator Operator, vals []Dat
 		panic("cols should be same size as values")
 	}
 	if len(cols) == 1 {
-		return fmt.Sprintf("`%s` %s %s", cols[0], operator, vals[0].String())
+		return fmt.Sprintf("`%s` %s %s", cols[0], operator, vals[0].sqlValue())
 	}
 	// Unless we are in the "final" position
 	// we need to use a different intermediate operator
@@ -111,8 +121,8 @@ func expandRowConstructorComparison(cols []string, operator Operator, vals []Dat
 	buffer := []string{}
 	for i, col := range cols {
 		if i == 0 {
-			conds = append(conds, fmt.Sprintf("(`%s` %s %s)", col, intermediateOperator, vals[i].String()))
-			buffer = append(buffer, fmt.Sprintf("`%s` %s %s", col, "=", vals[i].String()))
+			conds = append(conds, fmt.Sprintf("(`%s` %s %s)", col, intermediateOperator, vals[i].sqlValue()))
+			buffer = append(buffer, fmt.Sprintf("`%s` %s %s", col, "=", vals[i].sqlValue()))
 			continue
 		}
 		// If we are in the final position we can
@@ -121,8 +131,8 @@ func expandRowConstructorComparison(cols []string, operator Operator, vals []Dat
 		if i == len(cols)-1 {
 			intermediateOperator = operator
 		}
-		conds = append(conds, fmt.Sprintf("(%s AND `%s` %s %s)", strings.Join(buffer, " AND "), col, intermediateOperator, vals[i].String()))
-		buffer = append(buffer, fmt.Sprintf("`%s` %s %s", col, "=", vals[i].String()))
+		conds = append(conds, fmt.Sprintf("(%s AND `%s` %s %s)", strings.Join(buffer, " AND "), col, intermediateOperator, vals[i].sqlValue()))
+		buffer = append(buffer, fmt.Sprintf("`%s` %s %s", col, "=", vals[i].sqlValue()))
 	}
 	return "(" + strings.Join(conds, "\n OR ") + ")"
 }
@@ -72,6 +72,22 @@ func TestCastableTp(t *testing.T) {
 	}
 }
 
+func TestWrapCastType(t *testing.T) {
+	t1 := NewTableInfo(nil, "test", "wrapcastt1")
+	t1.columnsMySQLTps = map[string]string{
+		"id":   "int",
+		"doc":  "json",
+		"geo":  "geometry",
+		"pt":   "point",
+		"coll": "geomcollection",
+	}
+	assert.Equal(t, "CAST(`id` AS signed)", t1.WrapCastType("id"))
+	assert.Equal(t, "CAST(`doc` AS json)", t1.WrapCastType("doc"))
+	assert.Equal(t, "CONCAT(ST_SRID(`geo`), ST_AsBinary(`geo`))", t1.WrapCastType("geo"))
+	assert.Equal(t, "CONCAT(ST_SRID(`pt`), ST_AsBinary(`pt`))", t1.WrapCastType("pt"))
+	assert.Equal(t, "CONCAT(ST_SRID(`coll`), ST_AsBinary(`coll`))", t1.WrapCastType("coll"))
+}
+
 func TestQuoteCols(t *testing.T) {
 	cols := []string{"a", "b", "c"}
 	assert.Equal(t, "`a`, `b`, `c`", QuoteColumns(cols))
@@ -91,7 +107,7 @@ func TestExpandRowConstructorComparison(t *testing.T) {
 			OpGreaterThan,
 			[]Datum{newDatum(1, signedType), newDatum(2, signedType)}))
 
-	assert.Equal(t, "((`a` > \"PENDING\")\n OR (`a` = \"PENDING\" AND `b` > 2))",
+	assert.Equal(t, "((`a` > X'50454e44494e47')\n OR (`a` = X'50454e44494e47' AND `b` > 2))",
 		expandRowConstructorComparison([]string{"a", "b"},
 			OpGreaterThan,
 			[]Datum{newDatum("PENDING", binaryType), newDatum(2, signedType)}))
@@ -0,0 +1,73 @@
+package throttler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/siddontang/loggers"
+)
+
+// Changeset throttles while the changes read from the binary log that are
+// not yet applied to the new table exceed a maximum depth. When changes are
+// read faster than the flushes apply them, copying rows at full speed only
+// adds to the load that slows down the flushes, and the changeset grows
+// without bound. Throttling the copy lets the flushes catch up.
+type Changeset struct {
+	depth    func() int
+	maxDepth int
+	logger   loggers.Advanced
+}
+
+var _ Throttler = &Changeset{}
+
+// NewChangesetThrottler returns a Throttler that engages while depth
+// returns more than maxDepth, i.e. the GetDeltaLen of the repl.Client.
+func NewChangesetThrottler(depth func() int, maxDepth int, logger loggers.Advanced) (*Changeset, error) {
+	if maxDepth <= 0 {
+		return nil, errors.New("the max changeset depth must be greater than zero")
+	}
+	return &Changeset{
+		depth:    depth,
+		maxDepth: maxDepth,
+		logger:   logger,
+	}, nil
+}
+
+// Open does nothing, since the depth is read when it is needed.
+func (c *Changeset) Open() error {
+	return nil
+}
+
+func (c *Changeset) Close() error {
+	return nil
+}
+
+// UpdateLag does nothing, since the depth is read when it is needed.
+func (c *Changeset) UpdateLag() error {
+	return nil
+}
+
+func (c *Changeset) IsThrottled() bool {
+	return c.depth() > c.maxDepth
+}
+
+func (c *Changeset) State() string {
+	if depth := c.depth(); depth > c.maxDepth {
+		return fmt.Sprintf("engaged: changeset depth %d (max %d)", depth, c.maxDepth)
+	}
+	return "clear"
+}
+
+// BlockWait blocks until the changeset depth is within the
+// maximum, or up to 60s to allow some progress to be made.
+func (c *Changeset) BlockWait() error {
+	for range 60 {
+		if c.depth() <= c.maxDepth {
+			return nil
+		}
+		time.Sleep(blockWaitInterval)
+	}
+	c.logger.Warnf("changeset depth monitor timed out. depth: %d max: %d", c.depth(), c.maxDepth)
+	return nil
+}
@@ -0,0 +1,51 @@
+package throttler
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangesetThrottler(t *testing.T) {
+	var depth atomic.Int64
+	getDepth := func() int { return int(depth.Load()) }
+	_, err := NewChangesetThrottler(getDepth, 0, logrus.New())
+	assert.ErrorContains(t, err, "must be greater than zero")
+
+	throttler, err := NewChangesetThrottler(getDepth, 1000, logrus.New())
+	assert.NoError(t, err)
+	assert.NoError(t, throttler.Open())
+	defer throttler.Close()
+
+	// Below and at the threshold it is not throttled.
+	depth.Store(1000)
+	assert.NoError(t, throttler.UpdateLag())
+	assert.False(t, throttler.IsThrottled())
+	assert.Equal(t, "clear", throttler.State())
+	assert.NoError(t, throttler.BlockWait())
+
+	// As the changeset grows above the threshold it is throttled.
+	depth.Store(5000)
+	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: changeset depth 5000 (max 1000)", throttler.State())
+
+	// BlockWait blocks until the changeset is flushed.
+	blockWaitInterval = 10 * time.Millisecond
+	defer func() { blockWaitInterval = time.Second }()
+	startTime := time.Now()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		depth.Store(10)
+	}()
+	assert.NoError(t, throttler.BlockWait())
+	assert.GreaterOrEqual(t, time.Since(startTime), 50*time.Millisecond)
+	assert.False(t, throttler.IsThrottled())
+
+	// If it is never flushed, BlockWait gives up to allow some progress.
+	depth.Store(5000)
+	assert.NoError(t, throttler.BlockWait())
+	assert.True(t, throttler.IsThrottled())
+}
@@ -0,0 +1,186 @@
+package throttler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/siddontang/loggers"
+)
+
+// historyListLengthRe matches the history list length in the
+// TRANSACTIONS section of SHOW ENGINE INNODB STATUS.
+var historyListLengthRe = regexp.MustCompile(`History list length (\d+)`)
+
+// HistoryLength throttles while the InnoDB history list length is above a
+// threshold. The history list holds the undo logs of committed transactions
+// that have not yet been purged. Purge can not remove undo logs that are
+// still visible to an open transaction, so long running transactions, such
+// as copying chunks in REPEATABLE READ, let it grow. A long history list
+// slows down reads for the whole server, so throttling the copy gives purge
+// a chance to catch up.
+type HistoryLength struct {
+	sync.Mutex
+	db            *sql.DB
+	maxLength     uint64
+	currentLength atomic.Uint64
+	errorPolicy   ErrorPolicy
+	lengthErr     error // the error from the last check, if it failed
+	logger        loggers.Advanced
+	isClosed      atomic.Bool
+	innodbStatus  func() (string, error)
+}
+
+var _ Throttler = &HistoryLength{}
+
+// NewHistoryLengthThrottler returns a Throttler that engages while the
+// history list length of db is greater than maxLength.
+// An empty errorPolicy is treated as ErrorPolicyContinue.
+func NewHistoryLengthThrottler(db *sql.DB, maxLength uint64, errorPolicy ErrorPolicy, logger loggers.Advanced) (*HistoryLength, error) {
+	switch errorPolicy {
+	case "":
+		errorPolicy = ErrorPolicyContinue
+	case ErrorPolicyContinue, ErrorPolicyFail, ErrorPolicyBlock:
+	default:
+		return nil, fmt.Errorf("unknown throttler error policy %q, must be one of: %s, %s, %s",
+			errorPolicy, ErrorPolicyContinue, ErrorPolicyFail, ErrorPolicyBlock)
+	}
+	if maxLength == 0 {
+		return nil, errors.New("the max history list length must be greater than zero")
+	}
+	h := &HistoryLength{
+		db:          db,
+		maxLength:   maxLength,
+		errorPolicy: errorPolicy,
+		logger:      logger,
+	}
+	h.innodbStatus = h.showInnodbStatus
+	return h, nil
+}
+
+// Open checks the history list length, and then checks
+// it again every 5 seconds until the throttler is closed.
+func (h *HistoryLength) Open() error {
+	if err := h.UpdateLag(); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(loopInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if h.isClosed.Load() {
+				return
+			}
+			if err := h.UpdateLag(); err != nil {
+				h.logger.Errorf("error getting history list length: %s", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+func (h *HistoryLength) Close() error {
+	h.isClosed.Store(true)
+	return nil
+}
+
+// UpdateLag reads the history list length from SHOW ENGINE INNODB STATUS.
+// It is named for the Throttler interface: the lag is that of purge.
+func (h *HistoryLength) UpdateLag() error {
+	status, err := h.innodbStatus()
+	if err != nil {
+		err = fmt.Errorf("could not check the history list length: %w", err)
+		h.setLength(0, err)
+		return err
+	}
+	length, err := parseHistoryListLength(status)
+	if err != nil {
+		h.setLength(0, err)
+		return err
+	}
+	h.setLength(length, nil)
+	if h.IsThrottled() {
+		h.logger.Warnf("history list length is high, throttling in progress. length: %d max: %d", length, h.maxLength)
+	}
+	return nil
+}
+
+// showInnodbStatus returns the output of SHOW ENGINE INNODB STATUS,
+// which requires the PROCESS privilege.
+func (h *HistoryLength) showInnodbStatus() (string, error) {
+	var tp, name, status string
+	if err := h.db.QueryRow("SHOW ENGINE INNODB STATUS").Scan(&tp, &name, &status); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// parseHistoryListLength returns the history list
+// length from the output of SHOW ENGINE INNODB STATUS.
+func parseHistoryListLength(status string) (uint64, error) {
+	matches := historyListLengthRe.FindStringSubmatch(status)
+	if matches == nil {
+		return 0, errors.New("could not find the history list length in the InnoDB status")
+	}
+	return strconv.ParseUint(matches[1], 10, 64)
+}
+
+// setLength records the result of a check.
+func (h *HistoryLength) setLength(length uint64, err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.lengthErr = err
+	if err == nil {
+		h.currentLength.Store(length)
+	}
+}
+
+func (h *HistoryLength) lengthError() error {
+	h.Lock()
+	defer h.Unlock()
+	return h.lengthErr
+}
+
+func (h *HistoryLength) IsThrottled() bool {
+	if h.lengthError() != nil {
+		return h.errorPolicy == ErrorPolicyFail || h.errorPolicy == ErrorPolicyBlock
+	}
+	return h.currentLength.Load() > h.maxLength
+}
+
+// State describes whether the throttler is engaged because of the history
+// list length, or because the history list length can not be checked.
+func (h *HistoryLength) State() string {
+	if err := h.lengthError(); err != nil {
+		if h.IsThrottled() {
+			return fmt.Sprintf("engaged: history list length unknown: %v", err)
+		}
+		return fmt.Sprintf("clear: history list length unknown: %v", err)
+	}
+	if h.IsThrottled() {
+		return fmt.Sprintf("engaged: history list length %d (max %d)", h.currentLength.Load(), h.maxLength)
+	}
+	return "clear"
+}
+
+// BlockWait blocks until the history list length is within the maximum,
+// or up to 60s to allow some progress to be made. If the history list
+// length can not be checked, it follows the error policy.
+func (h *HistoryLength) BlockWait() error {
+	timedOut, err := blockWait(h.errorPolicy, func() (bool, error) {
+		if err := h.lengthError(); err != nil {
+			return true, err
+		}
+		return h.currentLength.Load() > h.maxLength, nil
+	})
+	if !timedOut {
+		return err
+	}
+	h.logger.Warnf("history list length monitor timed out. length: %d max: %d", h.currentLength.Load(), h.maxLength)
+	return nil
+}
@@ -0,0 +1,88 @@
+package throttler
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// innodbStatus returns a SHOW ENGINE INNODB STATUS
+// output with the given history list length.
+func innodbStatus(length uint64) string {
+	return fmt.Sprintf(`------------
+TRANSACTIONS
+------------
+Trx id counter 1808
+Purge done for trx's n:o < 1805 undo n:o < 0 state: running but idle
+History list length %d
+LIST OF TRANSACTIONS FOR EACH SESSION:
+`, length)
+}
+
+func TestParseHistoryListLength(t *testing.T) {
+	length, err := parseHistoryListLength(innodbStatus(1234))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1234), length)
+	_, err = parseHistoryListLength("no transactions section")
+	assert.ErrorContains(t, err, "could not find the history list length")
+}
+
+func TestHistoryLengthThrottler(t *testing.T) {
+	_, err := NewHistoryLengthThrottler(nil, 1000, "ignore", logrus.New())
+	assert.ErrorContains(t, err, `unknown throttler error policy "ignore"`)
+	_, err = NewHistoryLengthThrottler(nil, 0, "", logrus.New())
+	assert.ErrorContains(t, err, "must be greater than zero")
+
+	throttler, err := NewHistoryLengthThrottler(nil, 1000, "", logrus.New())
+	assert.NoError(t, err)
+	var length uint64
+	throttler.innodbStatus = func() (string, error) {
+		return innodbStatus(length), nil
+	}
+
+	// Below and at the threshold it is not throttled.
+	length = 1000
+	assert.NoError(t, throttler.UpdateLag())
+	assert.False(t, throttler.IsThrottled())
+	assert.Equal(t, "clear", throttler.State())
+	assert.NoError(t, throttler.BlockWait())
+
+	// Above the threshold it is throttled.
+	length = 5000
+	assert.NoError(t, throttler.UpdateLag())
+	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: history list length 5000 (max 1000)", throttler.State())
+
+	// BlockWait blocks until purge has caught up.
+	blockWaitInterval = 10 * time.Millisecond
+	defer func() { blockWaitInterval = time.Second }()
+	startTime := time.Now()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		throttler.setLength(10, nil)
+	}()
+	assert.NoError(t, throttler.BlockWait())
+	assert.GreaterOrEqual(t, time.Since(startTime), 50*time.Millisecond)
+	assert.False(t, throttler.IsThrottled())
+}
+
+func TestHistoryLengthThrottlerErrorPolicy(t *testing.T) {
+	throttler, err := NewHistoryLengthThrottler(nil, 1000, ErrorPolicyContinue, logrus.New())
+	assert.NoError(t, err)
+	throttler.innodbStatus = func() (string, error) {
+		return "", errors.New("Access denied; you need the PROCESS privilege")
+	}
+	assert.ErrorContains(t, throttler.UpdateLag(), "could not check the history list length")
+	assert.False(t, throttler.IsThrottled())
+	assert.Contains(t, throttler.State(), "clear: history list length unknown")
+	assert.NoError(t, throttler.BlockWait())
+
+	throttler.errorPolicy = ErrorPolicyFail
+	assert.True(t, throttler.IsThrottled())
+	assert.Contains(t, throttler.State(), "engaged: history list length unknown")
+	assert.ErrorContains(t, throttler.BlockWait(), "PROCESS privilege")
+}
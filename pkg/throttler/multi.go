@@ -0,0 +1,78 @@
+package throttler
+
+import (
+	"errors"
+	"strings"
+)
+
+// Multi combines throttlers, so that the copy can be throttled for more than
+// one reason, i.e. replica lag and changeset depth. It is throttled while
+// any of its throttlers is.
+type Multi struct {
+	throttlers []Throttler
+}
+
+var _ Throttler = &Multi{}
+
+// NewMultiThrottler returns a Throttler that combines throttlers.
+func NewMultiThrottler(throttlers ...Throttler) *Multi {
+	return &Multi{throttlers: throttlers}
+}
+
+func (m *Multi) Open() error {
+	for _, t := range m.throttlers {
+		if err := t.Open(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Multi) Close() error {
+	var errs []error
+	for _, t := range m.throttlers {
+		errs = append(errs, t.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Multi) UpdateLag() error {
+	var errs []error
+	for _, t := range m.throttlers {
+		errs = append(errs, t.UpdateLag())
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Multi) IsThrottled() bool {
+	for _, t := range m.throttlers {
+		if t.IsThrottled() {
+			return true
+		}
+	}
+	return false
+}
+
+// State joins the states of the throttlers that are engaged.
+func (m *Multi) State() string {
+	var states []string
+	for _, t := range m.throttlers {
+		if t.IsThrottled() {
+			states = append(states, t.State())
+		}
+	}
+	if len(states) == 0 {
+		return "clear"
+	}
+	return strings.Join(states, "; ")
+}
+
+// BlockWait calls the BlockWait of each throttler in turn.
+func (m *Multi) BlockWait() error {
+	for _, t := range m.throttlers {
+		if err := t.BlockWait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
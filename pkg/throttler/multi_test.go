@@ -0,0 +1,36 @@
+package throttler
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiThrottler(t *testing.T) {
+	var depth atomic.Int64
+	changeset, err := NewChangesetThrottler(func() int { return int(depth.Load()) }, 1000, logrus.New())
+	assert.NoError(t, err)
+	replica := &Noop{lagTolerance: 10 * time.Second}
+	throttler := NewMultiThrottler(replica, changeset)
+	assert.NoError(t, throttler.Open())
+	assert.NoError(t, throttler.UpdateLag())
+	assert.False(t, throttler.IsThrottled())
+	assert.Equal(t, "clear", throttler.State())
+	assert.NoError(t, throttler.BlockWait())
+
+	// It is throttled while any of the throttlers is.
+	depth.Store(5000)
+	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: changeset depth 5000 (max 1000)", throttler.State())
+	replica.currentLag = 12 * time.Second
+	assert.Equal(t, "engaged: replica lag 12s (max 10s); engaged: changeset depth 5000 (max 1000)", throttler.State())
+	depth.Store(0)
+	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: replica lag 12s (max 10s)", throttler.State())
+	replica.currentLag = 0
+	assert.False(t, throttler.IsThrottled())
+	assert.NoError(t, throttler.Close())
+}
@@ -72,12 +72,14 @@ func (l *MySQL80Replica) Close() error {
 func (l *MySQL80Replica) UpdateLag() error {
 	var newLagValue int64
 	if err := l.replica.QueryRow(MySQL8LagQuery).Scan(&newLagValue); err != nil { //nolint: execinquery
-		return errors.New("could not check replication lag, check that this is a MySQL 8.0 replica, and that performance_schema is enabled")
+		err = errors.New("could not check replication lag, check that this is a MySQL 8.0 replica, and that performance_schema is enabled")
+		l.setLag(0, err)
+		return err
 	}
-	atomic.StoreInt64(&l.currentLagInMs, newLagValue)
+	l.setLag(newLagValue, nil)
 	if l.IsThrottled() {
 		l.logger.Warnf("replication delayed, throttling in progress. lag: %v tolerance: %v",
-			atomic.LoadInt64(&l.currentLagInMs), l.lagTolerance)
+			atomic.LoadInt64(&l.currentLagInMs), l.MaxLag())
 	}
 	return nil
 }
@@ -1,6 +1,9 @@
 package throttler
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Noop struct {
 	currentLag   time.Duration // used for testing
@@ -21,7 +24,15 @@ func (t *Noop) IsThrottled() bool {
 	return t.currentLag > t.lagTolerance
 }
 
-func (t *Noop) BlockWait() {
+func (t *Noop) State() string {
+	if t.IsThrottled() {
+		return fmt.Sprintf("engaged: replica lag %v (max %v)", t.currentLag, t.lagTolerance)
+	}
+	return "clear"
+}
+
+func (t *Noop) BlockWait() error {
+	return nil
 }
 
 func (t *Noop) UpdateLag() error {
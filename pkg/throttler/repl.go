@@ -2,6 +2,8 @@ package throttler
 
 import (
 	"database/sql"
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -11,24 +13,83 @@ import (
 var blockWaitInterval = 1 * time.Second
 
 type Repl struct {
+	sync.Mutex
 	replica        *sql.DB
-	lagTolerance   time.Duration
+	lagTolerance   atomic.Int64 // in nanoseconds, see SetMaxLag
 	currentLagInMs int64
+	errorPolicy    ErrorPolicy
+	lagErr         error // the error from the last lag check, if it failed
 	logger         loggers.Advanced
 }
 
+// SetMaxLag changes the replica lag at which the throttler engages. It is
+// safe to call while the copy is running, i.e. to tolerate more lag during
+// off-peak hours. A BlockWait that is in progress uses the new maximum
+// from its next check, without waiting for the lag to be checked again.
+func (l *Repl) SetMaxLag(maxLag time.Duration) {
+	old := time.Duration(l.lagTolerance.Swap(int64(maxLag)))
+	if old != maxLag {
+		l.logger.Infof("replica max lag changed from %v to %v", old, maxLag)
+	}
+}
+
+// MaxLag returns the replica lag at which the throttler engages.
+func (l *Repl) MaxLag() time.Duration {
+	return time.Duration(l.lagTolerance.Load())
+}
+
+// setLag records the result of a lag check.
+func (l *Repl) setLag(lagInMs int64, err error) {
+	l.Lock()
+	defer l.Unlock()
+	l.lagErr = err
+	if err == nil {
+		atomic.StoreInt64(&l.currentLagInMs, lagInMs)
+	}
+}
+
+func (l *Repl) lagError() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.lagErr
+}
+
 func (l *Repl) IsThrottled() bool {
-	return atomic.LoadInt64(&l.currentLagInMs) >= l.lagTolerance.Milliseconds()
+	if l.lagError() != nil {
+		return l.errorPolicy == ErrorPolicyFail || l.errorPolicy == ErrorPolicyBlock
+	}
+	return atomic.LoadInt64(&l.currentLagInMs) >= l.MaxLag().Milliseconds()
+}
+
+// State describes whether the throttler is engaged because of the
+// replica lag, or because the lag can not be checked.
+func (l *Repl) State() string {
+	if err := l.lagError(); err != nil {
+		if l.IsThrottled() {
+			return fmt.Sprintf("engaged: replica lag unknown: %v", err)
+		}
+		return fmt.Sprintf("clear: replica lag unknown: %v", err)
+	}
+	lag := time.Duration(atomic.LoadInt64(&l.currentLagInMs)) * time.Millisecond
+	if l.IsThrottled() {
+		return fmt.Sprintf("engaged: replica lag %v (max %v)", lag, l.MaxLag())
+	}
+	return "clear"
 }
 
 // BlockWait blocks until the lag is within the tolerance, or up to 60s
-// to allow some progress to be made.
-func (l *Repl) BlockWait() {
-	for range 60 {
-		if atomic.LoadInt64(&l.currentLagInMs) < l.lagTolerance.Milliseconds() {
-			return
+// to allow some progress to be made. If the lag can not be checked,
+// it follows the error policy.
+func (l *Repl) BlockWait() error {
+	timedOut, err := blockWait(l.errorPolicy, func() (bool, error) {
+		if err := l.lagError(); err != nil {
+			return true, err
 		}
-		time.Sleep(blockWaitInterval)
+		return atomic.LoadInt64(&l.currentLagInMs) >= l.MaxLag().Milliseconds(), nil
+	})
+	if !timedOut {
+		return err
 	}
-	l.logger.Warnf("lag monitor timed out. lag: %v tolerance: %v", atomic.LoadInt64(&l.currentLagInMs), l.lagTolerance)
+	l.logger.Warnf("lag monitor timed out. lag: %v tolerance: %v", atomic.LoadInt64(&l.currentLagInMs), l.MaxLag())
+	return nil
 }
@@ -3,6 +3,7 @@ package throttler
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/siddontang/loggers"
@@ -10,26 +11,95 @@ import (
 
 var (
 	loopInterval = 5 * time.Second
+	// blockWaitTimeout is the longest that BlockWait blocks under
+	// ErrorPolicyBlock before it returns the error of the check.
+	blockWaitTimeout = 30 * time.Minute
+)
+
+// ErrorPolicy is how a throttler behaves when it can not determine
+// if it should throttle, i.e. because the replica can not be queried.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyContinue does not throttle while the check is failing.
+	// This is the default.
+	ErrorPolicyContinue ErrorPolicy = "continue"
+	// ErrorPolicyFail returns the error from BlockWait, which fails the migration.
+	ErrorPolicyFail ErrorPolicy = "fail"
+	// ErrorPolicyBlock blocks in BlockWait until the check succeeds again,
+	// or returns the error if it is still failing after blockWaitTimeout.
+	ErrorPolicyBlock ErrorPolicy = "block"
 )
 
 type Throttler interface {
 	Open() error
 	Close() error
 	IsThrottled() bool
-	BlockWait()
+	BlockWait() error
 	UpdateLag() error
+	// State describes whether the throttler is engaged and why,
+	// i.e. "engaged: replica lag 12s (max 10s)" or "clear".
+	State() string
+}
+
+// blockWait is the BlockWait loop of the throttlers that follow an
+// ErrorPolicy. check returns whether the throttler is engaged, and the
+// error of the last check if it failed. It blocks until the throttler
+// is clear, or for up to 60 intervals to allow some progress to be made,
+// in which case it returns timedOut. While the check is failing it
+// follows the error policy.
+func blockWait(errorPolicy ErrorPolicy, check func() (bool, error)) (timedOut bool, err error) {
+	var blockedSince time.Time
+	for i := 0; ; {
+		throttled, err := check()
+		if err != nil {
+			switch errorPolicy {
+			case ErrorPolicyFail:
+				return false, err
+			case ErrorPolicyBlock:
+				if blockedSince.IsZero() {
+					blockedSince = time.Now()
+				} else if time.Since(blockedSince) >= blockWaitTimeout {
+					return false, fmt.Errorf("throttler check has been failing for %v: %w", blockWaitTimeout, err)
+				}
+				time.Sleep(blockWaitInterval)
+				continue
+			case ErrorPolicyContinue:
+				return false, nil
+			}
+		}
+		if !throttled {
+			return false, nil
+		}
+		if i++; i > 60 {
+			return true, nil
+		}
+		time.Sleep(blockWaitInterval)
+	}
 }
 
 // NewReplicationThrottler returns a Throttler that is appropriate for the
 // current replica. It will return a MySQL80Replica throttler if the version is detected
 // as 8.0, and a MySQL57Replica throttler otherwise.
 // It returns an error if querying for either fails, i.e. it might not be a valid DB connection.
-func NewReplicationThrottler(replica *sql.DB, lagTolerance time.Duration, logger loggers.Advanced) (Throttler, error) {
-	return &MySQL80Replica{
+// An empty errorPolicy is treated as ErrorPolicyContinue.
+// The lagTolerance can be changed while the throttler is open with SetMaxLag.
+func NewReplicationThrottler(replica *sql.DB, lagTolerance time.Duration, errorPolicy ErrorPolicy, logger loggers.Advanced) (Throttler, error) {
+	switch errorPolicy {
+	case "":
+		errorPolicy = ErrorPolicyContinue
+	case ErrorPolicyContinue, ErrorPolicyFail, ErrorPolicyBlock:
+	default:
+		return nil, fmt.Errorf("unknown throttler error policy %q, must be one of: %s, %s, %s",
+			errorPolicy, ErrorPolicyContinue, ErrorPolicyFail, ErrorPolicyBlock)
+	}
+	throttler := &MySQL80Replica{
 		Repl: Repl{
-			replica:      replica,
-			lagTolerance: lagTolerance,
-			logger:       logger,
+			replica:     replica,
+			errorPolicy: errorPolicy,
+			logger:      logger,
 		},
-	}, nil
+	}
+	throttler.lagTolerance.Store(int64(lagTolerance))
+	return throttler, nil
 }
@@ -3,6 +3,7 @@ package throttler
 import (
 	"database/sql"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -22,12 +23,12 @@ func TestThrottlerInterface(t *testing.T) {
 
 	//	NewReplicationThrottler will attach either MySQL 8.0 or MySQL 5.7 throttler
 	loopInterval = 1 * time.Millisecond
-	throttler, err := NewReplicationThrottler(db, 60*time.Second, logrus.New())
+	throttler, err := NewReplicationThrottler(db, 60*time.Second, ErrorPolicyContinue, logrus.New())
 	assert.NoError(t, err)
 	assert.NoError(t, throttler.Open())
 
 	time.Sleep(50 * time.Millisecond)        // make sure the throttler loop can calculate.
-	throttler.BlockWait()                    // wait for catch up (there's no activity)
+	assert.NoError(t, throttler.BlockWait()) // wait for catch up (there's no activity)
 	assert.False(t, throttler.IsThrottled()) // there's a race, but its unlikely to be throttled
 
 	assert.NoError(t, throttler.Close())
@@ -41,9 +42,121 @@ func TestNoopThrottler(t *testing.T) {
 	throttler.currentLag = 1 * time.Second
 	throttler.lagTolerance = 2 * time.Second
 	assert.False(t, throttler.IsThrottled())
+	assert.Equal(t, "clear", throttler.State())
 	assert.NoError(t, throttler.UpdateLag())
-	throttler.BlockWait()
+	assert.NoError(t, throttler.BlockWait())
 	throttler.lagTolerance = 100 * time.Millisecond
 	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: replica lag 1s (max 100ms)", throttler.State())
 	assert.NoError(t, throttler.Close())
 }
+
+// unreachableReplica returns a connection to a replica that
+// can not be queried, so every lag check fails.
+func unreachableReplica(t *testing.T) *sql.DB {
+	db, err := sql.Open("mysql", "msandbox:msandbox@tcp(127.0.0.1:1)/test?timeout=100ms")
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestThrottlerErrorPolicy(t *testing.T) {
+	_, err := NewReplicationThrottler(unreachableReplica(t), time.Second, "ignore", logrus.New())
+	assert.ErrorContains(t, err, `unknown throttler error policy "ignore"`)
+
+	// The default is to continue unthrottled.
+	throttler, err := NewReplicationThrottler(unreachableReplica(t), time.Second, "", logrus.New())
+	assert.NoError(t, err)
+	assert.Equal(t, ErrorPolicyContinue, throttler.(*MySQL80Replica).errorPolicy)
+}
+
+func TestThrottlerErrorPolicyContinue(t *testing.T) {
+	throttler, err := NewReplicationThrottler(unreachableReplica(t), time.Second, ErrorPolicyContinue, logrus.New())
+	assert.NoError(t, err)
+	// Even if the last known lag was high, a failing check is not throttled.
+	atomic.StoreInt64(&throttler.(*MySQL80Replica).currentLagInMs, 5000)
+	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: replica lag 5s (max 1s)", throttler.State())
+	assert.Error(t, throttler.UpdateLag())
+	assert.False(t, throttler.IsThrottled())
+	assert.Equal(t, "clear: replica lag unknown: could not check replication lag, check that this is a MySQL 8.0 replica, and that performance_schema is enabled", throttler.State())
+	assert.NoError(t, throttler.BlockWait())
+}
+
+func TestThrottlerErrorPolicyFail(t *testing.T) {
+	throttler, err := NewReplicationThrottler(unreachableReplica(t), time.Second, ErrorPolicyFail, logrus.New())
+	assert.NoError(t, err)
+	assert.Error(t, throttler.UpdateLag())
+	assert.True(t, throttler.IsThrottled())
+	assert.Contains(t, throttler.State(), "engaged: replica lag unknown: could not check replication lag")
+	assert.ErrorContains(t, throttler.BlockWait(), "could not check replication lag")
+}
+
+func TestThrottlerErrorPolicyBlock(t *testing.T) {
+	blockWaitInterval = time.Millisecond
+	defer func() { blockWaitInterval = time.Second }()
+
+	throttler, err := NewReplicationThrottler(unreachableReplica(t), time.Second, ErrorPolicyBlock, logrus.New())
+	assert.NoError(t, err)
+	assert.Error(t, throttler.UpdateLag())
+	assert.True(t, throttler.IsThrottled())
+
+	// BlockWait blocks until the lag can be checked again.
+	startTime := time.Now()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		throttler.(*MySQL80Replica).setLag(0, nil)
+	}()
+	assert.NoError(t, throttler.BlockWait())
+	assert.GreaterOrEqual(t, time.Since(startTime), 100*time.Millisecond)
+	assert.False(t, throttler.IsThrottled())
+}
+
+func TestThrottlerErrorPolicyBlockTimeout(t *testing.T) {
+	blockWaitInterval = time.Millisecond
+	blockWaitTimeout = 50 * time.Millisecond
+	defer func() {
+		blockWaitInterval = time.Second
+		blockWaitTimeout = 30 * time.Minute
+	}()
+
+	throttler, err := NewReplicationThrottler(unreachableReplica(t), time.Second, ErrorPolicyBlock, logrus.New())
+	assert.NoError(t, err)
+	assert.Error(t, throttler.UpdateLag())
+
+	// If the lag still can not be checked, BlockWait gives up.
+	startTime := time.Now()
+	assert.ErrorContains(t, throttler.BlockWait(), "throttler check has been failing for 50ms: could not check replication lag")
+	assert.GreaterOrEqual(t, time.Since(startTime), 50*time.Millisecond)
+}
+
+func TestThrottlerSetMaxLag(t *testing.T) {
+	blockWaitInterval = 10 * time.Millisecond // BlockWait times out after 600ms.
+	defer func() { blockWaitInterval = time.Second }()
+
+	throttler, err := NewReplicationThrottler(unreachableReplica(t), time.Second, ErrorPolicyContinue, logrus.New())
+	assert.NoError(t, err)
+	replica := throttler.(*MySQL80Replica)
+	assert.Equal(t, time.Second, replica.MaxLag())
+	replica.setLag(5000, nil)
+	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: replica lag 5s (max 1s)", throttler.State())
+
+	// Raising the max lag while BlockWait is blocked releases it,
+	// without the lag being checked again.
+	startTime := time.Now()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		replica.SetMaxLag(10 * time.Second)
+	}()
+	assert.NoError(t, throttler.BlockWait())
+	assert.GreaterOrEqual(t, time.Since(startTime), 50*time.Millisecond)
+	assert.Less(t, time.Since(startTime), 500*time.Millisecond) // before BlockWait times out.
+	assert.False(t, throttler.IsThrottled())
+	assert.Equal(t, "clear", throttler.State())
+
+	// Lowering it engages the throttler again.
+	replica.SetMaxLag(2 * time.Second)
+	assert.True(t, throttler.IsThrottled())
+	assert.Equal(t, "engaged: replica lag 5s (max 2s)", throttler.State())
+}
@@ -2,7 +2,9 @@
 package utils
 
 import (
+	"encoding/binary"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/cashapp/spirit/pkg/dbconn/sqlescape"
@@ -11,6 +13,9 @@ import (
 
 const (
 	PrimaryKeySeparator = "-#-" // used to hash a composite primary key
+	// NullKeyValue represents a NULL column of a key in the output of
+	// SplitHashedKey and SplitPackedKey. It is distinct from an empty string.
+	NullKeyValue = "\x00NULL"
 )
 
 // HashKey is used to convert a composite key into a string
@@ -18,11 +23,122 @@ const (
 func HashKey(key []interface{}) string {
 	var pk []string
 	for _, v := range key {
+		if v == nil {
+			pk = append(pk, NullKeyValue)
+			continue
+		}
 		pk = append(pk, fmt.Sprintf("%v", v))
 	}
 	return strings.Join(pk, PrimaryKeySeparator)
 }
 
+// Tags used by PackKey to describe how each column of the key was encoded.
+const (
+	packedInt    byte = 'i' // zig-zag varint
+	packedUint   byte = 'u' // uvarint
+	packedString byte = 's' // uvarint length followed by the raw bytes
+	packedNull   byte = 'n' // no value follows
+)
+
+// PackKey is a compact alternative to HashKey. Integers are stored as
+// varints and every other value is stored length-prefixed, which avoids
+// both the decimal expansion of wide integers and the PrimaryKeySeparator
+// between columns. The result is only meaningful to UnpackKey.
+func PackKey(key []interface{}) string {
+	buf := make([]byte, 0, 16*len(key))
+	for _, v := range key {
+		switch val := v.(type) {
+		case nil:
+			buf = append(buf, packedNull)
+		case int8:
+			buf = binary.AppendVarint(append(buf, packedInt), int64(val))
+		case int16:
+			buf = binary.AppendVarint(append(buf, packedInt), int64(val))
+		case int32:
+			buf = binary.AppendVarint(append(buf, packedInt), int64(val))
+		case int64:
+			buf = binary.AppendVarint(append(buf, packedInt), val)
+		case int:
+			buf = binary.AppendVarint(append(buf, packedInt), int64(val))
+		case uint8:
+			buf = binary.AppendUvarint(append(buf, packedUint), uint64(val))
+		case uint16:
+			buf = binary.AppendUvarint(append(buf, packedUint), uint64(val))
+		case uint32:
+			buf = binary.AppendUvarint(append(buf, packedUint), uint64(val))
+		case uint64:
+			buf = binary.AppendUvarint(append(buf, packedUint), val)
+		case uint:
+			buf = binary.AppendUvarint(append(buf, packedUint), uint64(val))
+		case string:
+			buf = binary.AppendUvarint(append(buf, packedString), uint64(len(val)))
+			buf = append(buf, val...)
+		default:
+			// Use the same representation HashKey would have used,
+			// so that both encodings produce identical queries.
+			str := fmt.Sprintf("%v", v)
+			buf = binary.AppendUvarint(append(buf, packedString), uint64(len(str)))
+			buf = append(buf, str...)
+		}
+	}
+	return string(buf)
+}
+
+// UnpackKey converts a key created by PackKey to a string that can be used
+// in a query. The output is identical to UnhashKey(HashKey(key)).
+func UnpackKey(key string) string {
+	str, nulls := splitPackedKey(key)
+	for i, v := range str {
+		str[i] = quoteKeyValue(v, nulls[i])
+	}
+	if len(str) == 1 {
+		return str[0]
+	}
+	return "(" + strings.Join(str, ",") + ")"
+}
+
+// SplitPackedKey returns the string representation
+// of each column in a key created by PackKey.
+func SplitPackedKey(key string) []string {
+	str, _ := splitPackedKey(key)
+	return str
+}
+
+// splitPackedKey is SplitPackedKey, but also returns which columns are NULL.
+// Unlike NullKeyValue this is unambiguous, since a string column can have
+// any value.
+func splitPackedKey(key string) ([]string, []bool) {
+	var str []string
+	var nulls []bool
+	buf := []byte(key)
+	for len(buf) > 0 {
+		tag := buf[0]
+		buf = buf[1:]
+		nulls = append(nulls, tag == packedNull)
+		switch tag {
+		case packedNull:
+			str = append(str, NullKeyValue)
+		case packedInt:
+			n, read := binary.Varint(buf)
+			str, buf = append(str, strconv.FormatInt(n, 10)), buf[read:]
+		case packedUint:
+			n, read := binary.Uvarint(buf)
+			str, buf = append(str, strconv.FormatUint(n, 10)), buf[read:]
+		default: // packedString
+			n, read := binary.Uvarint(buf)
+			end := read + int(n)
+			str, buf = append(str, string(buf[read:end])), buf[end:]
+		}
+	}
+	return str, nulls
+}
+
+// SplitHashedKey returns the string representation
+// of each column in a key created by HashKey.
+func SplitHashedKey(key string) []string {
+	return strings.Split(key, PrimaryKeySeparator)
+}
+
 // IntersectNonGeneratedColumns returns a string of columns that are in both tables
 func IntersectNonGeneratedColumns(t1, t2 *table.TableInfo) string {
 	var intersection []string
@@ -38,22 +154,42 @@ func IntersectNonGeneratedColumns(t1, t2 *table.TableInfo) string {
 
 // UnhashKey converts a hashed key to a string that can be used in a query.
 func UnhashKey(key string) string {
-	str := strings.Split(key, PrimaryKeySeparator)
+	str := SplitHashedKey(key)
 	if len(str) == 1 {
-		return "'" + sqlescape.EscapeString(str[0]) + "'"
+		return quoteKeyValue(str[0], str[0] == NullKeyValue)
 	}
 	for i, v := range str {
-		str[i] = "'" + sqlescape.EscapeString(v) + "'"
+		str[i] = quoteKeyValue(v, v == NullKeyValue)
 	}
 	return "(" + strings.Join(str, ",") + ")"
 }
 
+// quoteKeyValue quotes a column of a key for use in a query.
+func quoteKeyValue(v string, isNull bool) string {
+	if isNull {
+		return "NULL"
+	}
+	return "'" + sqlescape.EscapeString(v) + "'"
+}
+
 // ErrInErr is a wrapper func to not nest too deeply in an error being handled
 // inside of an already error path. Not catching the error makes linters unhappy,
 // but because it's already in an error path, there's not much to do.
 func ErrInErr(_ error) {
 }
 
+// QueryComment returns template as a comment to prefix a statement with, so the
+// statement can be identified in the processlist or slow log. The placeholders
+// {table} and {chunk} are replaced by tbl and chunk. Any "*/" is broken up, so
+// the comment can not be terminated early. An empty template returns "".
+func QueryComment(template, tbl, chunk string) string {
+	if template == "" {
+		return ""
+	}
+	comment := strings.NewReplacer("{table}", tbl, "{chunk}", chunk).Replace(template)
+	return "/* " + strings.ReplaceAll(comment, "*/", "* /") + " */ "
+}
+
 func StripPort(hostname string) string {
 	if strings.Contains(hostname, ":") {
 		return strings.Split(hostname, ":")[0]
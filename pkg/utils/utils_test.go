@@ -42,6 +42,88 @@ func TestHashAndUnhashKey(t *testing.T) {
 	assert.Equal(t, "'1234'", unhashed)
 }
 
+func TestPackAndUnpackKey(t *testing.T) {
+	keys := [][]interface{}{
+		{"1234"},
+		{int64(1234)},
+		{int32(-5), uint64(18446744073709551615), "ACDC"},
+		{"it's", "ACDC", ""},
+		{int8(1), int16(-2), uint8(3), uint16(4), uint32(5), int(6), uint(7)},
+		{3.14, "x"},
+	}
+	for _, key := range keys {
+		// The unpacked value must be usable in exactly the same way
+		// as the output of the string based HashKey/UnhashKey.
+		assert.Equal(t, UnhashKey(HashKey(key)), UnpackKey(PackKey(key)))
+	}
+	// Values containing the PrimaryKeySeparator can not be round-tripped
+	// by HashKey, but can by PackKey.
+	assert.Equal(t, `('it\'s','a-#-b','')`, UnpackKey(PackKey([]interface{}{"it's", "a-#-b", ""})))
+	assert.Equal(t, "'-42'", UnpackKey(PackKey([]interface{}{int64(-42)})))
+
+	// The packed format is smaller for wide composite keys.
+	key := []interface{}{int64(1234567890123), int64(9876543210), "ACDC"}
+	assert.Less(t, len(PackKey(key)), len(HashKey(key)))
+}
+
+func TestKeyWithNull(t *testing.T) {
+	// A NULL column is distinct from an empty string.
+	withNull := []interface{}{int64(1), nil}
+	withEmpty := []interface{}{int64(1), ""}
+	assert.NotEqual(t, HashKey(withNull), HashKey(withEmpty))
+	assert.NotEqual(t, PackKey(withNull), PackKey(withEmpty))
+	assert.Equal(t, "('1',NULL)", UnhashKey(HashKey(withNull)))
+	assert.Equal(t, "('1',NULL)", UnpackKey(PackKey(withNull)))
+	assert.Equal(t, "('1','')", UnhashKey(HashKey(withEmpty)))
+	assert.Equal(t, "('1','')", UnpackKey(PackKey(withEmpty)))
+	assert.Equal(t, "NULL", UnhashKey(HashKey([]interface{}{nil})))
+	assert.Equal(t, "NULL", UnpackKey(PackKey([]interface{}{nil})))
+	assert.Equal(t, []string{"1", NullKeyValue}, SplitHashedKey(HashKey(withNull)))
+	assert.Equal(t, []string{"1", NullKeyValue}, SplitPackedKey(PackKey(withNull)))
+
+	// PackKey does not confuse NULL with a string that looks like it.
+	assert.Equal(t, `('1','\0NULL')`, UnpackKey(PackKey([]interface{}{int64(1), NullKeyValue})))
+}
+
+func BenchmarkHashKey(b *testing.B) {
+	benchmarkKeyEncoding(b, HashKey)
+}
+
+func BenchmarkPackKey(b *testing.B) {
+	benchmarkKeyEncoding(b, PackKey)
+}
+
+// benchmarkKeyEncoding fills a changeset-like map with composite keys
+// and reports the average bytes used by each key.
+func benchmarkKeyEncoding(b *testing.B, encode func([]interface{}) string) {
+	b.ReportAllocs()
+	var keyBytes int
+	for i := 0; i < b.N; i++ {
+		changeset := make(map[string]bool)
+		keyBytes = 0
+		for j := int64(0); j < 1000; j++ {
+			k := encode([]interface{}{int64(1700000000000) + j, int32(j % 7), "us-west-2"})
+			changeset[k] = j%2 == 0
+			keyBytes += len(k)
+		}
+	}
+	b.ReportMetric(float64(keyBytes)/1000, "key-bytes/op")
+}
+
+func TestQueryComment(t *testing.T) {
+	assert.Empty(t, QueryComment("", "`test`.`t1`", "`a` < 10"))
+	assert.Equal(t, "/* spirit migration=123 */ ", QueryComment("spirit migration=123", "`test`.`t1`", "`a` < 10"))
+	assert.Equal(t, "/* spirit table=`test`.`t1` chunk=`a` < 10 */ ",
+		QueryComment("spirit table={table} chunk={chunk}", "`test`.`t1`", "`a` < 10"))
+
+	// The comment can not be terminated early, by the template or the values.
+	assert.Equal(t, "/* spirit * / DROP TABLE t1; /* */ ", QueryComment("spirit */ DROP TABLE t1; /*", "", ""))
+	assert.Equal(t, "/* chunk=`a` < 'x* /y' */ ", QueryComment("chunk={chunk}", "", "`a` < 'x*/y'"))
+	assert.Equal(t, "/* ** //* / */ ", QueryComment("**//*/", "", ""))
+	// An executable comment can not be created.
+	assert.Equal(t, "/* !50000 x */ ", QueryComment("!50000 x", "", ""))
+}
+
 func TestStripPort(t *testing.T) {
 	assert.Equal(t, "hostname.com", StripPort("hostname.com"))
 	assert.Equal(t, "hostname.com", StripPort("hostname.com:3306"))